@@ -74,6 +74,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The freshness checks count from the start of the agent until it finds a
+	// completed backup
+	if err := common.RecordStart(time.Now()); err != nil {
+		slog.WarnContext(ctx, "unable to record the start of the agent", "error", err)
+	}
+
 	// Create Kubernetes clients
 	clientset, dynamicClient, err := getKubernetesClient()
 	if err != nil {
@@ -573,6 +579,7 @@ func reportInitialBackupStatus(ctx context.Context, scheduledBackupName string,
 		} else {
 			msg = fmt.Sprintf("last backup executed %s ago (completed successfully)", formatDuration(timeSinceExecution))
 		}
+		recordSuccess(ctx, mostRecentBackupTime)
 		outputs.SetSuccess(ctx, msg, perfData)
 		return phase, ""
 	}
@@ -604,10 +611,18 @@ func sendHeartbeat(ctx context.Context, scheduledBackupName string, lastHeartbea
 	switch lastPhase {
 	case backupPhaseCompleted:
 		timeSinceLastBackup := time.Since(lastBackupTime)
-		outputs.SetSuccess(ctx, fmt.Sprintf("last backup executed %s ago (completed successfully)", formatDuration(timeSinceLastBackup)), map[string]any{
-			"executed_at":          lastBackupTime.Unix(),
-			"time_since_execution": timeSinceLastBackup.Seconds(),
-		})
+		// The backups are overdue past the freshness bounds of the policy
+		switch common.FreshnessFromEnv().State(timeSinceLastBackup) {
+		case common.FRESHNESS_STALE:
+			outputs.SetError(ctx, fmt.Errorf("no successful backup in the last %s", formatDuration(timeSinceLastBackup)))
+		case common.FRESHNESS_OVERDUE:
+			outputs.SetWarning(ctx, fmt.Errorf("backups overdue: no successful backup in the last %s", formatDuration(timeSinceLastBackup)))
+		default:
+			outputs.SetSuccess(ctx, fmt.Sprintf("last backup executed %s ago (completed successfully)", formatDuration(timeSinceLastBackup)), map[string]any{
+				"executed_at":          lastBackupTime.Unix(),
+				"time_since_execution": timeSinceLastBackup.Seconds(),
+			})
+		}
 	case backupPhaseFailed:
 		timeSinceLastBackup := time.Since(lastBackupTime)
 		outputs.SetError(ctx, fmt.Errorf("last backup executed %s ago (failed: %s)", formatDuration(timeSinceLastBackup), lastErrMsg))
@@ -684,6 +699,7 @@ func handleFinishedBackup(ctx context.Context, scheduledBackupName, backupName s
 
 		duration := stoppedAt.Sub(startedAt)
 		slog.InfoContext(ctx, "backup completed", "scheduledBackup", scheduledBackupName, "backup", backupName)
+		recordSuccess(ctx, stoppedAt)
 		outputs.SetSuccess(ctx, fmt.Sprintf("backup process completed successfully in %s at %s", duration, stoppedAt), map[string]any{
 			"duration": duration.Seconds(),
 		})
@@ -715,6 +731,13 @@ func handleFinishedBackup(ctx context.Context, scheduledBackupName, backupName s
 	return time.Now(), errorMsg, nil
 }
 
+// recordSuccess records the time of a completed backup for the freshness checks.
+func recordSuccess(ctx context.Context, t time.Time) {
+	if err := common.RecordSuccess(t); err != nil {
+		slog.WarnContext(ctx, "unable to record the completed backup", "error", err)
+	}
+}
+
 // isOwnedBy checks if the object is owned by a resource with the given name and kind
 func isOwnedBy(obj *unstructured.Unstructured, ownerName, ownerKind string) bool {
 	ownerRefs := obj.GetOwnerReferences()
@@ -0,0 +1,124 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BC_STATE_DIR            = "BC_STATE_DIR"
+	BC_FRESHNESS_WARN_AFTER = "BC_FRESHNESS_WARN_AFTER"
+	BC_FRESHNESS_FAIL_AFTER = "BC_FRESHNESS_FAIL_AFTER"
+)
+
+// The files of the state directory recording the time the agent started and
+// the time of its latest successful backup, shared with the scripts.
+const (
+	startedFile     = "started"
+	lastSuccessFile = "last-success"
+)
+
+// The freshness of the backups of an agent.
+const (
+	FRESHNESS_FRESH   = "Fresh"
+	FRESHNESS_OVERDUE = "Overdue"
+	FRESHNESS_STALE   = "Stale"
+)
+
+// StateDir returns the directory of the state shared by the agents and the
+// scripts.
+func StateDir() string {
+	if dir := os.Getenv(BC_STATE_DIR); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "backup-controller-state")
+}
+
+// RecordStart records the time the agent started, which stands for the latest
+// successful backup until it completes or finds one.
+func RecordStart(t time.Time) error {
+	return writeTime(startedFile, t)
+}
+
+// RecordSuccess records the time of a successful backup, unless a more recent
+// one is already recorded.
+func RecordSuccess(t time.Time) error {
+	if last, ok := readTime(lastSuccessFile); ok && !t.After(last) {
+		return nil
+	}
+	return writeTime(lastSuccessFile, t)
+}
+
+// LastSuccess returns the time of the latest successful backup, or the time
+// the agent started and false when there is none.
+func LastSuccess() (time.Time, bool) {
+	if t, ok := readTime(lastSuccessFile); ok {
+		return t, true
+	}
+	t, _ := readTime(startedFile)
+	return t, false
+}
+
+// Freshness bounds the age of the latest successful backup, as set by the
+// controller. A zero bound is not checked.
+type Freshness struct {
+	WarnAfter time.Duration
+	FailAfter time.Duration
+}
+
+// FreshnessFromEnv returns the freshness bounds of the agent.
+func FreshnessFromEnv() Freshness {
+	return Freshness{
+		WarnAfter: envSeconds(BC_FRESHNESS_WARN_AFTER),
+		FailAfter: envSeconds(BC_FRESHNESS_FAIL_AFTER),
+	}
+}
+
+// Enabled tells whether a bound is set.
+func (f Freshness) Enabled() bool {
+	return f.WarnAfter > 0 || f.FailAfter > 0
+}
+
+// State returns the freshness of a backup of the given age.
+func (f Freshness) State(age time.Duration) string {
+	switch {
+	case f.FailAfter > 0 && age > f.FailAfter:
+		return FRESHNESS_STALE
+	case f.WarnAfter > 0 && age > f.WarnAfter:
+		return FRESHNESS_OVERDUE
+	default:
+		return FRESHNESS_FRESH
+	}
+}
+
+func writeTime(name string, t time.Time) error {
+	dir := StateDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), []byte(fmt.Sprintf("%d\n", t.Unix())), 0o644)
+}
+
+func readTime(name string) (time.Time, bool) {
+	data, err := os.ReadFile(filepath.Join(StateDir(), name))
+	if err != nil {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+func envSeconds(name string) time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,106 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The file of the state directory holding the report of the latest backup run.
+const runReportFile = "last-run.json"
+
+// The statuses of the backup runs and of their phases.
+const (
+	RUN_SUCCEEDED = "succeeded"
+	RUN_WARNING   = "warning"
+	RUN_FAILED    = "failed"
+	RUN_SKIPPED   = "skipped"
+)
+
+// RunReport is the report of a backup run, written by run-backup.sh.
+type RunReport struct {
+	Status        string        `json:"status"`
+	Message       string        `json:"message"`
+	Host          string        `json:"host"`
+	Schedule      string        `json:"schedule,omitempty"`
+	ScheduledTime time.Time     `json:"scheduledTime"`
+	StartTime     time.Time     `json:"startTime"`
+	EndTime       time.Time     `json:"endTime"`
+	Duration      int64         `json:"duration"`
+	Phases        []PhaseReport `json:"phases"`
+
+	// The warnings of a run completed with the warning status, e.g. a partial
+	// snapshot, and the files restic could not read
+	Warnings        []string `json:"warnings,omitempty"`
+	UnreadableFiles []string `json:"unreadableFiles,omitempty"`
+
+	// The figures of the restic summary, when the backup completed
+	SnapshotID string          `json:"snapshotId,omitempty"`
+	Files      *FileCounts     `json:"files,omitempty"`
+	Dirs       *FileCounts     `json:"dirs,omitempty"`
+	Bytes      *ByteCounts     `json:"bytes,omitempty"`
+	Summary    json.RawMessage `json:"summary,omitempty"`
+}
+
+// PhaseReport is the report of a phase of a backup run: repository,
+// hooks-pre, backup, hooks-post, forget, prune or check.
+type PhaseReport struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Duration  int64     `json:"duration"`
+	Errors    []string  `json:"errors,omitempty"`
+
+	// The attempts of a phase retried on the transient errors of the backend
+	Attempts []AttemptReport `json:"attempts,omitempty"`
+}
+
+// AttemptReport is the report of an attempt of a retried phase.
+type AttemptReport struct {
+	Attempt   int       `json:"attempt"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	ExitCode  int       `json:"exitCode"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// FileCounts counts the files or the directories of a backup.
+type FileCounts struct {
+	New        int64  `json:"new"`
+	Changed    int64  `json:"changed"`
+	Unmodified int64  `json:"unmodified"`
+	Processed  *int64 `json:"processed,omitempty"`
+}
+
+// ByteCounts counts the bytes of a backup.
+type ByteCounts struct {
+	Processed   int64  `json:"processed"`
+	Added       int64  `json:"added"`
+	AddedPacked *int64 `json:"addedPacked,omitempty"`
+}
+
+// LastRunReport returns the report of the latest backup run, or nil when there
+// is none.
+func LastRunReport() (*RunReport, error) {
+	report, err := ReadRunReport(filepath.Join(StateDir(), runReportFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return report, err
+}
+
+// ReadRunReport reads the report of a backup run from the file.
+func ReadRunReport(file string) (*RunReport, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var report RunReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
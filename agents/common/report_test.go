@@ -0,0 +1,70 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LastRunReport", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		setEnv(map[string]string{BC_STATE_DIR: dir})
+	})
+
+	It("returns nil without any run", func() {
+		Expect(LastRunReport()).To(BeNil())
+	})
+
+	It("reads the report of the latest run", func() {
+		Expect(os.WriteFile(filepath.Join(dir, runReportFile), []byte(`{
+			"status": "warning",
+			"message": "backup process completed with warnings",
+			"host": "app-0",
+			"scheduledTime": "2025-06-01T02:00:00Z",
+			"startTime": "2025-06-01T02:00:05Z",
+			"endTime": "2025-06-01T02:01:05Z",
+			"duration": 60,
+			"phases": [
+				{"name": "backup", "status": "succeeded", "startTime": "2025-06-01T02:00:10Z",
+				 "endTime": "2025-06-01T02:01:00Z", "duration": 50, "errors": ["backup /data/a: permission denied"],
+				 "attempts": [
+					{"attempt": 1, "startTime": "2025-06-01T02:00:10Z", "endTime": "2025-06-01T02:00:20Z", "exitCode": 1, "error": "connection refused"},
+					{"attempt": 2, "startTime": "2025-06-01T02:00:50Z", "endTime": "2025-06-01T02:01:00Z", "exitCode": 3}
+				 ]}
+			],
+			"warnings": ["1 file(s) could not be read: /data/a"],
+			"unreadableFiles": ["/data/a"],
+			"snapshotId": "abcdef",
+			"files": {"new": 1, "changed": 2, "unmodified": 3, "processed": 6},
+			"dirs": {"new": 0, "changed": 1, "unmodified": 4},
+			"bytes": {"processed": 1024, "added": 512},
+			"summary": {"snapshot_id": "abcdef", "data_blobs": 7}
+		}`), 0o644)).To(Succeed())
+
+		report, err := LastRunReport()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Status).To(Equal(RUN_WARNING))
+		Expect(report.StartTime).To(Equal(time.Date(2025, 6, 1, 2, 0, 5, 0, time.UTC)))
+		Expect(report.Phases).To(HaveLen(1))
+		Expect(report.Phases[0].Errors).To(ConsistOf("backup /data/a: permission denied"))
+		Expect(report.Phases[0].Attempts).To(HaveLen(2))
+		Expect(report.Phases[0].Attempts[0].Error).To(Equal("connection refused"))
+		Expect(report.Phases[0].Attempts[1].ExitCode).To(Equal(3))
+		Expect(report.UnreadableFiles).To(ConsistOf("/data/a"))
+		Expect(*report.Files.Processed).To(Equal(int64(6)))
+		Expect(report.Bytes.AddedPacked).To(BeNil())
+		Expect(report.Summary).To(MatchJSON(`{"snapshot_id": "abcdef", "data_blobs": 7}`))
+	})
+
+	It("fails on a corrupted report", func() {
+		Expect(os.WriteFile(filepath.Join(dir, runReportFile), []byte(`{"status":`), 0o644)).To(Succeed())
+		_, err := LastRunReport()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The exit codes of restic (>= 0.17) telling the outcome of a command.
+const (
+	RESTIC_EXIT_FATAL          = 1
+	RESTIC_EXIT_PARTIAL        = 3
+	RESTIC_EXIT_NO_REPOSITORY  = 10
+	RESTIC_EXIT_LOCKED         = 11
+	RESTIC_EXIT_WRONG_PASSWORD = 12
+)
+
+// tagNames lists the Kubernetes metadata the controller passes to the agents
+// as BC_TAG_<NAME> variables.
+var tagNames = []string{"NAMESPACE", "OWNER_KIND", "OWNER_NAME", "POD", "REPLICA", "POLICY", "SCHEDULE", "CLUSTER"}
+
+// ResticBackupArgs returns the restic backup flags matching the BC_BACKUP_*
+// options set by the controller.
+func ResticBackupArgs() ([]string, error) {
+	var args []string
+
+	if value := os.Getenv("BC_BACKUP_EXCLUDE"); value != "" {
+		var patterns []string
+		if err := json.Unmarshal([]byte(value), &patterns); err != nil {
+			return nil, fmt.Errorf("invalid BC_BACKUP_EXCLUDE: %w", err)
+		}
+		for _, pattern := range patterns {
+			args = append(args, "--exclude="+pattern)
+		}
+	}
+	if os.Getenv("BC_BACKUP_EXCLUDE_CACHES") == "true" {
+		args = append(args, "--exclude-caches")
+	}
+	if value := os.Getenv("BC_BACKUP_EXCLUDE_LARGER_THAN"); value != "" {
+		args = append(args, "--exclude-larger-than="+value)
+	}
+	if os.Getenv("BC_BACKUP_ONE_FILE_SYSTEM") == "true" {
+		args = append(args, "--one-file-system")
+	}
+	if value := os.Getenv("BC_BACKUP_COMPRESSION"); value != "" {
+		args = append(args, "--compression="+value)
+	}
+	if value := os.Getenv("BC_BACKUP_READ_CONCURRENCY"); value != "" {
+		args = append(args, "--read-concurrency="+value)
+	}
+
+	return args, nil
+}
+
+// ResticTagArgs returns the restic --tag flags of the Kubernetes metadata set
+// by the controller, e.g. --tag=namespace=default.
+func ResticTagArgs() []string {
+	var args []string
+	for _, name := range tagNames {
+		if value := os.Getenv("BC_TAG_" + name); value != "" {
+			key := strings.ReplaceAll(strings.ToLower(name), "_", "-")
+			args = append(args, "--tag="+key+"="+value)
+		}
+	}
+	return args
+}
+
+// ResticExitCode returns the exit code of a failed restic command, so that the
+// scripts running the agents can tell a partial snapshot or a locked repository
+// from a fatal error.
+func ResticExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return RESTIC_EXIT_FATAL
+}
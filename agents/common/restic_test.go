@@ -0,0 +1,92 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// setEnv sets the environment variables for the current spec.
+func setEnv(env map[string]string) {
+	for name, value := range env {
+		Expect(os.Setenv(name, value)).To(Succeed())
+		DeferCleanup(os.Unsetenv, name)
+	}
+}
+
+var _ = Describe("restic", func() {
+	DescribeTable("ResticExitCode returns the exit code of the failed commands",
+		func(err func() error, code int) {
+			Expect(ResticExitCode(err())).To(Equal(code))
+		},
+		Entry("partial snapshot", func() error { return exec.Command("sh", "-c", "exit 3").Run() }, RESTIC_EXIT_PARTIAL),
+		Entry("missing repository", func() error { return exec.Command("sh", "-c", "exit 10").Run() }, RESTIC_EXIT_NO_REPOSITORY),
+		Entry("locked repository", func() error { return exec.Command("sh", "-c", "exit 11").Run() }, RESTIC_EXIT_LOCKED),
+		Entry("wrong password", func() error { return exec.Command("sh", "-c", "exit 12").Run() }, RESTIC_EXIT_WRONG_PASSWORD),
+		Entry("wrapped exit error", func() error {
+			return fmt.Errorf("backup failed: %w", exec.Command("sh", "-c", "exit 11").Run())
+		}, RESTIC_EXIT_LOCKED),
+		Entry("command killed by a signal", func() error { return exec.Command("sh", "-c", "kill -9 $$").Run() }, RESTIC_EXIT_FATAL),
+		Entry("command not found", func() error { return exec.Command("/nonexistent/restic").Run() }, RESTIC_EXIT_FATAL),
+		Entry("any other error", func() error { return errors.New("pg_dump failed") }, RESTIC_EXIT_FATAL),
+	)
+
+	DescribeTable("ResticTagArgs returns the tags of the Kubernetes metadata",
+		func(env map[string]string, args []string) {
+			setEnv(env)
+			Expect(ResticTagArgs()).To(Equal(args))
+		},
+		Entry("without any metadata", map[string]string{}, nil),
+		Entry("in the order of the names", map[string]string{
+			"BC_TAG_CLUSTER":    "production",
+			"BC_TAG_NAMESPACE":  "default",
+			"BC_TAG_OWNER_KIND": "StatefulSet",
+			"BC_TAG_OWNER_NAME": "db",
+			"BC_TAG_REPLICA":    "0",
+		}, []string{
+			"--tag=namespace=default",
+			"--tag=owner-kind=StatefulSet",
+			"--tag=owner-name=db",
+			"--tag=replica=0",
+			"--tag=cluster=production",
+		}),
+	)
+
+	DescribeTable("ResticBackupArgs returns the flags of the backup options",
+		func(env map[string]string, args []string) {
+			setEnv(env)
+			Expect(ResticBackupArgs()).To(Equal(args))
+		},
+		Entry("without any option", map[string]string{}, nil),
+		Entry("with every option", map[string]string{
+			"BC_BACKUP_EXCLUDE":             `["*.tmp","cache/"]`,
+			"BC_BACKUP_EXCLUDE_CACHES":      "true",
+			"BC_BACKUP_EXCLUDE_LARGER_THAN": "1G",
+			"BC_BACKUP_ONE_FILE_SYSTEM":     "true",
+			"BC_BACKUP_COMPRESSION":         "max",
+			"BC_BACKUP_READ_CONCURRENCY":    "4",
+		}, []string{
+			"--exclude=*.tmp",
+			"--exclude=cache/",
+			"--exclude-caches",
+			"--exclude-larger-than=1G",
+			"--one-file-system",
+			"--compression=max",
+			"--read-concurrency=4",
+		}),
+		Entry("with the switches turned off", map[string]string{
+			"BC_BACKUP_EXCLUDE_CACHES":  "false",
+			"BC_BACKUP_ONE_FILE_SYSTEM": "false",
+		}, nil),
+	)
+
+	It("ResticBackupArgs fails on invalid exclude patterns", func() {
+		setEnv(map[string]string{"BC_BACKUP_EXCLUDE": "*.tmp"})
+		_, err := ResticBackupArgs()
+		Expect(err).To(MatchError(ContainSubstring("invalid BC_BACKUP_EXCLUDE")))
+	})
+})
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// The windows searched for the previous run of a schedule, the expressions which
+// never fire within the last one having no previous run.
+var previousRunWindows = []time.Duration{
+	time.Hour,
+	24 * time.Hour,
+	8 * 24 * time.Hour,
+	32 * 24 * time.Hour,
+	367 * 24 * time.Hour,
+	5 * 367 * 24 * time.Hour,
+}
+
+// ParseSchedule parses the standard cron expression of a schedule, in the time
+// zone when it does not set its own (CRON_TZ= or TZ=).
+func ParseSchedule(expression, timeZone string) (cron.Schedule, error) {
+	if timeZone != "" && !strings.HasPrefix(expression, "CRON_TZ=") && !strings.HasPrefix(expression, "TZ=") {
+		expression = "CRON_TZ=" + timeZone + " " + expression
+	}
+	return cron.ParseStandard(expression)
+}
+
+// PreviousRun returns the latest time at or before t the standard cron
+// expression fires, in the time zone when it does not set its own. It fails for
+// the schedules without a previous run, e.g. @every.
+func PreviousRun(expression, timeZone string, t time.Time) (time.Time, error) {
+	schedule, err := ParseSchedule(expression, timeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if _, ok := schedule.(cron.ConstantDelaySchedule); ok {
+		return time.Time{}, fmt.Errorf("the schedule %q has no previous run", expression)
+	}
+
+	// The schedules only fire on whole minutes
+	t = t.Truncate(time.Minute)
+	for _, window := range previousRunWindows {
+		var previous time.Time
+		for next := schedule.Next(t.Add(-window)); !next.IsZero() && !next.After(t); next = schedule.Next(next) {
+			previous = next
+		}
+		if !previous.IsZero() {
+			return previous, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("the schedule %q did not fire in the last five years", expression)
+}
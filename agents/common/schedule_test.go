@@ -0,0 +1,68 @@
+package common
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PreviousRun", func() {
+	paris, err := time.LoadLocation("Europe/Paris")
+	Expect(err).NotTo(HaveOccurred())
+
+	DescribeTable("returns the latest run at or before the time",
+		func(expression, timeZone string, t, previous time.Time) {
+			run, err := PreviousRun(expression, timeZone, t)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(run).To(BeTemporally("==", previous))
+		},
+		Entry("at the time of a run", "0 2 * * *", "",
+			time.Date(2025, 6, 10, 2, 0, 0, 0, time.UTC), time.Date(2025, 6, 10, 2, 0, 0, 0, time.UTC)),
+		Entry("within the minute of a run", "0 2 * * *", "",
+			time.Date(2025, 6, 10, 2, 0, 42, 0, time.UTC), time.Date(2025, 6, 10, 2, 0, 0, 0, time.UTC)),
+		Entry("before the run of the day", "0 2 * * *", "",
+			time.Date(2025, 6, 10, 1, 59, 0, 0, time.UTC), time.Date(2025, 6, 9, 2, 0, 0, 0, time.UTC)),
+		Entry("with steps", "*/15 */6 * * *", "",
+			time.Date(2025, 6, 10, 7, 20, 0, 0, time.UTC), time.Date(2025, 6, 10, 6, 45, 0, 0, time.UTC)),
+		Entry("with a stepped range", "10-40/10 * * * *", "",
+			time.Date(2025, 6, 10, 7, 55, 0, 0, time.UTC), time.Date(2025, 6, 10, 7, 40, 0, 0, time.UTC)),
+		Entry("with ranges and lists", "30 1,13 * * 1-5", "",
+			time.Date(2025, 6, 9, 0, 0, 0, 0, time.UTC), time.Date(2025, 6, 6, 13, 30, 0, 0, time.UTC)),
+		Entry("with the names of the days and months", "0 3 * JAN,JUN SUN", "",
+			time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC), time.Date(2025, 6, 8, 3, 0, 0, 0, time.UTC)),
+		Entry("on either restricted day field", "0 0 1 * MON", "",
+			time.Date(2025, 6, 3, 12, 0, 0, 0, time.UTC), time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)),
+		Entry("monthly across the year", "@monthly", "",
+			time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+		Entry("yearly", "@yearly", "",
+			time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+		Entry("on the leap days", "0 0 29 2 *", "",
+			time.Date(2027, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)),
+		Entry("in the time zone", "0 2 * * *", "Europe/Paris",
+			time.Date(2025, 6, 10, 1, 0, 0, 0, time.UTC), time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC)),
+		Entry("in the time zone of the expression", "CRON_TZ=Europe/Paris 0 2 * * *", "America/New_York",
+			time.Date(2025, 6, 10, 1, 0, 0, 0, time.UTC), time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC)),
+		// 02:30 does not exist on the day the clocks go forward, and exists
+		// twice on the day they go back, when the schedules fire on the second
+		// one like the supervisor does
+		Entry("skipping the missing time of the DST start", "30 2 * * *", "Europe/Paris",
+			time.Date(2025, 3, 30, 12, 0, 0, 0, paris), time.Date(2025, 3, 29, 2, 30, 0, 0, paris)),
+		Entry("once on the repeated time of the DST end", "30 2 * * *", "Europe/Paris",
+			time.Date(2025, 10, 26, 12, 0, 0, 0, paris), time.Date(2025, 10, 26, 1, 30, 0, 0, time.UTC)),
+		Entry("hourly across the DST end", "0 * * * *", "Europe/Paris",
+			time.Date(2025, 10, 26, 1, 30, 0, 0, time.UTC), time.Date(2025, 10, 26, 1, 0, 0, 0, time.UTC)),
+	)
+
+	DescribeTable("fails without a previous run",
+		func(expression, message string) {
+			_, err := PreviousRun(expression, "", time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC))
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("for @every", "@every 1h", "has no previous run"),
+		Entry("for the days which never happen", "0 0 30 2 *", "did not fire in the last five years"),
+		// The day of the week 7 is rejected by the webhooks as well
+		Entry("for the day of the week 7", "0 2 * * 7", "above maximum (6)"),
+		Entry("for an invalid expression", "every day", "expected exactly 5 fields"),
+	)
+})
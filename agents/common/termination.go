@@ -0,0 +1,26 @@
+package common
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// The files of the state directory marking the termination of the pod, shared
+// with the scripts: no run starts once the pod is terminating, and the failures
+// of the run in progress are reported as an interruption once it is aborted.
+const (
+	terminatingFile = "terminating"
+	abortingFile    = "aborting"
+)
+
+// ClearTermination forgets the termination of a previous instance of the agent.
+func ClearTermination() error {
+	for _, name := range []string{terminatingFile, abortingFile} {
+		if err := os.Remove(filepath.Join(StateDir(), name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The file of the state directory holding the report of the latest
+// verification run.
+const verifyReportFile = "last-verify.json"
+
+// The statuses of the verification runs.
+const (
+	VERIFY_VERIFIED = "verified"
+	VERIFY_WARNING  = "warning"
+	VERIFY_FAILED   = "failed"
+)
+
+// VerifyReport is the report of a verification run, written by run-verify.sh:
+// the restore of the latest snapshot of the agent, or of a sample of its files,
+// and its checks.
+type VerifyReport struct {
+	Status    string    `json:"status"`
+	Message   string    `json:"message"`
+	Host      string    `json:"host"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Duration  int64     `json:"duration"`
+
+	// The verified snapshot, and the number of files of the sample when the
+	// files were sampled
+	SnapshotID   string     `json:"snapshotId,omitempty"`
+	SnapshotTime *time.Time `json:"snapshotTime,omitempty"`
+	SampleSize   *int64     `json:"sampleSize,omitempty"`
+
+	// The restored files, how long the restore took, and how many restored files
+	// were compared with the live ones
+	RestoreDuration int64 `json:"restoreDuration"`
+	Files           int64 `json:"files"`
+	Bytes           int64 `json:"bytes"`
+	LiveCompared    int64 `json:"liveCompared"`
+
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// LastVerifyReport returns the report of the latest verification run, or nil
+// when there is none.
+func LastVerifyReport() (*VerifyReport, error) {
+	data, err := os.ReadFile(filepath.Join(StateDir(), verifyReportFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var report VerifyReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rclsilver-org/backup-controller/agents/common"
+)
+
+const (
+	BC_SCHEDULE_TIMEZONE = "BC_SCHEDULE_TIMEZONE"
+)
+
+// bc-cron computes the runs of the cron schedules for the scripts, in the
+// time zone BC_SCHEDULE_TIMEZONE, e.g.
+//
+//	bc-cron previous "0 2 * * *" [time]
+//
+// prints the latest time (seconds since the epoch) at or before the time (now
+// by default) the schedule fires. It exits with 1 when it cannot tell, e.g. for
+// @every.
+func main() {
+	if len(os.Args) < 3 || len(os.Args) > 4 || os.Args[1] != "previous" {
+		fmt.Fprintln(os.Stderr, "usage: bc-cron previous <expression> [time]")
+		os.Exit(2)
+	}
+
+	t := time.Now()
+	if len(os.Args) == 4 {
+		seconds, err := strconv.ParseInt(os.Args[3], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid time %q: %v\n", os.Args[3], err)
+			os.Exit(2)
+		}
+		t = time.Unix(seconds, 0)
+	}
+
+	previous, err := common.PreviousRun(os.Args[2], os.Getenv(BC_SCHEDULE_TIMEZONE), t)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(previous.Unix())
+}
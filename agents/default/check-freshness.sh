@@ -0,0 +1,35 @@
+#!/bin/bash
+
+# Report the overdue backups through the output module: a WARNING when the
+# latest successful backup is older than BC_FRESHNESS_WARN_AFTER seconds, and
+# an error when it is older than BC_FRESHNESS_FAIL_AFTER seconds. The fresh
+# backups are reported by the runs themselves.
+
+set -e
+
+if [ -z "${BC_SCRIPTS_DIR}" ]; then
+  export BC_SCRIPTS_DIR="${BC_ROOT_DIR}/scripts"
+fi
+
+source ${BC_SCRIPTS_DIR}/lib/common.sh
+
+if [ -f "${BC_ENV}" ]; then
+  source ${BC_ENV}
+fi
+load_settings
+
+output_load || exit 1
+
+AGE=$(backup_age)
+if [ -n "${BC_FRESHNESS_FAIL_AFTER}" ] && [ "${AGE}" -gt "${BC_FRESHNESS_FAIL_AFTER}" ]; then
+  log "ERROR: No successful backup in the last $(human_duration "${AGE}")."
+  output_set_error "no successful backup in the last $(human_duration "${AGE}") (fail after $(human_duration "${BC_FRESHNESS_FAIL_AFTER}")) at $(date '+%Y-%m-%d %H:%M:%S')"
+elif [ -n "${BC_FRESHNESS_WARN_AFTER}" ] && [ "${AGE}" -gt "${BC_FRESHNESS_WARN_AFTER}" ]; then
+  # Do not turn the error reported by a failed run into a warning
+  if [ "$(recorded_time last-failure)" -gt "$(recorded_time last-success)" ]; then
+    log "WARNING: The backups are overdue; the failure of the latest run is already reported."
+    exit 0
+  fi
+  log "WARNING: The backups are overdue: no successful backup in the last $(human_duration "${AGE}")."
+  output_set_warning "backups overdue: no successful backup in the last $(human_duration "${AGE}") (warn after $(human_duration "${BC_FRESHNESS_WARN_AFTER}")) at $(date '+%Y-%m-%d %H:%M:%S')"
+fi
@@ -10,7 +10,7 @@ source ${BC_SCRIPTS_DIR}/lib/common.sh
 
 REQUIRED_VARS=("BC_SCHEDULE" "RESTIC_REPOSITORY" "RESTIC_PASSWORD")
 
-check_env_vars "${REQUIRED_VARS[@]}"
+check_env_vars "${REQUIRED_VARS[@]}" || exit 1
 
 if [ -z "${BC_CMD}" ] && [ -z "${BC_BACKUP_DIR}" ]; then
   echo "ERROR: bot BC_CMD and BC_BACKUP_DIR are not set!"
@@ -43,14 +43,141 @@ ALL_VARS=("${DEFAULT_VARS[@]}" "${EXTRA_VARS_ARRAY[@]}")
   done
 ) >${BC_ENV}
 
-# Generate the crontab file
+# The entrypoint stays the main process of the container to handle the
+# termination of the pod: it stops scheduling the runs and lets the run in
+# progress complete within the grace period of the pod, or aborts it (see
+# terminate_runs).
+clear_termination
+CROND_PID=""
+RUN_PID=""
+terminate() {
+  trap - TERM INT
+  log "Terminating the agent."
+  if [ -n "${CROND_PID}" ]; then
+    kill "${CROND_PID}" 2>/dev/null || true
+  fi
+  terminate_runs || true
+  if [ -n "${RUN_PID}" ]; then
+    wait "${RUN_PID}"
+  fi
+  exit
+}
+trap terminate TERM INT
+
+# Run a single maintenance task and exit, e.g. when the agent is run by a CronJob
+if [ "${BC_RUN_ONCE}" == "true" ] && [ -n "${BC_MAINTENANCE_TASK}" ]; then
+  log "Running the ${BC_MAINTENANCE_TASK} task."
+  ${BC_ROOT_DIR}/scripts/run-maintenance.sh "${BC_MAINTENANCE_TASK}" &
+  RUN_PID=$!
+  wait "${RUN_PID}"
+  exit
+fi
+
+# Run a single backup and exit, e.g. when the agent is run by a CronJob
+if [ "${BC_RUN_ONCE}" == "true" ]; then
+  log "Running a single backup."
+  ${BC_ROOT_DIR}/scripts/run-backup.sh &
+  RUN_PID=$!
+  wait "${RUN_PID}"
+  exit
+fi
+
+# write_crontab <schedule> : (re)generate the crontab file, which crond reloads
+# when it changes, and record the active schedule.
 CRON_FILE=/etc/crontabs/root
-(
-  echo "SHELL=/bin/bash"
-  echo "PATH=${PATH}"
-  echo "${BC_SCHEDULE} BC_ROOT_DIR=${BC_ROOT_DIR} ${BC_ROOT_DIR}/scripts/run-backup.sh >> /proc/1/fd/1 2>&1"
-) >${CRON_FILE}
+write_crontab() {
+  (
+    echo "SHELL=/bin/bash"
+    echo "PATH=${PATH}"
+    if [ -n "${BC_SCHEDULE_TIMEZONE}" ]; then
+      echo "CRON_TZ=${BC_SCHEDULE_TIMEZONE}"
+    fi
+    # The backups are triggered by the controller with the central scheduler
+    if [ "${BC_CENTRAL_SCHEDULER}" != "true" ]; then
+      echo "${1} BC_ROOT_DIR=${BC_ROOT_DIR} ${BC_ROOT_DIR}/scripts/run-backup.sh >> /proc/1/fd/1 2>&1"
+    fi
+    # The maintenance tasks with their own schedule
+    for task in forget prune check; do
+      local var="BC_${task^^}_SCHEDULE"
+      if [ -n "${!var}" ]; then
+        echo "${!var} BC_ROOT_DIR=${BC_ROOT_DIR} ${BC_ROOT_DIR}/scripts/run-maintenance.sh ${task} >> /proc/1/fd/1 2>&1"
+      fi
+    done
+    # The verification of the restores, which needs the scratch volume added
+    # when the pod is created
+    if [ -n "${BC_VERIFY_SCHEDULE}" ] && [ -n "${BC_VERIFY_DIR}" ]; then
+      echo "${BC_VERIFY_SCHEDULE} BC_ROOT_DIR=${BC_ROOT_DIR} ${BC_ROOT_DIR}/scripts/run-verify.sh >> /proc/1/fd/1 2>&1"
+    fi
+    # The freshness of the backups
+    if [ -n "${BC_FRESHNESS_WARN_AFTER}${BC_FRESHNESS_FAIL_AFTER}" ]; then
+      echo "${BC_FRESHNESS_CHECK_SCHEDULE:-*/15 * * * *} BC_ROOT_DIR=${BC_ROOT_DIR} ${BC_ROOT_DIR}/scripts/check-freshness.sh >> /proc/1/fd/1 2>&1"
+    fi
+  ) >${CRON_FILE}
+  mkdir -p "${BC_STATE_DIR}"
+  echo "${1}" >"${BC_STATE_DIR}/schedule"
+}
+
+# The freshness checks count from the start of the agent until it completes a
+# backup or finds one in the repository
+record_time started
+if [ -n "${BC_FRESHNESS_WARN_AFTER}${BC_FRESHNESS_FAIL_AFTER}" ]; then
+  seed_last_success &
+fi
+
+# The schedules ConfigMap maintained by the controller is more recent than the
+# variables set when the pod was created
+load_settings
+SCHEDULE="${BC_SCHEDULE}"
+if [ -n "${BC_SCHEDULE_FILE}" ] && [ -s "${BC_SCHEDULE_FILE}" ]; then
+  SCHEDULE="$(cat "${BC_SCHEDULE_FILE}")"
+fi
+write_crontab "${SCHEDULE}"
+if [ "${BC_CENTRAL_SCHEDULER}" == "true" ]; then
+  log "The backups are triggered by the central scheduler on the schedule '${SCHEDULE}'."
+else
+  log "Using the schedule '${SCHEDULE}'."
+fi
+
+# Catch up the run missed while the agent was not running, e.g. when the pod was
+# rescheduled across the scheduled time: crond would only wait for the next one.
+# The central scheduler does not catch up the runs, so that they stay within its
+# limits.
+if [ "${BC_CATCHUP_POLICY}" == "Immediate" ] && [ "${BC_CENTRAL_SCHEDULER}" != "true" ]; then
+  (
+    if ! PREVIOUS=$(cron_previous "${SCHEDULE}" "$(date +%s)"); then
+      log "WARNING: unable to compute the previous run of the schedule '${SCHEDULE}'; not catching up."
+      exit 0
+    fi
+    LAST=$(last_backup_time)
+    if [ "${LAST}" -ge $((PREVIOUS - ${BC_CATCHUP_GRACE_PERIOD:-0})) ]; then
+      log "The latest backup is up to date; no catch-up needed."
+      exit 0
+    fi
+    log "Missed the run of $(printf '%(%Y-%m-%d %H:%M:%S)T' "${PREVIOUS}"); running a catch-up backup."
+    ${BC_ROOT_DIR}/scripts/run-backup.sh >>/proc/1/fd/1 2>&1
+  ) &
+fi
+
+# Watch the schedule and its settings in the background and rewrite the crontab
+# when they change; the runs load the settings themselves
+if [ -n "${BC_SCHEDULE_FILE}" ]; then
+  (
+    SIGNATURE="$(settings_signature)"
+    while sleep "${BC_SCHEDULE_RELOAD_INTERVAL:-30}"; do
+      if [ ! -s "${BC_SCHEDULE_FILE}" ] || [ "$(settings_signature)" == "${SIGNATURE}" ]; then
+        continue
+      fi
+      SIGNATURE="$(settings_signature)"
+      load_settings
+      NEW_SCHEDULE="$(cat "${BC_SCHEDULE_FILE}")"
+      write_crontab "${NEW_SCHEDULE}"
+      log "Reloaded the schedule '${NEW_SCHEDULE}' and its settings."
+    done
+  ) &
+fi
 
 # Start crond in the foreground
 log "Starting crond..."
-exec crond -f -s -m off
+crond -f -s -m off &
+CROND_PID=$!
+wait "${CROND_PID}"
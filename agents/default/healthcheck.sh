@@ -0,0 +1,42 @@
+#!/bin/bash
+
+# Check the freshness of the backups of the agent: fail when the latest
+# successful backup is older than --max-age seconds, BC_FRESHNESS_FAIL_AFTER by
+# default. It is the readiness probe of the agents with a freshness bound.
+
+set -e
+
+if [ -z "${BC_SCRIPTS_DIR}" ]; then
+  export BC_SCRIPTS_DIR="${BC_ROOT_DIR}/scripts"
+fi
+
+source ${BC_SCRIPTS_DIR}/lib/common.sh
+
+MAX_AGE="${BC_FRESHNESS_FAIL_AFTER}"
+while [ $# -gt 0 ]; do
+  case "$1" in
+  --max-age)
+    MAX_AGE="$2"
+    shift 2
+    ;;
+  --max-age=*)
+    MAX_AGE="${1#*=}"
+    shift
+    ;;
+  *)
+    echo "Usage: $0 [--max-age <seconds>]" >&2
+    exit 2
+    ;;
+  esac
+done
+
+AGE=$(backup_age)
+if [ -n "${MAX_AGE}" ] && [ "${AGE}" -gt "${MAX_AGE}" ]; then
+  echo "STALE: no successful backup in the last $(human_duration "${AGE}") (max $(human_duration "${MAX_AGE}"))"
+  exit 1
+fi
+if [ "$(recorded_time last-success)" -eq 0 ]; then
+  echo "OK: no successful backup yet; the agent started $(human_duration "${AGE}") ago"
+else
+  echo "OK: the latest successful backup is $(human_duration "${AGE}") old"
+fi
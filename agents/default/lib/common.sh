@@ -4,12 +4,13 @@ function log() {
   echo "[$(date '+%Y-%m-%d %H:%M:%S')] ${*}"
 }
 
+# check_env_vars <name...> : fail when one of the variables is not set.
 check_env_vars() {
   local vars=("$@")
   for var in "${vars[@]}"; do
     if [ -z "${!var}" ]; then
       echo "ERROR: ${var} is not set!"
-      exit 1
+      return 1
     fi
   done
 }
@@ -26,12 +27,243 @@ if [ -z "${BC_STATE_DIR}" ]; then
   export BC_STATE_DIR=${TMPDIR:-/tmp}/backup-controller-state
 fi
 
+# The functions of the output modules, output_set_report being optional.
+OUTPUT_FUNCTIONS=(output_set_success output_set_unknown output_set_warning output_set_error output_set_report)
+OUTPUT_MODULES=()
+OUTPUT_NOTIFY_MODULES=()
+
+# The CLI notifying the output modules written in Go (icinga, nagios, void).
+BC_NOTIFY=${BC_NOTIFY:-bc-notify}
+
+# The CLI computing the runs of the cron schedules.
+BC_CRON=${BC_CRON:-bc-cron}
+
+# The performance data of the successful backups sent to the output modules
+# through bc-notify, with the variables set by run-backup.sh holding them.
+OUTPUT_DATA=(
+  duration=BACKUP_DURATION
+  files_new=BACKUP_FILES_NEW
+  files_changed=BACKUP_FILES_CHANGED
+  files_unmodified=BACKUP_FILES_UNMODIFIED
+  dirs_new=BACKUP_DIRS_NEW
+  dirs_changed=BACKUP_DIRS_CHANGED
+  dirs_unmodified=BACKUP_DIRS_UNMODIFIED
+  data_blobs=BACKUP_DATA_BLOBS
+  tree_blobs=BACKUP_TREE_BLOBS
+  data_added=BACKUP_DATA_ADDED
+  data_added_packed=BACKUP_DATA_ADDED_PACKED
+  total_files=BACKUP_TOTAL_FILES
+  total_bytes=BACKUP_TOTAL_BYTES
+)
+
+# output_load : initialize the output modules listed in BC_OUTPUT_MODULE,
+# separated by commas, or the void one when it is not set. The modules of
+# bc-notify are notified through it; the other ones are custom modules sourced
+# from BC_OUTPUTS_DIR (see outputs/example.sh), whose functions are renamed
+# after them (e.g. output_set_error__custom). The output_set_* functions notify
+# every module. A module which is not found or fails to initialize is reported
+# and left out, without blocking the others; it fails when none is left.
+output_load() {
+  local modules=() module name fn builtin
+  IFS=',' read -r -a modules <<<"${BC_OUTPUT_MODULE:-void}"
+  builtin=$("${BC_NOTIFY}" --list 2>/dev/null || true)
+  OUTPUT_MODULES=()
+  OUTPUT_NOTIFY_MODULES=()
+  for module in "${modules[@]}"; do
+    module="${module//[[:space:]]/}"
+    if [ -z "${module}" ]; then
+      continue
+    fi
+
+    if grep -qxF "${module}" <<<"${builtin}"; then
+      if ! "${BC_NOTIFY}" --module "${module}" --init; then
+        log "ERROR: Failed to initialize the '${module}' output module. Please check your configuration."
+        continue
+      fi
+      OUTPUT_NOTIFY_MODULES+=("${module}")
+    elif [ -f "${BC_OUTPUTS_DIR}/${module}.sh" ]; then
+      unset -f output_init "${OUTPUT_FUNCTIONS[@]}"
+      source "${BC_OUTPUTS_DIR}/${module}.sh"
+      if ! output_init; then
+        log "ERROR: Failed to initialize the '${module}' output module. Please check your configuration."
+        continue
+      fi
+
+      name="${module//[^a-zA-Z0-9_]/_}"
+      for fn in "${OUTPUT_FUNCTIONS[@]}"; do
+        if declare -F "${fn}" >/dev/null; then
+          eval "$(declare -f "${fn}" | sed "1s/^${fn} /${fn}__${name} /")"
+        fi
+      done
+      OUTPUT_MODULES+=("${module}")
+    else
+      log "ERROR: Output module '${module}' not found!"
+      continue
+    fi
+
+    if [ -n "${BC_OUTPUT_MODULE}" ]; then
+      log "Output module '${module}' initialized successfully."
+    fi
+  done
+  unset -f output_init "${OUTPUT_FUNCTIONS[@]}"
+
+  for fn in "${OUTPUT_FUNCTIONS[@]}"; do
+    eval "${fn}() { output_notify ${fn} \"\$@\"; }"
+  done
+
+  [ $((${#OUTPUT_MODULES[@]} + ${#OUTPUT_NOTIFY_MODULES[@]})) -gt 0 ]
+}
+
+# output_notify <function> <args...> : call the function of every output module
+# defining it. A module failing is reported, and the others still notified. The
+# modules of bc-notify send the result of a backup run from its report, so the
+# state following output_set_report is not sent to them again.
+output_notify() {
+  local fn="$1" module name entry value args=()
+  shift
+  for module in "${OUTPUT_MODULES[@]}"; do
+    name="${module//[^a-zA-Z0-9_]/_}"
+    if declare -F "${fn}__${name}" >/dev/null; then
+      "${fn}__${name}" "$@" || log "WARNING: the '${module}' output module failed to run ${fn}."
+    fi
+  done
+
+  # bc-notify reports the failures of each module itself
+  if [ "${#OUTPUT_NOTIFY_MODULES[@]}" -eq 0 ]; then
+    return 0
+  fi
+  if [ "${fn}" = "output_set_report" ]; then
+    OUTPUT_REPORTED=true
+    "${BC_NOTIFY}" --module "$(IFS=','; echo "${OUTPUT_NOTIFY_MODULES[*]}")" report "$1" ||
+      log "WARNING: bc-notify failed to run ${fn}."
+    return 0
+  fi
+  if [ -n "${OUTPUT_REPORTED}" ]; then
+    OUTPUT_REPORTED=""
+    return 0
+  fi
+  if [ "${fn}" = "output_set_success" ]; then
+    for entry in "${OUTPUT_DATA[@]}"; do
+      value="${entry#*=}"
+      value="${!value}"
+      if [ -n "${value}" ]; then
+        args+=(--data "${entry%%=*}=${value}")
+      fi
+    done
+  fi
+  "${BC_NOTIFY}" --module "$(IFS=','; echo "${OUTPUT_NOTIFY_MODULES[*]}")" --state "${fn#output_set_}" --message "$*" "${args[@]}" ||
+    log "WARNING: bc-notify failed to run ${fn}."
+}
+
+# human_duration <seconds> : print the duration, e.g. 1h 2m 3s.
+human_duration() {
+  local seconds="$1" human
+  human="$((seconds % 60))s"
+  if [ "${seconds}" -ge 60 ]; then
+    human="$(((seconds % 3600) / 60))m ${human}"
+  fi
+  if [ "${seconds}" -ge 3600 ]; then
+    human="$((seconds / 3600))h ${human}"
+  fi
+  echo "${human}"
+}
+
+# human_bytes <bytes> : print the size, e.g. 1.5 GiB.
+human_bytes() {
+  jq -rn --argjson bytes "$1" '
+    if $bytes >= 1073741824 then "\($bytes / 107374182.4 | floor / 10) GiB"
+    elif $bytes >= 1048576 then "\($bytes / 104857.6 | floor / 10) MiB"
+    elif $bytes >= 1024 then "\($bytes / 102.4 | floor / 10) KiB"
+    else "\($bytes) B" end'
+}
+
 # Return a stable, per-repository key derived from RESTIC_REPOSITORY so that
 # lock files and maintenance markers of distinct backups never collide.
 repo_key() {
   echo -n "${RESTIC_REPOSITORY}" | md5sum | cut -d' ' -f1
 }
 
+# repo_lock_file : print the lock file of the runs against the repository,
+# BC_LOCK_FILE when set.
+repo_lock_file() {
+  echo "${BC_LOCK_FILE:-${TMPDIR:-/tmp}/backup-controller-$(repo_key).lock}"
+}
+
+# terminating : succeed once the pod of the agent is terminating; no run starts
+# then.
+terminating() {
+  [ -f "${BC_STATE_DIR}/terminating" ]
+}
+
+# aborting : succeed once the run in progress is aborted because the pod is
+# terminating; its failures are reported as an interruption.
+aborting() {
+  [ -f "${BC_STATE_DIR}/aborting" ]
+}
+
+# clear_termination : forget the termination of a previous instance of the
+# agent.
+clear_termination() {
+  rm -f "${BC_STATE_DIR}/terminating" "${BC_STATE_DIR}/aborting"
+}
+
+# terminate_runs : on the termination of the pod, prevent the new runs and wait
+# for the run in progress, which holds the lock of the repository, up to the
+# grace period of the pod (BC_TERMINATION_GRACE_PERIOD, default 30s) minus the
+# time kept to abort it (BC_TERMINATION_ABORT_PERIOD, default 15s). Past it,
+# the run is aborted: restic is interrupted, which removes its lock, and the
+# scripts report the interruption. Fails when the run did not exit in time.
+terminate_runs() {
+  local grace="${BC_TERMINATION_GRACE_PERIOD:-30}" abort="${BC_TERMINATION_ABORT_PERIOD:-15}"
+  local lock_file deadline pid signaled=" "
+  lock_file=$(repo_lock_file)
+  if [ "${abort}" -gt "${grace}" ]; then
+    abort="${grace}"
+  fi
+
+  mkdir -p "${BC_STATE_DIR}"
+  touch "${BC_STATE_DIR}/terminating"
+  if flock -w $((grace - abort)) "${lock_file}" true; then
+    return 0
+  fi
+
+  log "The run in progress did not complete within $((grace - abort))s; aborting it."
+  touch "${BC_STATE_DIR}/aborting"
+  deadline=$(($(date +%s) + abort))
+  until flock -n "${lock_file}" true; do
+    if [ "$(date +%s)" -ge "${deadline}" ]; then
+      log "WARNING: the aborted run did not exit within ${abort}s."
+      return 1
+    fi
+    # Interrupt each restic command once, a second signal skipping its cleanup,
+    # but let the aborted run unlock the repository
+    for pid in $(pgrep -x restic); do
+      if [[ "${signaled}" != *" ${pid} "* ]] && [[ "$(tr '\0' ' ' <"/proc/${pid}/cmdline" 2>/dev/null)" != *" unlock"* ]]; then
+        kill -INT "${pid}" 2>/dev/null || true
+        signaled+="${pid} "
+      fi
+    done
+    sleep 1
+  done
+  log "The run in progress was aborted."
+}
+
+# The last run of the maintenance tasks run every BC_<TASK>_INTERVAL seconds is
+# recorded in the repository itself, as a tiny snapshot tagged with the task and
+# its time, so that it survives the restarts of the agents and is shared by all
+# the agents of the repository. The older records are forgotten along with the
+# snapshots by the forget task.
+MAINTENANCE_TAG="backup-controller-maintenance"
+
+# maintenance_last <task> : print the time (seconds since the epoch) <task> last
+# ran for the repository, or 0 if it never did.
+maintenance_last() {
+  local last
+  last=$(restic snapshots --no-lock --json --tag "${MAINTENANCE_TAG},task=$1" 2>/dev/null |
+    jq -r '[.[].tags[] | select(startswith("time=")) | ltrimstr("time=") | tonumber] | max // 0')
+  echo "${last:-0}"
+}
+
 # maintenance_due <task> <interval_seconds>
 # Succeed (task is due) when the interval is empty or <= 0 (which preserves the
 # legacy "run on every backup" behavior), or when <task> last ran for the
@@ -41,19 +273,651 @@ maintenance_due() {
   if [ "${interval}" -le 0 ] 2>/dev/null; then
     return 0
   fi
-  local marker="${BC_STATE_DIR}/$(repo_key).${task}"
-  if [ ! -f "${marker}" ]; then
-    return 0
-  fi
   local last now
-  last=$(cat "${marker}" 2>/dev/null || echo 0)
+  last=$(maintenance_last "${task}")
   now=$(date +%s)
   [ $((now - last)) -ge "${interval}" ]
 }
 
-# maintenance_mark <task> : record that <task> just completed for the repository.
+# maintenance_mark <task> : record that <task> just completed for the repository,
+# when it runs every BC_<TASK>_INTERVAL seconds after the backups, the only case
+# where the record is read (see maintenance_due).
 maintenance_mark() {
-  local task="$1"
+  local task="$1" interval="BC_${1^^}_INTERVAL" schedule="BC_${1^^}_SCHEDULE" now
+  if [ -n "${!schedule}" ] || ! [ "${!interval:-0}" -gt 0 ] 2>/dev/null; then
+    return 0
+  fi
+  now=$(date +%s)
+  echo "${now}" | restic backup --quiet --stdin --stdin-filename "maintenance-${task}" \
+    --host backup-controller --tag "${MAINTENANCE_TAG}" --tag "task=${task}" --tag "time=${now}" >/dev/null
+}
+
+# restic_capture <args...> : run restic, keeping its error output, still
+# printed, in RESTIC_ERRORS. Returns the exit code of restic.
+restic_capture() {
+  { RESTIC_ERRORS=$(restic "$@" 2>&1 >&3 3>&- | tee -a /dev/stderr; exit "${PIPESTATUS[0]}"); } 3>&1
+}
+
+# maintenance_run <task> : run the forget, prune or check maintenance task and
+# record it. The retention of forget is BC_RETENTION_DAYS, grouped by the tags
+# set by restic_tag_args; the check also reads BC_CHECK_READ_DATA_SUBSET. The
+# error output of restic is kept in RESTIC_ERRORS (see restic_capture).
+maintenance_run() {
+  local task="$1" args=()
+  case "${task}" in
+  forget)
+    restic_tag_args
+    args=(forget -d "${BC_RETENTION_DAYS}" -c --keep-tag "${MAINTENANCE_TAG}")
+    # The snapshots taken before the agents tagged them are forgotten along
+    # the ones of their host without any tag (selected by an empty --tag)
+    if [ ${#BC_FORGET_ARGS[@]} -gt 0 ]; then
+      restic_capture "${args[@]}" "--host=${RESTIC_HOST:-${HOSTNAME}}" --tag= || return $?
+    fi
+    args+=("${BC_FORGET_ARGS[@]}")
+    # Only the latest record of each task is kept, the paths telling the tasks
+    # apart
+    restic_capture forget --quiet --host backup-controller --tag "${MAINTENANCE_TAG}" --group-by paths --keep-last 1 || return $?
+    ;;
+  prune)
+    args=(prune)
+    ;;
+  check)
+    args=(check)
+    if [ -n "${BC_CHECK_READ_DATA_SUBSET}" ]; then
+      args+=("--read-data-subset=${BC_CHECK_READ_DATA_SUBSET}")
+    fi
+    ;;
+  *)
+    log "ERROR: unknown maintenance task '${task}'."
+    return 1
+    ;;
+  esac
+
+  restic_capture "${args[@]}" || return $?
+  maintenance_mark "${task}" || log "WARNING: could not record the ${task} run (continuing)."
+}
+
+# restic_backup_args : fill the BC_BACKUP_ARGS array with the restic backup
+# flags matching the BC_BACKUP_* options set by the controller. The exclude
+# patterns are passed as a JSON array.
+restic_backup_args() {
+  BC_BACKUP_ARGS=()
+  if [ -n "${BC_BACKUP_EXCLUDE}" ]; then
+    local pattern
+    while IFS= read -r pattern; do
+      BC_BACKUP_ARGS+=("--exclude=${pattern}")
+    done < <(echo "${BC_BACKUP_EXCLUDE}" | jq -r '.[]')
+  fi
+  if [ "${BC_BACKUP_EXCLUDE_CACHES}" = "true" ]; then
+    BC_BACKUP_ARGS+=("--exclude-caches")
+  fi
+  if [ -n "${BC_BACKUP_EXCLUDE_LARGER_THAN}" ]; then
+    BC_BACKUP_ARGS+=("--exclude-larger-than=${BC_BACKUP_EXCLUDE_LARGER_THAN}")
+  fi
+  if [ "${BC_BACKUP_ONE_FILE_SYSTEM}" = "true" ]; then
+    BC_BACKUP_ARGS+=("--one-file-system")
+  fi
+  if [ -n "${BC_BACKUP_COMPRESSION}" ]; then
+    BC_BACKUP_ARGS+=("--compression=${BC_BACKUP_COMPRESSION}")
+  fi
+  if [ -n "${BC_BACKUP_READ_CONCURRENCY}" ]; then
+    BC_BACKUP_ARGS+=("--read-concurrency=${BC_BACKUP_READ_CONCURRENCY}")
+  fi
+}
+
+# restic_tag_args : fill the BC_TAG_ARGS array with the --tag flags of the
+# Kubernetes metadata set by the controller, and BC_FORGET_ARGS with the flags
+# grouping the retention by host, paths and these tags. The pod tag is left out
+# of the grouping so that a renamed pod keeps the snapshot history of its
+# predecessors, the replicas of a StatefulSet are told apart by the replica
+# tag; without any tag, restic groups by host and paths as before.
+# BC_IDENTITY_TAGS holds the grouping tags, comma-separated.
+restic_tag_args() {
+  BC_TAG_ARGS=()
+  BC_FORGET_ARGS=()
+  BC_IDENTITY_TAGS=""
+  local identity=() name tag
+  for name in NAMESPACE OWNER_KIND OWNER_NAME POD REPLICA POLICY SCHEDULE CLUSTER; do
+    local var="BC_TAG_${name}"
+    if [ -z "${!var}" ]; then
+      continue
+    fi
+    tag="$(echo "${name}" | tr 'A-Z_' 'a-z-')=${!var}"
+    BC_TAG_ARGS+=("--tag=${tag}")
+    if [ "${name}" != "POD" ]; then
+      identity+=("${tag}")
+    fi
+  done
+  if [ ${#identity[@]} -gt 0 ]; then
+    BC_IDENTITY_TAGS="$(IFS=','; echo "${identity[*]}")"
+    BC_FORGET_ARGS=("--tag=${BC_IDENTITY_TAGS}" "--group-by=host,paths,tags")
+  fi
+}
+
+# agent_snapshot_args : set AGENT_SNAPSHOT_ARGS to the `restic snapshots` flags
+# selecting the snapshots of the agent: those of its host, and of its paths
+# when it backs up BC_BACKUP_DIR as a whole. The replicas of a StatefulSet
+# sharing a host are told apart by their replica tag.
+agent_snapshot_args() {
+  local path paths
+  AGENT_SNAPSHOT_ARGS=("--host=${RESTIC_HOST:-${HOSTNAME}}")
+  if [ -n "${BC_TAG_REPLICA}" ]; then
+    AGENT_SNAPSHOT_ARGS+=("--tag=replica=${BC_TAG_REPLICA}")
+  fi
+  if [ -z "${BC_CMD}" ] && [ -z "${BC_BACKUP_INCLUDE}" ]; then
+    IFS=':' read -r -a paths <<<"${BC_BACKUP_DIR}"
+    for path in "${paths[@]}"; do
+      AGENT_SNAPSHOT_ARGS+=("--path=${path}")
+    done
+  fi
+}
+
+# last_backup_time : print the time (seconds since the epoch) of the latest
+# snapshot of the agent, or 0 if there is none. The maintenance records are
+# left out.
+last_backup_time() {
+  local last
+  agent_snapshot_args
+  # restic prints the local time of the snapshots with their UTC offset
+  last=$(restic snapshots --no-lock --json --latest 1 "${AGENT_SNAPSHOT_ARGS[@]}" 2>/dev/null | jq -r --arg tag "${MAINTENANCE_TAG}" '
+    [.[] | select((.tags // []) | index($tag) | not) | .time
+      | (.[0:19] + "Z" | fromdateiso8601)
+        - ((capture("(?<sign>[+-])(?<h>[0-9]{2}):(?<m>[0-9]{2})$") // {sign: "+", h: "0", m: "0"})
+          | ((.h | tonumber) * 3600 + (.m | tonumber) * 60) * (if .sign == "-" then -1 else 1 end))
+    ] | max // 0')
+  echo "${last:-0}"
+}
+
+# cron_previous <expression> <time> : print the latest time (seconds since the
+# epoch) at or before <time> the standard cron <expression> fires, in the time
+# zone BC_SCHEDULE_TIMEZONE, and fail when it cannot tell, e.g. for @every.
+cron_previous() {
+  BC_SCHEDULE_TIMEZONE="${BC_SCHEDULE_TIMEZONE}" "${BC_CRON}" previous "$1" "$2"
+}
+
+# load_settings : export the settings of the schedule and of the policy the
+# controller publishes in the schedules ConfigMap next to the schedule, more
+# recent than the variables set when the pod was created. An empty value drops
+# a setting.
+load_settings() {
+  local file name value
+  for file in "${BC_SCHEDULE_FILE:+${BC_SCHEDULE_FILE}.env}" "${BC_POLICY_FILE}"; do
+    if [ -z "${file}" ] || [ ! -s "${file}" ]; then
+      continue
+    fi
+    while IFS='=' read -r name value; do
+      if [[ "${name}" =~ ^BC_[A-Z_]+$ ]]; then
+        export "${name}=${value}"
+      fi
+    done <"${file}"
+  done
+}
+
+# settings_signature : print the content of the schedule and settings files
+# published by the controller, to detect their changes.
+settings_signature() {
+  cat "${BC_SCHEDULE_FILE}" "${BC_SCHEDULE_FILE}.env" ${BC_POLICY_FILE:+"${BC_POLICY_FILE}"} 2>/dev/null
+}
+
+# active_schedule : print the cron expression the agent currently runs on.
+active_schedule() {
+  if [ -s "${BC_STATE_DIR}/schedule" ]; then
+    cat "${BC_STATE_DIR}/schedule"
+  else
+    echo "${BC_SCHEDULE}"
+  fi
+}
+
+# active_blackout <time> : print the end and the reason of the blackout window
+# in effect at <time> (seconds since the epoch), as published by the controller
+# next to the schedule, and fail when there is none.
+active_blackout() {
+  local file="${BC_SCHEDULE_FILE}.blackouts"
+  if [ -z "${BC_SCHEDULE_FILE}" ] || [ ! -s "${file}" ]; then
+    return 1
+  fi
+  awk -v t="$1" '$1 <= t && t < $2 && $2 > end { end = $2; $1 = $2 = ""; reason = substr($0, 3) } END { if (!end) exit 1; print end, reason }' "${file}"
+}
+
+# hooks_kubeconfig : write the kubeconfig the hooks run with, authenticating
+# with the token of the hooks ServiceAccount the controller mounts in the agent
+# at BC_HOOKS_TOKEN_DIR rather than with the one of the pod, and print its path.
+hooks_kubeconfig() {
+  local file="${TMPDIR:-/tmp}/backup-controller-hooks.kubeconfig"
+  local host="${KUBERNETES_SERVICE_HOST}"
+  case "${host}" in
+  *:*) host="[${host}]" ;;
+  esac
+  if [ ! -s "${file}" ]; then
+    cat >"${file}" <<EOF
+apiVersion: v1
+kind: Config
+clusters:
+- name: cluster
+  cluster:
+    server: https://${host}:${KUBERNETES_SERVICE_PORT}
+    certificate-authority: ${BC_HOOKS_TOKEN_DIR}/ca.crt
+users:
+- name: hooks
+  user:
+    tokenFile: ${BC_HOOKS_TOKEN_DIR}/token
+contexts:
+- name: hooks
+  context:
+    cluster: cluster
+    user: hooks
+    namespace: ${BC_POD_NAMESPACE}
+current-context: hooks
+EOF
+  fi
+  echo "${file}"
+}
+
+# HOOK_DENIED_RETRIES and HOOK_DENIED_DELAY bound the retries of a hook denied
+# by the API server.
+HOOK_DENIED_RETRIES=6
+HOOK_DENIED_DELAY=10
+
+# hook_denied <output> : tell whether a hook failed because the agent is not
+# allowed to exec into the pod yet, or has no token yet.
+hook_denied() {
+  [ ! -s "${BC_HOOKS_TOKEN_DIR}/token" ] || echo "$1" | grep -qiE '^error.*(forbidden|unauthorized)'
+}
+
+# run_hooks <pre|post> : run the hooks of the phase (BC_HOOKS_PRE or
+# BC_HOOKS_POST, set by the controller) in the containers of the application
+# pod through `kubectl exec`, each one bounded by its timeout. The pre hooks
+# stop at the first failure of a hook failing the backup, while the post hooks
+# all run. The output of the hooks is appended to HOOKS_OUTPUT, a one-line
+# summary to HOOKS_REPORT, and HOOKS_ERROR is set when a hook failing the
+# backup fails.
+#
+# The controller only grants the exec on a pod, and creates the token, once it
+# sees the pod: the first hooks of a new pod may be denied until it catches up,
+# so a denied hook is retried up to HOOK_DENIED_RETRIES times.
+run_hooks() {
+  local phase="$1" hooks hook name container timeout on_error output rc kubeconfig attempt
+  local -a command
+  local line
+  if [ "${phase}" = "pre" ]; then
+    hooks="${BC_HOOKS_PRE}"
+  else
+    hooks="${BC_HOOKS_POST}"
+  fi
+  if [ -z "${hooks}" ]; then
+    return 0
+  fi
+  kubeconfig=$(hooks_kubeconfig)
+
+  while IFS= read -r hook; do
+    # The name ends up in the status messages, which some outputs embed in JSON
+    name=$(echo "${hook}" | jq -r '.name // .container | gsub("[\"\\\\\n]"; "")')
+    container=$(echo "${hook}" | jq -r '.container')
+    timeout=$(echo "${hook}" | jq -r '.timeout')
+    on_error=$(echo "${hook}" | jq -r '.onError')
+    eval "command=($(echo "${hook}" | jq -r '.command | map(@sh) | join(" ")'))"
+
+    log "Running the ${phase} hook '${name}' in the container '${container}'."
+    attempt=0
+    while :; do
+      rc=0
+      output=$(timeout "${timeout}" kubectl exec --kubeconfig "${kubeconfig}" --namespace "${BC_POD_NAMESPACE}" \
+        "${BC_POD_NAME}" --container "${container}" -- "${command[@]}" 2>&1) || rc=$?
+      if [ "${rc}" -eq 0 ] || [ "${attempt}" -ge "${HOOK_DENIED_RETRIES}" ] || ! hook_denied "${output}"; then
+        break
+      fi
+      attempt=$((attempt + 1))
+      log "The ${phase} hook '${name}' was denied, retrying in ${HOOK_DENIED_DELAY}s (${attempt}/${HOOK_DENIED_RETRIES})."
+      sleep "${HOOK_DENIED_DELAY}"
+    done
+    if [ -n "${output}" ]; then
+      while IFS= read -r line; do
+        echo "[${phase}:${name}] ${line}"
+      done <<<"${output}"
+      HOOKS_OUTPUT+="[${phase}:${name}]"$'\n'"${output}"$'\n'
+    fi
+
+    if [ "${rc}" -eq 0 ]; then
+      HOOKS_REPORT+="${HOOKS_REPORT:+, }${phase}:${name} ok"
+      continue
+    fi
+    # timeout exits with 124 (143 with busybox) when the hook overran
+    if [ "${rc}" -eq 124 ] || [ "${rc}" -eq 143 ]; then
+      log "ERROR: The ${phase} hook '${name}' timed out after ${timeout}s."
+      HOOKS_REPORT+="${HOOKS_REPORT:+, }${phase}:${name} timed out"
+      phase_error "${phase} hook ${name} timed out after ${timeout}s"
+    else
+      log "ERROR: The ${phase} hook '${name}' failed (exit code ${rc})."
+      HOOKS_REPORT+="${HOOKS_REPORT:+, }${phase}:${name} failed (${rc})"
+      phase_error "${phase} hook ${name} failed with exit code ${rc}"
+    fi
+    if [ "${on_error}" = "Continue" ]; then
+      log "Continuing despite the failure of the ${phase} hook '${name}'."
+      continue
+    fi
+    HOOKS_ERROR="${phase} hook ${name} failed"
+    if [ "${phase}" = "pre" ]; then
+      return 1
+    fi
+  done < <(echo "${hooks}" | jq -c '.[]')
+
+  [ -z "${HOOKS_ERROR}" ]
+}
+
+# The freshness checks compare the time of the latest successful backup of the
+# agent, recorded in the state directory, to BC_FRESHNESS_WARN_AFTER and
+# BC_FRESHNESS_FAIL_AFTER. Until the agent completes or finds a backup, the time
+# it started stands for it, so that it gets the time to run the first one.
+
+# record_time <started|last-success|last-failure> [<time>] : record the time of
+# the event, now by default.
+record_time() {
+  mkdir -p "${BC_STATE_DIR}"
+  echo "${2:-$(date +%s)}" >"${BC_STATE_DIR}/$1"
+}
+
+# recorded_time <started|last-success|last-failure> : print the recorded time
+# of the event, or 0.
+recorded_time() {
+  local t=0
+  if [ -s "${BC_STATE_DIR}/$1" ]; then
+    t=$(cat "${BC_STATE_DIR}/$1")
+  fi
+  echo "${t}"
+}
+
+# seed_last_success : record the time of the latest backup of the agent found
+# in the repository, e.g. after a restart, unless it already completed one.
+seed_last_success() {
+  local last
+  if [ "$(recorded_time last-success)" -gt 0 ]; then
+    return 0
+  fi
+  last=$(last_backup_time)
+  if [ "${last}" -gt 0 ] && [ "$(recorded_time last-success)" -lt "${last}" ]; then
+    record_time last-success "${last}"
+  fi
+}
+
+# backup_age : print the age, in seconds, of the latest successful backup.
+backup_age() {
+  local last
+  last=$(recorded_time last-success)
+  if [ "${last}" -eq 0 ]; then
+    last=$(recorded_time started)
+  fi
+  echo $(($(date +%s) - last))
+}
+
+# The report of a backup run is a JSON document with the phases of the run,
+# their timings and errors, and the figures of the restic summary. It is
+# written to ${BC_STATE_DIR}/last-run.json, exported as BACKUP_REPORT_FILE and
+# handed to the output modules defining output_set_report.
+
+# report_init : start the report of a run.
+report_init() {
+  REPORT_START=$(date +%s)
+  REPORT_PHASES="[]"
+  REPORT_PHASE=""
+  REPORT_WRITTEN=""
+  REPORT_WARNINGS="[]"
+  RESTIC_SUMMARY=""
+  RESTIC_UNREADABLE="[]"
+}
+
+# phase_start <name> : start a phase of the run.
+phase_start() {
+  REPORT_PHASE="$1"
+  REPORT_PHASE_START=$(date +%s)
+  REPORT_PHASE_ERRORS="[]"
+  REPORT_PHASE_ATTEMPTS="[]"
+}
+
+# phase_error <message> : record an error of the current phase.
+phase_error() {
+  if [ -z "${REPORT_PHASE}" ]; then
+    return 0
+  fi
+  REPORT_PHASE_ERRORS=$(jq -c --arg message "$1" '. + [$message]' <<<"${REPORT_PHASE_ERRORS}")
+}
+
+# phase_attempt <started> <code> [error] : record an attempt of the current
+# phase, which ended with the exit code <code>.
+phase_attempt() {
+  if [ -z "${REPORT_PHASE}" ]; then
+    return 0
+  fi
+  REPORT_PHASE_ATTEMPTS=$(jq -c --argjson started "$1" --argjson ended "$(date +%s)" \
+    --argjson code "$2" --arg error "$3" '
+    . + [{attempt: (length + 1), startTime: ($started | todate), endTime: ($ended | todate),
+      exitCode: $code} + (if $error == "" then {} else {error: $error} end)]' <<<"${REPORT_PHASE_ATTEMPTS}")
+}
+
+# report_warning <message> : record a warning of the run, which completes with
+# the warning status.
+report_warning() {
+  log "WARNING: $1"
+  REPORT_WARNINGS=$(jq -c --arg message "$1" '. + [$message]' <<<"${REPORT_WARNINGS}")
+}
+
+# report_warnings : print the warnings of the run, separated by semicolons.
+report_warnings() {
+  jq -r 'join("; ")' <<<"${REPORT_WARNINGS}"
+}
+
+# phase_end <succeeded|warning|failed> : end the current phase, with its
+# attempts when it was retried.
+phase_end() {
+  if [ -z "${REPORT_PHASE}" ]; then
+    return 0
+  fi
+  REPORT_PHASES=$(jq -c --arg name "${REPORT_PHASE}" --arg status "$1" \
+    --argjson started "${REPORT_PHASE_START}" --argjson ended "$(date +%s)" \
+    --argjson errors "${REPORT_PHASE_ERRORS}" --argjson attempts "${REPORT_PHASE_ATTEMPTS:-[]}" '
+    . + [{name: $name, status: $status, startTime: ($started | todate), endTime: ($ended | todate),
+      duration: ($ended - $started)} + (if $errors == [] then {} else {errors: $errors} end)
+      + (if ($attempts | length) > 1 then {attempts: $attempts} else {} end)]' <<<"${REPORT_PHASES}")
+  REPORT_PHASE=""
+}
+
+# report_write <succeeded|warning|failed|skipped> <message> : end the run,
+# ending the current phase with it, and write its report with the warnings and
+# the files restic could not read.
+report_write() {
+  local status="$1" message="$2" file="${BC_STATE_DIR}/last-run.json"
+  if [ -n "${REPORT_PHASE}" ]; then
+    if [ "${status}" = "failed" ]; then
+      phase_error "${message}"
+      phase_end failed
+    else
+      phase_end succeeded
+    fi
+  fi
+
   mkdir -p "${BC_STATE_DIR}"
-  echo "$(date +%s)" >"${BC_STATE_DIR}/$(repo_key).${task}"
+  jq -n --arg status "${status}" --arg message "${message}" \
+    --arg host "${RESTIC_HOST:-${HOSTNAME}}" --arg schedule "$(active_schedule)" \
+    --argjson scheduled "${SCHEDULED_TIME:-${REPORT_START}}" --argjson started "${REPORT_START}" \
+    --argjson ended "$(date +%s)" --argjson phases "${REPORT_PHASES}" \
+    --argjson summary "${RESTIC_SUMMARY:-null}" --argjson warnings "${REPORT_WARNINGS:-[]}" \
+    --argjson unreadable "${RESTIC_UNREADABLE:-[]}" '
+    {
+      status: $status,
+      message: $message,
+      host: $host,
+      schedule: $schedule,
+      scheduledTime: ($scheduled | todate),
+      startTime: ($started | todate),
+      endTime: ($ended | todate),
+      duration: ($ended - $started),
+      phases: $phases
+    } + (if $warnings == [] then {} else {warnings: $warnings} end)
+      + (if $unreadable == [] then {} else {unreadableFiles: $unreadable} end)
+      + if $summary == null then {} else {
+      snapshotId: $summary.snapshot_id,
+      files: {
+        new: $summary.files_new,
+        changed: $summary.files_changed,
+        unmodified: $summary.files_unmodified,
+        processed: $summary.total_files_processed
+      },
+      dirs: {
+        new: $summary.dirs_new,
+        changed: $summary.dirs_changed,
+        unmodified: $summary.dirs_unmodified
+      },
+      bytes: {
+        processed: $summary.total_bytes_processed,
+        added: $summary.data_added,
+        addedPacked: $summary.data_added_packed
+      },
+      summary: $summary
+    } end' >"${file}.tmp" && mv "${file}.tmp" "${file}"
+
+  REPORT_WRITTEN=true
+  export BACKUP_REPORT_FILE="${file}"
+  if declare -F output_set_report >/dev/null; then
+    output_set_report "${file}"
+  fi
+}
+
+# run_backup_command <command...> : run the backup command, which prints the
+# messages of `restic backup --json`, possibly among plain log lines. The
+# progress and the errors are logged, the errors recorded in the current phase,
+# RESTIC_SUMMARY is set to the summary message, RESTIC_UNREADABLE to the JSON
+# array of the files which could not be read and RESTIC_ERRORS to the errors
+# and the plain log lines. Returns the exit code of the command.
+run_backup_command() {
+  local line type item rc=0
+  RESTIC_SUMMARY=""
+  RESTIC_UNREADABLE="[]"
+  RESTIC_ERRORS=""
+  while IFS= read -r line; do
+    type=""
+    if [[ "${line}" == "{"* ]]; then
+      type=$(jq -r '.message_type // empty' <<<"${line}" 2>/dev/null || true)
+    fi
+    case "${type}" in
+    status)
+      log "Progress: $(jq -r '
+        def bytes: if . >= 1073741824 then "\(. / 107374182.4 | floor / 10) GiB"
+          elif . >= 1048576 then "\(. / 104857.6 | floor / 10) MiB"
+          elif . >= 1024 then "\(. / 102.4 | floor / 10) KiB"
+          else "\(.) B" end;
+        "\((.percent_done // 0) * 1000 | floor / 10)% (\(.files_done // 0)/\(.total_files // 0) files, \(.bytes_done // 0 | bytes)/\(.total_bytes // 0 | bytes))"
+        + if .seconds_remaining then ", \(.seconds_remaining)s remaining" else "" end' <<<"${line}")"
+      ;;
+    error)
+      item=$(jq -r '.item // empty' <<<"${line}")
+      if [ -n "${item}" ]; then
+        RESTIC_UNREADABLE=$(jq -c --arg item "${item}" '. + [$item] | unique' <<<"${RESTIC_UNREADABLE}")
+      fi
+      # restic < 0.17 does not serialize the message of the errors
+      line=$(jq -r '"\(.during // "backup") \(.item // ""): \(.error | if type == "object" then .message // "unknown error" else . end)"' <<<"${line}")
+      log "ERROR: ${line}"
+      phase_error "${line}"
+      RESTIC_ERRORS+="${line}"$'\n'
+      ;;
+    summary)
+      RESTIC_SUMMARY="${line}"
+      ;;
+    exit)
+      rc=$(jq -r '.code' <<<"${line}")
+      ;;
+    verbose_status) ;;
+    *)
+      echo "${line}"
+      RESTIC_ERRORS+="${line}"$'\n'
+      ;;
+    esac
+  done < <(
+    rc=0
+    "$@" 2>&1 || rc=$?
+    echo "{\"message_type\":\"exit\",\"code\":${rc}}"
+  )
+  return "${rc}"
+}
+
+# restic_exit_reason <code> : print the meaning of an exit code of restic
+# (>= 0.17).
+restic_exit_reason() {
+  case "$1" in
+  3) echo "some source files could not be read" ;;
+  10) echo "the repository does not exist" ;;
+  11) echo "the repository is locked by another process" ;;
+  12) echo "wrong repository password" ;;
+  130) echo "interrupted" ;;
+  *) echo "exit code $1" ;;
+  esac
+}
+
+# The errors of the backends worth retrying: the connectivity problems and the
+# 5xx responses, e.g. "503 Service Unavailable" or "status code: 502".
+RESTIC_TRANSIENT_ERRORS='connection refused|connection reset|connection timed out|i/o timeout|timeout awaiting|TLS handshake timeout|no such host|[Tt]emporary failure in name resolution|network is unreachable|no route to host|broken pipe|unexpected EOF|server misbehaving|(^|[^0-9])5[0-9]{2} [A-Z][a-z]+|status( code)?:? 5[0-9]{2}([^0-9]|$)|SlowDown|ServiceUnavailable|InternalError'
+
+# restic_transient <code> <errors> : whether a restic command which exited with
+# <code> and printed <errors> failed with a transient error of the backend. The
+# other failures, e.g. a locked or missing repository, a wrong password, files
+# which could not be read or an interruption, are not retried.
+restic_transient() {
+  [ "$1" -eq 1 ] && grep -qE "${RESTIC_TRANSIENT_ERRORS}" <<<"$2"
+}
+
+# with_retries <command...> : run a restic step (run_backup_command or
+# maintenance_run), which sets RESTIC_ERRORS, up to BC_RETRY_MAX_ATTEMPTS
+# (default 1) times while it fails with a transient error. The first retry waits
+# BC_RETRY_BACKOFF seconds (default 30), doubled before each next one. The
+# attempts are recorded in the current phase; no retry starts once the pod is
+# terminating. Returns the exit code of the last attempt.
+with_retries() {
+  local max="${BC_RETRY_MAX_ATTEMPTS:-1}" delay="${BC_RETRY_BACKOFF:-30}" attempt=1 started rc error i
+  while true; do
+    started=$(date +%s)
+    rc=0
+    RESTIC_ERRORS=""
+    "$@" || rc=$?
+    error=""
+    if [ "${rc}" -ne 0 ]; then
+      error=$(grep -v '^[[:space:]]*$' <<<"${RESTIC_ERRORS}" | tail -n 1 || true)
+      error="${error:-$(restic_exit_reason "${rc}")}"
+    fi
+    phase_attempt "${started}" "${rc}" "${error}"
+    if [ "${rc}" -eq 0 ] || [ "${attempt}" -ge "${max}" ] || ! restic_transient "${rc}" "${RESTIC_ERRORS}"; then
+      return "${rc}"
+    fi
+
+    log "WARNING: attempt ${attempt}/${max} failed with a transient error (${error}); retrying in $(human_duration "${delay}")."
+    for ((i = 0; i < delay; i++)); do
+      if terminating; then
+        log "The pod is terminating; not retrying."
+        return "${rc}"
+      fi
+      sleep 1
+    done
+    attempt=$((attempt + 1))
+    delay=$((delay * 2))
+  done
+}
+
+# unreadable_summary [max] : print the number of files restic could not read and
+# the first <max> (10) of them.
+unreadable_summary() {
+  jq -r --argjson max "${1:-10}" '
+    "\(length) file(s) could not be read: \(.[:$max] | join(", "))"
+    + if length > $max then " and \(length - $max) more" else "" end' <<<"${RESTIC_UNREADABLE}"
+}
+
+# agent_latest_snapshot : print the latest snapshot of the agent, as listed by
+# `restic snapshots --json`, or nothing when it has none.
+agent_latest_snapshot() {
+  agent_snapshot_args
+  restic snapshots --no-lock --json --latest 1 "${AGENT_SNAPSHOT_ARGS[@]}" |
+    jq -c --arg tag "${MAINTENANCE_TAG}" '
+      [.[] | select((.tags // []) | index($tag) | not)] | max_by(.time) // empty'
+}
+
+# agent_latest_summary : print the summary of the latest snapshot of the agent,
+# with its ID, for the backup commands which do not print their summary.
+agent_latest_summary() {
+  agent_latest_snapshot | jq -c '(.summary // {}) + {snapshot_id: .id}'
 }
@@ -0,0 +1,188 @@
+package lib
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rclsilver-org/backup-controller/agents/common"
+)
+
+// bash runs the script with the functions of common.sh in the state directory,
+// with the positional arguments, and returns its output. The commands stubbed
+// in the directory are found first.
+func bash(stateDir, script string, args ...string) (string, error) {
+	cmd := exec.Command("bash", append([]string{"-c", "source ./common.sh && " + script, "bash"}, args...)...)
+	cmd.Env = append(os.Environ(), "BC_STATE_DIR="+stateDir, "BC_SCHEDULE=0 2 * * *", "HOSTNAME=app-0",
+		"PATH="+filepath.Join(stateDir, "bin")+":"+os.Getenv("PATH"))
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+// stub replaces the command by a shell script in the directory, appending its
+// arguments to the returned file before running the body.
+func stub(dir, name, body string) string {
+	calls := filepath.Join(dir, name+".calls")
+	Expect(os.MkdirAll(filepath.Join(dir, "bin"), 0o755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "bin", name),
+		[]byte("#!/bin/sh\necho \"$*\" >>"+calls+"\n"+body+"\n"), 0o755)).To(Succeed())
+	return calls
+}
+
+// readCalls returns the arguments of the calls of a stubbed command, one call
+// per line.
+func readCalls(calls string) string {
+	data, err := os.ReadFile(calls)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	Expect(err).NotTo(HaveOccurred())
+	return string(data)
+}
+
+var _ = Describe("common.sh", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	Describe("the run reports", func() {
+		// restic prints the messages of `restic backup --json` among the plain
+		// log lines of the backup commands
+		const backup = `backup() {
+  echo '{"message_type":"status","percent_done":0.5,"files_done":1,"total_files":2,"bytes_done":2048,"total_bytes":4096}'
+  echo '{"message_type":"error","error":{"message":"permission denied"},"during":"archival","item":"/data/a"}'
+  echo '{"message_type":"error","error":"permission denied","item":"/data/b"}'
+  echo 'a plain log line'
+  echo '{"message_type":"summary","snapshot_id":"abcdef","files_new":1,"files_changed":2,"files_unmodified":3,"dirs_new":0,"dirs_changed":1,"dirs_unmodified":4,"data_blobs":7,"tree_blobs":2,"data_added":512,"data_added_packed":256,"total_files_processed":6,"total_bytes_processed":1024}'
+  return 3
+}`
+
+		It("reports the summary and the errors of the backup command", func() {
+			output, err := bash(dir, backup+`
+report_init
+phase_start backup
+rc=0
+run_backup_command backup || rc=$?
+echo "rc=${rc}"
+report_warning "$(unreadable_summary)"
+report_write warning "backup process completed with warnings"`)
+			Expect(err).NotTo(HaveOccurred(), output)
+			Expect(output).To(ContainSubstring("Progress: 50% (1/2 files, 2 KiB/4 KiB)"))
+			Expect(output).To(ContainSubstring("ERROR: archival /data/a: permission denied"))
+			Expect(output).To(ContainSubstring("a plain log line"))
+			Expect(output).To(ContainSubstring("rc=3"))
+
+			report, err := common.ReadRunReport(filepath.Join(dir, "last-run.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Status).To(Equal(common.RUN_WARNING))
+			Expect(report.Host).To(Equal("app-0"))
+			Expect(report.Schedule).To(Equal("0 2 * * *"))
+			Expect(report.Warnings).To(ConsistOf("2 file(s) could not be read: /data/a, /data/b"))
+			Expect(report.UnreadableFiles).To(Equal([]string{"/data/a", "/data/b"}))
+			Expect(report.Phases).To(HaveLen(1))
+			Expect(report.Phases[0].Name).To(Equal("backup"))
+			Expect(report.Phases[0].Status).To(Equal(common.RUN_SUCCEEDED))
+			Expect(report.Phases[0].Errors).To(Equal([]string{
+				"archival /data/a: permission denied",
+				"backup /data/b: permission denied",
+			}))
+
+			Expect(report.SnapshotID).To(Equal("abcdef"))
+			Expect(report.Files.New).To(Equal(int64(1)))
+			Expect(report.Files.Changed).To(Equal(int64(2)))
+			Expect(report.Files.Unmodified).To(Equal(int64(3)))
+			Expect(*report.Files.Processed).To(Equal(int64(6)))
+			Expect(*report.Dirs).To(Equal(common.FileCounts{Changed: 1, Unmodified: 4}))
+			Expect(report.Bytes.Processed).To(Equal(int64(1024)))
+			Expect(report.Bytes.Added).To(Equal(int64(512)))
+			Expect(*report.Bytes.AddedPacked).To(Equal(int64(256)))
+			Expect(report.Summary).To(MatchJSON(`{"message_type":"summary","snapshot_id":"abcdef","files_new":1,"files_changed":2,"files_unmodified":3,"dirs_new":0,"dirs_changed":1,"dirs_unmodified":4,"data_blobs":7,"tree_blobs":2,"data_added":512,"data_added_packed":256,"total_files_processed":6,"total_bytes_processed":1024}`))
+		})
+
+		It("reports the failed runs without a summary", func() {
+			output, err := bash(dir, `
+report_init
+phase_start repository
+report_write failed "wrong repository password"`)
+			Expect(err).NotTo(HaveOccurred(), output)
+
+			report, err := common.ReadRunReport(filepath.Join(dir, "last-run.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Status).To(Equal(common.RUN_FAILED))
+			Expect(report.Message).To(Equal("wrong repository password"))
+			Expect(report.Phases).To(HaveLen(1))
+			Expect(report.Phases[0].Status).To(Equal(common.RUN_FAILED))
+			Expect(report.Phases[0].Errors).To(ConsistOf("wrong repository password"))
+			Expect(report.SnapshotID).To(BeEmpty())
+			Expect(report.Files).To(BeNil())
+			Expect(report.Summary).To(BeNil())
+		})
+
+		It("sends the report to the output modules of bc-notify instead of the state", func() {
+			calls := stub(dir, "bc-notify", `[ "$1" = --list ] && echo void; exit 0`)
+
+			output, err := bash(dir, `BC_OUTPUT_MODULE=void
+output_load
+report_init
+report_write succeeded "done"
+output_set_success "done"
+output_set_warning "overdue"`)
+			Expect(err).NotTo(HaveOccurred(), output)
+
+			Expect(readCalls(calls)).To(Equal("--list\n" +
+				"--module void --init\n" +
+				"--module void report " + filepath.Join(dir, "last-run.json") + "\n" +
+				"--module void --state warning --message overdue\n"))
+		})
+	})
+
+	Describe("the maintenance records", func() {
+		var calls string
+
+		BeforeEach(func() {
+			calls = stub(dir, "restic", "cat >/dev/null")
+		})
+
+		It("records the tasks run every interval after the backups", func() {
+			output, err := bash(dir, `BC_PRUNE_INTERVAL=86400 maintenance_mark prune`)
+			Expect(err).NotTo(HaveOccurred(), output)
+			Expect(readCalls(calls)).To(MatchRegexp(`^backup --quiet --stdin --stdin-filename maintenance-prune ` +
+				`--host backup-controller --tag backup-controller-maintenance --tag task=prune --tag time=[0-9]+\n$`))
+		})
+
+		DescribeTable("does not record the tasks whose record is not read",
+			func(script string) {
+				output, err := bash(dir, script)
+				Expect(err).NotTo(HaveOccurred(), output)
+				Expect(readCalls(calls)).To(BeEmpty())
+			},
+			Entry("run on every backup", `maintenance_mark prune`),
+			Entry("run on every backup with a zero interval", `BC_CHECK_INTERVAL=0 maintenance_mark check`),
+			Entry("run on their own schedule", `BC_PRUNE_INTERVAL=86400 BC_PRUNE_SCHEDULE="0 3 * * 0" maintenance_mark prune`),
+			Entry("without any interval", `maintenance_mark forget`),
+		)
+
+		It("forgets the older records with the snapshots", func() {
+			output, err := bash(dir, `BC_RETENTION_DAYS=7 maintenance_run forget`)
+			Expect(err).NotTo(HaveOccurred(), output)
+			Expect(readCalls(calls)).To(Equal(
+				"forget --quiet --host backup-controller --tag backup-controller-maintenance --group-by paths --keep-last 1\n" +
+					"forget -d 7 -c --keep-tag backup-controller-maintenance\n"))
+		})
+	})
+
+	It("computes the previous run of the schedules with bc-cron in their time zone", func() {
+		calls := stub(dir, "bc-cron", `echo "${BC_SCHEDULE_TIMEZONE}"`)
+
+		output, err := bash(dir, `BC_SCHEDULE_TIMEZONE=Europe/Paris
+cron_previous "0 2 * * *" 1749520800`)
+		Expect(err).NotTo(HaveOccurred(), output)
+		Expect(output).To(Equal("Europe/Paris\n"))
+		Expect(readCalls(calls)).To(Equal("previous 0 2 * * * 1749520800\n"))
+	})
+})
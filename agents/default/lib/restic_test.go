@@ -0,0 +1,235 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rclsilver-org/backup-controller/agents/common"
+)
+
+// runBackup runs run-backup.sh with the commands stubbed in the directory and
+// returns its output.
+func runBackup(dir string, env ...string) (string, error) {
+	scripts, err := filepath.Abs("..")
+	Expect(err).NotTo(HaveOccurred())
+
+	cmd := exec.Command("bash", filepath.Join(scripts, "run-backup.sh"))
+	cmd.Env = append(os.Environ(),
+		"BC_SCRIPTS_DIR="+scripts,
+		"BC_ENV="+filepath.Join(dir, "env"),
+		"BC_STATE_DIR="+dir,
+		"BC_LOCK_FILE="+filepath.Join(dir, "lock"),
+		"BC_OUTPUT_MODULE=void",
+		"BC_RETENTION_DAYS=0",
+		"BC_CMD=backup-command",
+		"RESTIC_REPOSITORY=s3:backups/app",
+		"HOSTNAME=app-0",
+		"PATH="+filepath.Join(dir, "bin")+":"+os.Getenv("PATH"),
+	)
+	cmd.Env = append(cmd.Env, env...)
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+var _ = Describe("restic", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	DescribeTable("tells the meaning of the exit codes",
+		func(code, reason string) {
+			output, err := bash(dir, `restic_exit_reason `+code)
+			Expect(err).NotTo(HaveOccurred(), output)
+			Expect(output).To(Equal(reason + "\n"))
+		},
+		Entry("partial snapshot", "3", "some source files could not be read"),
+		Entry("missing repository", "10", "the repository does not exist"),
+		Entry("locked repository", "11", "the repository is locked by another process"),
+		Entry("wrong password", "12", "wrong repository password"),
+		Entry("interruption", "130", "interrupted"),
+		Entry("any other code", "1", "exit code 1"),
+	)
+
+	DescribeTable("tells the transient errors of the backends",
+		func(code int, errors string, transient bool) {
+			_, err := bash(dir, `restic_transient "$1" "$2"`, strconv.Itoa(code), errors)
+			if transient {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("refused connection", 1, "Fatal: dial tcp 10.0.0.1:443: connect: connection refused", true),
+		Entry("reset connection", 1, "read tcp: connection reset by peer", true),
+		Entry("i/o timeout", 1, "dial tcp: i/o timeout", true),
+		Entry("TLS handshake timeout", 1, "net/http: TLS handshake timeout", true),
+		Entry("unknown host", 1, "dial tcp: lookup minio: no such host", true),
+		Entry("DNS failure", 1, "Temporary failure in name resolution", true),
+		Entry("5xx response", 1, "Load(<lock/12>): 503 Service Unavailable", true),
+		Entry("5xx status code", 1, "unexpected HTTP response (status code: 502)", true),
+		Entry("S3 throttling", 1, "api error SlowDown: Please reduce your request rate", true),
+		Entry("truncated response", 1, "unexpected EOF", true),
+		Entry("error among other lines", 1, "repository opened\nconnection refused\n", true),
+		Entry("4xx response", 1, "404 Not Found", false),
+		Entry("number looking like a 5xx", 1, "1503 Files processed", false),
+		Entry("5xx status code within a number", 1, "status code: 5023", false),
+		Entry("wrong password", 1, "Fatal: wrong password or no key found", false),
+		Entry("other exit code", 11, "connection refused", false),
+		Entry("partial snapshot", 3, "connection reset", false),
+	)
+
+	DescribeTable("returns the exit code of the backup command",
+		func(code string) {
+			output, err := bash(dir, `rc=0; run_backup_command sh -c "echo some output; exit `+code+`" >/dev/null || rc=$?; echo "${rc}"`)
+			Expect(err).NotTo(HaveOccurred(), output)
+			Expect(output).To(Equal(code + "\n"))
+		},
+		Entry("success", "0"),
+		Entry("partial snapshot", "3"),
+		Entry("missing repository", "10"),
+		Entry("locked repository", "11"),
+		Entry("wrong password", "12"),
+	)
+
+	Describe("with_retries", func() {
+		// attempt prints the errors of the file of the next attempt, and exits
+		// with its code
+		const attempt = `attempt() {
+  local n=$(($(cat "${BC_STATE_DIR}/attempts" 2>/dev/null || echo 0) + 1))
+  echo "${n}" >"${BC_STATE_DIR}/attempts"
+  RESTIC_ERRORS=$(cat "${BC_STATE_DIR}/errors-${n}")
+  return $(cat "${BC_STATE_DIR}/code-${n}")
+}
+report_init
+phase_start backup
+`
+
+		// attempts writes the exit codes and the errors of the attempts, as
+		// "<code> <errors>".
+		attempts := func(results ...string) {
+			for i, result := range results {
+				code, errors, _ := strings.Cut(result, " ")
+				Expect(os.WriteFile(filepath.Join(dir, fmt.Sprintf("code-%d", i+1)), []byte(code), 0o644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(dir, fmt.Sprintf("errors-%d", i+1)), []byte(errors), 0o644)).To(Succeed())
+			}
+		}
+
+		// backupPhase ends the run and returns the backup phase of its report.
+		backupPhase := func(script string) common.PhaseReport {
+			output, err := bash(dir, attempt+script+`
+report_write succeeded done`)
+			Expect(err).NotTo(HaveOccurred(), output)
+			report, err := common.ReadRunReport(filepath.Join(dir, "last-run.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Phases).To(HaveLen(1))
+			return report.Phases[0]
+		}
+
+		It("retries the transient errors up to the maximum attempts", func() {
+			attempts("1 connection refused", "1 503 Service Unavailable", "1 connection refused")
+			phase := backupPhase(`rc=0; BC_RETRY_MAX_ATTEMPTS=3 BC_RETRY_BACKOFF=0 with_retries attempt || rc=$?; echo "rc=${rc}"`)
+
+			Expect(phase.Attempts).To(HaveLen(3))
+			Expect(phase.Attempts[0].Error).To(Equal("connection refused"))
+			Expect(phase.Attempts[1].Error).To(Equal("503 Service Unavailable"))
+			Expect(phase.Attempts[2].ExitCode).To(Equal(1))
+		})
+
+		It("stops retrying once an attempt succeeds", func() {
+			attempts("1 connection refused", "0 ")
+			phase := backupPhase(`BC_RETRY_MAX_ATTEMPTS=3 BC_RETRY_BACKOFF=0 with_retries attempt`)
+
+			Expect(phase.Attempts).To(HaveLen(2))
+			Expect(phase.Attempts[1].ExitCode).To(Equal(0))
+			Expect(phase.Attempts[1].Error).To(BeEmpty())
+		})
+
+		It("does not retry the other errors", func() {
+			attempts("12 Fatal: wrong password or no key found", "0 ")
+			phase := backupPhase(`rc=0; BC_RETRY_MAX_ATTEMPTS=3 BC_RETRY_BACKOFF=0 with_retries attempt || rc=$?; [ "${rc}" -eq 12 ]`)
+
+			// A single attempt is not listed
+			Expect(phase.Attempts).To(BeEmpty())
+			Expect(os.ReadFile(filepath.Join(dir, "attempts"))).To(Equal([]byte("1\n")))
+		})
+
+		It("reports the meaning of the exit code without any error output", func() {
+			attempts("11 ", "11 ")
+			output, err := bash(dir, attempt+`rc=0; with_retries attempt || rc=$?; echo "${REPORT_PHASE_ATTEMPTS}"`)
+			Expect(err).NotTo(HaveOccurred(), output)
+			Expect(output).To(ContainSubstring(`"exitCode":11,"error":"the repository is locked by another process"`))
+		})
+	})
+
+	Describe("run-backup.sh", func() {
+		BeforeEach(func() {
+			stub(dir, "bc-notify", `[ "$1" = --list ] && echo void; exit 0`)
+			// The repository probe exits with PROBE_CODE, the listing of the
+			// snapshots finds none
+			stub(dir, "restic", `case "$1" in
+cat) exit "${PROBE_CODE:-0}" ;;
+snapshots) echo "[]" ;;
+esac`)
+			// The backup command exits with BACKUP_CODE, printing the summary
+			// unless it failed
+			stub(dir, "backup-command", `[ "${BACKUP_CODE:-0}" -le 3 ] &&
+  echo '{"message_type":"summary","snapshot_id":"abcdef","files_new":1,"data_added":512}'
+[ "${BACKUP_CODE:-0}" -eq 3 ] &&
+  echo '{"message_type":"error","error":{"message":"permission denied"},"during":"archival","item":"/data/a"}'
+exit "${BACKUP_CODE:-0}"`)
+		})
+
+		DescribeTable("classifies the outcome of the run by the exit codes of restic",
+			func(env []string, status, message string, failed bool) {
+				output, err := runBackup(dir, env...)
+				if failed {
+					Expect(err).To(HaveOccurred(), output)
+				} else {
+					Expect(err).NotTo(HaveOccurred(), output)
+				}
+
+				report, err := common.ReadRunReport(filepath.Join(dir, "last-run.json"))
+				Expect(err).NotTo(HaveOccurred(), output)
+				Expect(report.Status).To(Equal(status), output)
+				Expect(report.Message).To(ContainSubstring(message))
+				Expect(readCalls(filepath.Join(dir, "bc-notify.calls"))).To(HaveSuffix(
+					"--module void report " + filepath.Join(dir, "last-run.json") + "\n"))
+			},
+			Entry("success", nil, common.RUN_SUCCEEDED, "backup process completed successfully", false),
+			Entry("partial snapshot", []string{"BACKUP_CODE=3"}, common.RUN_WARNING,
+				"partial snapshot: 1 file(s) could not be read: /data/a", false),
+			Entry("locked repository", []string{"BACKUP_CODE=11"}, common.RUN_WARNING,
+				"backup skipped: the repository is locked by another process", false),
+			Entry("wrong password of the backup", []string{"BACKUP_CODE=12"}, common.RUN_FAILED,
+				"backup command execution failed (wrong repository password)", true),
+			Entry("fatal error", []string{"BACKUP_CODE=1"}, common.RUN_FAILED,
+				"backup command execution failed (exit code 1)", true),
+			Entry("wrong password of the repository", []string{"PROBE_CODE=12"}, common.RUN_FAILED,
+				"restic repository authentication failed (wrong password)", true),
+			Entry("unreachable repository", []string{"PROBE_CODE=1"}, common.RUN_FAILED,
+				"restic repository unreachable (exit code 1)", true),
+		)
+
+		It("records the successful runs, partial snapshots included", func() {
+			output, err := runBackup(dir, "BACKUP_CODE=3")
+			Expect(err).NotTo(HaveOccurred(), output)
+			Expect(filepath.Join(dir, "last-success")).To(BeAnExistingFile())
+		})
+
+		It("removes the stale locks and retries once when the repository is locked", func() {
+			output, err := runBackup(dir, "BACKUP_CODE=11")
+			Expect(err).NotTo(HaveOccurred(), output)
+			Expect(readCalls(filepath.Join(dir, "backup-command.calls"))).To(Equal("\n\n"))
+			Expect(filepath.Join(dir, "last-success")).NotTo(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,14 @@
+package lib
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestLib(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Lib Suite")
+}
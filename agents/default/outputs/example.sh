@@ -1,5 +1,10 @@
 #!/bin/bash
 
+# A custom output module, used when BC_OUTPUT_MODULE lists "example". The
+# modules shipped with the agents (icinga, nagios, void) are written in Go and
+# run through bc-notify; the custom ones are sourced from BC_OUTPUTS_DIR and
+# define the functions below.
+
 function output_init() {
   echo "Initialization of the output module"
 }
@@ -19,3 +24,9 @@ function output_set_warning() {
 function output_set_error() {
   echo "Output is set to 'error' with message: ${*}"
 }
+
+# Optional: called with the path of the JSON report of each backup run before
+# its status is set, e.g. to forward the figures of the run.
+function output_set_report() {
+  echo "Output got the run report: $(jq -c '{status, snapshotId, duration}' "${1}")"
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"sort"
 
 	"github.com/rclsilver-org/backup-controller/agents/common"
 )
@@ -81,6 +82,12 @@ func (o *icingaOutput) SetUnknown(ctx context.Context, err error) error {
 	return o.send(ctx, 3, err.Error(), nil)
 }
 
+func (o *icingaOutput) SetReport(ctx context.Context, report *common.RunReport) error {
+	code, output, data := reportResult(report)
+	slog.InfoContext(ctx, "sending the run report to Icinga", "host", o.host, "service", o.service, "status", report.Status)
+	return o.send(ctx, code, output, data)
+}
+
 type status struct {
 	Type            string   `json:"type"`
 	Filter          string   `json:"filter"`
@@ -98,8 +105,13 @@ func (o *icingaOutput) send(ctx context.Context, exitStatus int, output string,
 		PluginOutput: output,
 		Pretty:       true,
 	}
-	for k, v := range performanceData {
-		status.PerformanceData = append(status.PerformanceData, fmt.Sprintf("%s=%v", k, v))
+	keys := make([]string, 0, len(performanceData))
+	for k := range performanceData {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		status.PerformanceData = append(status.PerformanceData, fmt.Sprintf("%s=%v", k, performanceData[k]))
 	}
 
 	slog.DebugContext(ctx, "preparing to send status to icinga",
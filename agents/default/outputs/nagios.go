@@ -0,0 +1,111 @@
+package outputs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+
+	"github.com/rclsilver-org/backup-controller/agents/common"
+)
+
+const (
+	NAGIOS_OUTPUT = "nagios"
+
+	BC_OUTPUT_NAGIOS_NSCA_HOST   = "BC_OUTPUT_NAGIOS_NSCA_HOST"
+	BC_OUTPUT_NAGIOS_NSCA_CONFIG = "BC_OUTPUT_NAGIOS_NSCA_CONFIG"
+	BC_OUTPUT_NAGIOS_HOST        = "BC_OUTPUT_NAGIOS_HOST"
+	BC_OUTPUT_NAGIOS_SERVICE     = "BC_OUTPUT_NAGIOS_SERVICE"
+)
+
+// The send_nsca command submitting the passive check results, replaced by the
+// tests.
+var sendNSCACommand = "send_nsca"
+
+func init() {
+	register(NAGIOS_OUTPUT, &nagiosOutput{})
+}
+
+type nagiosOutput struct {
+	nscaHost   string
+	nscaConfig string
+
+	host    string
+	service string
+}
+
+func (o *nagiosOutput) Name() string {
+	return NAGIOS_OUTPUT
+}
+
+func (o *nagiosOutput) Init() error {
+	if err := common.RequiredEnvVar(BC_OUTPUT_NAGIOS_NSCA_HOST, BC_OUTPUT_NAGIOS_HOST, BC_OUTPUT_NAGIOS_SERVICE); err != nil {
+		return err
+	}
+
+	o.nscaHost = common.GetEnv(BC_OUTPUT_NAGIOS_NSCA_HOST, "")
+	o.nscaConfig = common.GetEnv(BC_OUTPUT_NAGIOS_NSCA_CONFIG, "/etc/send_nsca.cfg")
+
+	o.host = common.GetEnv(BC_OUTPUT_NAGIOS_HOST, "")
+	o.service = common.GetEnv(BC_OUTPUT_NAGIOS_SERVICE, "")
+
+	slog.Debug("nagios output initialized",
+		"nsca_host", o.nscaHost,
+		"nsca_config", o.nscaConfig,
+		"host", o.host,
+		"service", o.service)
+
+	return nil
+}
+
+func (o *nagiosOutput) SetSuccess(ctx context.Context, msg string, data map[string]any) error {
+	slog.InfoContext(ctx, "sending SUCCESS status to Nagios", "host", o.host, "service", o.service, "message", msg)
+	return o.send(ctx, 0, "OK - "+msg)
+}
+
+func (o *nagiosOutput) SetWarning(ctx context.Context, err error) error {
+	slog.InfoContext(ctx, "sending WARNING status to Nagios", "host", o.host, "service", o.service, "error", err.Error())
+	return o.send(ctx, 1, "WARNING - "+err.Error())
+}
+
+func (o *nagiosOutput) SetError(ctx context.Context, err error) error {
+	slog.InfoContext(ctx, "sending ERROR status to Nagios", "host", o.host, "service", o.service, "error", err.Error())
+	return o.send(ctx, 2, "KO - "+err.Error())
+}
+
+func (o *nagiosOutput) SetUnknown(ctx context.Context, err error) error {
+	slog.InfoContext(ctx, "sending UNKNOWN status to Nagios", "host", o.host, "service", o.service, "error", err.Error())
+	return o.send(ctx, 3, "UNKNOWN - "+err.Error())
+}
+
+func (o *nagiosOutput) SetReport(ctx context.Context, report *common.RunReport) error {
+	code, output, _ := reportResult(report)
+	slog.InfoContext(ctx, "sending the run report to Nagios", "host", o.host, "service", o.service, "status", report.Status)
+	return o.send(ctx, code, nagiosPrefixes[code]+output)
+}
+
+// The prefixes of the outputs, by exit status.
+var nagiosPrefixes = []string{"OK - ", "WARNING - ", "KO - ", "UNKNOWN - "}
+
+// send submits a passive check result through send_nsca. NSCA reads a result
+// per line, so only the first line of the output is sent.
+func (o *nagiosOutput) send(ctx context.Context, code int, output string) error {
+	output, _, _ = strings.Cut(output, "\n")
+
+	cmd := exec.CommandContext(ctx, sendNSCACommand, "-H", o.nscaHost, "-c", o.nscaConfig)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s\t%s\t%d\t%s\n", o.host, o.service, code, output))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("unable to send the status: %w: %s", err, msg)
+		}
+		return fmt.Errorf("unable to send the status: %w", err)
+	}
+
+	slog.InfoContext(ctx, "status successfully sent to Nagios", "host", o.host, "service", o.service, "exit_status", code)
+	return nil
+}
@@ -2,8 +2,11 @@ package outputs
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sort"
 	"strings"
 	"sync"
 
@@ -17,6 +20,7 @@ type output interface {
 	SetWarning(ctx context.Context, err error) error
 	SetError(ctx context.Context, err error) error
 	SetUnknown(ctx context.Context, err error) error
+	SetReport(ctx context.Context, report *common.RunReport) error
 }
 
 var (
@@ -26,10 +30,20 @@ var (
 	outputModules []output
 )
 
+// Init initializes the output modules listed in BC_OUTPUT_MODULE.
 func Init(ctx context.Context) error {
-	outputTypes := common.GetEnv("BC_OUTPUT_MODULE", "")
+	return InitModules(ctx, common.GetEnv("BC_OUTPUT_MODULE", ""))
+}
+
+// InitModules initializes the output modules of the comma-separated list. A
+// module which is not found or fails to initialize is reported and left out,
+// without blocking the others: it only fails when none of the listed modules
+// could be initialized.
+func InitModules(ctx context.Context, modules string) error {
+	var errs []error
+	outputModules = nil
 
-	for _, outputType := range strings.Split(outputTypes, ",") {
+	for _, outputType := range strings.Split(modules, ",") {
 		outputType = strings.TrimSpace(outputType)
 		if outputType == "" {
 			continue
@@ -37,50 +51,163 @@ func Init(ctx context.Context) error {
 
 		output, ok := outputs[outputType]
 		if !ok {
-			return fmt.Errorf("output module %q not found", outputType)
+			err := fmt.Errorf("output module %q not found", outputType)
+			slog.ErrorContext(ctx, "unable to load output module", "module", outputType, "error", err)
+			errs = append(errs, err)
+			continue
 		}
 
 		slog.Default().DebugContext(ctx, "initializing output module", "module", outputType)
 		if err := output.Init(); err != nil {
-			return fmt.Errorf("unable to initialize output module %q: %w", outputType, err)
+			err = fmt.Errorf("unable to initialize output module %q: %w", outputType, err)
+			slog.ErrorContext(ctx, "unable to initialize output module", "module", outputType, "error", err)
+			errs = append(errs, err)
+			continue
 		}
 
 		outputModules = append(outputModules, output)
 	}
 
+	if len(outputModules) == 0 && len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
 	return nil
 }
 
-func SetSuccess(ctx context.Context, msg string, data map[string]any) {
+// Modules returns the names of the registered output modules.
+func Modules() []string {
+	outputsMut.Lock()
+	defer outputsMut.Unlock()
+
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetSuccess notifies the output modules of a success. The errors of the
+// modules are logged and returned, the other modules being notified anyway.
+func SetSuccess(ctx context.Context, msg string, data map[string]any) error {
+	var errs []error
 	for _, m := range outputModules {
 		if err := m.SetSuccess(ctx, msg, data); err != nil {
 			slog.ErrorContext(ctx, "unable to send success state", "module", m.Name(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name(), err))
 		}
 	}
+	return errors.Join(errs...)
 }
 
-func SetUnknown(ctx context.Context, e error) {
+// SetUnknown notifies the output modules of an unknown state, like SetSuccess.
+func SetUnknown(ctx context.Context, e error) error {
+	var errs []error
 	for _, m := range outputModules {
 		if err := m.SetUnknown(ctx, e); err != nil {
 			slog.ErrorContext(ctx, "unable to send unknown state", "module", m.Name(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name(), err))
 		}
 	}
+	return errors.Join(errs...)
 }
 
-func SetWarning(ctx context.Context, e error) {
+// SetWarning notifies the output modules of a warning, like SetSuccess.
+func SetWarning(ctx context.Context, e error) error {
+	var errs []error
 	for _, m := range outputModules {
 		if err := m.SetWarning(ctx, e); err != nil {
 			slog.ErrorContext(ctx, "unable to send warning state", "module", m.Name(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name(), err))
 		}
 	}
+	return errors.Join(errs...)
 }
 
-func SetError(ctx context.Context, e error) {
+// SetError notifies the output modules of an error, like SetSuccess.
+func SetError(ctx context.Context, e error) error {
+	var errs []error
 	for _, m := range outputModules {
 		if err := m.SetError(ctx, e); err != nil {
 			slog.ErrorContext(ctx, "unable to send error state", "module", m.Name(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetReport notifies the output modules of the result of a backup run, from its
+// report, like SetSuccess.
+func SetReport(ctx context.Context, report *common.RunReport) error {
+	var errs []error
+	for _, m := range outputModules {
+		if err := m.SetReport(ctx, report); err != nil {
+			slog.ErrorContext(ctx, "unable to send the run report", "module", m.Name(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reportResult returns the check result of a backup run: its exit status, its
+// output, followed by the files restic could not read, and the performance data
+// of the snapshot it created, if any.
+func reportResult(report *common.RunReport) (int, string, map[string]any) {
+	code, output := 0, report.Message
+	switch report.Status {
+	case common.RUN_WARNING:
+		code = 1
+	case common.RUN_SKIPPED:
+		code, output = 1, "skipped: "+output
+	case common.RUN_FAILED:
+		code = 2
+	case common.RUN_SUCCEEDED:
+	default:
+		code = 3
+	}
+	if len(report.UnreadableFiles) > 0 {
+		output += "\nUnreadable files:\n" + strings.Join(report.UnreadableFiles, "\n")
+	}
+
+	if report.Summary == nil {
+		return code, output, nil
+	}
+	data := map[string]any{"duration": report.Duration}
+	if report.Files != nil {
+		data["files_new"] = report.Files.New
+		data["files_changed"] = report.Files.Changed
+		data["files_unmodified"] = report.Files.Unmodified
+		if report.Files.Processed != nil {
+			data["total_files"] = *report.Files.Processed
+		}
+	}
+	if report.Dirs != nil {
+		data["dirs_new"] = report.Dirs.New
+		data["dirs_changed"] = report.Dirs.Changed
+		data["dirs_unmodified"] = report.Dirs.Unmodified
+	}
+	if report.Bytes != nil {
+		data["data_added"] = report.Bytes.Added
+		data["total_bytes"] = report.Bytes.Processed
+		if report.Bytes.AddedPacked != nil {
+			data["data_added_packed"] = *report.Bytes.AddedPacked
+		}
+	}
+	// The blobs are only part of the raw summary
+	var blobs struct {
+		DataBlobs *int64 `json:"data_blobs"`
+		TreeBlobs *int64 `json:"tree_blobs"`
+	}
+	if err := json.Unmarshal(report.Summary, &blobs); err == nil {
+		if blobs.DataBlobs != nil {
+			data["data_blobs"] = *blobs.DataBlobs
+		}
+		if blobs.TreeBlobs != nil {
+			data["tree_blobs"] = *blobs.TreeBlobs
 		}
 	}
+	return code, output, data
 }
 
 func register(name string, obj output) {
@@ -0,0 +1,244 @@
+package outputs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rclsilver-org/backup-controller/agents/common"
+)
+
+// setEnv sets the environment variables for the current spec.
+func setEnv(env map[string]string) {
+	for name, value := range env {
+		Expect(os.Setenv(name, value)).To(Succeed())
+		DeferCleanup(os.Unsetenv, name)
+	}
+}
+
+// icingaServer serves the Icinga API, recording the statuses it receives and
+// answering with the given code.
+func icingaServer(code int, received *[]status) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		Expect(r.URL.Path).To(Equal("/v1/actions/process-check-result"))
+		user, pass, ok := r.BasicAuth()
+		Expect(ok).To(BeTrue())
+		Expect(user).To(Equal("user"))
+		Expect(pass).To(Equal("pass"))
+
+		var st status
+		Expect(json.NewDecoder(r.Body).Decode(&st)).To(Succeed())
+		*received = append(*received, st)
+		w.WriteHeader(code)
+	}))
+	DeferCleanup(server.Close)
+	return server
+}
+
+// fakeSendNSCA replaces send_nsca by a script writing the results it reads to
+// the returned file.
+func fakeSendNSCA() string {
+	dir := GinkgoT().TempDir()
+	results := filepath.Join(dir, "results")
+	script := filepath.Join(dir, "send_nsca")
+	Expect(os.WriteFile(script, []byte("#!/bin/sh\ncat >>"+results+"\n"), 0o755)).To(Succeed())
+
+	previous := sendNSCACommand
+	sendNSCACommand = script
+	DeferCleanup(func() { sendNSCACommand = previous })
+	return results
+}
+
+var _ = Describe("Outputs", func() {
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		DeferCleanup(func() { outputModules = nil })
+	})
+
+	It("lists the registered modules", func() {
+		Expect(Modules()).To(Equal([]string{ICINGA_OUTPUT, NAGIOS_OUTPUT, VOID_OUTPUT}))
+	})
+
+	Describe("InitModules", func() {
+		It("leaves out the modules which are not found or fail to initialize", func() {
+			Expect(InitModules(ctx, "missing, icinga,void")).To(Succeed())
+			Expect(outputModules).To(HaveLen(1))
+			Expect(outputModules[0].Name()).To(Equal(VOID_OUTPUT))
+		})
+
+		It("fails when none of the modules could be initialized", func() {
+			err := InitModules(ctx, "missing,icinga")
+			Expect(err).To(MatchError(ContainSubstring(`output module "missing" not found`)))
+			Expect(err).To(MatchError(ContainSubstring(`unable to initialize output module "icinga"`)))
+		})
+
+		It("succeeds without any module", func() {
+			Expect(InitModules(ctx, "")).To(Succeed())
+			Expect(outputModules).To(BeEmpty())
+		})
+	})
+
+	Describe("icinga", func() {
+		var received []status
+
+		BeforeEach(func() {
+			received = nil
+		})
+
+		initIcinga := func(code int) {
+			server := icingaServer(code, &received)
+			setEnv(map[string]string{
+				BC_OUTPUT_ICINGA_API_URL: server.URL,
+				BC_OUTPUT_ICINGA_USER:    "user",
+				BC_OUTPUT_ICINGA_PASS:    "pass",
+				BC_OUTPUT_ICINGA_HOST:    "host",
+				BC_OUTPUT_ICINGA_SERVICE: "backup",
+			})
+			Expect(InitModules(ctx, ICINGA_OUTPUT)).To(Succeed())
+		}
+
+		It("sends the success with the sorted performance data", func() {
+			initIcinga(http.StatusOK)
+			Expect(SetSuccess(ctx, "done", map[string]any{"files_new": 3, "duration": "12"})).To(Succeed())
+
+			Expect(received).To(HaveLen(1))
+			Expect(received[0].Filter).To(Equal(`host.name=="host" && service.name=="backup"`))
+			Expect(received[0].ExitStatus).To(Equal(0))
+			Expect(received[0].PluginOutput).To(Equal("done"))
+			Expect(received[0].PerformanceData).To(Equal([]string{"duration=12", "files_new=3"}))
+		})
+
+		It("sends the warnings with their long output", func() {
+			initIcinga(http.StatusOK)
+			Expect(SetWarning(ctx, errors.New("partial snapshot\nUnreadable files:\n/data/a"))).To(Succeed())
+
+			Expect(received).To(HaveLen(1))
+			Expect(received[0].ExitStatus).To(Equal(1))
+			Expect(received[0].PluginOutput).To(Equal("partial snapshot\nUnreadable files:\n/data/a"))
+		})
+
+		It("sends the result of a backup run with the figures of its snapshot", func() {
+			initIcinga(http.StatusOK)
+			processed, packed := int64(6), int64(256)
+			Expect(SetReport(ctx, &common.RunReport{
+				Status:          common.RUN_WARNING,
+				Message:         "backup process completed with warnings",
+				Duration:        60,
+				UnreadableFiles: []string{"/data/a", "/data/b"},
+				Files:           &common.FileCounts{New: 1, Changed: 2, Unmodified: 3, Processed: &processed},
+				Dirs:            &common.FileCounts{Changed: 1, Unmodified: 4},
+				Bytes:           &common.ByteCounts{Processed: 1024, Added: 512, AddedPacked: &packed},
+				Summary:         json.RawMessage(`{"data_blobs": 7, "tree_blobs": 2}`),
+			})).To(Succeed())
+
+			Expect(received).To(HaveLen(1))
+			Expect(received[0].ExitStatus).To(Equal(1))
+			Expect(received[0].PluginOutput).To(Equal("backup process completed with warnings\nUnreadable files:\n/data/a\n/data/b"))
+			Expect(received[0].PerformanceData).To(Equal([]string{
+				"data_added=512", "data_added_packed=256", "data_blobs=7", "dirs_changed=1", "dirs_new=0",
+				"dirs_unmodified=4", "duration=60", "files_changed=2", "files_new=1", "files_unmodified=3",
+				"total_bytes=1024", "total_files=6", "tree_blobs=2",
+			}))
+		})
+
+		It("sends the result of a backup run without a snapshot", func() {
+			initIcinga(http.StatusOK)
+			Expect(SetReport(ctx, &common.RunReport{Status: common.RUN_FAILED, Message: "repository locked"})).To(Succeed())
+
+			Expect(received).To(HaveLen(1))
+			Expect(received[0].ExitStatus).To(Equal(2))
+			Expect(received[0].PluginOutput).To(Equal("repository locked"))
+			Expect(received[0].PerformanceData).To(BeEmpty())
+		})
+
+		It("fails on an unexpected status code", func() {
+			initIcinga(http.StatusInternalServerError)
+			Expect(SetError(ctx, errors.New("failed"))).To(MatchError(ContainSubstring("unexpected status code: 500")))
+		})
+	})
+
+	Describe("nagios", func() {
+		It("requires its configuration", func() {
+			Expect(InitModules(ctx, NAGIOS_OUTPUT)).To(MatchError(ContainSubstring(BC_OUTPUT_NAGIOS_NSCA_HOST)))
+		})
+
+		It("sends the first line of the states through send_nsca", func() {
+			results := fakeSendNSCA()
+			setEnv(map[string]string{
+				BC_OUTPUT_NAGIOS_NSCA_HOST: "nsca",
+				BC_OUTPUT_NAGIOS_HOST:      "host",
+				BC_OUTPUT_NAGIOS_SERVICE:   "backup",
+			})
+			Expect(InitModules(ctx, NAGIOS_OUTPUT)).To(Succeed())
+
+			Expect(SetSuccess(ctx, "done", nil)).To(Succeed())
+			Expect(SetWarning(ctx, errors.New("partial snapshot\n/data/a"))).To(Succeed())
+			Expect(SetError(ctx, errors.New("failed"))).To(Succeed())
+			Expect(SetUnknown(ctx, errors.New("no backup yet"))).To(Succeed())
+
+			data, err := os.ReadFile(results)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("host\tbackup\t0\tOK - done\n" +
+				"host\tbackup\t1\tWARNING - partial snapshot\n" +
+				"host\tbackup\t2\tKO - failed\n" +
+				"host\tbackup\t3\tUNKNOWN - no backup yet\n"))
+		})
+
+		It("sends the first line of the result of the backup runs", func() {
+			results := fakeSendNSCA()
+			setEnv(map[string]string{
+				BC_OUTPUT_NAGIOS_NSCA_HOST: "nsca",
+				BC_OUTPUT_NAGIOS_HOST:      "host",
+				BC_OUTPUT_NAGIOS_SERVICE:   "backup",
+			})
+			Expect(InitModules(ctx, NAGIOS_OUTPUT)).To(Succeed())
+
+			for _, report := range []common.RunReport{
+				{Status: common.RUN_SUCCEEDED, Message: "done"},
+				{Status: common.RUN_WARNING, Message: "partial snapshot", UnreadableFiles: []string{"/data/a"}},
+				{Status: common.RUN_SKIPPED, Message: "blackout (maintenance)"},
+				{Status: common.RUN_FAILED, Message: "failed"},
+			} {
+				Expect(SetReport(ctx, &report)).To(Succeed())
+			}
+
+			data, err := os.ReadFile(results)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("host\tbackup\t0\tOK - done\n" +
+				"host\tbackup\t1\tWARNING - partial snapshot\n" +
+				"host\tbackup\t1\tWARNING - skipped: blackout (maintenance)\n" +
+				"host\tbackup\t2\tKO - failed\n"))
+		})
+	})
+
+	It("notifies every module when one of them fails", func() {
+		var received []status
+		server := icingaServer(http.StatusInternalServerError, &received)
+		results := fakeSendNSCA()
+		setEnv(map[string]string{
+			BC_OUTPUT_ICINGA_API_URL:   server.URL,
+			BC_OUTPUT_ICINGA_USER:      "user",
+			BC_OUTPUT_ICINGA_PASS:      "pass",
+			BC_OUTPUT_ICINGA_HOST:      "host",
+			BC_OUTPUT_ICINGA_SERVICE:   "backup",
+			BC_OUTPUT_NAGIOS_NSCA_HOST: "nsca",
+			BC_OUTPUT_NAGIOS_HOST:      "host",
+			BC_OUTPUT_NAGIOS_SERVICE:   "backup",
+		})
+		Expect(InitModules(ctx, "icinga,nagios")).To(Succeed())
+
+		err := SetError(ctx, errors.New("failed"))
+		Expect(err).To(MatchError(HavePrefix("icinga: ")))
+		Expect(received).To(HaveLen(1))
+		Expect(results).To(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,14 @@
+package outputs
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestOutputs(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Outputs Suite")
+}
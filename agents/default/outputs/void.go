@@ -1,6 +1,10 @@
 package outputs
 
-import "context"
+import (
+	"context"
+
+	"github.com/rclsilver-org/backup-controller/agents/common"
+)
 
 const VOID_OUTPUT = "void"
 
@@ -33,3 +37,7 @@ func (o *voidOutput) SetError(ctx context.Context, err error) error {
 func (o *voidOutput) SetUnknown(ctx context.Context, err error) error {
 	return nil
 }
+
+func (o *voidOutput) SetReport(ctx context.Context, report *common.RunReport) error {
+	return nil
+}
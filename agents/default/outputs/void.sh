@@ -1,26 +0,0 @@
-#!/bin/bash
-
-function output_init() {
-  # do nothing
-  >/dev/null
-}
-
-function output_set_success() {
-  # do nothing
-  >/dev/null
-}
-
-function output_set_unknown() {
-  # do nothing
-  >/dev/null
-}
-
-function output_set_warning() {
-  # do nothing
-  >/dev/null
-}
-
-function output_set_error() {
-  # do nothing
-  >/dev/null
-}
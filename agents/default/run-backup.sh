@@ -11,40 +11,156 @@ source ${BC_SCRIPTS_DIR}/lib/common.sh
 if [ -f "${BC_ENV}" ]; then
   source ${BC_ENV}
 fi
+load_settings
+
+# The central scheduler of the controller passes the time the run was due,
+# and already spread the runs.
+SCHEDULED_TIME=${BC_SCHEDULED_TIME:-$(date +%s)}
+
+# Spread the runs of the pods sharing the schedule by a delay up to
+# BC_SCHEDULE_JITTER seconds, derived from the pod name so that it is stable.
+# The starting deadline counts from the jittered due time.
+if [ -z "${BC_SCHEDULED_TIME}" ] && [ -n "${BC_SCHEDULE_JITTER}" ] && [ "${BC_SCHEDULE_JITTER}" -gt 0 ]; then
+  JITTER=$(( $(echo -n "${HOSTNAME}" | cksum | cut -d' ' -f1) % (BC_SCHEDULE_JITTER + 1) ))
+  log "Delaying the backup by ${JITTER}s."
+  sleep ${JITTER}
+  SCHEDULED_TIME=$((SCHEDULED_TIME + JITTER))
+fi
 
 # Prevent concurrent runs against the same repository. A slow prune or check
 # must never let the next scheduled run pile up on top of it: overlapping runs
 # stack restic locks and I/O and, over time, can wedge the whole repository.
 # The lock is keyed on the repository so distinct backups never block each other.
-if [ -z "${BC_LOCK_FILE}" ]; then
-  BC_LOCK_FILE="${TMPDIR:-/tmp}/backup-controller-$(repo_key).lock"
-fi
-exec 9>"${BC_LOCK_FILE}"
-if ! flock -n 9; then
+# With a starting deadline, the run waits for the lock until the deadline.
+exec 9>"$(repo_lock_file)"
+lock_repository() {
+  local wait=0
+  if [ -n "${BC_SCHEDULE_STARTING_DEADLINE}" ]; then
+    wait=$((SCHEDULED_TIME + BC_SCHEDULE_STARTING_DEADLINE - $(date +%s)))
+    if [ "${wait}" -lt 0 ]; then
+      wait=0
+    fi
+  fi
+  flock -w "${wait}" 9
+}
+if ! lock_repository; then
   log "Another backup run for this repository is already in progress; skipping."
   exit 0
 fi
 
+if [ -n "${BC_SCHEDULE_STARTING_DEADLINE}" ] && [ $(($(date +%s) - SCHEDULED_TIME)) -gt "${BC_SCHEDULE_STARTING_DEADLINE}" ]; then
+  log "Missed the starting deadline of ${BC_SCHEDULE_STARTING_DEADLINE}s; skipping."
+  exit 0
+fi
+
+if terminating; then
+  log "The pod is terminating; skipping."
+  exit 0
+fi
+
 START_TIME=$(date +%s)
 
-log "Starting the backup process."
+HOOKS_OUTPUT=""
+HOOKS_REPORT=""
+HOOKS_ERROR=""
+HOOKS_STARTED=""
+HOOKS_DONE=""
+
+# finish_hooks : run the post hooks once, if the pre hooks started.
+finish_hooks() {
+  if [ -z "${HOOKS_STARTED}" ] || [ -n "${HOOKS_DONE}" ]; then
+    return 0
+  fi
+  HOOKS_DONE=true
+  if [ -z "${BC_HOOKS_POST}" ]; then
+    return 0
+  fi
+  phase_start hooks-post
+  if ! run_hooks post; then
+    phase_end failed
+    return 1
+  fi
+  phase_end succeeded
+}
 
-# Initialize the output module
-if [ ! -z "${BC_OUTPUT_MODULE}" ]; then
-  if [ ! -f "${BC_OUTPUTS_DIR}/${BC_OUTPUT_MODULE}.sh" ]; then
-    log "Output module '${BC_OUTPUT_MODULE}' not found!"
-    exit 1
+# run_failed <message> : fail the run, reporting the message, unless it was
+# aborted on the termination of the pod.
+run_failed() {
+  if aborting && [ -z "${INTERRUPTED}" ]; then
+    run_interrupted
   fi
-  source "${BC_OUTPUTS_DIR}/${BC_OUTPUT_MODULE}.sh"
+  phase_error "$1"
+  phase_end failed
+  finish_hooks || true
+  report_write failed "$1"
+  output_set_error "$1"
+  exit 1
+}
 
-  if ! output_init; then
-    log "ERROR: Failed to initialize the '${BC_OUTPUT_MODULE}' output module. Please check your configuration."
-    exit 1
+# run_warned <message> : end the run with a warning, without a snapshot, e.g.
+# when the repository is locked by another process.
+run_warned() {
+  report_warning "$1"
+  phase_end warning
+  if ! finish_hooks; then
+    run_failed "${HOOKS_ERROR} (${HOOKS_REPORT}) at $(date '+%Y-%m-%d %H:%M:%S')"
   fi
-  log "Output module '${BC_OUTPUT_MODULE}' initialized successfully."
-else
-  source "${BC_OUTPUTS_DIR}/void.sh"
-fi
+  local message="$1 at $(date '+%Y-%m-%d %H:%M:%S')"
+  report_write warning "${message}"
+  output_set_warning "${message}"
+  exit 0
+}
+
+# run_interrupted : end the run aborted on the termination of the pod with a
+# warning, once its post hooks ran and the repository is unlocked.
+run_interrupted() {
+  INTERRUPTED=true
+  log "The backup was aborted because the pod is terminating."
+  restic unlock || log "WARNING: could not remove stale locks."
+  run_warned "interrupted by pod termination"
+}
+
+# on_exit : finish the hooks and the report, and record the failed runs, whose
+# errors the freshness checks do not report over.
+on_exit() {
+  local rc=$?
+  finish_hooks || true
+  if [ "${rc}" -ne 0 ]; then
+    if [ -z "${REPORT_WRITTEN}" ]; then
+      report_write failed "the backup process exited with code ${rc}"
+    fi
+    record_time last-failure
+  fi
+}
+report_init
+trap on_exit EXIT
+
+log "Starting the backup process."
+
+# Initialize the output modules
+output_load || exit 1
+
+# Do not run during the blackout windows of the schedule and the freezes: the
+# run is deferred to the end of the window when the starting deadline allows it,
+# and skipped otherwise. The repository is not locked while the run waits, so
+# that the other runs against it are not blocked by the window.
+while BLACKOUT=$(active_blackout "$(date +%s)"); do
+  read -r BLACKOUT_END BLACKOUT_REASON <<<"${BLACKOUT}"
+  if [ -n "${BC_SCHEDULE_STARTING_DEADLINE}" ] && [ "${BLACKOUT_END}" -le $((SCHEDULED_TIME + BC_SCHEDULE_STARTING_DEADLINE)) ]; then
+    log "Deferring the backup until the end of the blackout window (${BLACKOUT_REASON})."
+    flock -u 9
+    sleep $((BLACKOUT_END - $(date +%s)))
+    if ! lock_repository; then
+      log "Another backup run for this repository is in progress at the end of the blackout window; skipping."
+      exit 0
+    fi
+    continue
+  fi
+  log "Skipping the backup during the blackout window (${BLACKOUT_REASON})."
+  report_write skipped "blackout (${BLACKOUT_REASON})"
+  output_set_warning "skipped: blackout (${BLACKOUT_REASON}) at $(date '+%Y-%m-%d %H:%M:%S')"
+  exit 0
+done
 
 # Verify or initialize the Restic repository.
 # Probe the state with a lock-free `restic cat config` and branch on restic's
@@ -53,10 +169,17 @@ fi
 # mistaken for a missing one:
 #   0  -> repository is present and readable
 #   10 -> repository does not exist -> initialize it
-#   *  -> any other error (locked, wrong password, unreachable) -> fail loudly
-#         and DO NOT init, to avoid clobbering an existing repository.
+#   12 -> wrong password -> fail loudly
+#   *  -> any other error (unreachable) -> fail loudly and DO NOT init, to
+#         avoid clobbering an existing repository.
+# The probe, like the backup, forget and prune steps, is retried on the
+# transient errors of the backend (BC_RETRY_MAX_ATTEMPTS and BC_RETRY_BACKOFF).
+repository_probe() {
+  RESTIC_ERRORS=$(restic cat config --no-lock 2>&1 >/dev/null)
+}
+phase_start repository
 rc=0
-restic cat config --no-lock >/dev/null 2>&1 || rc=$?
+with_retries repository_probe || rc=$?
 if [ "${rc}" -eq 0 ]; then
   log "Restic repository is accessible and valid."
 elif [ "${rc}" -eq 10 ]; then
@@ -65,13 +188,14 @@ elif [ "${rc}" -eq 10 ]; then
     log "Restic repository initialized successfully."
   else
     log "ERROR: Failed to initialize Restic repository. Please check your configuration."
-    output_set_error "restic repository initialization failed at $(date '+%Y-%m-%d %H:%M:%S')"
-    exit 1
+    run_failed "restic repository initialization failed at $(date '+%Y-%m-%d %H:%M:%S')"
   fi
+elif [ "${rc}" -eq 12 ]; then
+  log "ERROR: Wrong password for the Restic repository."
+  run_failed "restic repository authentication failed (wrong password) at $(date '+%Y-%m-%d %H:%M:%S')"
 else
   log "ERROR: Restic repository unreachable (exit code ${rc}); not initializing to avoid clobbering an existing repository."
-  output_set_error "restic repository check failed with exit code ${rc} at $(date '+%Y-%m-%d %H:%M:%S')"
-  exit 1
+  run_failed "restic repository unreachable ($(restic_exit_reason "${rc}")) at $(date '+%Y-%m-%d %H:%M:%S')"
 fi
 
 # Routinely drop stale locks left behind when a run was interrupted or when the
@@ -82,58 +206,128 @@ fi
 # time out while listing them.
 log "Removing stale locks (if any)."
 restic unlock || log "WARNING: could not remove stale locks (continuing)."
+phase_end succeeded
+
+# Tag the snapshots with the Kubernetes metadata of the backup
+restic_tag_args
 
 # Compute the command if not set
 if [ -z "${BC_CMD}" ]; then
   BC_LIST_FILES="/tmp/list-files.txt"
   IFS=':' read -r -a BC_PATHS <<< "$BC_BACKUP_DIR"
   > ${BC_LIST_FILES}
-  BC_EXCLUDE_ARGS=""
+  BC_EXCLUDE_ARGS=()
   for path in "${BC_PATHS[@]}"; do
-      echo "$path" >> ${BC_LIST_FILES}
+      if [ -n "${BC_BACKUP_INCLUDE}" ]; then
+          # Only back up the included patterns, relative to each path; restic
+          # expands the globs of the files-from list
+          while IFS= read -r pattern; do
+              if [[ "${pattern}" == /* ]]; then
+                  echo "${pattern}" >> ${BC_LIST_FILES}
+              else
+                  echo "${path}/${pattern}" >> ${BC_LIST_FILES}
+              fi
+          done < <(echo "${BC_BACKUP_INCLUDE}" | jq -r '.[]')
+      else
+          echo "$path" >> ${BC_LIST_FILES}
+      fi
       # Honor a per-path ignore file listing exclude patterns (one per line)
       if [ -f "${path}/.restic-ignore" ]; then
-          BC_EXCLUDE_ARGS="${BC_EXCLUDE_ARGS} --exclude-file=${path}/.restic-ignore"
+          BC_EXCLUDE_ARGS+=("--exclude-file=${path}/.restic-ignore")
       fi
   done
-  BC_CMD="restic backup --files-from=${BC_LIST_FILES}${BC_EXCLUDE_ARGS}"
+  restic_backup_args
+  BC_CMD_ARGS=(restic backup --json "--files-from=${BC_LIST_FILES}" "${BC_EXCLUDE_ARGS[@]}" "${BC_BACKUP_ARGS[@]}" "${BC_TAG_ARGS[@]}")
+else
+  read -r -a BC_CMD_ARGS <<< "${BC_CMD}"
+fi
+
+# Run the pre hooks in the application containers, e.g. to flush or freeze the
+# data. Once they started, the post hooks run whatever the outcome of the run.
+if [ -n "${BC_HOOKS_PRE}${BC_HOOKS_POST}" ]; then
+  HOOKS_STARTED=true
+  phase_start hooks-pre
+  if ! run_hooks pre; then
+    run_failed "${HOOKS_ERROR} (${HOOKS_REPORT}) at $(date '+%Y-%m-%d %H:%M:%S')"
+  fi
+  phase_end succeeded
 fi
 
-# Execute the backup command
-log "Executing the backup command: ${BC_CMD}"
+# Execute the backup command. Its restic messages are parsed rather than
+# querying the latest snapshot afterwards, which may be another host's.
+phase_start backup
+log "Executing the backup command: ${BC_CMD_ARGS[*]}"
 rc=0
-${BC_CMD} || rc=$?
+with_retries run_backup_command "${BC_CMD_ARGS[@]}" || rc=$?
 if [ "${rc}" -eq 11 ]; then
   # Repository is locked (restic exit code 11). It may be a stale lock left by
   # an interrupted run, or a backup still in progress. `restic unlock` (without
   # --remove-all) only removes locks restic itself considers stale, so a lock
   # actively refreshed by a running backup is preserved and the retry will
-  # correctly fail again. Retry once.
+  # correctly fail again. Retry once, and skip the run with a warning when the
+  # repository is still locked.
   log "Repository is locked (exit 11). Removing stale locks and retrying once."
   restic unlock || true
   rc=0
-  ${BC_CMD} || rc=$?
+  with_retries run_backup_command "${BC_CMD_ARGS[@]}" || rc=$?
 fi
+# A partial snapshot (restic exit code 3) was created without the files which
+# could not be read: the run goes on and completes with a warning listing them.
+BACKUP_STATUS=succeeded
 if [ "${rc}" -eq 0 ]; then
   log "Backup command executed successfully."
+  record_time last-success
+elif [ "${rc}" -eq 3 ]; then
+  record_time last-success
+  if [ "${RESTIC_UNREADABLE}" = "[]" ]; then
+    report_warning "partial snapshot: some source files could not be read"
+  else
+    report_warning "partial snapshot: $(unreadable_summary)"
+  fi
+  BACKUP_STATUS=warning
+elif [ "${rc}" -eq 11 ]; then
+  run_warned "backup skipped: $(restic_exit_reason "${rc}")"
 else
   log "ERROR: Backup command failed (exit code ${rc}). Please check the logs and configuration."
-  output_set_error "backup command execution failed at $(date '+%Y-%m-%d %H:%M:%S')"
-  exit 1
+  run_failed "backup command execution failed ($(restic_exit_reason "${rc}")) at $(date '+%Y-%m-%d %H:%M:%S')"
+fi
+# The custom commands may not print the restic messages: fall back to the latest
+# snapshot of the agent
+if [ -z "${RESTIC_SUMMARY}" ]; then
+  RESTIC_SUMMARY=$(agent_latest_summary || true)
+fi
+phase_end "${BACKUP_STATUS}"
+
+if ! finish_hooks; then
+  run_failed "${HOOKS_ERROR} (${HOOKS_REPORT}) at $(date '+%Y-%m-%d %H:%M:%S')"
 fi
 
+# The maintenance tasks with their own schedule (BC_FORGET_SCHEDULE,
+# BC_PRUNE_SCHEDULE and BC_CHECK_SCHEDULE) run as their own jobs through
+# run-maintenance.sh; the other ones run after the backup. A task skipped
+# because another process holds the lock of the repository is a warning, the
+# snapshot being already saved.
+
 # Enforce the retention policy on every run. `restic forget` only rewrites
 # snapshot references, which is cheap; the expensive repacking of unused data is
 # done separately by `prune` below, on its own (less frequent) cadence.
-if [ ! -z "${BC_RETENTION_DAYS}" ] && [ "${BC_RETENTION_DAYS}" -gt 0 ]; then
+if [ -n "${BC_FORGET_SCHEDULE}" ]; then
+  log "Skipping snapshot forget; it runs on its own schedule (${BC_FORGET_SCHEDULE})."
+elif [ ! -z "${BC_RETENTION_DAYS}" ] && [ "${BC_RETENTION_DAYS}" -gt 0 ]; then
   log "Applying retention policy: keep snapshots from the last ${BC_RETENTION_DAYS} days."
 
-  if restic forget -d ${BC_RETENTION_DAYS} -c; then
+  phase_start forget
+  rc=0
+  with_retries maintenance_run forget || rc=$?
+  if [ "${rc}" -eq 0 ]; then
     log "Retention policy applied successfully."
+    phase_end succeeded
+  elif [ "${rc}" -eq 11 ]; then
+    report_warning "skipped snapshot forget: $(restic_exit_reason "${rc}")"
+    phase_end warning
   else
     log "ERROR: Failed to apply the retention policy. Please check the Restic logs for details."
-    output_set_error "snapshot forget failed at $(date '+%Y-%m-%d %H:%M:%S')"
-    exit 1
+    run_failed "snapshot forget failed at $(date '+%Y-%m-%d %H:%M:%S')"
   fi
 else
   log "No snapshot retention policy defined or retention count is set to 0. Skipping snapshot forget."
@@ -142,15 +336,23 @@ fi
 # Prune (repack unused data) is I/O heavy on the object store, so it runs at most
 # once every BC_PRUNE_INTERVAL seconds instead of on every backup. Leaving
 # BC_PRUNE_INTERVAL unset or 0 keeps the previous behavior (prune on every run).
-if maintenance_due "prune" "${BC_PRUNE_INTERVAL:-0}"; then
+# The last run is recorded in the repository, so restarts do not reset it.
+if [ -n "${BC_PRUNE_SCHEDULE}" ]; then
+  log "Skipping prune; it runs on its own schedule (${BC_PRUNE_SCHEDULE})."
+elif maintenance_due "prune" "${BC_PRUNE_INTERVAL:-0}"; then
   log "Pruning the repository (repacking unused data)."
-  if restic prune; then
-    maintenance_mark "prune"
+  phase_start prune
+  rc=0
+  with_retries maintenance_run prune || rc=$?
+  if [ "${rc}" -eq 0 ]; then
     log "Repository pruned successfully."
+    phase_end succeeded
+  elif [ "${rc}" -eq 11 ]; then
+    report_warning "skipped repository prune: $(restic_exit_reason "${rc}")"
+    phase_end warning
   else
     log "ERROR: Failed to prune the repository. Please check the Restic logs for details."
-    output_set_error "repository prune failed at $(date '+%Y-%m-%d %H:%M:%S')"
-    exit 1
+    run_failed "repository prune failed at $(date '+%Y-%m-%d %H:%M:%S')"
   fi
 else
   log "Skipping prune; not due yet (BC_PRUNE_INTERVAL=${BC_PRUNE_INTERVAL}s)."
@@ -158,15 +360,25 @@ fi
 
 # The integrity check reads the whole repository, so it also runs at most once
 # every BC_CHECK_INTERVAL seconds. Unset or 0 keeps checking on every run.
-if maintenance_due "check" "${BC_CHECK_INTERVAL:-0}"; then
+if [ -n "${BC_CHECK_SCHEDULE}" ]; then
+  log "Skipping integrity check; it runs on its own schedule (${BC_CHECK_SCHEDULE})."
+elif maintenance_due "check" "${BC_CHECK_INTERVAL:-0}"; then
   log "Performing a repository integrity check."
-  if restic check; then
-    maintenance_mark "check"
+  phase_start check
+  rc=0
+  maintenance_run check || rc=$?
+  if [ "${rc}" -eq 0 ]; then
     log "Repository integrity check completed successfully. No errors found."
-  else
+    phase_end succeeded
+  elif [ "${rc}" -eq 11 ]; then
+    report_warning "skipped integrity check: $(restic_exit_reason "${rc}")"
+    phase_end warning
+  elif [ "${rc}" -eq 1 ]; then
     log "ERROR: Repository integrity check failed. Please investigate the issue."
-    output_set_error "restic repository integrity check failed at $(date '+%Y-%m-%d %H:%M:%S')"
-    exit 1
+    run_failed "restic repository integrity check failed, the repository may be corrupted, at $(date '+%Y-%m-%d %H:%M:%S')"
+  else
+    log "ERROR: Repository integrity check could not run (exit code ${rc})."
+    run_failed "restic repository integrity check failed ($(restic_exit_reason "${rc}")) at $(date '+%Y-%m-%d %H:%M:%S')"
   fi
 else
   log "Skipping integrity check; not due yet (BC_CHECK_INTERVAL=${BC_CHECK_INTERVAL}s)."
@@ -175,36 +387,51 @@ fi
 #  Send the final status to the output module
 END_TIME=$(date +%s)
 TOTAL_DURATION=$((END_TIME - START_TIME))
-HOURS=$((TOTAL_DURATION / 3600))
-MINUTES=$(((TOTAL_DURATION % 3600) / 60))
-SECONDS=$((TOTAL_DURATION % 60))
-
-HUMAN_DURATION="${SECONDS}s"
-if [ ${MINUTES} -gt 0 ]; then
-  HUMAN_DURATION="${MINUTES}m ${HUMAN_DURATION}"
-fi
-
-if [ ${HOURS} -gt 0 ]; then
-  HUMAN_DURATION="${HOURS}h ${HUMAN_DURATION}"
-fi
-
-# Restic latest snapshot
-SNAPSHOT_SUMMARY=$(restic snapshots --latest 1 --json | jq -r '.[0].summary')
-
-export BACKUP_FILES_NEW=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.files_new')
-export BACKUP_FILES_CHANGED=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.files_changed')
-export BACKUP_FILES_UNMODIFIED=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.files_unmodified')
-export BACKUP_DIRS_NEW=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.dirs_new')
-export BACKUP_DIRS_CHANGED=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.dirs_changed')
-export BACKUP_DIRS_UNMODIFIED=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.dirs_unmodified')
-export BACKUP_DATA_BLOBS=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.data_blobs')
-export BACKUP_TREE_BLOBS=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.tree_blobs')
-export BACKUP_DATA_ADDED=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.data_added')
-export BACKUP_DATA_ADDED_PACKED=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.data_added_packed')
-export BACKUP_TOTAL_FILES=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.total_files_processed')
-export BACKUP_TOTAL_BYTES=$(echo ${SNAPSHOT_SUMMARY} | jq -r '.total_bytes_processed')
+HUMAN_DURATION=$(human_duration "${TOTAL_DURATION}")
+
+# The figures of the restic summary of the backup
+summary_field() {
+  if [ -n "${RESTIC_SUMMARY}" ]; then
+    jq -r --arg field "$1" '.[$field] // empty' <<<"${RESTIC_SUMMARY}"
+  fi
+}
+export BACKUP_SNAPSHOT_ID=$(summary_field snapshot_id)
+export BACKUP_FILES_NEW=$(summary_field files_new)
+export BACKUP_FILES_CHANGED=$(summary_field files_changed)
+export BACKUP_FILES_UNMODIFIED=$(summary_field files_unmodified)
+export BACKUP_DIRS_NEW=$(summary_field dirs_new)
+export BACKUP_DIRS_CHANGED=$(summary_field dirs_changed)
+export BACKUP_DIRS_UNMODIFIED=$(summary_field dirs_unmodified)
+export BACKUP_DATA_BLOBS=$(summary_field data_blobs)
+export BACKUP_TREE_BLOBS=$(summary_field tree_blobs)
+export BACKUP_DATA_ADDED=$(summary_field data_added)
+export BACKUP_DATA_ADDED_PACKED=$(summary_field data_added_packed)
+export BACKUP_TOTAL_FILES=$(summary_field total_files_processed)
+export BACKUP_TOTAL_BYTES=$(summary_field total_bytes_processed)
 export BACKUP_DURATION=${TOTAL_DURATION}
 
-output_set_success "backup process completed successfully in ${HUMAN_DURATION} at $(date '+%Y-%m-%d %H:%M:%S')"
+export BACKUP_SCHEDULE="$(active_schedule)"
+export BACKUP_HOOKS_OUTPUT="${HOOKS_OUTPUT}"
+# The warnings of the run, separated by semicolons, and the files restic could
+# not read, one per line
+export BACKUP_WARNINGS=$(report_warnings)
+export BACKUP_UNREADABLE_FILES=$(jq -r '.[]' <<<"${RESTIC_UNREADABLE}")
+
+if [ -n "${BACKUP_WARNINGS}" ]; then
+  MESSAGE="backup process completed with warnings in ${HUMAN_DURATION} at $(date '+%Y-%m-%d %H:%M:%S'): ${BACKUP_WARNINGS} (schedule: ${BACKUP_SCHEDULE}${HOOKS_REPORT:+, hooks: ${HOOKS_REPORT}})"
+  report_write warning "${MESSAGE}"
+  # The long output lists the files restic could not read
+  if [ -n "${BACKUP_UNREADABLE_FILES}" ]; then
+    MESSAGE+=$'\n'"Unreadable files:"$'\n'"${BACKUP_UNREADABLE_FILES}"
+  fi
+  output_set_warning "${MESSAGE}"
+
+  log "Backup process completed with warnings in ${HUMAN_DURATION}."
+  exit 0
+fi
+
+MESSAGE="backup process completed successfully in ${HUMAN_DURATION} at $(date '+%Y-%m-%d %H:%M:%S') (schedule: ${BACKUP_SCHEDULE}${HOOKS_REPORT:+, hooks: ${HOOKS_REPORT}})"
+report_write succeeded "${MESSAGE}"
+output_set_success "${MESSAGE}"
 
 log "Backup process completed successfully in ${HUMAN_DURATION}."
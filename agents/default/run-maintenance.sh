@@ -0,0 +1,81 @@
+#!/bin/bash
+
+# Run a single maintenance task of the repository (forget, prune or check), on
+# its own schedule. The failures are reported through the output module as
+# errors, and the skipped tasks as warnings.
+
+set -e
+
+if [ -z "${BC_SCRIPTS_DIR}" ]; then
+  export BC_SCRIPTS_DIR="${BC_ROOT_DIR}/scripts"
+fi
+
+source ${BC_SCRIPTS_DIR}/lib/common.sh
+
+if [ -f "${BC_ENV}" ]; then
+  source ${BC_ENV}
+fi
+load_settings
+
+TASK="$1"
+if [ -z "${TASK}" ]; then
+  echo "Usage: $0 forget|prune|check"
+  exit 1
+fi
+
+# Initialize the output modules
+output_load || exit 1
+
+# Share the lock of the backups of the repository, waiting for a running backup
+# to complete rather than skipping the task
+exec 9>"$(repo_lock_file)"
+if ! flock -w "${BC_MAINTENANCE_LOCK_WAIT:-3600}" 9; then
+  log "A backup run for this repository is still in progress; skipping the ${TASK} task."
+  output_set_warning "skipped ${TASK}: a backup run is still in progress at $(date '+%Y-%m-%d %H:%M:%S')"
+  exit 0
+fi
+
+if terminating; then
+  log "The pod is terminating; skipping the ${TASK} task."
+  exit 0
+fi
+
+# The maintenance tasks do not run during the blackout windows either
+if BLACKOUT=$(active_blackout "$(date +%s)"); then
+  read -r BLACKOUT_END BLACKOUT_REASON <<<"${BLACKOUT}"
+  log "Skipping the ${TASK} task during the blackout window (${BLACKOUT_REASON})."
+  output_set_warning "skipped ${TASK}: blackout (${BLACKOUT_REASON}) at $(date '+%Y-%m-%d %H:%M:%S')"
+  exit 0
+fi
+
+if [ "${TASK}" == "forget" ] && { [ -z "${BC_RETENTION_DAYS}" ] || [ "${BC_RETENTION_DAYS}" -le 0 ]; }; then
+  log "No snapshot retention policy defined or retention count is set to 0. Skipping snapshot forget."
+  exit 0
+fi
+
+# The forget and prune tasks are retried on the transient errors of the backend
+log "Running the ${TASK} task."
+rc=0
+if [ "${TASK}" == "check" ]; then
+  maintenance_run "${TASK}" || rc=$?
+else
+  with_retries maintenance_run "${TASK}" || rc=$?
+fi
+if [ "${rc}" -eq 0 ]; then
+  log "The ${TASK} task completed successfully."
+elif aborting; then
+  log "The ${TASK} task was aborted because the pod is terminating."
+  restic unlock || log "WARNING: could not remove stale locks."
+  output_set_warning "skipped ${TASK}: interrupted by pod termination at $(date '+%Y-%m-%d %H:%M:%S')"
+elif [ "${rc}" -eq 11 ]; then
+  log "WARNING: Skipping the ${TASK} task: $(restic_exit_reason "${rc}")."
+  output_set_warning "skipped ${TASK}: $(restic_exit_reason "${rc}") at $(date '+%Y-%m-%d %H:%M:%S')"
+elif [ "${TASK}" == "check" ] && [ "${rc}" -eq 1 ]; then
+  log "ERROR: The repository integrity check failed. Please investigate the issue."
+  output_set_error "repository check failed, the repository may be corrupted, at $(date '+%Y-%m-%d %H:%M:%S')"
+  exit 1
+else
+  log "ERROR: The ${TASK} task failed. Please check the Restic logs for details."
+  output_set_error "repository ${TASK} failed ($(restic_exit_reason "${rc}")) at $(date '+%Y-%m-%d %H:%M:%S')"
+  exit 1
+fi
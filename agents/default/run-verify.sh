@@ -0,0 +1,262 @@
+#!/bin/bash
+
+# Verify that the latest snapshot of the agent can be restored, on its own
+# schedule (BC_VERIFY_SCHEDULE). The snapshot, or a random sample of
+# BC_VERIFY_SAMPLE_SIZE of its files, is restored into the scratch directory
+# BC_VERIFY_DIR, then:
+#   - restic verifies the content of the restored files against the hashes of
+#     the snapshot (restic restore --verify);
+#   - their sizes must match the metadata of the snapshot (restic ls);
+#   - with BC_VERIFY_COMPARE_LIVE, their content must match the live files
+#     whose size and modification time did not change since the snapshot.
+# The result is written to the verification report of the state directory and
+# sent to the output modules: VERIFIED (success), WARNING or ERROR.
+
+set -e
+
+if [ -z "${BC_SCRIPTS_DIR}" ]; then
+  export BC_SCRIPTS_DIR="${BC_ROOT_DIR}/scripts"
+fi
+
+source ${BC_SCRIPTS_DIR}/lib/common.sh
+
+if [ -f "${BC_ENV}" ]; then
+  source ${BC_ENV}
+fi
+load_settings
+
+if [ -z "${BC_VERIFY_DIR}" ]; then
+  echo "ERROR: BC_VERIFY_DIR is not set!"
+  exit 1
+fi
+
+# The figures sent with the VERIFIED state
+OUTPUT_DATA=(
+  duration=VERIFY_DURATION
+  restore_duration=VERIFY_RESTORE_DURATION
+  files=VERIFY_FILES
+  bytes=VERIFY_BYTES
+  live_compared=VERIFY_LIVE_COMPARED
+)
+
+# Initialize the output modules
+output_load || exit 1
+
+# Share the lock of the backups of the repository, waiting for a running backup
+# to complete rather than skipping the verification
+exec 9>"$(repo_lock_file)"
+if ! flock -w "${BC_MAINTENANCE_LOCK_WAIT:-3600}" 9; then
+  log "A backup run for this repository is still in progress; skipping the verification."
+  output_set_warning "skipped verification: a backup run is still in progress at $(date '+%Y-%m-%d %H:%M:%S')"
+  exit 0
+fi
+
+if terminating; then
+  log "The pod is terminating; skipping the verification."
+  exit 0
+fi
+
+if BLACKOUT=$(active_blackout "$(date +%s)"); then
+  read -r BLACKOUT_END BLACKOUT_REASON <<<"${BLACKOUT}"
+  log "Skipping the verification during the blackout window (${BLACKOUT_REASON})."
+  output_set_warning "skipped verification: blackout (${BLACKOUT_REASON}) at $(date '+%Y-%m-%d %H:%M:%S')"
+  exit 0
+fi
+
+VERIFY_START=$(date +%s)
+VERIFY_TARGET="${BC_VERIFY_DIR}/restore"
+VERIFY_FILES_LIST=$(mktemp)
+VERIFY_INCLUDE_FILE=$(mktemp)
+VERIFY_ERRORS="[]"
+VERIFY_WARNINGS="[]"
+VERIFY_SNAPSHOT="null"
+export VERIFY_DURATION=0 VERIFY_RESTORE_DURATION=0 VERIFY_FILES=0 VERIFY_BYTES=0 VERIFY_LIVE_COMPARED=0
+
+# verify_error <message> : record an error of the verification.
+verify_error() {
+  log "ERROR: $1"
+  VERIFY_ERRORS=$(jq -c --arg message "$1" '. + [$message]' <<<"${VERIFY_ERRORS}")
+}
+
+# verify_warning <message> : record a warning of the verification.
+verify_warning() {
+  log "WARNING: $1"
+  VERIFY_WARNINGS=$(jq -c --arg message "$1" '. + [$message]' <<<"${VERIFY_WARNINGS}")
+}
+
+# verify_write <verified|warning|failed> <message> : write the verification
+# report, the evidence of the restores kept for the audits.
+verify_write() {
+  local file="${BC_STATE_DIR}/last-verify.json" ended
+  ended=$(date +%s)
+  VERIFY_DURATION=$((ended - VERIFY_START))
+  mkdir -p "${BC_STATE_DIR}"
+  jq -n --arg status "$1" --arg message "$2" --arg host "${RESTIC_HOST:-${HOSTNAME}}" \
+    --argjson started "${VERIFY_START}" --argjson ended "${ended}" \
+    --argjson restore "${VERIFY_RESTORE_DURATION}" --argjson snapshot "${VERIFY_SNAPSHOT}" \
+    --argjson sample "${BC_VERIFY_SAMPLE_SIZE:-null}" --argjson files "${VERIFY_FILES}" \
+    --argjson bytes "${VERIFY_BYTES}" --argjson live "${VERIFY_LIVE_COMPARED}" \
+    --argjson errors "${VERIFY_ERRORS}" --argjson warnings "${VERIFY_WARNINGS}" '
+    {
+      status: $status,
+      message: $message,
+      host: $host,
+      startTime: ($started | todate),
+      endTime: ($ended | todate),
+      duration: ($ended - $started),
+      restoreDuration: $restore,
+      files: $files,
+      bytes: $bytes,
+      liveCompared: $live
+    } + (if $snapshot == null then {} else {snapshotId: $snapshot.id, snapshotTime: $snapshot.time} end)
+      + (if $sample == null then {} else {sampleSize: $sample} end)
+      + (if $errors == [] then {} else {errors: $errors} end)
+      + (if $warnings == [] then {} else {warnings: $warnings} end)' >"${file}.tmp" && mv "${file}.tmp" "${file}"
+  export VERIFY_REPORT_FILE="${file}"
+}
+
+# verify_failed <message> : end the verification with an error.
+verify_failed() {
+  verify_error "$1"
+  local message="restore verification failed: $1 at $(date '+%Y-%m-%d %H:%M:%S')"
+  verify_write failed "${message}"
+  output_set_error "${message}"
+  exit 1
+}
+
+# on_exit : drop the restored files, which may be large.
+on_exit() {
+  rm -rf "${VERIFY_TARGET}" "${VERIFY_FILES_LIST}" "${VERIFY_INCLUDE_FILE}"
+}
+trap on_exit EXIT
+
+log "Starting the verification of the latest snapshot."
+
+rc=0
+VERIFY_SNAPSHOT=$(agent_latest_snapshot) || rc=$?
+if [ "${rc}" -ne 0 ]; then
+  VERIFY_SNAPSHOT="null"
+  verify_failed "unable to list the snapshots ($(restic_exit_reason "${rc}"))"
+fi
+if [ -z "${VERIFY_SNAPSHOT}" ]; then
+  VERIFY_SNAPSHOT="null"
+  verify_warning "no snapshot to verify"
+  MESSAGE="skipped verification: no snapshot to verify at $(date '+%Y-%m-%d %H:%M:%S')"
+  verify_write warning "${MESSAGE}"
+  output_set_warning "${MESSAGE}"
+  exit 0
+fi
+SNAPSHOT_ID=$(jq -r '.short_id // .id[:8]' <<<"${VERIFY_SNAPSHOT}")
+SNAPSHOT_TIME=$(jq -r '.time' <<<"${VERIFY_SNAPSHOT}")
+
+# The regular files of the snapshot, with their sizes, optionally sampled
+log "Listing the files of the snapshot ${SNAPSHOT_ID} (${SNAPSHOT_TIME})."
+rc=0
+(
+  set -o pipefail
+  restic ls --no-lock --json "$(jq -r '.id' <<<"${VERIFY_SNAPSHOT}")" |
+    jq -r 'select(.struct_type == "node" and .type == "file") | "\(.size // 0)\t\(.path)"'
+) >"${VERIFY_FILES_LIST}" || rc=$?
+if [ "${rc}" -ne 0 ]; then
+  verify_failed "unable to list the files of the snapshot ${SNAPSHOT_ID}"
+fi
+if [ -n "${BC_VERIFY_SAMPLE_SIZE}" ]; then
+  shuf -n "${BC_VERIFY_SAMPLE_SIZE}" "${VERIFY_FILES_LIST}" >"${VERIFY_FILES_LIST}.sample"
+  mv "${VERIFY_FILES_LIST}.sample" "${VERIFY_FILES_LIST}"
+fi
+VERIFY_FILES=$(wc -l <"${VERIFY_FILES_LIST}")
+VERIFY_BYTES=$(awk -F'\t' '{ total += $1 } END { printf "%d", total }' "${VERIFY_FILES_LIST}")
+
+if [ "${VERIFY_FILES}" -eq 0 ]; then
+  verify_warning "the snapshot ${SNAPSHOT_ID} has no file to verify"
+  MESSAGE="skipped verification: the snapshot ${SNAPSHOT_ID} has no file at $(date '+%Y-%m-%d %H:%M:%S')"
+  verify_write warning "${MESSAGE}"
+  output_set_warning "${MESSAGE}"
+  exit 0
+fi
+if [ -n "${BC_VERIFY_SIZE_LIMIT}" ] && [ "${VERIFY_BYTES}" -gt "${BC_VERIFY_SIZE_LIMIT}" ]; then
+  verify_failed "the ${VERIFY_FILES} selected file(s) ($(human_bytes "${VERIFY_BYTES}")) exceed the scratch size limit ($(human_bytes "${BC_VERIFY_SIZE_LIMIT}"))"
+fi
+
+# Restore the selected files, restic verifying their content. The paths of the
+# sample are escaped, restic reading patterns.
+RESTORE_ARGS=(restore "$(jq -r '.id' <<<"${VERIFY_SNAPSHOT}")" --target "${VERIFY_TARGET}" --verify)
+if [ -n "${BC_VERIFY_SAMPLE_SIZE}" ]; then
+  cut -f2- "${VERIFY_FILES_LIST}" | sed 's/[][*?\\]/\\&/g' >"${VERIFY_INCLUDE_FILE}"
+  RESTORE_ARGS+=("--include-file=${VERIFY_INCLUDE_FILE}")
+fi
+rm -rf "${VERIFY_TARGET}"
+mkdir -p "${VERIFY_TARGET}"
+log "Restoring ${VERIFY_FILES} file(s) ($(human_bytes "${VERIFY_BYTES}")) of the snapshot ${SNAPSHOT_ID}."
+RESTORE_START=$(date +%s)
+rc=0
+with_retries restic_capture "${RESTORE_ARGS[@]}" || rc=$?
+VERIFY_RESTORE_DURATION=$(($(date +%s) - RESTORE_START))
+if [ "${rc}" -ne 0 ]; then
+  ERROR=$(grep -v '^[[:space:]]*$' <<<"${RESTIC_ERRORS}" | tail -n 1 || true)
+  verify_failed "the restore of the snapshot ${SNAPSHOT_ID} failed ($(restic_exit_reason "${rc}"))${ERROR:+: ${ERROR}}"
+fi
+
+# Check the restored files against the metadata of the snapshot and, when they
+# did not change since, the live files. The restore keeps the modification
+# times, so that the restored and live files are compared as is.
+MISSING=()
+MISMATCHED=()
+LIVE_DIFFERENT=()
+while IFS=$'\t' read -r size path; do
+  restored="${VERIFY_TARGET}${path}"
+  if [ ! -f "${restored}" ]; then
+    MISSING+=("${path}")
+    continue
+  fi
+  if [ "$(stat -c %s "${restored}")" != "${size}" ]; then
+    MISMATCHED+=("${path}")
+    continue
+  fi
+  if [ "${BC_VERIFY_COMPARE_LIVE}" == "true" ] && [ -f "${path}" ] &&
+    [ "$(stat -c '%s %Y' "${path}")" == "$(stat -c '%s %Y' "${restored}")" ]; then
+    VERIFY_LIVE_COMPARED=$((VERIFY_LIVE_COMPARED + 1))
+    if ! cmp -s "${path}" "${restored}"; then
+      LIVE_DIFFERENT+=("${path}")
+    fi
+  fi
+done <"${VERIFY_FILES_LIST}"
+
+# file_list <paths...> : print the first 10 paths and the number of the others.
+file_list() {
+  jq -rn '$ARGS.positional | (.[:10] | join(", ")) + if length > 10 then " and \(length - 10) more" else "" end' --args "$@"
+}
+if [ "${#MISSING[@]}" -gt 0 ]; then
+  verify_error "${#MISSING[@]} file(s) were not restored: $(file_list "${MISSING[@]}")"
+fi
+if [ "${#MISMATCHED[@]}" -gt 0 ]; then
+  verify_error "${#MISMATCHED[@]} restored file(s) do not match the size of the snapshot: $(file_list "${MISMATCHED[@]}")"
+fi
+if [ "${#LIVE_DIFFERENT[@]}" -gt 0 ]; then
+  verify_warning "${#LIVE_DIFFERENT[@]} restored file(s) differ from the live files unchanged since the snapshot: $(file_list "${LIVE_DIFFERENT[@]}")"
+fi
+
+SUMMARY="${VERIFY_FILES} file(s) ($(human_bytes "${VERIFY_BYTES}")) of the snapshot ${SNAPSHOT_ID} from ${SNAPSHOT_TIME} restored in $(human_duration "${VERIFY_RESTORE_DURATION}")"
+if [ "${BC_VERIFY_COMPARE_LIVE}" == "true" ]; then
+  SUMMARY+=", ${VERIFY_LIVE_COMPARED} compared with the live files"
+fi
+
+if [ "${VERIFY_ERRORS}" != "[]" ]; then
+  MESSAGE="restore verification failed: $(jq -r 'join("; ")' <<<"${VERIFY_ERRORS}") (${SUMMARY}) at $(date '+%Y-%m-%d %H:%M:%S')"
+  verify_write failed "${MESSAGE}"
+  output_set_error "${MESSAGE}"
+  exit 1
+fi
+
+if [ "${VERIFY_WARNINGS}" != "[]" ]; then
+  MESSAGE="restore verified with warnings: $(jq -r 'join("; ")' <<<"${VERIFY_WARNINGS}") (${SUMMARY}) at $(date '+%Y-%m-%d %H:%M:%S')"
+  verify_write warning "${MESSAGE}"
+  output_set_warning "${MESSAGE}"
+  log "Verification completed with warnings."
+  exit 0
+fi
+
+MESSAGE="VERIFIED: ${SUMMARY} at $(date '+%Y-%m-%d %H:%M:%S')"
+verify_write verified "${MESSAGE}"
+output_set_success "${MESSAGE}"
+log "Verification completed successfully: ${SUMMARY}."
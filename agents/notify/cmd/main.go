@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/rclsilver-org/backup-controller/agents/common"
+	"github.com/rclsilver-org/backup-controller/agents/default/outputs"
+)
+
+const (
+	BC_OUTPUT_MODULE = "BC_OUTPUT_MODULE"
+)
+
+// The states an output module can be notified of.
+const (
+	STATE_SUCCESS = "success"
+	STATE_WARNING = "warning"
+	STATE_ERROR   = "error"
+	STATE_UNKNOWN = "unknown"
+)
+
+// dataFlags collects the repeated --data key=value flags.
+type dataFlags map[string]any
+
+func (d dataFlags) String() string {
+	return fmt.Sprint(map[string]any(d))
+}
+
+func (d dataFlags) Set(value string) error {
+	key, v, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	d[key] = v
+	return nil
+}
+
+// bc-notify notifies the output modules of the agents from the scripts, e.g.
+//
+//	bc-notify --state success --message "backup completed" --data duration=12
+//
+// or of the result of a backup run, from its report:
+//
+//	bc-notify report /tmp/backup-controller-state/last-run.json
+//
+// It exits with 1 when a module could not be notified, the others being
+// notified anyway.
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logLevel := slog.LevelInfo
+	if common.IsDebug() {
+		logLevel = slog.LevelDebug
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: logLevel,
+	}))
+	slog.SetDefault(logger)
+
+	data := dataFlags{}
+	modules := flag.String("module", os.Getenv(BC_OUTPUT_MODULE), "comma-separated output modules to notify")
+	state := flag.String("state", "", "state to send: success, warning, error or unknown")
+	message := flag.String("message", "", "message of the state")
+	flag.Var(data, "data", "key=value performance data of a success, repeated")
+	initOnly := flag.Bool("init", false, "only initialize the output modules")
+	list := flag.Bool("list", false, "list the output modules and exit")
+	flag.Parse()
+
+	if *list {
+		for _, name := range outputs.Modules() {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	if err := outputs.InitModules(ctx, *modules); err != nil {
+		logger.ErrorContext(ctx, "unable to initialize the output modules", "error", err)
+		os.Exit(1)
+	}
+	if *initOnly {
+		return
+	}
+
+	if flag.Arg(0) == "report" {
+		if err := report(ctx, flag.Arg(1)); err != nil {
+			logger.ErrorContext(ctx, "unable to notify the output modules of the run report", "file", flag.Arg(1), "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := notify(ctx, *state, *message, data); err != nil {
+		logger.ErrorContext(ctx, "unable to notify the output modules", "state", *state, "error", err)
+		os.Exit(1)
+	}
+}
+
+// report sends the result of a backup run, read from its report, to the output
+// modules.
+func report(ctx context.Context, file string) error {
+	if file == "" {
+		return errors.New("missing report file")
+	}
+	runReport, err := common.ReadRunReport(file)
+	if err != nil {
+		return fmt.Errorf("unable to read the run report: %w", err)
+	}
+	return outputs.SetReport(ctx, runReport)
+}
+
+// notify sends the state to the output modules.
+func notify(ctx context.Context, state, message string, data map[string]any) error {
+	switch state {
+	case STATE_SUCCESS:
+		return outputs.SetSuccess(ctx, message, data)
+	case STATE_WARNING:
+		return outputs.SetWarning(ctx, errors.New(message))
+	case STATE_ERROR:
+		return outputs.SetError(ctx, errors.New(message))
+	case STATE_UNKNOWN:
+		return outputs.SetUnknown(ctx, errors.New(message))
+	default:
+		return fmt.Errorf("invalid state %q", state)
+	}
+}
@@ -19,6 +19,8 @@ const (
 	PGUSER     = "PGUSER"
 	PGPASSWORD = "PGPASSWORD"
 	PGDATA     = "PGDATA"
+
+	BC_BACKUP_INCLUDE = "BC_BACKUP_INCLUDE"
 )
 
 func main() {
@@ -38,6 +40,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The data directory is backed up as a whole, a subset of its files
+	// could not be restored
+	if os.Getenv(BC_BACKUP_INCLUDE) != "" {
+		logger.ErrorContext(ctx, "the include backup option is not supported by the PostgreSQL agent")
+		os.Exit(1)
+	}
+
 	pgdata := os.Getenv(PGDATA)
 	if err := common.IsDirectory(pgdata); err != nil {
 		logger.ErrorContext(ctx, "postgresql data directory is not readable", "error", err)
@@ -84,7 +93,8 @@ func main() {
 		} else {
 			logger.Info("backup mode is now disabled")
 		}
-		os.Exit(1)
+		// A partial snapshot is reported as a warning by the script
+		os.Exit(common.ResticExitCode(err))
 	}
 
 	// Disable the backup mode
@@ -140,9 +150,19 @@ func pgStopBackup(ctx context.Context, db *sql.DB, pgVersion float64) error {
 	return nil
 }
 
-// executeRestic launches a restic backup of the given path
+// executeRestic launches a restic backup of the given path. Its JSON messages
+// are parsed by the script running the agent.
 func executeRestic(ctx context.Context, path string) error {
-	cmd := exec.CommandContext(ctx, "restic", "backup", path)
+	args, err := common.ResticBackupArgs()
+	if err != nil {
+		return err
+	}
+
+	args = append(args, "--json")
+
+	args = append(args, common.ResticTagArgs()...)
+
+	cmd := exec.CommandContext(ctx, "restic", append([]string{"backup", path}, args...)...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
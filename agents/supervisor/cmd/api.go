@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rclsilver-org/backup-controller/agents/common"
+)
+
+// status is the status of the agent returned by GET /status.
+type status struct {
+	Phase    string `json:"phase"`
+	Schedule string `json:"schedule,omitempty"`
+	TimeZone string `json:"timeZone,omitempty"`
+	// Central is set when the backups are triggered by the central scheduler
+	// of the controller, which the agent does not track
+	Central       bool         `json:"central,omitempty"`
+	NextRun       *time.Time   `json:"nextRun,omitempty"`
+	LastRun       *runStatus   `json:"lastRun,omitempty"`
+	LastError     string       `json:"lastError,omitempty"`
+	LastErrorTime *time.Time   `json:"lastErrorTime,omitempty"`
+	Maintenance   []taskStatus `json:"maintenance,omitempty"`
+	// LastSuccess and Freshness are set with a freshness bound
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	Freshness   string     `json:"freshness,omitempty"`
+}
+
+// freshnessStatus is the freshness of the backups returned by GET /freshness,
+// with the durations in seconds.
+type freshnessStatus struct {
+	State       string     `json:"state"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	Age         int64      `json:"age"`
+	WarnAfter   int64      `json:"warnAfter,omitempty"`
+	FailAfter   int64      `json:"failAfter,omitempty"`
+}
+
+// taskStatus is the status of a maintenance task with its own schedule.
+type taskStatus struct {
+	Task     string     `json:"task"`
+	Schedule string     `json:"schedule"`
+	NextRun  time.Time  `json:"nextRun"`
+	LastRun  *runStatus `json:"lastRun,omitempty"`
+}
+
+// handler returns the handler of the HTTP API of the supervisor:
+//   - POST /run triggers a backup (202, or 409 if one is in progress);
+//   - GET /status returns the status of the agent;
+//   - GET /freshness returns the freshness of the backups, and fails once the
+//     latest successful backup is older than the failAfter bound, or than the
+//     maxAge query parameter when set (e.g. ?maxAge=36h);
+//   - GET /report returns the report of the latest backup run (404 without);
+//   - GET /verify returns the report of the latest verification run (404
+//     without);
+//   - GET /healthz succeeds while the scheduling loop is alive;
+//   - GET /readyz succeeds once the schedules are loaded.
+//
+// When a token is set, the requests but the probes must carry it as a bearer
+// token.
+func (s *supervisor) handler(ctx context.Context, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /run", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isReady() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "the agent is not ready"})
+			return
+		}
+		// The run outlives the request
+		if !s.trigger(ctx, TRIGGER_API) {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "a backup is already in progress"})
+			return
+		}
+		writeJSON(w, http.StatusAccepted, s.status())
+	})
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.status())
+	})
+
+	mux.HandleFunc("GET /freshness", func(w http.ResponseWriter, r *http.Request) {
+		bounds := s.freshness
+		if value := r.URL.Query().Get("maxAge"); value != "" {
+			maxAge, err := time.ParseDuration(value)
+			if err != nil || maxAge <= 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid maxAge"})
+				return
+			}
+			bounds.FailAfter = maxAge
+		}
+
+		st := freshnessOf(bounds)
+
+		code := http.StatusOK
+		if st.State == common.FRESHNESS_STALE {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, st)
+	})
+
+	mux.HandleFunc("GET /report", func(w http.ResponseWriter, r *http.Request) {
+		report, err := common.LastRunReport()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if report == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no backup run yet"})
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+
+	mux.HandleFunc("GET /verify", func(w http.ResponseWriter, r *http.Request) {
+		report, err := common.LastVerifyReport()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if report == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no verification run yet"})
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+
+	handler := authenticate(mux, token)
+
+	// The kubelet probes the agent without the token
+	probes := http.NewServeMux()
+	probes.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		probe(w, s.healthy())
+	})
+	probes.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		probe(w, s.isReady())
+	})
+	probes.Handle("/", handler)
+
+	return probes
+}
+
+// authenticate rejects the requests without the given bearer token, if any.
+func authenticate(next http.Handler, token string) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback tells whether the address only listens on the loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func probe(w http.ResponseWriter, ok bool) {
+	if !ok {
+		http.Error(w, "not ok", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
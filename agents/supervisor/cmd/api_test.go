@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rclsilver-org/backup-controller/agents/common"
+)
+
+var _ = Describe("API", func() {
+	var (
+		s      *supervisor
+		dir    string
+		server *httptest.Server
+		token  string
+	)
+
+	BeforeEach(func() {
+		setEnv(map[string]string{"BC_SCHEDULE": "0 3 * * *"})
+		c := &clock{now: time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC)}
+		s, dir, _ = testSupervisor(c)
+		token = ""
+	})
+
+	JustBeforeEach(func() {
+		server = httptest.NewServer(s.handler(context.Background(), token))
+		DeferCleanup(server.Close)
+	})
+
+	// request sends a request to the API with the given token, if any, and
+	// returns the response code and its decoded JSON body, if any.
+	request := func(method, path, token string) (int, map[string]any) {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+
+		var body map[string]any
+		if resp.Header.Get("Content-Type") == "application/json" {
+			Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		}
+		return resp.StatusCode, body
+	}
+
+	Describe("POST /run", func() {
+		It("refuses to run a backup before the schedules are loaded", func() {
+			code, _ := request(http.MethodPost, "/run", "")
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+		})
+
+		It("runs a backup, unless one is in progress", func() {
+			release := filepath.Join(dir, "release")
+			writeScript(dir, "run-backup.sh", "while [ ! -e "+release+" ]; do sleep 0.01; done")
+			Expect(s.init()).To(Succeed())
+
+			code, body := request(http.MethodPost, "/run", "")
+			Expect(code).To(Equal(http.StatusAccepted))
+			Expect(body).To(HaveKeyWithValue("phase", PHASE_BACKUP))
+
+			code, _ = request(http.MethodPost, "/run", "")
+			Expect(code).To(Equal(http.StatusConflict))
+
+			Expect(os.WriteFile(release, nil, 0o644)).To(Succeed())
+			s.wg.Wait()
+		})
+
+		It("only accepts POST", func() {
+			code, _ := request(http.MethodGet, "/run", "")
+			Expect(code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Describe("GET /status", func() {
+		It("returns the schedule and the next run", func() {
+			Expect(s.init()).To(Succeed())
+
+			code, body := request(http.MethodGet, "/status", "")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(HaveKeyWithValue("phase", PHASE_IDLE))
+			Expect(body).To(HaveKeyWithValue("schedule", "0 3 * * *"))
+			Expect(body).To(HaveKeyWithValue("nextRun", "2025-01-01T03:00:00Z"))
+		})
+	})
+
+	Describe("GET /report and /verify", func() {
+		It("returns 404 without a run", func() {
+			code, _ := request(http.MethodGet, "/report", "")
+			Expect(code).To(Equal(http.StatusNotFound))
+			code, _ = request(http.MethodGet, "/verify", "")
+			Expect(code).To(Equal(http.StatusNotFound))
+		})
+
+		It("returns the report of the latest run", func() {
+			state := filepath.Join(dir, "state")
+			Expect(os.MkdirAll(state, 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(state, "last-run.json"), []byte(`{"status":"warning","message":"partial snapshot"}`), 0o644)).To(Succeed())
+
+			code, body := request(http.MethodGet, "/report", "")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(HaveKeyWithValue("status", "warning"))
+			Expect(body).To(HaveKeyWithValue("message", "partial snapshot"))
+		})
+	})
+
+	Describe("GET /freshness", func() {
+		It("fails once the latest backup is older than maxAge", func() {
+			Expect(common.RecordSuccess(time.Now().Add(-2 * time.Hour))).To(Succeed())
+
+			code, body := request(http.MethodGet, "/freshness?maxAge=3h", "")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(HaveKeyWithValue("state", common.FRESHNESS_FRESH))
+
+			code, body = request(http.MethodGet, "/freshness?maxAge=1h", "")
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+			Expect(body).To(HaveKeyWithValue("state", common.FRESHNESS_STALE))
+
+			code, _ = request(http.MethodGet, "/freshness?maxAge=soon", "")
+			Expect(code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("probes", func() {
+		It("reports the readiness and the liveness of the agent", func() {
+			code, _ := request(http.MethodGet, "/readyz", "")
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+			code, _ = request(http.MethodGet, "/healthz", "")
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+
+			Expect(s.init()).To(Succeed())
+			s.tick(context.Background())
+
+			code, _ = request(http.MethodGet, "/readyz", "")
+			Expect(code).To(Equal(http.StatusOK))
+			code, _ = request(http.MethodGet, "/healthz", "")
+			Expect(code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("with a token", func() {
+		BeforeEach(func() {
+			token = "secret"
+			Expect(s.init()).To(Succeed())
+		})
+
+		It("rejects the requests without the token", func() {
+			code, _ := request(http.MethodGet, "/status", "")
+			Expect(code).To(Equal(http.StatusUnauthorized))
+			code, _ = request(http.MethodGet, "/status", "wrong")
+			Expect(code).To(Equal(http.StatusUnauthorized))
+			code, _ = request(http.MethodPost, "/run", "")
+			Expect(code).To(Equal(http.StatusUnauthorized))
+			code, _ = request(http.MethodGet, "/report", "")
+			Expect(code).To(Equal(http.StatusUnauthorized))
+
+			code, _ = request(http.MethodGet, "/status", "secret")
+			Expect(code).To(Equal(http.StatusOK))
+		})
+
+		It("leaves the probes open", func() {
+			code, _ := request(http.MethodGet, "/readyz", "")
+			Expect(code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("isLoopback", func() {
+		DescribeTable("tells the loopback addresses apart",
+			func(addr string, expected bool) {
+				Expect(isLoopback(addr)).To(Equal(expected))
+			},
+			Entry("the default address", defaultAddr, true),
+			Entry("localhost", "localhost:8079", true),
+			Entry("IPv6 loopback", "[::1]:8079", true),
+			Entry("all the interfaces", ":8079", false),
+			Entry("all the IPv4 interfaces", "0.0.0.0:8079", false),
+			Entry("a pod IP", "10.0.0.12:8079", false),
+			Entry("an invalid address", "8079", false),
+		)
+	})
+})
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/rclsilver-org/backup-controller/agents/common"
+	"github.com/rclsilver-org/backup-controller/agents/default/outputs"
+)
+
+const (
+	BC_ROOT_DIR                 = "BC_ROOT_DIR"
+	BC_SCRIPTS_DIR              = "BC_SCRIPTS_DIR"
+	BC_RUN_ONCE                 = "BC_RUN_ONCE"
+	BC_SCHEDULE                 = "BC_SCHEDULE"
+	BC_SCHEDULE_FILE            = "BC_SCHEDULE_FILE"
+	BC_POLICY_FILE              = "BC_POLICY_FILE"
+	BC_SCHEDULE_TIMEZONE        = "BC_SCHEDULE_TIMEZONE"
+	BC_SCHEDULE_RELOAD_INTERVAL = "BC_SCHEDULE_RELOAD_INTERVAL"
+	BC_CENTRAL_SCHEDULER        = "BC_CENTRAL_SCHEDULER"
+	BC_CATCHUP_POLICY           = "BC_CATCHUP_POLICY"
+	BC_FRESHNESS_CHECK_SCHEDULE = "BC_FRESHNESS_CHECK_SCHEDULE"
+	BC_VERIFY_SCHEDULE          = "BC_VERIFY_SCHEDULE"
+	BC_VERIFY_DIR               = "BC_VERIFY_DIR"
+	BC_FORGET_SCHEDULE          = "BC_FORGET_SCHEDULE"
+	BC_PRUNE_SCHEDULE           = "BC_PRUNE_SCHEDULE"
+	BC_CHECK_SCHEDULE           = "BC_CHECK_SCHEDULE"
+	BC_SUPERVISOR_ADDR          = "BC_SUPERVISOR_ADDR"
+	BC_SUPERVISOR_TOKEN         = "BC_SUPERVISOR_TOKEN"
+	RESTIC_REPOSITORY           = "RESTIC_REPOSITORY"
+	RESTIC_PASSWORD             = "RESTIC_PASSWORD"
+	TZDATA                      = "TZDATA"
+)
+
+// The default address of the HTTP API. It only listens on the loopback
+// interface: listening on another one requires a token.
+const defaultAddr = "127.0.0.1:8079"
+
+// The supervisor replaces entrypoint.sh and crond: it runs the scripts of the
+// default agent on their schedule and exposes their status over HTTP.
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logLevel := slog.LevelInfo
+	if common.IsDebug() {
+		logLevel = slog.LevelDebug
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: logLevel,
+	}))
+	slog.SetDefault(logger)
+
+	scriptsDir := common.GetEnv(BC_SCRIPTS_DIR, filepath.Join(os.Getenv(BC_ROOT_DIR), "scripts"))
+
+	// The agents run by a CronJob run once: the entrypoint handles it
+	if os.Getenv(BC_RUN_ONCE) == "true" {
+		entrypoint := filepath.Join(scriptsDir, "entrypoint.sh")
+		if err := syscall.Exec(entrypoint, []string{entrypoint}, os.Environ()); err != nil {
+			logger.ErrorContext(ctx, "unable to run the entrypoint", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := common.RequiredEnvVar(RESTIC_REPOSITORY, RESTIC_PASSWORD); err != nil {
+		logger.ErrorContext(ctx, "unable to verify environment variables", "error", err)
+		os.Exit(1)
+	}
+
+	addr := common.GetEnv(BC_SUPERVISOR_ADDR, defaultAddr)
+	token := os.Getenv(BC_SUPERVISOR_TOKEN)
+	if token == "" && !isLoopback(addr) {
+		logger.ErrorContext(ctx, "the HTTP API requires a token when it does not only listen on the loopback interface", "address", addr, "variable", BC_SUPERVISOR_TOKEN)
+		os.Exit(1)
+	}
+
+	if zone := os.Getenv(TZDATA); zone != "" {
+		setLocalTime(ctx, logger, zone)
+	}
+
+	if err := outputs.Init(ctx); err != nil {
+		logger.ErrorContext(ctx, "unable to initialize the output modules", "error", err)
+		os.Exit(1)
+	}
+
+	// The freshness checks count from the start of the agent until it completes
+	// a backup or finds one in the repository
+	if err := common.RecordStart(time.Now()); err != nil {
+		logger.WarnContext(ctx, "unable to record the start of the agent", "error", err)
+	}
+	if err := common.ClearTermination(); err != nil {
+		logger.WarnContext(ctx, "unable to clear the termination of the previous agent", "error", err)
+	}
+
+	s := newSupervisor(logger, scriptsDir)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.handler(ctx, token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		logger.InfoContext(ctx, "serving the HTTP API", "address", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.ErrorContext(ctx, "unable to serve the HTTP API", "error", err)
+			stop()
+		}
+	}()
+
+	err := s.run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	_ = server.Shutdown(shutdownCtx)
+
+	if err != nil {
+		logger.ErrorContext(ctx, "unable to run the supervisor", "error", err)
+		outputs.SetUnknown(context.WithoutCancel(ctx), err)
+		os.Exit(1)
+	}
+}
+
+// setLocalTime sets the time zone of the agent and of the scripts, like the
+// entrypoint does.
+func setLocalTime(ctx context.Context, logger *slog.Logger, zone string) {
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		logger.WarnContext(ctx, "invalid zone name", "zone", zone, "error", err)
+		return
+	}
+	time.Local = location
+
+	_ = os.Remove("/etc/localtime")
+	if err := os.Symlink(filepath.Join("/usr/share/zoneinfo", zone), "/etc/localtime"); err != nil {
+		logger.WarnContext(ctx, "unable to set the local time zone", "zone", zone, "error", err)
+	}
+}
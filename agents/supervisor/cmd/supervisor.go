@@ -0,0 +1,729 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/rclsilver-org/backup-controller/agents/common"
+	"github.com/rclsilver-org/backup-controller/agents/default/outputs"
+)
+
+// The triggers of the runs, reported in the status.
+const (
+	TRIGGER_SCHEDULE = "schedule"
+	TRIGGER_API      = "api"
+	TRIGGER_CATCH_UP = "catch-up"
+)
+
+// The phases of the agent, reported in the status.
+const (
+	PHASE_IDLE        = "Idle"
+	PHASE_BACKUP      = "Backup"
+	PHASE_MAINTENANCE = "Maintenance"
+)
+
+// The default schedule of the freshness checks.
+const defaultFreshnessCheckSchedule = "*/15 * * * *"
+
+// How long a run is given to exit after being asked to stop, once the runs in
+// progress were terminated.
+const stopGracePeriod = 30 * time.Second
+
+// runStatus is the status of a run of a job.
+type runStatus struct {
+	Trigger   string     `json:"trigger"`
+	StartTime time.Time  `json:"startTime"`
+	EndTime   *time.Time `json:"endTime,omitempty"`
+	Succeeded bool       `json:"succeeded"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// job is the backup, a maintenance task or the freshness check, run by a
+// script of the agent on its own schedule.
+type job struct {
+	name    string
+	command []string
+
+	expression string
+	schedule   cron.Schedule
+	next       time.Time
+
+	running bool
+	last    *runStatus
+}
+
+// supervisor schedules and runs the backup and the maintenance tasks of the
+// agent, and keeps track of their status.
+type supervisor struct {
+	logger     *slog.Logger
+	scriptsDir string
+
+	// now returns the current time, replaced by the tests
+	now func() time.Time
+
+	// The schedule is not used when the runs are triggered by the central
+	// scheduler of the controller
+	central        bool
+	timeZone       string
+	scheduleFile   string
+	policyFile     string
+	reloadInterval time.Duration
+	freshness      common.Freshness
+
+	mu          sync.Mutex
+	backup      *job
+	maintenance []*job
+	ready       bool
+	lastTick    time.Time
+	lastError   string
+	lastErrorAt *time.Time
+	wake        chan struct{}
+	wg          sync.WaitGroup
+
+	// The runs outlive the context of the supervisor until they are terminated
+	runCtx   context.Context
+	stopRuns context.CancelFunc
+
+	// freshnessCheck reports the overdue backups, when a bound is set
+	freshnessCheck *job
+}
+
+// newSupervisor returns a supervisor running the scripts of the given
+// directory, configured by the environment of the agent.
+func newSupervisor(logger *slog.Logger, scriptsDir string) *supervisor {
+	s := &supervisor{
+		logger:         logger,
+		scriptsDir:     scriptsDir,
+		now:            time.Now,
+		central:        os.Getenv(BC_CENTRAL_SCHEDULER) == "true",
+		scheduleFile:   os.Getenv(BC_SCHEDULE_FILE),
+		policyFile:     os.Getenv(BC_POLICY_FILE),
+		reloadInterval: envSeconds(BC_SCHEDULE_RELOAD_INTERVAL, 30*time.Second),
+		freshness:      common.FreshnessFromEnv(),
+		backup: &job{
+			name:    "backup",
+			command: []string{filepath.Join(scriptsDir, "run-backup.sh")},
+		},
+		wake: make(chan struct{}, 1),
+	}
+	s.runCtx, s.stopRuns = context.WithCancel(context.Background())
+
+	// The maintenance tasks without a schedule run after each backup
+	settings := s.readSettings()
+	s.timeZone = settings.get(BC_SCHEDULE_TIMEZONE)
+	for _, task := range maintenanceTasks {
+		if expression := s.taskExpression(settings, task.name, task.variable); expression != "" {
+			s.maintenance = append(s.maintenance, &job{
+				name:       task.name,
+				command:    s.taskCommand(task.name),
+				expression: expression,
+			})
+		}
+	}
+
+	if s.freshness.Enabled() {
+		s.freshnessCheck = &job{
+			name:       "freshness",
+			command:    []string{filepath.Join(scriptsDir, "check-freshness.sh")},
+			expression: common.GetEnv(BC_FRESHNESS_CHECK_SCHEDULE, defaultFreshnessCheckSchedule),
+		}
+	}
+
+	return s
+}
+
+// jobs returns the jobs of the agent. The caller must hold the lock.
+func (s *supervisor) jobs() []*job {
+	jobs := append([]*job{s.backup}, s.maintenance...)
+	if s.freshnessCheck != nil {
+		jobs = append(jobs, s.freshnessCheck)
+	}
+	return jobs
+}
+
+// run schedules the jobs until the context is done, then terminates the runs
+// in progress and waits for them to exit.
+func (s *supervisor) run(ctx context.Context) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+
+	// Checking the latest snapshot may take a while on a large repository
+	if os.Getenv(BC_CATCHUP_POLICY) == "Immediate" && !s.central {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.catchUp(ctx)
+		}()
+	}
+
+	// The latest backup of the agent may be older than its start
+	if s.freshness.Enabled() {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.seedLastSuccess(ctx)
+		}()
+	}
+
+	reload := time.NewTicker(s.reloadInterval)
+	defer reload.Stop()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		s.tick(ctx)
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if next, ok := s.nextRun(); ok {
+			timer.Reset(next.Sub(s.now()))
+		}
+
+		select {
+		case <-ctx.Done():
+			s.terminate()
+			s.wg.Wait()
+			return nil
+		case <-reload.C:
+			s.reload()
+		case <-timer.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// init parses the schedules of the jobs.
+func (s *supervisor) init() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs() {
+		if j == s.backup {
+			continue
+		}
+		schedule, err := s.parse(j.expression)
+		if err != nil {
+			return fmt.Errorf("invalid schedule %q of the %s task: %w", j.expression, j.name, err)
+		}
+		j.schedule = schedule
+		j.next = schedule.Next(s.now())
+	}
+
+	if s.central {
+		s.logger.Info("the backups are triggered by the central scheduler")
+		s.ready = true
+		return nil
+	}
+
+	expression := os.Getenv(BC_SCHEDULE)
+	if value, ok := s.readScheduleFile(); ok {
+		expression = value
+	}
+	if err := s.setSchedule(expression); err != nil {
+		return err
+	}
+	s.ready = true
+
+	return nil
+}
+
+// reload applies the schedule and the settings of the ConfigMap maintained by
+// the controller when they change: the time zone and the schedules of the
+// maintenance tasks. The runs load the other settings themselves.
+func (s *supervisor) reload() {
+	settings := s.readSettings()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timeZone := settings.get(BC_SCHEDULE_TIMEZONE)
+	reparse := timeZone != s.timeZone
+	if reparse {
+		if _, err := time.LoadLocation(timeZone); err != nil {
+			s.logger.Error("unable to reload the time zone", "timeZone", timeZone, "error", err)
+			reparse = false
+		} else {
+			s.timeZone = timeZone
+			s.logger.Info("reloaded the time zone", "timeZone", timeZone)
+		}
+	}
+
+	// The jobs in progress keep their status
+	var maintenance []*job
+	for _, task := range maintenanceTasks {
+		expression := s.taskExpression(settings, task.name, task.variable)
+		if expression == "" {
+			continue
+		}
+		j := &job{name: task.name, command: s.taskCommand(task.name)}
+		for _, existing := range s.maintenance {
+			if existing.name == task.name {
+				j = existing
+			}
+		}
+		if j.schedule == nil || j.expression != expression || reparse {
+			schedule, err := s.parse(expression)
+			if err != nil {
+				s.logger.Error("unable to reload the schedule of the task", "task", task.name, "schedule", expression, "error", err)
+				if j.schedule == nil {
+					continue
+				}
+			} else {
+				j.expression = expression
+				j.schedule = schedule
+				j.next = schedule.Next(s.now())
+				s.logger.Info("reloaded the schedule of the task", "task", task.name, "schedule", expression)
+			}
+		}
+		maintenance = append(maintenance, j)
+	}
+	s.maintenance = maintenance
+
+	if reparse && s.freshnessCheck != nil {
+		if schedule, err := s.parse(s.freshnessCheck.expression); err == nil {
+			s.freshnessCheck.schedule = schedule
+			s.freshnessCheck.next = schedule.Next(s.now())
+		}
+	}
+
+	if s.central {
+		return
+	}
+
+	expression, ok := s.readScheduleFile()
+	if !ok || (expression == s.backup.expression && !reparse) {
+		return
+	}
+	if err := s.setSchedule(expression); err != nil {
+		s.logger.Error("unable to reload the schedule", "schedule", expression, "error", err)
+		return
+	}
+	s.logger.Info("reloaded the schedule", "schedule", expression)
+}
+
+// maintenanceTasks lists the maintenance tasks run on their own schedule,
+// along with the variable holding it. The verification of the restores is
+// listed with them.
+var maintenanceTasks = []struct{ name, variable string }{
+	{"forget", BC_FORGET_SCHEDULE},
+	{"prune", BC_PRUNE_SCHEDULE},
+	{"check", BC_CHECK_SCHEDULE},
+	{"verify", BC_VERIFY_SCHEDULE},
+}
+
+// taskExpression returns the schedule of a maintenance task, empty when it
+// runs after each backup or not at all. The verification runs need the scratch
+// volume added when the pod is created.
+func (s *supervisor) taskExpression(settings settings, task, variable string) string {
+	if task == "verify" && os.Getenv(BC_VERIFY_DIR) == "" {
+		return ""
+	}
+	return settings.get(variable)
+}
+
+// taskCommand returns the command running a maintenance task.
+func (s *supervisor) taskCommand(task string) []string {
+	if task == "verify" {
+		return []string{filepath.Join(s.scriptsDir, "run-verify.sh")}
+	}
+	return []string{filepath.Join(s.scriptsDir, "run-maintenance.sh"), task}
+}
+
+// settings are the settings of the schedule and of the policy published by the
+// controller, as "NAME=value" lines. An empty value drops a setting.
+type settings map[string]string
+
+// get returns the value of the setting, or the variable set when the pod was
+// created when it is not published.
+func (v settings) get(name string) string {
+	if value, ok := v[name]; ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// readSettings reads the settings of the schedule and of the policy.
+func (s *supervisor) readSettings() settings {
+	values := make(settings)
+	var files []string
+	if s.scheduleFile != "" {
+		files = append(files, s.scheduleFile+".env")
+	}
+	if s.policyFile != "" {
+		files = append(files, s.policyFile)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			name, value, ok := strings.Cut(line, "=")
+			if ok && strings.HasPrefix(name, "BC_") {
+				values[name] = value
+			}
+		}
+	}
+	return values
+}
+
+// setSchedule sets the schedule of the backup and records it for the scripts.
+// The caller must hold the lock.
+func (s *supervisor) setSchedule(expression string) error {
+	schedule, err := s.parse(expression)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", expression, err)
+	}
+
+	s.backup.expression = expression
+	s.backup.schedule = schedule
+	s.backup.next = schedule.Next(s.now())
+
+	dir := common.StateDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "schedule"), []byte(expression+"\n"), 0o644)
+}
+
+func (s *supervisor) readScheduleFile() (string, bool) {
+	if s.scheduleFile == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(s.scheduleFile)
+	if err != nil {
+		return "", false
+	}
+	expression := strings.TrimSpace(string(data))
+	return expression, expression != ""
+}
+
+func (s *supervisor) parse(expression string) (cron.Schedule, error) {
+	return common.ParseSchedule(expression, s.timeZone)
+}
+
+// tick starts the jobs which are due.
+func (s *supervisor) tick(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.lastTick = now
+
+	for _, j := range s.jobs() {
+		if j.schedule == nil || now.Before(j.next) {
+			continue
+		}
+		j.next = j.schedule.Next(now)
+		s.start(ctx, j, TRIGGER_SCHEDULE)
+	}
+}
+
+// nextRun returns the time the next job is due.
+func (s *supervisor) nextRun() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, j := range s.jobs() {
+		if j.schedule != nil && (next.IsZero() || j.next.Before(next)) {
+			next = j.next
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// trigger starts a backup, unless one is already in progress.
+func (s *supervisor) trigger(ctx context.Context, trigger string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.start(ctx, s.backup, trigger)
+}
+
+// catchUp starts a backup when the latest snapshot is older than the previous
+// run of the schedule, using the helpers of the scripts.
+func (s *supervisor) catchUp(ctx context.Context) {
+	s.mu.Lock()
+	expression, timeZone := s.backup.expression, s.timeZone
+	s.mu.Unlock()
+
+	previous, err := common.PreviousRun(expression, timeZone, s.now())
+	if err != nil {
+		s.logger.Warn("unable to compute the previous run of the schedule; not catching up", "schedule", expression, "error", err)
+		return
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/bash", "-c", `source "${BC_SCRIPTS_DIR}/lib/common.sh" &&
+		[ "$(last_backup_time)" -lt $(($1 - ${BC_CATCHUP_GRACE_PERIOD:-0})) ]`, "catch-up", strconv.FormatInt(previous.Unix(), 10))
+	cmd.Env = append(os.Environ(), "BC_SCRIPTS_DIR="+s.scriptsDir)
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			s.logger.Error("unable to check whether a run was missed", "error", err)
+			return
+		}
+		s.logger.Info("the latest backup is up to date; no catch-up needed")
+		return
+	}
+
+	s.logger.Info("missed the previous run of the schedule; running a catch-up backup")
+	s.trigger(ctx, TRIGGER_CATCH_UP)
+}
+
+// seedLastSuccess records the time of the latest backup of the agent found in
+// the repository, using the helpers of the scripts.
+func (s *supervisor) seedLastSuccess(ctx context.Context) {
+	cmd := exec.CommandContext(ctx, "/bin/bash", "-c", `source "${BC_SCRIPTS_DIR}/lib/common.sh" && seed_last_success`)
+	cmd.Env = append(os.Environ(), "BC_SCRIPTS_DIR="+s.scriptsDir)
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		s.logger.Warn("unable to find the latest backup in the repository", "error", err)
+	}
+}
+
+// terminate lets the run in progress complete within the grace period of the
+// pod, or aborts it, using the helpers of the scripts, then stops the runs
+// left.
+func (s *supervisor) terminate() {
+	defer s.stopRuns()
+
+	s.logger.Info("terminating the runs in progress")
+	cmd := exec.Command("/bin/bash", "-c", `source "${BC_SCRIPTS_DIR}/lib/common.sh" && terminate_runs`)
+	cmd.Env = append(os.Environ(), "BC_SCRIPTS_DIR="+s.scriptsDir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		s.logger.Warn("unable to terminate the runs in progress", "error", err)
+	}
+}
+
+// start runs the job in the background, unless it is already in progress.
+// The caller must hold the lock.
+func (s *supervisor) start(ctx context.Context, j *job, trigger string) bool {
+	logger := s.logger.With("job", j.name, "trigger", trigger)
+
+	if j.running {
+		logger.Warn("skipping the run because the previous one is still in progress")
+		return false
+	}
+
+	run := &runStatus{Trigger: trigger, StartTime: s.now()}
+	j.running = true
+	j.last = run
+
+	logger.Info("starting the run")
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		err := s.exec(s.runCtx, j.command)
+
+		s.mu.Lock()
+		end := s.now()
+		run.EndTime = &end
+		run.Succeeded = err == nil
+		if err != nil {
+			run.Error = err.Error()
+			s.lastError = run.Error
+			s.lastErrorAt = &end
+		}
+		j.running = false
+		s.mu.Unlock()
+
+		if err != nil {
+			logger.Error("the run failed", "error", err)
+			// The scripts report their own failures; only report the runs
+			// which could not complete
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || !exitErr.Exited() {
+				outputs.SetError(context.WithoutCancel(ctx), fmt.Errorf("%s run failed at %s: %w", j.name, end.Format(time.DateTime), err))
+			}
+		} else {
+			logger.Info("the run succeeded", "duration", end.Sub(run.StartTime).Round(time.Second).String())
+		}
+
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}()
+
+	return true
+}
+
+// exec runs the command, forwarding its output to the logs of the agent. The
+// error holds the last line of the output when the command fails.
+func (s *supervisor) exec(ctx context.Context, command []string) error {
+	output := &lastLineWriter{w: os.Stdout}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = stopGracePeriod
+
+	if err := cmd.Run(); err != nil {
+		if line := output.last(); line != "" {
+			return fmt.Errorf("%w: %s", err, line)
+		}
+		return err
+	}
+	return nil
+}
+
+// status returns the status of the agent.
+func (s *supervisor) status() status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := status{
+		Phase:         PHASE_IDLE,
+		Schedule:      s.backup.expression,
+		TimeZone:      s.timeZone,
+		Central:       s.central,
+		LastRun:       copyRun(s.backup.last),
+		LastError:     s.lastError,
+		LastErrorTime: s.lastErrorAt,
+	}
+	if s.backup.schedule != nil {
+		st.NextRun = &s.backup.next
+	}
+	if s.freshness.Enabled() {
+		f := freshnessOf(s.freshness)
+		st.LastSuccess = f.LastSuccess
+		st.Freshness = f.State
+	}
+
+	for _, j := range s.maintenance {
+		if j.running && st.Phase == PHASE_IDLE {
+			st.Phase = PHASE_MAINTENANCE
+		}
+		st.Maintenance = append(st.Maintenance, taskStatus{
+			Task:     j.name,
+			Schedule: j.expression,
+			NextRun:  j.next,
+			LastRun:  copyRun(j.last),
+		})
+	}
+	if s.backup.running {
+		st.Phase = PHASE_BACKUP
+	}
+
+	return st
+}
+
+// freshnessOf returns the freshness of the backups against the bounds.
+func freshnessOf(bounds common.Freshness) freshnessStatus {
+	last, ok := common.LastSuccess()
+	age := time.Since(last)
+
+	st := freshnessStatus{
+		State:     bounds.State(age),
+		Age:       int64(age.Seconds()),
+		WarnAfter: int64(bounds.WarnAfter.Seconds()),
+		FailAfter: int64(bounds.FailAfter.Seconds()),
+	}
+	if ok {
+		st.LastSuccess = &last
+	}
+	return st
+}
+
+// healthy tells whether the scheduling loop is alive.
+func (s *supervisor) healthy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.lastTick.IsZero() && s.now().Sub(s.lastTick) < 3*s.reloadInterval
+}
+
+// isReady tells whether the schedules are loaded.
+func (s *supervisor) isReady() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ready
+}
+
+func copyRun(run *runStatus) *runStatus {
+	if run == nil {
+		return nil
+	}
+	c := *run
+	return &c
+}
+
+// lastLineWriter forwards the output of a command and keeps its last line.
+type lastLineWriter struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	partial []byte
+	line    string
+}
+
+func (l *lastLineWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(l.partial[:i])); line != "" {
+			l.line = line
+		}
+		l.partial = l.partial[i+1:]
+	}
+
+	return l.w.Write(p)
+}
+
+func (l *lastLineWriter) last() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if line := strings.TrimSpace(string(l.partial)); line != "" {
+		return line
+	}
+	return l.line
+}
+
+// envSeconds returns the duration of the variable, in seconds.
+func envSeconds(name string, defaultValue time.Duration) time.Duration {
+	var seconds int
+	if _, err := fmt.Sscanf(os.Getenv(name), "%d", &seconds); err != nil || seconds <= 0 {
+		return defaultValue
+	}
+	return time.Duration(seconds) * time.Second
+}
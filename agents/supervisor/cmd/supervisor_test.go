@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// clock is a fake clock moved forward by the tests.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// setEnv sets the environment variables for the current spec.
+func setEnv(env map[string]string) {
+	for name, value := range env {
+		Expect(os.Setenv(name, value)).To(Succeed())
+		DeferCleanup(os.Unsetenv, name)
+	}
+}
+
+// writeScript writes a script of the agent to the scripts directory.
+func writeScript(dir, name, content string) {
+	Expect(os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+content+"\n"), 0o755)).To(Succeed())
+}
+
+// testSupervisor returns a supervisor running the scripts of a temporary
+// directory, whose run-backup.sh appends a line to the returned file, on the
+// given clock.
+func testSupervisor(c *clock) (*supervisor, string, string) {
+	dir := GinkgoT().TempDir()
+	runs := filepath.Join(dir, "runs")
+	writeScript(dir, "run-backup.sh", "echo backup >>"+runs)
+	writeScript(dir, "run-maintenance.sh", `echo "$1" >>`+runs)
+
+	setEnv(map[string]string{"BC_STATE_DIR": filepath.Join(dir, "state")})
+
+	s := newSupervisor(slog.New(slog.NewTextHandler(io.Discard, nil)), dir)
+	s.now = c.Now
+	DeferCleanup(func() {
+		s.stopRuns()
+		s.wg.Wait()
+	})
+	return s, dir, runs
+}
+
+// readRuns returns the lines written by the scripts.
+func readRuns(file string) string {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	Expect(err).NotTo(HaveOccurred())
+	return string(data)
+}
+
+var _ = Describe("Supervisor", func() {
+	var (
+		ctx context.Context
+		c   *clock
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		c = &clock{now: time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC)}
+		setEnv(map[string]string{"BC_SCHEDULE": "0 3 * * *"})
+	})
+
+	Describe("scheduling", func() {
+		It("runs the backup once it is due", func() {
+			s, _, runs := testSupervisor(c)
+			Expect(s.init()).To(Succeed())
+			Expect(s.isReady()).To(BeTrue())
+
+			next, ok := s.nextRun()
+			Expect(ok).To(BeTrue())
+			Expect(next).To(Equal(time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC)))
+
+			s.tick(ctx)
+			s.wg.Wait()
+			Expect(readRuns(runs)).To(BeEmpty())
+
+			c.Set(next)
+			s.tick(ctx)
+			s.wg.Wait()
+			Expect(readRuns(runs)).To(Equal("backup\n"))
+
+			st := s.status()
+			Expect(st.Phase).To(Equal(PHASE_IDLE))
+			Expect(st.LastRun.Trigger).To(Equal(TRIGGER_SCHEDULE))
+			Expect(st.LastRun.Succeeded).To(BeTrue())
+			Expect(st.NextRun).To(HaveValue(Equal(time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC))))
+		})
+
+		It("applies the time zone of the schedule", func() {
+			setEnv(map[string]string{BC_SCHEDULE_TIMEZONE: "Europe/Paris"})
+			s, _, _ := testSupervisor(c)
+			Expect(s.init()).To(Succeed())
+
+			next, _ := s.nextRun()
+			Expect(next).To(Equal(time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC)))
+		})
+
+		It("runs the maintenance tasks with their own schedule", func() {
+			setEnv(map[string]string{BC_PRUNE_SCHEDULE: "0 2 * * *"})
+			s, _, runs := testSupervisor(c)
+			Expect(s.init()).To(Succeed())
+
+			next, _ := s.nextRun()
+			Expect(next).To(Equal(time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC)))
+
+			c.Set(next)
+			s.tick(ctx)
+			s.wg.Wait()
+			Expect(readRuns(runs)).To(Equal("prune\n"))
+			Expect(s.status().Maintenance).To(ConsistOf(HaveField("Task", "prune")))
+		})
+
+		It("reloads the schedule published by the controller", func() {
+			dir := GinkgoT().TempDir()
+			file := filepath.Join(dir, "daily")
+			Expect(os.WriteFile(file, []byte("0 3 * * *\n"), 0o644)).To(Succeed())
+			setEnv(map[string]string{BC_SCHEDULE_FILE: file})
+
+			s, _, _ := testSupervisor(c)
+			Expect(s.init()).To(Succeed())
+
+			Expect(os.WriteFile(file, []byte("30 1 * * *\n"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(file+".env", []byte("BC_CHECK_SCHEDULE=0 4 * * 0\n"), 0o644)).To(Succeed())
+			s.reload()
+
+			next, _ := s.nextRun()
+			Expect(next).To(Equal(time.Date(2025, 1, 1, 1, 30, 0, 0, time.UTC)))
+			Expect(s.status().Schedule).To(Equal("30 1 * * *"))
+			Expect(s.status().Maintenance).To(ConsistOf(HaveField("Task", "check")))
+		})
+
+		It("rejects an invalid schedule", func() {
+			setEnv(map[string]string{BC_SCHEDULE: "every day"})
+			s, _, _ := testSupervisor(c)
+			Expect(s.init()).To(MatchError(ContainSubstring(`invalid schedule "every day"`)))
+			Expect(s.isReady()).To(BeFalse())
+		})
+
+		It("does not schedule the backups triggered by the central scheduler", func() {
+			setEnv(map[string]string{BC_CENTRAL_SCHEDULER: "true"})
+			s, _, _ := testSupervisor(c)
+			Expect(s.init()).To(Succeed())
+			Expect(s.isReady()).To(BeTrue())
+
+			_, ok := s.nextRun()
+			Expect(ok).To(BeFalse())
+			Expect(s.status().Central).To(BeTrue())
+		})
+
+		It("is healthy while the scheduling loop ticks", func() {
+			s, _, _ := testSupervisor(c)
+			Expect(s.init()).To(Succeed())
+			Expect(s.healthy()).To(BeFalse())
+
+			s.tick(ctx)
+			Expect(s.healthy()).To(BeTrue())
+
+			c.Set(c.Now().Add(3 * s.reloadInterval))
+			Expect(s.healthy()).To(BeFalse())
+		})
+	})
+
+	Describe("triggers", func() {
+		It("does not start a backup while one is in progress", func() {
+			s, dir, runs := testSupervisor(c)
+			release := filepath.Join(dir, "release")
+			writeScript(dir, "run-backup.sh", "while [ ! -e "+release+" ]; do sleep 0.01; done; echo backup >>"+runs)
+			Expect(s.init()).To(Succeed())
+
+			Expect(s.trigger(ctx, TRIGGER_API)).To(BeTrue())
+			Expect(s.status().Phase).To(Equal(PHASE_BACKUP))
+			Expect(s.trigger(ctx, TRIGGER_API)).To(BeFalse())
+
+			Expect(os.WriteFile(release, nil, 0o644)).To(Succeed())
+			s.wg.Wait()
+			Expect(readRuns(runs)).To(Equal("backup\n"))
+			Expect(s.status().LastRun.Trigger).To(Equal(TRIGGER_API))
+
+			Expect(s.trigger(ctx, TRIGGER_API)).To(BeTrue())
+			s.wg.Wait()
+			Expect(readRuns(runs)).To(Equal("backup\nbackup\n"))
+		})
+
+		It("records the last line of a failed run", func() {
+			s, dir, _ := testSupervisor(c)
+			writeScript(dir, "run-backup.sh", "echo starting; echo the repository is locked; exit 11")
+			Expect(s.init()).To(Succeed())
+
+			Expect(s.trigger(ctx, TRIGGER_API)).To(BeTrue())
+			s.wg.Wait()
+
+			st := s.status()
+			Expect(st.LastRun.Succeeded).To(BeFalse())
+			Expect(st.LastRun.EndTime).To(HaveValue(Equal(c.Now())))
+			Expect(st.LastError).To(Equal("exit status 11: the repository is locked"))
+			Expect(st.LastErrorTime).To(HaveValue(Equal(c.Now())))
+		})
+	})
+
+	Describe("catch-up", func() {
+		// catchUpSupervisor returns a supervisor whose latest backup was taken
+		// at the given time.
+		catchUpSupervisor := func(last time.Time) (*supervisor, string) {
+			s, dir, runs := testSupervisor(c)
+			Expect(os.MkdirAll(filepath.Join(dir, "lib"), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "lib", "common.sh"),
+				[]byte(fmt.Sprintf("last_backup_time() { echo %d; }\n", last.Unix())), 0o644)).To(Succeed())
+			Expect(s.init()).To(Succeed())
+			return s, runs
+		}
+
+		It("runs a backup when the previous run of the schedule was missed", func() {
+			s, runs := catchUpSupervisor(time.Date(2024, 12, 31, 2, 0, 0, 0, time.UTC))
+			s.catchUp(ctx)
+			s.wg.Wait()
+			Expect(readRuns(runs)).To(Equal("backup\n"))
+			Expect(s.status().LastRun.Trigger).To(Equal(TRIGGER_CATCH_UP))
+		})
+
+		It("does not run a backup when the latest one is up to date", func() {
+			s, runs := catchUpSupervisor(time.Date(2024, 12, 31, 3, 5, 0, 0, time.UTC))
+			s.catchUp(ctx)
+			s.wg.Wait()
+			Expect(readRuns(runs)).To(BeEmpty())
+		})
+
+		It("leaves out the grace period", func() {
+			setEnv(map[string]string{"BC_CATCHUP_GRACE_PERIOD": "7200"})
+			s, runs := catchUpSupervisor(time.Date(2024, 12, 31, 2, 0, 0, 0, time.UTC))
+			s.catchUp(ctx)
+			s.wg.Wait()
+			Expect(readRuns(runs)).To(BeEmpty())
+		})
+
+		It("does not run a backup without a previous run of the schedule", func() {
+			setEnv(map[string]string{"BC_SCHEDULE": "@every 1h"})
+			s, runs := catchUpSupervisor(time.Time{})
+			s.catchUp(ctx)
+			s.wg.Wait()
+			Expect(readRuns(runs)).To(BeEmpty())
+		})
+	})
+})
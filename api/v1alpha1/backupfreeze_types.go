@@ -0,0 +1,62 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupFreezeSpec defines the desired state of BackupFreeze.
+type BackupFreezeSpec struct {
+	// Reason is reported by the agents when they skip a run (optional).
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Start is the beginning of the freeze. Defaults to its creation.
+	// +optional
+	Start *metav1.Time `json:"start,omitempty"`
+
+	// End is the end of the freeze. Without it, the freeze lasts until it is deleted.
+	// +optional
+	End *metav1.Time `json:"end,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.spec.reason`
+// +kubebuilder:printcolumn:name="End",type=string,JSONPath=`.spec.end`
+
+// BackupFreeze pauses all the backups of the cluster while it is in effect.
+type BackupFreeze struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BackupFreezeSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BackupFreezeList contains a list of BackupFreeze.
+type BackupFreezeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BackupFreeze `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BackupFreeze{}, &BackupFreezeList{})
+}
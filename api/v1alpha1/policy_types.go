@@ -18,6 +18,7 @@ package v1alpha1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -44,13 +45,14 @@ type Image struct {
 //
 // Fields:
 //   - Image: The exporter image (e.g. "ngosang/restic-exporter"). Required when Exporter is set.
-//   - Port: The port the exporter serves /metrics on. Defaults to 8001.
+//   - Port: The port the exporter serves /metrics on. Defaults to 8001, moved to the next free port when it is already taken in the pod.
 //   - Environment: Extra environment variables for the exporter (restic credentials are inherited from the agent).
 type Exporter struct {
 	// Image of the exporter.
 	Image Image `json:"image"`
 
-	// Port the exporter serves metrics on (optional, default 8001).
+	// Port the exporter serves metrics on (optional, default 8001), moved to the
+	// next free port when it is already taken in the pod.
 	Port int32 `json:"port,omitempty"`
 
 	// Environment declares extra environment variables for the exporter (optional).
@@ -98,15 +100,312 @@ type CopyVolumeMount struct {
 	ContainerName string `json:"container,omitempty"`
 }
 
+// VolumeType is the type of the source of a pod volume.
+// +kubebuilder:validation:Enum=hostPath;persistentVolumeClaim;nfs;iscsi;fc;csi;ephemeral;emptyDir;configMap;secret
+type VolumeType string
+
+const (
+	VolumeTypeHostPath              VolumeType = "hostPath"
+	VolumeTypePersistentVolumeClaim VolumeType = "persistentVolumeClaim"
+	VolumeTypeNFS                   VolumeType = "nfs"
+	VolumeTypeISCSI                 VolumeType = "iscsi"
+	VolumeTypeFC                    VolumeType = "fc"
+	VolumeTypeCSI                   VolumeType = "csi"
+	VolumeTypeEphemeral             VolumeType = "ephemeral"
+	VolumeTypeEmptyDir              VolumeType = "emptyDir"
+	VolumeTypeConfigMap             VolumeType = "configMap"
+	VolumeTypeSecret                VolumeType = "secret"
+)
+
+// AutoDetect configures the detection of the volume mounts to back up. A mount
+// is copied when its volume and its container are included and not excluded.
+//
+// Fields:
+//   - VolumeTypes: The accepted volume sources. Defaults to hostPath, persistentVolumeClaim, nfs, iscsi, fc and csi.
+//   - ExcludeVolumeTypes: The rejected volume sources.
+//   - Volumes: Glob patterns on the names of the accepted volumes, among those of an accepted source. Defaults to all.
+//   - ExcludeVolumes: Glob patterns on the names of the rejected volumes.
+//   - MountPaths: Glob patterns on the accepted mount paths. Defaults to all.
+//   - ExcludeMountPaths: Glob patterns on the rejected mount paths.
+//   - Containers: The names of the containers whose mounts are copied. Defaults to all.
+//   - ReadOnly: Whether the copied mounts are read-only in the agent.
+type AutoDetect struct {
+	// VolumeTypes lists the accepted volume sources (optional).
+	VolumeTypes []VolumeType `json:"volumeTypes,omitempty"`
+
+	// ExcludeVolumeTypes lists the rejected volume sources (optional).
+	ExcludeVolumeTypes []VolumeType `json:"excludeVolumeTypes,omitempty"`
+
+	// Volumes lists glob patterns on the names of the accepted volumes (optional).
+	Volumes []string `json:"volumes,omitempty"`
+
+	// ExcludeVolumes lists glob patterns on the names of the rejected volumes (optional).
+	ExcludeVolumes []string `json:"excludeVolumes,omitempty"`
+
+	// MountPaths lists glob patterns on the accepted mount paths (optional).
+	MountPaths []string `json:"mountPaths,omitempty"`
+
+	// ExcludeMountPaths lists glob patterns on the rejected mount paths (optional).
+	ExcludeMountPaths []string `json:"excludeMountPaths,omitempty"`
+
+	// Containers lists the containers whose mounts are copied (optional).
+	Containers []string `json:"containers,omitempty"`
+
+	// ReadOnly copies the mounts as read-only (optional).
+	ReadOnly bool `json:"readOnly,omitempty"`
+}
+
+// BackupOptions configures the restic backup run by the agent. The options can
+// be overridden per pod with the backup options annotation.
+//
+// Fields:
+//   - Exclude: Patterns of the files to exclude (restic --exclude).
+//   - Include: Patterns of the files to back up, relative to the backed up directories. Defaults to everything. Not supported by the PostgreSQL agent.
+//   - ExcludeCaches: Whether to exclude the directories holding a CACHEDIR.TAG file (restic --exclude-caches).
+//   - ExcludeLargerThan: The maximum size of the backed up files, e.g. "1G" (restic --exclude-larger-than).
+//   - OneFileSystem: Whether to stay on the file system of the backed up directories (restic --one-file-system).
+//   - Compression: The compression mode of the repository, "auto", "off" or "max" (restic --compression).
+//   - ReadConcurrency: The number of files read concurrently (restic --read-concurrency).
+type BackupOptions struct {
+	// Exclude lists the patterns of the excluded files (optional).
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Include lists the patterns of the backed up files (optional). The
+	// PostgreSQL agent fails when it is set.
+	Include []string `json:"include,omitempty"`
+
+	// ExcludeCaches excludes the cache directories (optional).
+	ExcludeCaches bool `json:"excludeCaches,omitempty"`
+
+	// ExcludeLargerThan excludes the files larger than the given size (optional).
+	// +kubebuilder:validation:Pattern=`^[0-9]+[kKmMgGtT]?$`
+	ExcludeLargerThan string `json:"excludeLargerThan,omitempty"`
+
+	// OneFileSystem stays on the file system of the backed up directories (optional).
+	OneFileSystem bool `json:"oneFileSystem,omitempty"`
+
+	// Compression sets the compression mode (optional, default auto).
+	// +kubebuilder:validation:Enum=auto;off;max
+	Compression string `json:"compression,omitempty"`
+
+	// ReadConcurrency sets the number of files read concurrently (optional).
+	// +kubebuilder:validation:Minimum=1
+	ReadConcurrency int32 `json:"readConcurrency,omitempty"`
+}
+
+// PolicyMode defines how the backup agent is run for the pods using a policy.
+// +kubebuilder:validation:Enum=Sidecar;CronJob;Snapshot
+type PolicyMode string
+
+const (
+	// PolicyModeSidecar injects the backup agent as a sidecar container in every pod.
+	PolicyModeSidecar PolicyMode = "Sidecar"
+
+	// PolicyModeCronJob runs the backup agent in a single CronJob per owning workload
+	// and volume instead of injecting it in the pods. It is meant for ReadWriteMany
+	// volumes (e.g. NFS) shared by several replicas, which would otherwise all back
+	// up the same data.
+	PolicyModeCronJob PolicyMode = "CronJob"
+
+	// PolicyModeSnapshot backs up a crash-consistent copy of the volumes: on each
+	// run the controller takes a CSI VolumeSnapshot of every backed up
+	// PersistentVolumeClaim, provisions a temporary claim from it and runs the
+	// agent once against it in a short-lived Job. The snapshot and the claim are
+	// deleted once the Job is finished.
+	PolicyModeSnapshot PolicyMode = "Snapshot"
+)
+
+// Snapshot configures the CSI snapshots taken by the policies in Snapshot mode.
+//
+// Fields:
+//   - VolumeSnapshotClassName: The VolumeSnapshotClass of the snapshots. If omitted, the default class of the CSI driver is used.
+//   - StorageClassName: The StorageClass of the temporary claims restored from the snapshots. If omitted, the class of the source claim is used.
+type Snapshot struct {
+	// VolumeSnapshotClassName of the snapshots (optional).
+	VolumeSnapshotClassName *string `json:"volumeSnapshotClassName,omitempty"`
+
+	// StorageClassName of the temporary claims (optional).
+	StorageClassName *string `json:"storageClassName,omitempty"`
+}
+
+// Hooks are the commands run around the backups.
+type Hooks struct {
+	// Pre are run in order before the backup. The backup is skipped when one of
+	// them fails with the Fail error policy.
+	// +optional
+	Pre []Hook `json:"pre,omitempty"`
+
+	// Post are run in order after the backup, even when it or a pre hook failed.
+	// +optional
+	Post []Hook `json:"post,omitempty"`
+}
+
+// HookErrorPolicy tells what a failure of a hook does to the backup.
+// +kubebuilder:validation:Enum=Fail;Continue
+type HookErrorPolicy string
+
+const (
+	// HookErrorPolicyFail fails the backup.
+	HookErrorPolicyFail HookErrorPolicy = "Fail"
+
+	// HookErrorPolicyContinue only reports the failure.
+	HookErrorPolicyContinue HookErrorPolicy = "Continue"
+)
+
+// Hook is a command run in a container of the pod.
+type Hook struct {
+	// Name identifies the hook in the logs and the reports (optional).
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Container is the name of the container the command is run in.
+	// +kubebuilder:validation:MinLength=1
+	Container string `json:"container"`
+
+	// Command is the command and its arguments. It is not run in a shell.
+	// +kubebuilder:validation:MinItems=1
+	Command []string `json:"command"`
+
+	// Timeout bounds the duration of the command (optional, default 1m).
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// OnError is the error policy of the hook (optional, default Fail).
+	// +kubebuilder:default=Fail
+	// +optional
+	OnError HookErrorPolicy `json:"onError,omitempty"`
+}
+
+// Freshness bounds the age of the latest successful backup of the agents.
+type Freshness struct {
+	// WarnAfter is the age past which the agent reports a WARNING through its
+	// output module (optional).
+	// +optional
+	WarnAfter *metav1.Duration `json:"warnAfter,omitempty"`
+
+	// FailAfter is the age past which the agent reports an error through its
+	// output module and fails its readiness probe, unless the policy sets one
+	// (optional). Note that an unready agent makes the whole pod unready.
+	// +optional
+	FailAfter *metav1.Duration `json:"failAfter,omitempty"`
+}
+
+// Termination configures how the agents handle the termination of their pod
+// while a backup is in progress: they wait for it to complete within the grace
+// period of the pod, then abort it, unlock the repository and report the
+// interruption.
+type Termination struct {
+	// GracePeriod raises the termination grace period of the pods running the
+	// agents, so that a backup in progress gets the time to complete (optional).
+	// The grace period of the pods is never lowered.
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+
+	// AbortPeriod is the time kept at the end of the grace period to abort the
+	// backup in progress and report it (optional, default 15s).
+	// +optional
+	AbortPeriod *metav1.Duration `json:"abortPeriod,omitempty"`
+}
+
+// Retry configures the retries of the backup, forget and prune steps of the
+// agents failing with a transient error of the backend, such as a connectivity
+// problem or a 5xx response. The outputs are only notified of the final state
+// of the run, once all the attempts are done.
+type Retry struct {
+	// MaxAttempts is the maximum number of attempts of each step (optional,
+	// default 1, i.e. no retry).
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	MaxAttempts int32 `json:"maxAttempts,omitempty"`
+
+	// Backoff is the delay before the first retry, doubled before each next one
+	// (optional, default 30s).
+	// +optional
+	Backoff *metav1.Duration `json:"backoff,omitempty"`
+}
+
+// Verify configures the verification runs of the agents: they restore the
+// latest snapshot of the agent, or a random sample of its files, into a scratch
+// emptyDir, check the restored files against the metadata of restic and,
+// optionally, against the live files unchanged since the snapshot, then report
+// a VERIFIED, WARNING or ERROR state to the output modules.
+type Verify struct {
+	// Schedule is the cron expression of the verification runs, in the time zone
+	// of the schedule.
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// SampleSize is the number of files of the snapshot randomly picked and
+	// restored (optional, default all the files).
+	// +kubebuilder:validation:Minimum=1
+	SampleSize int32 `json:"sampleSize,omitempty"`
+
+	// CompareLive compares the content of the restored files with the live files
+	// whose size and modification time did not change since the snapshot
+	// (optional).
+	CompareLive bool `json:"compareLive,omitempty"`
+
+	// ScratchSizeLimit limits the size of the scratch emptyDir (optional). A run
+	// selecting more data fails before restoring it, rather than getting the pod
+	// evicted.
+	// +optional
+	ScratchSizeLimit *resource.Quantity `json:"scratchSizeLimit,omitempty"`
+}
+
 // PolicySpec defines the desired state of Policy.
 type PolicySpec struct {
 	// Image specifies the Docker image to use.
 	Image Image `json:"image"`
 
+	// Mode selects how the backup agent is run (optional, default Sidecar).
+	//   - Sidecar: the agent is injected in every pod by the webhook.
+	//   - CronJob: the controller creates one CronJob per owning workload
+	//     (Deployment or StatefulSet) and volume, on the schedule's cron, and
+	//     nothing is injected in the pods.
+	//   - Snapshot: the controller runs the agent in a Job against a CSI snapshot
+	//     of each PersistentVolumeClaim of the owning workload, on the schedule's
+	//     cron, and nothing is injected in the pods.
+	// +kubebuilder:default=Sidecar
+	Mode PolicyMode `json:"mode,omitempty"`
+
+	// Snapshot configures the CSI snapshots taken in Snapshot mode (optional).
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+
+	// PodSelectorExpression is a CEL expression selecting the pods the policy
+	// applies to (optional). It gets the pod as the `pod` variable and must
+	// return a bool, e.g. `pod.metadata.name.endsWith("-0")`.
+	PodSelectorExpression string `json:"podSelectorExpression,omitempty"`
+
+	// Backup configures the restic backup run by the agent (optional).
+	Backup *BackupOptions `json:"backup,omitempty"`
+
 	// Exporter optionally injects a metrics exporter sidecar that shares the
 	// agent's credentials and exposes Prometheus metrics about the repository.
 	Exporter *Exporter `json:"exporter,omitempty"`
 
+	// Hooks are commands run in the containers of the pod around each backup,
+	// e.g. to quiesce the application (optional, Sidecar mode only). The default
+	// agent runs them through the exec API, with the token of a ServiceAccount
+	// the controller maintains in the namespace and mounts in the agents only.
+	Hooks *Hooks `json:"hooks,omitempty"`
+
+	// Freshness checks the age of the latest successful backup of each agent
+	// (optional, Sidecar mode only). Until an agent completes or finds a
+	// backup, the age counts from its start.
+	Freshness *Freshness `json:"freshness,omitempty"`
+
+	// Termination configures the handling of the termination of the pods
+	// running the agents (optional).
+	Termination *Termination `json:"termination,omitempty"`
+
+	// Retry configures the retries of the steps of the backup runs failing with
+	// a transient error (optional, default no retry).
+	Retry *Retry `json:"retry,omitempty"`
+
+	// Verify schedules the verification of the restores of the backups
+	// (optional, Sidecar mode only).
+	Verify *Verify `json:"verify,omitempty"`
+
 	// Environment declares a list of environment variables to declare.
 	Environment []corev1.EnvVar `json:"environment,omitempty"`
 
@@ -120,10 +419,18 @@ type PolicySpec struct {
 	// If set to true, the controller will attempt to identify and replicate the appropriate volume mounts.
 	AutoDetectVolumeMounts bool `json:"autoDetectVolumeMounts,omitempty"`
 
+	// AutoDetect configures the automatic detection of the volume mounts to be
+	// copied (optional). Setting it enables the detection.
+	AutoDetect *AutoDetect `json:"autoDetect,omitempty"`
+
 	// LivenessProbe optionally sets a liveness probe on the injected backup agent.
 	// No default is applied: the correct check depends on the agent image (e.g.
 	// the default/postgresql agents run crond, while the cnpg agent is a plain
-	// binary), so it must be declared explicitly per policy.
+	// binary), so it must be declared explicitly per policy. The supervisor agent
+	// serves /healthz and /readyz on 127.0.0.1:8079, e.g. for a
+	// `curl -f http://127.0.0.1:8079/healthz` exec probe. HTTP probes need
+	// BC_SUPERVISOR_ADDR set to ":8079", which requires a BC_SUPERVISOR_TOKEN
+	// for the other endpoints.
 	LivenessProbe *corev1.Probe `json:"livenessProbe,omitempty"`
 
 	// ReadinessProbe optionally sets a readiness probe on the injected backup agent (default none).
@@ -38,6 +38,112 @@ type ScheduleSpec struct {
 	//
 	// Docs: https://man7.org/linux/man-pages/man5/crontab.5.html
 	Schedule string `json:"schedule,omitempty"`
+
+	// TimeZone is the name of the time zone the schedule is interpreted in, from the tz
+	// database (e.g. "Europe/Paris"). Defaults to the time zone of the agent.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Jitter spreads the runs of the pods sharing the schedule: each run is delayed by a
+	// random duration up to the jitter, which is stable for a given pod.
+	// +optional
+	Jitter *metav1.Duration `json:"jitter,omitempty"`
+
+	// StartingDeadline is how late a run may start after its scheduled time, e.g. while
+	// waiting for a previous run to complete. Later runs are skipped.
+	// +optional
+	StartingDeadline *metav1.Duration `json:"startingDeadline,omitempty"`
+
+	// BlackoutWindows are the windows during which the backups must not run. A run
+	// falling in a window is deferred to its end when the starting deadline allows it,
+	// and skipped otherwise.
+	// +optional
+	BlackoutWindows []BlackoutWindow `json:"blackoutWindows,omitempty"`
+
+	// Maintenance sets independent schedules for the maintenance tasks of the
+	// repository, run as their own jobs. The tasks without a schedule keep running
+	// after each backup. In Snapshot mode, all the tasks run after each backup.
+	// +optional
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+
+	// CatchUp configures the backup run by the agents on startup when they missed
+	// the previous run of the schedule, e.g. because the pod was rescheduled.
+	// +optional
+	CatchUp *CatchUp `json:"catchUp,omitempty"`
+}
+
+// CatchUpPolicy tells whether the agents catch up a missed run on startup.
+// +kubebuilder:validation:Enum=Never;Immediate
+type CatchUpPolicy string
+
+const (
+	// CatchUpPolicyNever waits for the next run of the schedule.
+	CatchUpPolicyNever CatchUpPolicy = "Never"
+	// CatchUpPolicyImmediate runs a backup as soon as the agent starts.
+	CatchUpPolicyImmediate CatchUpPolicy = "Immediate"
+)
+
+// CatchUp configures the catch-up of the missed runs. A run is missed when the
+// latest snapshot of the agent, for its host (or its Kubernetes tags) and paths,
+// is older than the previous run of the schedule.
+type CatchUp struct {
+	// Policy is the catch-up policy. Defaults to Never.
+	// +kubebuilder:default=Never
+	// +optional
+	Policy CatchUpPolicy `json:"policy,omitempty"`
+
+	// GracePeriod is how much older than the previous run of the schedule the latest
+	// snapshot may be before the run is considered missed, e.g. to tolerate a missed
+	// run of a frequent schedule.
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// Maintenance sets the crontab expressions of the maintenance tasks of the repository,
+// interpreted in the time zone of the schedule.
+type Maintenance struct {
+	// Forget applies the retention policy.
+	// +optional
+	Forget string `json:"forget,omitempty"`
+
+	// Prune removes the unreferenced data.
+	// +optional
+	Prune string `json:"prune,omitempty"`
+
+	// Check verifies the integrity of the repository.
+	// +optional
+	Check string `json:"check,omitempty"`
+
+	// CheckReadDataSubset also verifies a subset of the data on each check, passed to
+	// --read-data-subset: a percentage ("5%"), a part ("1/10") or a size ("500M").
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]+)?%|[0-9]+/[0-9]+|[0-9]+[KMGT]?)$`
+	// +optional
+	CheckReadDataSubset string `json:"checkReadDataSubset,omitempty"`
+}
+
+// BlackoutWindow is either a recurring window, starting on a cron expression for a
+// duration, or an absolute date range.
+type BlackoutWindow struct {
+	// Reason is reported by the agents when they skip a run (optional).
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Schedule is the crontab expression the recurring window starts on, interpreted
+	// in the time zone of the schedule.
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// Duration is the duration of the recurring window.
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// Start is the beginning of the date range.
+	// +optional
+	Start *metav1.Time `json:"start,omitempty"`
+
+	// End is the end of the date range.
+	// +optional
+	End *metav1.Time `json:"end,omitempty"`
 }
 
 // ScheduleStatus defines the observed state of Schedule.
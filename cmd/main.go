@@ -21,11 +21,16 @@ import (
 	"flag"
 	"os"
 	"path/filepath"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	// Embed the tz database, the controller image does not ship it and the
+	// time zones of the schedules are validated and used by the controller.
+	_ "time/tzdata"
+
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -37,10 +42,14 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	corev1 "k8s.io/api/core/v1"
 
 	api "github.com/rclsilver-org/backup-controller/api/v1alpha1"
 	backupcontrollerrclsilverorggithubcomv1alpha1 "github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/controller"
+	"github.com/rclsilver-org/backup-controller/internal/scheduler"
 	webhookcorev1 "github.com/rclsilver-org/backup-controller/internal/webhook/v1"
 	webhook_v1alpha1 "github.com/rclsilver-org/backup-controller/internal/webhook/v1alpha1"
 	webhookbackupcontrollerrclsilverorggithubcomv1alpha1 "github.com/rclsilver-org/backup-controller/internal/webhook/v1alpha1"
@@ -57,6 +66,7 @@ func init() {
 
 	utilruntime.Must(api.AddToScheme(scheme))
 	utilruntime.Must(corev1.AddToScheme(scheme))
+	utilruntime.Must(snapshotv1.AddToScheme(scheme))
 	utilruntime.Must(backupcontrollerrclsilverorggithubcomv1alpha1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
@@ -70,6 +80,10 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var clusterName string
+	var templateFuncs string
+	var centralScheduler bool
+	var limits scheduler.Limits
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -88,6 +102,21 @@ func main() {
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&clusterName, "cluster-name", "", "The name of the cluster, exposed as .cluster to the policy templates.")
+	flag.StringVar(&templateFuncs, "template-functions", "",
+		"Comma-separated list of the functions allowed in the policy templates. "+
+			"Defaults to all the sprig functions but env, expandenv and getHostByName, "+
+			"and the ownerName, podLabel, sanitizeDNS and hashShort helpers.")
+	flag.BoolVar(&centralScheduler, "central-scheduler", false,
+		"If set, the leader triggers the runs of the backup agents injected in the pods "+
+			"instead of their own crond, within the --max-concurrent-* limits.")
+	flag.IntVar(&limits.Cluster, "max-concurrent-backups", 0,
+		"The maximum number of backup runs in progress in the cluster with the central scheduler (0 for no limit).")
+	flag.IntVar(&limits.PerNode, "max-concurrent-backups-per-node", 0,
+		"The maximum number of backup runs in progress on a node with the central scheduler (0 for no limit).")
+	flag.IntVar(&limits.PerRepository, "max-concurrent-backups-per-repository", 0,
+		"The maximum number of backup runs in progress against a restic repository with the central scheduler "+
+			"(0 for no limit).")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -187,6 +216,7 @@ func main() {
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
+		Cache:                  controller.CacheOptions(),
 		Metrics:                metricsServerOptions,
 		WebhookServer:          webhookServer,
 		HealthProbeBindAddress: probeAddr,
@@ -208,6 +238,21 @@ func main() {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
+
+	renderer := &agent.Renderer{
+		Client:      mgr.GetClient(),
+		ClusterName: clusterName,
+	}
+	if templateFuncs != "" {
+		for _, name := range strings.Split(templateFuncs, ",") {
+			renderer.AllowedFuncs = append(renderer.AllowedFuncs, strings.TrimSpace(name))
+		}
+		if err := agent.ValidateFuncNames(renderer.AllowedFuncs); err != nil {
+			setupLog.Error(err, "invalid template functions")
+			os.Exit(1)
+		}
+	}
+
 	// nolint:goconst
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
 		if err = webhook_v1alpha1.SetupPolicyWebhookWithManager(mgr); err != nil {
@@ -217,7 +262,7 @@ func main() {
 	}
 	// nolint:goconst
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
-		if err = webhookcorev1.SetupPodWebhookWithManager(mgr); err != nil {
+		if err = webhookcorev1.SetupPodWebhookWithManager(mgr, renderer, centralScheduler); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "Pod")
 			os.Exit(1)
 		}
@@ -229,6 +274,51 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	if err = (&controller.CronJobReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Renderer: renderer,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CronJob")
+		os.Exit(1)
+	}
+	if err = (&controller.SnapshotReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Renderer: renderer,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Snapshot")
+		os.Exit(1)
+	}
+	if err = (&controller.SchedulesReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Schedules")
+		os.Exit(1)
+	}
+	if err = (&controller.HooksReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Hooks")
+		os.Exit(1)
+	}
+	if centralScheduler {
+		executor, err := scheduler.NewPodExecutor(mgr.GetConfig())
+		if err != nil {
+			setupLog.Error(err, "unable to create the pod executor")
+			os.Exit(1)
+		}
+		if err := mgr.Add(&scheduler.Scheduler{
+			Client:   mgr.GetClient(),
+			Executor: executor,
+			Limits:   limits,
+		}); err != nil {
+			setupLog.Error(err, "unable to add the central scheduler to manager")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if metricsCertWatcher != nil {
@@ -0,0 +1,257 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package agent builds the backup agent container from a Policy and a Schedule.
+// It is shared by the pod webhook, which injects the agent as a sidecar, and by
+// the controllers which run it out of the pod (e.g. as a CronJob).
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+// ContainerName is the name of the backup agent container.
+const ContainerName = "backup-agent"
+
+// BuildContainer builds the backup agent container for the given pod. The policy
+// must already be rendered against the pod (see Renderer).
+func BuildContainer(ctx context.Context, pod *corev1.Pod, policy v1alpha1.Policy, schedule v1alpha1.Schedule) (corev1.Container, error) {
+	log := log.FromContext(ctx)
+
+	container := corev1.Container{
+		Name: ContainerName,
+	}
+
+	container.Image, container.ImagePullPolicy = ResolveImage(policy.Spec.Image)
+
+	if policy.Spec.AutoDetectVolumeMounts || policy.Spec.AutoDetect != nil {
+		mounts, env, err := detectVolumeMounts(*pod, policy.Spec.AutoDetect)
+		if err != nil {
+			return corev1.Container{}, fmt.Errorf("error while detecting volume mounts: %w", err)
+		}
+
+		if len(mounts) == 1 {
+			log.Info("detected volume mount", "path", mounts[0].MountPath)
+		} else {
+			log.Info(fmt.Sprintf("detected %d volume mounts", len(mounts)))
+		}
+
+		container.VolumeMounts = append(container.VolumeMounts, mounts...)
+		container.Env = append(container.Env, env...)
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  "BC_BACKUP_DIR",
+			Value: BackupDir(mounts),
+		})
+	}
+
+	container.Env = append(container.Env, policy.Spec.Environment...)
+
+	for _, spec := range policy.Spec.CopyEnv {
+		variable, err := getContainerEnv(pod, spec.ContainerName, spec.VariableName)
+		if err != nil {
+			return corev1.Container{}, fmt.Errorf("error while copying environment variable %q from the container %q: %w", spec.VariableName, spec.ContainerName, err)
+		}
+		if spec.NewName != "" {
+			variable.Name = spec.NewName
+		}
+		container.Env = append(container.Env, variable)
+	}
+
+	for _, spec := range policy.Spec.CopyVolumeMount {
+		mount, err := getContainerVolumeMount(pod, spec.ContainerName, spec.MountPath)
+		if err != nil {
+			return corev1.Container{}, fmt.Errorf("error while copying volume mount %q from the container %q: %w", spec.MountPath, spec.ContainerName, err)
+		}
+		container.VolumeMounts = append(container.VolumeMounts, mount)
+	}
+
+	backupEnv, err := backupOptionsEnv(pod.GetAnnotations(), policy.Spec.Backup)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	container.Env = append(container.Env, backupEnv...)
+
+	hookEnv, err := hooksEnv(pod, policy.Spec.Hooks)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	container.Env = append(container.Env, hookEnv...)
+	container.Env = append(container.Env, freshnessEnv(policy.Spec.Freshness)...)
+	container.Env = append(container.Env, terminationEnv(pod, policy.Spec.Termination)...)
+	container.Env = append(container.Env, retryEnv(policy.Spec.Retry)...)
+	container.Env = append(container.Env, verifyEnv(policy.Spec.Verify)...)
+
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name:  "BC_SCHEDULE",
+		Value: schedule.Spec.Schedule,
+	})
+	container.Env = append(container.Env, scheduleEnv(schedule.Spec)...)
+
+	if err := applyRetentionDays(pod.GetAnnotations(), policy.Name, &container); err != nil {
+		return corev1.Container{}, err
+	}
+
+	var zero int64 = 0
+	var false bool = false
+	container.SecurityContext = &corev1.SecurityContext{
+		RunAsUser:    &zero,
+		RunAsGroup:   &zero,
+		RunAsNonRoot: &false,
+	}
+
+	return container, nil
+}
+
+// BackupDir returns the value of BC_BACKUP_DIR for the given mounts: their
+// mount paths joined with ':'.
+func BackupDir(mounts []corev1.VolumeMount) string {
+	var backupDir string
+	for i, m := range mounts {
+		if i > 0 {
+			backupDir += ":"
+		}
+		backupDir += m.MountPath
+	}
+	return backupDir
+}
+
+// ResolveImage returns the full image reference and pull policy for an Image
+// spec: Always for an empty/"latest" tag, IfNotPresent otherwise, unless an
+// explicit pull policy is set.
+func ResolveImage(img v1alpha1.Image) (string, corev1.PullPolicy) {
+	ref := img.Name
+	if img.Tag != "" {
+		ref += ":" + img.Tag
+	}
+
+	switch {
+	case img.PullPolicy != "":
+		return ref, img.PullPolicy
+	case img.Tag == "" || img.Tag == "latest":
+		return ref, corev1.PullAlways
+	default:
+		return ref, corev1.PullIfNotPresent
+	}
+}
+
+func getContainerEnv(pod *corev1.Pod, container, variable string) (corev1.EnvVar, error) {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == container {
+			for _, e := range c.Env {
+				if e.Name == variable {
+					return e, nil
+				}
+			}
+			return corev1.EnvVar{}, fmt.Errorf("variable not found")
+		}
+	}
+	return corev1.EnvVar{}, fmt.Errorf("container not found")
+}
+
+func getContainerVolumeMount(pod *corev1.Pod, container, mountPath string) (corev1.VolumeMount, error) {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == container {
+			for _, m := range c.VolumeMounts {
+				if m.MountPath == mountPath {
+					return m, nil
+				}
+			}
+			return corev1.VolumeMount{}, fmt.Errorf("volume mount not found")
+		}
+	}
+	return corev1.VolumeMount{}, fmt.Errorf("container not found")
+}
+
+// applyRetentionDays sets BC_RETENTION_DAYS from the retention annotation of
+// the pod. It holds either a number of days for all the agents, or a
+// comma-separated list of policy=days overrides, e.g. "onsite=7,offsite=90".
+// A number without policy applies to the agents without an override.
+func applyRetentionDays(annotations map[string]string, policyName string, container *corev1.Container) error {
+	value, ok := annotations[constants.RetentionDaysAnnotation]
+	if !ok {
+		return nil
+	}
+
+	var retentionDays string
+	for _, entry := range strings.Split(value, ",") {
+		policy, days, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			policy, days = "", policy
+		}
+
+		if n, err := strconv.Atoi(days); err != nil || n <= 0 {
+			return fmt.Errorf("annotation %q must be a positive integer or a list of policy=days, got %q", constants.RetentionDaysAnnotation, value)
+		}
+
+		if policy == policyName || (policy == "" && retentionDays == "") {
+			retentionDays = days
+		}
+	}
+
+	if retentionDays == "" {
+		return nil
+	}
+
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name:  "BC_RETENTION_DAYS",
+		Value: retentionDays,
+	})
+
+	return nil
+}
+
+// scheduleEnv returns the environment variables passing the optional settings
+// of the schedule to the agent, the durations in seconds.
+func scheduleEnv(spec v1alpha1.ScheduleSpec) []corev1.EnvVar {
+	var env []corev1.EnvVar
+	if spec.TimeZone != "" {
+		env = append(env, corev1.EnvVar{Name: "BC_SCHEDULE_TIMEZONE", Value: spec.TimeZone})
+	}
+	if spec.Jitter != nil && spec.Jitter.Duration > 0 {
+		env = append(env, corev1.EnvVar{Name: "BC_SCHEDULE_JITTER", Value: strconv.Itoa(int(spec.Jitter.Seconds()))})
+	}
+	if spec.StartingDeadline != nil && spec.StartingDeadline.Duration > 0 {
+		env = append(env, corev1.EnvVar{Name: "BC_SCHEDULE_STARTING_DEADLINE", Value: strconv.Itoa(int(spec.StartingDeadline.Seconds()))})
+	}
+	if m := spec.Maintenance; m != nil {
+		for _, e := range []corev1.EnvVar{
+			{Name: "BC_FORGET_SCHEDULE", Value: m.Forget},
+			{Name: "BC_PRUNE_SCHEDULE", Value: m.Prune},
+			{Name: "BC_CHECK_SCHEDULE", Value: m.Check},
+			{Name: "BC_CHECK_READ_DATA_SUBSET", Value: m.CheckReadDataSubset},
+		} {
+			if e.Value != "" {
+				env = append(env, e)
+			}
+		}
+	}
+	if c := spec.CatchUp; c != nil && c.Policy != "" && c.Policy != v1alpha1.CatchUpPolicyNever {
+		env = append(env, corev1.EnvVar{Name: "BC_CATCHUP_POLICY", Value: string(c.Policy)})
+		if c.GracePeriod != nil && c.GracePeriod.Duration > 0 {
+			env = append(env, corev1.EnvVar{Name: "BC_CATCHUP_GRACE_PERIOD", Value: strconv.Itoa(int(c.GracePeriod.Seconds()))})
+		}
+	}
+	return env
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+// maxSuffixLength keeps the container names derived from a backup within the
+// 63 characters limit.
+const maxSuffixLength = 40
+
+// Backup is a policy and schedule pair requested by a pod: each pair gets its
+// own backup agent.
+type Backup struct {
+	Policy   string `json:"policy"`
+	Schedule string `json:"schedule"`
+}
+
+// ParseBackups returns the backups requested by the given pod annotations:
+// the pair of the policy and schedule annotations, followed by the pairs of
+// the backups annotation. The latter holds either a comma-separated list of
+// policy@schedule pairs, e.g. "onsite@daily,offsite@weekly", or a JSON list
+// of {"policy": ..., "schedule": ...} objects.
+func ParseBackups(annotations map[string]string) ([]Backup, error) {
+	var backups []Backup
+
+	policy := annotations[constants.PolicyAnnotation]
+	schedule := annotations[constants.ScheduleAnnotation]
+	if policy != "" && schedule != "" {
+		backups = append(backups, Backup{Policy: policy, Schedule: schedule})
+	}
+
+	value := strings.TrimSpace(annotations[constants.BackupsAnnotation])
+
+	var pairs []Backup
+	switch {
+	case value == "":
+	case strings.HasPrefix(value, "["):
+		if err := json.Unmarshal([]byte(value), &pairs); err != nil {
+			return nil, fmt.Errorf("invalid annotation %q: %w", constants.BackupsAnnotation, err)
+		}
+	default:
+		for _, pair := range strings.Split(value, ",") {
+			policy, schedule, _ := strings.Cut(strings.TrimSpace(pair), "@")
+			pairs = append(pairs, Backup{Policy: policy, Schedule: schedule})
+		}
+	}
+
+	for _, pair := range pairs {
+		if pair.Policy == "" || pair.Schedule == "" {
+			return nil, fmt.Errorf("invalid annotation %q: expected policy@schedule pairs, got %q", constants.BackupsAnnotation, value)
+		}
+		for _, b := range backups {
+			if b == pair {
+				return nil, fmt.Errorf("invalid annotation %q: duplicated pair %s@%s", constants.BackupsAnnotation, pair.Policy, pair.Schedule)
+			}
+		}
+		backups = append(backups, pair)
+	}
+
+	return backups, nil
+}
+
+// Suffix returns a DNS label identifying the backup, used to name its
+// containers when a pod requests several backups.
+func (b Backup) Suffix() string {
+	suffix := sanitizeDNS(b.Policy + "-" + b.Schedule)
+	if len(suffix) <= maxSuffixLength {
+		return suffix
+	}
+	return strings.Trim(suffix[:maxSuffixLength-9], "-") + "-" + hashShort(b.Policy+"@"+b.Schedule)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+var _ = Describe("Backups", func() {
+	It("Should combine the policy and schedule annotations with the backups annotation", func() {
+		backups, err := ParseBackups(map[string]string{
+			constants.PolicyAnnotation:   "local",
+			constants.ScheduleAnnotation: "hourly",
+			constants.BackupsAnnotation:  "onsite@daily, offsite@weekly",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backups).To(Equal([]Backup{
+			{Policy: "local", Schedule: "hourly"},
+			{Policy: "onsite", Schedule: "daily"},
+			{Policy: "offsite", Schedule: "weekly"},
+		}))
+	})
+
+	It("Should accept a JSON list of pairs", func() {
+		backups, err := ParseBackups(map[string]string{
+			constants.BackupsAnnotation: `[{"policy": "onsite", "schedule": "daily"}]`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backups).To(Equal([]Backup{{Policy: "onsite", Schedule: "daily"}}))
+	})
+
+	It("Should reject incomplete and duplicated pairs", func() {
+		_, err := ParseBackups(map[string]string{constants.BackupsAnnotation: "onsite"})
+		Expect(err).To(HaveOccurred())
+
+		_, err = ParseBackups(map[string]string{constants.BackupsAnnotation: "onsite@daily,onsite@daily"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should derive short DNS suffixes", func() {
+		Expect(Backup{Policy: "OnSite", Schedule: "daily"}.Suffix()).To(Equal("onsite-daily"))
+		Expect(len(Backup{Policy: "a-very-long-policy-name-for-offsite", Schedule: "every-sunday-night"}.Suffix())).To(BeNumerically("<=", maxSuffixLength))
+	})
+
+	It("Should apply the retention override of the policy", func() {
+		annotations := map[string]string{constants.RetentionDaysAnnotation: "30,offsite=90"}
+
+		var onsite, offsite corev1.Container
+		Expect(applyRetentionDays(annotations, "onsite", &onsite)).To(Succeed())
+		Expect(applyRetentionDays(annotations, "offsite", &offsite)).To(Succeed())
+		Expect(onsite.Env).To(Equal([]corev1.EnvVar{{Name: "BC_RETENTION_DAYS", Value: "30"}}))
+		Expect(offsite.Env).To(Equal([]corev1.EnvVar{{Name: "BC_RETENTION_DAYS", Value: "90"}}))
+	})
+})
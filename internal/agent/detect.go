@@ -0,0 +1,232 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+// defaultVolumeTypes lists the volume sources accepted by the detection when
+// the policy does not set any.
+var defaultVolumeTypes = []v1alpha1.VolumeType{
+	v1alpha1.VolumeTypeHostPath,
+	v1alpha1.VolumeTypePersistentVolumeClaim,
+	v1alpha1.VolumeTypeNFS,
+	v1alpha1.VolumeTypeISCSI,
+	v1alpha1.VolumeTypeFC,
+	v1alpha1.VolumeTypeCSI,
+}
+
+var subPathExprVariables = regexp.MustCompile(`\$\(([A-Za-z_][A-Za-z0-9_]*)\)`)
+
+// ValidatePatterns checks the glob patterns of the given detection rules.
+func ValidatePatterns(rules *v1alpha1.AutoDetect) error {
+	for _, patterns := range [][]string{rules.Volumes, rules.ExcludeVolumes, rules.MountPaths, rules.ExcludeMountPaths} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// detectVolumeMounts returns the volume mounts of the pod matching the
+// detection rules, along with the environment variables their subPathExpr
+// need. The annotations of the pod override the volumes and the containers of
+// the rules.
+//
+// The same data is only mounted once: the mounts of a volume mounted by
+// several containers are deduplicated, and the subPath mounts of a volume
+// which is also mounted entirely are skipped. Different data mounted at the
+// same path by several containers is mounted at distinct paths in the agent.
+func detectVolumeMounts(pod corev1.Pod, rules *v1alpha1.AutoDetect) ([]corev1.VolumeMount, []corev1.EnvVar, error) {
+	if rules == nil {
+		rules = &v1alpha1.AutoDetect{}
+	}
+
+	annotations := pod.GetAnnotations()
+	volumeNames := splitList(annotations[constants.AutoDetectVolumeAnnotation])
+	containerNames := splitList(annotations[constants.AutoDetectContainerAnnotation])
+	if len(containerNames) == 0 {
+		containerNames = rules.Containers
+	}
+
+	volumeTypes := rules.VolumeTypes
+	if len(volumeTypes) == 0 {
+		volumeTypes = defaultVolumeTypes
+	}
+
+	validVolumes := make(map[string]bool)
+	for _, v := range pod.Spec.Volumes {
+		if len(volumeNames) > 0 {
+			validVolumes[v.Name] = slices.Contains(volumeNames, v.Name)
+			continue
+		}
+
+		t := volumeType(v)
+		valid := slices.Contains(volumeTypes, t) && (len(rules.Volumes) == 0 || matchAny(rules.Volumes, v.Name))
+		if slices.Contains(rules.ExcludeVolumeTypes, t) || matchAny(rules.ExcludeVolumes, v.Name) {
+			valid = false
+		}
+		validVolumes[v.Name] = valid
+	}
+
+	type candidate struct {
+		mount     corev1.VolumeMount
+		container *corev1.Container
+	}
+
+	var candidates []candidate
+	entireVolumes := make(map[string]bool)
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		if len(containerNames) > 0 && !slices.Contains(containerNames, c.Name) {
+			continue
+		}
+
+		for _, m := range c.VolumeMounts {
+			if !validVolumes[m.Name] {
+				continue
+			}
+			if len(rules.MountPaths) > 0 && !matchAny(rules.MountPaths, m.MountPath) {
+				continue
+			}
+			if matchAny(rules.ExcludeMountPaths, m.MountPath) {
+				continue
+			}
+
+			candidates = append(candidates, candidate{mount: m, container: c})
+			if m.SubPath == "" && m.SubPathExpr == "" {
+				entireVolumes[m.Name] = true
+			}
+		}
+	}
+
+	var mounts []corev1.VolumeMount
+	var env []corev1.EnvVar
+	seenSources := make(map[string]bool)
+	seenPaths := make(map[string]bool)
+
+	for _, c := range candidates {
+		m := c.mount
+
+		partial := m.SubPath != "" || m.SubPathExpr != ""
+		if partial && entireVolumes[m.Name] {
+			continue
+		}
+
+		source := m.Name + "/" + m.SubPath + "/" + m.SubPathExpr
+		if seenSources[source] {
+			continue
+		}
+		seenSources[source] = true
+
+		// Another data mounted at the same path by another container is
+		// mounted in the agent at a path suffixed with the container name
+		if seenPaths[m.MountPath] {
+			mountPath := m.MountPath + "-" + c.container.Name
+			for i := 2; seenPaths[mountPath]; i++ {
+				mountPath = fmt.Sprintf("%s-%s-%d", m.MountPath, c.container.Name, i)
+			}
+			m.MountPath = mountPath
+		}
+		seenPaths[m.MountPath] = true
+
+		// The variables of a subPathExpr are expanded from the container env
+		for _, match := range subPathExprVariables.FindAllStringSubmatch(m.SubPathExpr, -1) {
+			if slices.ContainsFunc(env, func(e corev1.EnvVar) bool { return e.Name == match[1] }) {
+				continue
+			}
+			for _, e := range c.container.Env {
+				if e.Name == match[1] {
+					env = append(env, e)
+				}
+			}
+		}
+
+		m.ReadOnly = m.ReadOnly || rules.ReadOnly
+		// The agent does not need to propagate mounts, which would require
+		// it to be privileged
+		m.MountPropagation = nil
+
+		mounts = append(mounts, m)
+	}
+
+	if len(mounts) == 0 {
+		return nil, nil, fmt.Errorf("no volume found")
+	}
+
+	return mounts, env, nil
+}
+
+// volumeType returns the type of the source of the volume, or an empty string
+// for the unsupported ones.
+func volumeType(v corev1.Volume) v1alpha1.VolumeType {
+	switch {
+	case v.HostPath != nil && v.HostPath.Path != "":
+		return v1alpha1.VolumeTypeHostPath
+	case v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName != "":
+		return v1alpha1.VolumeTypePersistentVolumeClaim
+	case v.NFS != nil && v.NFS.Server != "":
+		return v1alpha1.VolumeTypeNFS
+	case v.ISCSI != nil && v.ISCSI.IQN != "":
+		return v1alpha1.VolumeTypeISCSI
+	case v.FC != nil && v.FC.Lun != nil:
+		return v1alpha1.VolumeTypeFC
+	case v.CSI != nil && v.CSI.Driver != "":
+		return v1alpha1.VolumeTypeCSI
+	case v.Ephemeral != nil:
+		return v1alpha1.VolumeTypeEphemeral
+	case v.EmptyDir != nil:
+		return v1alpha1.VolumeTypeEmptyDir
+	case v.ConfigMap != nil:
+		return v1alpha1.VolumeTypeConfigMap
+	case v.Secret != nil:
+		return v1alpha1.VolumeTypeSecret
+	}
+	return ""
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated list, ignoring the empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
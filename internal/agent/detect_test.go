@@ -0,0 +1,97 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+var _ = Describe("Volume mounts detection", func() {
+	var pod corev1.Pod
+
+	BeforeEach(func() {
+		pod = corev1.Pod{
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name: "app",
+						Env:  []corev1.EnvVar{{Name: "POD_NAME", Value: "app-0"}},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "data", MountPath: "/data"},
+							{Name: "data", MountPath: "/data/logs", SubPath: "logs"},
+							{Name: "shared", MountPath: "/shared", SubPathExpr: "$(POD_NAME)"},
+							{Name: "cache", MountPath: "/cache"},
+						},
+					},
+					{
+						Name:         "sidecar",
+						VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/var/data"}},
+					},
+				},
+				Volumes: []corev1.Volume{
+					{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+					{Name: "shared", VolumeSource: corev1.VolumeSource{NFS: &corev1.NFSVolumeSource{Server: "nas", Path: "/"}}},
+					{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				},
+			},
+		}
+	})
+
+	It("Should back up the same data only once", func() {
+		mounts, env, err := detectVolumeMounts(pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(BackupDir(mounts)).To(Equal("/data:/shared"))
+		Expect(env).To(Equal([]corev1.EnvVar{{Name: "POD_NAME", Value: "app-0"}}))
+	})
+
+	It("Should mount different data mounted at the same path at distinct paths", func() {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name:         "worker",
+			VolumeMounts: []corev1.VolumeMount{{Name: "media", MountPath: "/data"}},
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "media", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "media"}},
+		})
+
+		mounts, _, err := detectVolumeMounts(pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(BackupDir(mounts)).To(Equal("/data:/shared:/data-worker"))
+	})
+
+	It("Should apply the include and exclude rules", func() {
+		mounts, _, err := detectVolumeMounts(pod, &v1alpha1.AutoDetect{
+			VolumeTypes:       []v1alpha1.VolumeType{v1alpha1.VolumeTypePersistentVolumeClaim, v1alpha1.VolumeTypeEmptyDir},
+			ExcludeMountPaths: []string{"/data*"},
+			Containers:        []string{"app", "sidecar"},
+			ReadOnly:          true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mounts).To(Equal([]corev1.VolumeMount{
+			{Name: "cache", MountPath: "/cache", ReadOnly: true},
+			{Name: "data", MountPath: "/var/data", ReadOnly: true},
+		}))
+	})
+
+	It("Should reject invalid patterns", func() {
+		Expect(ValidatePatterns(&v1alpha1.AutoDetect{Volumes: []string{"["}})).To(HaveOccurred())
+	})
+})
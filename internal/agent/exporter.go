@@ -0,0 +1,86 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+// ExporterContainerName is the name of the metrics exporter sidecar.
+const ExporterContainerName = "restic-exporter"
+
+// BuildExporterContainer builds the metrics exporter sidecar. It inherits the
+// backup agent's environment (restic repository + credentials) so it can query
+// the same repository, then applies the listen port and exporter-specific env.
+func BuildExporterContainer(exporter *v1alpha1.Exporter, agentEnv []corev1.EnvVar) corev1.Container {
+	port := exporter.Port
+	if port == 0 {
+		port = 8001
+	}
+
+	image, pullPolicy := ResolveImage(exporter.Image)
+
+	env := append([]corev1.EnvVar{}, agentEnv...)
+	env = append(env, corev1.EnvVar{Name: "LISTEN_PORT", Value: fmt.Sprintf("%d", port)})
+	env = append(env, exporter.Environment...)
+
+	liveness := exporter.LivenessProbe
+	if liveness == nil {
+		liveness = metricsProbe(port, 30, 60, 5)
+	}
+	readiness := exporter.ReadinessProbe
+	if readiness == nil {
+		readiness = metricsProbe(port, 10, 30, 3)
+	}
+
+	return corev1.Container{
+		Name:            ExporterContainerName,
+		Image:           image,
+		ImagePullPolicy: pullPolicy,
+		Env:             env,
+		Ports: []corev1.ContainerPort{{
+			Name:          "metrics",
+			ContainerPort: port,
+			Protocol:      corev1.ProtocolTCP,
+		}},
+		LivenessProbe:  liveness,
+		ReadinessProbe: readiness,
+		StartupProbe:   exporter.StartupProbe,
+	}
+}
+
+// metricsProbe builds an HTTP GET probe against /metrics on the given port,
+// used as the default health check for the exporter sidecar.
+func metricsProbe(port, initialDelaySeconds, periodSeconds, failureThreshold int32) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: "/metrics",
+				Port: intstr.FromInt32(port),
+			},
+		},
+		InitialDelaySeconds: initialDelaySeconds,
+		PeriodSeconds:       periodSeconds,
+		TimeoutSeconds:      5,
+		FailureThreshold:    failureThreshold,
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"math"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+// freshnessEnv returns the environment variables passing the freshness bounds
+// of the policy to the agent, in seconds.
+func freshnessEnv(freshness *v1alpha1.Freshness) []corev1.EnvVar {
+	if freshness == nil {
+		return nil
+	}
+
+	var env []corev1.EnvVar
+	if freshness.WarnAfter != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  "BC_FRESHNESS_WARN_AFTER",
+			Value: strconv.Itoa(int(math.Ceil(freshness.WarnAfter.Seconds()))),
+		})
+	}
+	if freshness.FailAfter != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  "BC_FRESHNESS_FAIL_AFTER",
+			Value: strconv.Itoa(int(math.Ceil(freshness.FailAfter.Seconds()))),
+		})
+	}
+	return env
+}
+
+// FreshnessProbe returns the readiness probe of an agent failing while its
+// latest successful backup is older than the failAfter bound of the policy, or
+// nil without one. The probe runs the healthcheck script of the default agent,
+// which all the agent images are based on.
+func FreshnessProbe(freshness *v1alpha1.Freshness) *corev1.Probe {
+	if freshness == nil || freshness.FailAfter == nil {
+		return nil
+	}
+
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				// The probes do not expand the variables of the container
+				Command: []string{"/bin/bash", "-c", `exec "${BC_ROOT_DIR}/scripts/healthcheck.sh"`},
+			},
+		},
+		PeriodSeconds:    60,
+		TimeoutSeconds:   10,
+		FailureThreshold: 1,
+	}
+}
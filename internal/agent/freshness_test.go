@@ -0,0 +1,51 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+var _ = Describe("Freshness", func() {
+	It("Should pass the bounds to the agent in seconds", func() {
+		freshness := &v1alpha1.Freshness{
+			WarnAfter: &metav1.Duration{Duration: 26 * time.Hour},
+			FailAfter: &metav1.Duration{Duration: 50 * time.Hour},
+		}
+		Expect(freshnessEnv(freshness)).To(Equal([]corev1.EnvVar{
+			{Name: "BC_FRESHNESS_WARN_AFTER", Value: "93600"},
+			{Name: "BC_FRESHNESS_FAIL_AFTER", Value: "180000"},
+		}))
+
+		probe := FreshnessProbe(freshness)
+		Expect(probe).NotTo(BeNil())
+		Expect(probe.Exec.Command).To(ContainElement(ContainSubstring("healthcheck.sh")))
+	})
+
+	It("Should only probe the freshness with a failAfter bound", func() {
+		Expect(FreshnessProbe(nil)).To(BeNil())
+		Expect(FreshnessProbe(&v1alpha1.Freshness{WarnAfter: &metav1.Duration{Duration: time.Hour}})).To(BeNil())
+	})
+})
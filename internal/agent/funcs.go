@@ -0,0 +1,93 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+)
+
+// unsafeFuncs lists the sprig functions which leak the controller environment
+// or reach the network. They are never available in the policy templates.
+var unsafeFuncs = []string{"env", "expandenv", "getHostByName"}
+
+var invalidDNSChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// safeFuncs returns the functions available in the policy templates, with the
+// project helpers bound to the given template context.
+func safeFuncs(data map[string]any) template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	for _, name := range unsafeFuncs {
+		delete(funcs, name)
+	}
+
+	// ownerName returns the name of the top-level controller of the pod
+	funcs["ownerName"] = func() string {
+		owner, _ := data["owner"].(map[string]any)
+		name, _ := owner["name"].(string)
+		return name
+	}
+
+	// podLabel returns the value of a label of the pod, or an empty string
+	funcs["podLabel"] = func(key string) string {
+		pod, _ := data["pod"].(map[string]any)
+		metadata, _ := pod["metadata"].(map[string]any)
+		labels, _ := metadata["labels"].(map[string]any)
+		value, _ := labels[key].(string)
+		return value
+	}
+
+	funcs["sanitizeDNS"] = sanitizeDNS
+	funcs["hashShort"] = hashShort
+
+	return funcs
+}
+
+// sanitizeDNS turns the given string into a valid DNS label: lower case
+// alphanumeric characters or '-', at most 63 characters.
+func sanitizeDNS(s string) string {
+	s = invalidDNSChars.ReplaceAllString(strings.ToLower(s), "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return strings.Trim(s, "-")
+}
+
+// hashShort returns the first 8 hexadecimal characters of the SHA-256 of the
+// given string.
+func hashShort(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// ValidateFuncNames checks that the given functions may be allowed in the
+// policy templates.
+func ValidateFuncNames(names []string) error {
+	funcs := safeFuncs(nil)
+	for _, name := range names {
+		if _, ok := funcs[name]; !ok {
+			return fmt.Errorf("unknown or unsafe template function %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+// defaultHookTimeout bounds the duration of the hooks without a timeout.
+const defaultHookTimeout = time.Minute
+
+// HooksVolumeName is the name of the pod volume of the token of the hooks
+// ServiceAccount.
+const HooksVolumeName = "backup-hooks-token"
+
+// hook is a hook as passed to the agent, with the timeout in seconds.
+type hook struct {
+	Name      string   `json:"name,omitempty"`
+	Container string   `json:"container"`
+	Command   []string `json:"command"`
+	Timeout   int      `json:"timeout"`
+	OnError   string   `json:"onError"`
+}
+
+// hooksEnv returns the environment variables passing the hooks of the policy
+// to the agent, as JSON arrays, along with the name and the namespace of the
+// pod it runs them in and the directory of the token it runs them with. The
+// target containers must exist in the pod.
+func hooksEnv(pod *corev1.Pod, hooks *v1alpha1.Hooks) ([]corev1.EnvVar, error) {
+	if hooks == nil || len(hooks.Pre)+len(hooks.Post) == 0 {
+		return nil, nil
+	}
+
+	env := []corev1.EnvVar{
+		// The name of the pod may still be generated when it is admitted
+		{Name: "BC_POD_NAME", ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+		}},
+		{Name: "BC_POD_NAMESPACE", ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+		}},
+		{Name: "BC_HOOKS_TOKEN_DIR", Value: constants.HooksTokenMountPath},
+	}
+
+	for _, phase := range []struct {
+		name  string
+		hooks []v1alpha1.Hook
+	}{{"BC_HOOKS_PRE", hooks.Pre}, {"BC_HOOKS_POST", hooks.Post}} {
+		if len(phase.hooks) == 0 {
+			continue
+		}
+
+		list := make([]hook, 0, len(phase.hooks))
+		for _, h := range phase.hooks {
+			if !hasContainer(pod, h.Container) {
+				return nil, fmt.Errorf("the container %q of the hook %q does not exist", h.Container, h.Name)
+			}
+
+			timeout := defaultHookTimeout
+			if h.Timeout != nil && h.Timeout.Duration > 0 {
+				timeout = h.Timeout.Duration
+			}
+			onError := h.OnError
+			if onError == "" {
+				onError = v1alpha1.HookErrorPolicyFail
+			}
+
+			list = append(list, hook{
+				Name:      h.Name,
+				Container: h.Container,
+				Command:   h.Command,
+				Timeout:   int(math.Ceil(timeout.Seconds())),
+				OnError:   string(onError),
+			})
+		}
+
+		data, err := json.Marshal(list)
+		if err != nil {
+			return nil, fmt.Errorf("error while marshaling the hooks: %w", err)
+		}
+		env = append(env, corev1.EnvVar{Name: phase.name, Value: string(data)})
+	}
+
+	return env, nil
+}
+
+// HooksVolume mounts the token of the hooks ServiceAccount maintained by the
+// controller in the agent container, and returns its volume, or nil when the
+// policy has no hooks. Only the agents get the token, so that the containers
+// of the application cannot exec into the pods. The volume is shared by the
+// agents of the pod, and is optional since the controller only creates the
+// token once it sees the pod.
+func HooksVolume(container *corev1.Container, hooks *v1alpha1.Hooks) *corev1.Volume {
+	if hooks == nil || len(hooks.Pre)+len(hooks.Post) == 0 {
+		return nil
+	}
+
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      HooksVolumeName,
+		MountPath: constants.HooksTokenMountPath,
+		ReadOnly:  true,
+	})
+	return &corev1.Volume{
+		Name: HooksVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: constants.HooksRoleName},
+						Items: []corev1.KeyToPath{
+							{Key: corev1.ServiceAccountTokenKey, Path: corev1.ServiceAccountTokenKey},
+							{Key: corev1.ServiceAccountRootCAKey, Path: corev1.ServiceAccountRootCAKey},
+						},
+						Optional: ptr.To(true),
+					},
+				}},
+			},
+		},
+	}
+}
+
+func hasContainer(pod *corev1.Pod, name string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
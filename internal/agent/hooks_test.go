@@ -0,0 +1,89 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+var _ = Describe("Hooks", func() {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "redis"}}}}
+
+	It("Should pass the hooks with their defaults to the agent", func() {
+		env, err := hooksEnv(pod, &v1alpha1.Hooks{
+			Pre: []v1alpha1.Hook{{
+				Name:      "save",
+				Container: "redis",
+				Command:   []string{"redis-cli", "SAVE"},
+				Timeout:   &metav1.Duration{Duration: 90 * time.Second},
+			}},
+			Post: []v1alpha1.Hook{{
+				Container: "redis",
+				Command:   []string{"redis-cli", "BGREWRITEAOF"},
+				OnError:   v1alpha1.HookErrorPolicyContinue,
+			}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(env).To(HaveLen(5))
+		Expect(env[0].Name).To(Equal("BC_POD_NAME"))
+		Expect(env[0].ValueFrom.FieldRef.FieldPath).To(Equal("metadata.name"))
+		Expect(env[1].Name).To(Equal("BC_POD_NAMESPACE"))
+		Expect(env[2]).To(Equal(corev1.EnvVar{Name: "BC_HOOKS_TOKEN_DIR", Value: constants.HooksTokenMountPath}))
+		Expect(env[3]).To(Equal(corev1.EnvVar{
+			Name:  "BC_HOOKS_PRE",
+			Value: `[{"name":"save","container":"redis","command":["redis-cli","SAVE"],"timeout":90,"onError":"Fail"}]`,
+		}))
+		Expect(env[4]).To(Equal(corev1.EnvVar{
+			Name:  "BC_HOOKS_POST",
+			Value: `[{"container":"redis","command":["redis-cli","BGREWRITEAOF"],"timeout":60,"onError":"Continue"}]`,
+		}))
+	})
+
+	It("Should mount the token of the hooks ServiceAccount in the agent only", func() {
+		container := &corev1.Container{Name: ContainerName}
+		Expect(HooksVolume(container, &v1alpha1.Hooks{})).To(BeNil())
+		Expect(container.VolumeMounts).To(BeEmpty())
+
+		volume := HooksVolume(container, &v1alpha1.Hooks{
+			Pre: []v1alpha1.Hook{{Name: "save", Container: "redis", Command: []string{"redis-cli", "SAVE"}}},
+		})
+		Expect(volume).NotTo(BeNil())
+		Expect(volume.Projected.Sources).To(HaveLen(1))
+		Expect(volume.Projected.Sources[0].Secret.Name).To(Equal(constants.HooksRoleName))
+		Expect(container.VolumeMounts).To(ConsistOf(corev1.VolumeMount{
+			Name:      HooksVolumeName,
+			MountPath: constants.HooksTokenMountPath,
+			ReadOnly:  true,
+		}))
+	})
+
+	It("Should reject the hooks of unknown containers", func() {
+		_, err := hooksEnv(pod, &v1alpha1.Hooks{
+			Pre: []v1alpha1.Hook{{Name: "save", Container: "app", Command: []string{"true"}}},
+		})
+		Expect(err).To(MatchError(ContainSubstring(`"app"`)))
+	})
+})
@@ -0,0 +1,113 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+var sizePattern = regexp.MustCompile(`^[0-9]+[kKmMgGtT]?$`)
+
+// backupOptionsEnv returns the environment variables passing the backup
+// options of the policy, overridden by the annotation of the pod, to the
+// agent. The agents translate them into restic flags; the lists are passed
+// as JSON arrays.
+func backupOptionsEnv(annotations map[string]string, policyOptions *v1alpha1.BackupOptions) ([]corev1.EnvVar, error) {
+	var options v1alpha1.BackupOptions
+	if policyOptions != nil {
+		options = *policyOptions.DeepCopy()
+	}
+
+	// The fields set by the annotation replace those of the policy
+	if value, ok := annotations[constants.BackupOptionsAnnotation]; ok {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&options); err != nil {
+			return nil, fmt.Errorf("invalid annotation %q: %w", constants.BackupOptionsAnnotation, err)
+		}
+		if err := validateBackupOptions(options); err != nil {
+			return nil, fmt.Errorf("invalid annotation %q: %w", constants.BackupOptionsAnnotation, err)
+		}
+	}
+
+	var env []corev1.EnvVar
+	add := func(name, value string) {
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+
+	addList := func(name string, patterns []string) error {
+		if len(patterns) == 0 {
+			return nil
+		}
+		data, err := json.Marshal(patterns)
+		if err != nil {
+			return fmt.Errorf("error while marshaling the backup patterns: %w", err)
+		}
+		add(name, string(data))
+		return nil
+	}
+
+	if err := addList("BC_BACKUP_EXCLUDE", options.Exclude); err != nil {
+		return nil, err
+	}
+	if err := addList("BC_BACKUP_INCLUDE", options.Include); err != nil {
+		return nil, err
+	}
+	if options.ExcludeCaches {
+		add("BC_BACKUP_EXCLUDE_CACHES", "true")
+	}
+	if options.ExcludeLargerThan != "" {
+		add("BC_BACKUP_EXCLUDE_LARGER_THAN", options.ExcludeLargerThan)
+	}
+	if options.OneFileSystem {
+		add("BC_BACKUP_ONE_FILE_SYSTEM", "true")
+	}
+	if options.Compression != "" {
+		add("BC_BACKUP_COMPRESSION", options.Compression)
+	}
+	if options.ReadConcurrency > 0 {
+		add("BC_BACKUP_READ_CONCURRENCY", strconv.Itoa(int(options.ReadConcurrency)))
+	}
+
+	return env, nil
+}
+
+// validateBackupOptions checks the options the CRD schema validates on the
+// policies, for those set by annotation.
+func validateBackupOptions(options v1alpha1.BackupOptions) error {
+	if options.ExcludeLargerThan != "" && !sizePattern.MatchString(options.ExcludeLargerThan) {
+		return fmt.Errorf("invalid size %q", options.ExcludeLargerThan)
+	}
+	switch options.Compression {
+	case "", "auto", "off", "max":
+	default:
+		return fmt.Errorf("invalid compression %q", options.Compression)
+	}
+	if options.ReadConcurrency < 0 {
+		return fmt.Errorf("invalid read concurrency %d", options.ReadConcurrency)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+var _ = Describe("Backup options", func() {
+	It("Should override the options of the policy with the annotation", func() {
+		policy := &v1alpha1.BackupOptions{
+			Exclude:     []string{"*.tmp"},
+			Compression: "max",
+		}
+		annotations := map[string]string{
+			constants.BackupOptionsAnnotation: `{"exclude": ["cache/"], "oneFileSystem": true}`,
+		}
+
+		env, err := backupOptionsEnv(annotations, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(env).To(Equal([]corev1.EnvVar{
+			{Name: "BC_BACKUP_EXCLUDE", Value: `["cache/"]`},
+			{Name: "BC_BACKUP_ONE_FILE_SYSTEM", Value: "true"},
+			{Name: "BC_BACKUP_COMPRESSION", Value: "max"},
+		}))
+		Expect(policy.Exclude).To(Equal([]string{"*.tmp"}))
+	})
+
+	It("Should reject invalid annotations", func() {
+		for _, value := range []string{`{"unknown": true}`, `{"compression": "fast"}`, `{"excludeLargerThan": "1 GiB"}`} {
+			_, err := backupOptionsEnv(map[string]string{constants.BackupOptionsAnnotation: value}, nil)
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+})
@@ -0,0 +1,49 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"math"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+// retryEnv returns the environment variables passing the retries of the policy
+// to the agent, the backoff in seconds. The agent does not retry without them.
+func retryEnv(retry *v1alpha1.Retry) []corev1.EnvVar {
+	if retry == nil {
+		return nil
+	}
+
+	var env []corev1.EnvVar
+	if retry.MaxAttempts > 0 {
+		env = append(env, corev1.EnvVar{
+			Name:  "BC_RETRY_MAX_ATTEMPTS",
+			Value: strconv.Itoa(int(retry.MaxAttempts)),
+		})
+	}
+	if retry.Backoff != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  "BC_RETRY_BACKOFF",
+			Value: strconv.Itoa(int(math.Ceil(retry.Backoff.Seconds()))),
+		})
+	}
+	return env
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+var _ = Describe("Retry", func() {
+	It("Should pass the attempts and the backoff to the agent in seconds", func() {
+		retry := &v1alpha1.Retry{
+			MaxAttempts: 3,
+			Backoff:     &metav1.Duration{Duration: 1500 * time.Millisecond},
+		}
+		Expect(retryEnv(retry)).To(Equal([]corev1.EnvVar{
+			{Name: "BC_RETRY_MAX_ATTEMPTS", Value: "3"},
+			{Name: "BC_RETRY_BACKOFF", Value: "2"},
+		}))
+	})
+
+	It("Should not pass anything without retries", func() {
+		Expect(retryEnv(nil)).To(BeEmpty())
+		Expect(retryEnv(&v1alpha1.Retry{})).To(BeEmpty())
+	})
+})
@@ -0,0 +1,135 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+// SchedulesVolumeName is the name of the pod volume of the schedules ConfigMap.
+const SchedulesVolumeName = "backup-schedules"
+
+// CentralSchedulerEnv is set to "true" on the agents whose runs are triggered
+// by the central scheduler of the manager rather than by their own crond.
+const CentralSchedulerEnv = "BC_CENTRAL_SCHEDULER"
+
+// SchedulesVolume returns the volume of the schedules ConfigMap maintained by
+// the controller. It is optional, so the pod starts before the ConfigMap is
+// first created.
+func SchedulesVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: SchedulesVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: constants.SchedulesConfigMapName},
+				Optional:             ptr.To(true),
+			},
+		},
+	}
+}
+
+// WatchSchedule mounts the schedules ConfigMap in the agent container and
+// points it to the keys of its schedule and of its policy, which it watches to
+// reload their settings without being restarted.
+func WatchSchedule(container *corev1.Container, schedule, policy string) {
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      SchedulesVolumeName,
+		MountPath: constants.SchedulesMountPath,
+		ReadOnly:  true,
+	})
+	container.Env = append(container.Env,
+		corev1.EnvVar{
+			Name:  "BC_SCHEDULE_FILE",
+			Value: path.Join(constants.SchedulesMountPath, schedule),
+		},
+		corev1.EnvVar{
+			Name:  "BC_POLICY_FILE",
+			Value: path.Join(constants.SchedulesMountPath, PolicySettingsKey(policy)),
+		},
+	)
+}
+
+// scheduleSettings lists the variables of the schedule settings the agents
+// reload.
+var scheduleSettings = []string{
+	"BC_SCHEDULE_TIMEZONE",
+	"BC_SCHEDULE_JITTER",
+	"BC_SCHEDULE_STARTING_DEADLINE",
+	"BC_FORGET_SCHEDULE",
+	"BC_PRUNE_SCHEDULE",
+	"BC_CHECK_SCHEDULE",
+	"BC_CHECK_READ_DATA_SUBSET",
+	"BC_CATCHUP_POLICY",
+	"BC_CATCHUP_GRACE_PERIOD",
+}
+
+// policySettings lists the variables of the policy settings the agents
+// reload. The scratch volume of the verification runs is only added when the
+// pod is created.
+var policySettings = []string{
+	"BC_VERIFY_SCHEDULE",
+	"BC_VERIFY_SAMPLE_SIZE",
+	"BC_VERIFY_COMPARE_LIVE",
+}
+
+// ScheduleSettingsKey returns the key of the schedules ConfigMap holding the
+// settings of the given schedule.
+func ScheduleSettingsKey(schedule string) string {
+	return schedule + ".env"
+}
+
+// PolicySettingsKey returns the key of the schedules ConfigMap holding the
+// settings of the given policy.
+func PolicySettingsKey(policy string) string {
+	return policy + ".policy.env"
+}
+
+// ScheduleSettings returns the settings of the schedule the agents reload, as
+// "NAME=value" lines.
+func ScheduleSettings(spec v1alpha1.ScheduleSpec) string {
+	return settings(scheduleEnv(spec), scheduleSettings)
+}
+
+// PolicySettings returns the settings of the policy the agents reload, as
+// "NAME=value" lines.
+func PolicySettings(spec v1alpha1.PolicySpec) string {
+	return settings(verifyEnv(spec.Verify), policySettings)
+}
+
+// settings renders the given variables as "NAME=value" lines. The variables
+// which are not set are listed with an empty value, so that the agents drop
+// the ones set when the pod was created.
+func settings(env []corev1.EnvVar, names []string) string {
+	values := make(map[string]string, len(env))
+	for _, e := range env {
+		values[e.Name] = e.Value
+	}
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%s\n", name, values[name])
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+// ReplicaTagEnv is the variable passing the replica of a StatefulSet to the
+// agent.
+const ReplicaTagEnv = "BC_TAG_REPLICA"
+
+// TagEnv returns the environment variables passing the Kubernetes metadata
+// of the backup to the agent, which tags the snapshots with them: the
+// namespace, the top-level owner, the pod, the replica of a StatefulSet, the
+// policy, the schedule and the cluster. The agents group the retention by
+// these tags, except the pod one, so that a new pod name does not orphan the
+// snapshots of its predecessors.
+func (r *Renderer) TagEnv(ctx context.Context, policy v1alpha1.Policy, schedule v1alpha1.Schedule, pod corev1.Pod) ([]corev1.EnvVar, error) {
+	owner, err := r.owner(ctx, pod)
+	if err != nil {
+		return nil, err
+	}
+	ownerKind, _ := owner["kind"].(string)
+	ownerName, _ := owner["name"].(string)
+
+	env := []corev1.EnvVar{
+		{Name: "BC_TAG_NAMESPACE", Value: pod.Namespace},
+		{Name: "BC_TAG_OWNER_KIND", Value: ownerKind},
+		{Name: "BC_TAG_OWNER_NAME", Value: ownerName},
+		// The name of the pod may still be generated when it is admitted
+		{Name: "BC_TAG_POD", ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+		}},
+		{Name: "BC_TAG_POLICY", Value: policy.Name},
+		{Name: "BC_TAG_SCHEDULE", Value: schedule.Name},
+	}
+	// The pods of a StatefulSet keep their name, which tells apart the
+	// snapshots of its replicas
+	if ref := metav1.GetControllerOf(&pod); ref != nil && ref.Kind == "StatefulSet" {
+		env = append(env, corev1.EnvVar{Name: ReplicaTagEnv, ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+		}})
+	}
+	if r.ClusterName != "" {
+		env = append(env, corev1.EnvVar{Name: "BC_TAG_CLUSTER", Value: r.ClusterName})
+	}
+
+	return env, nil
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+// maxOwnerDepth bounds the resolution of the owner chain of a pod.
+const maxOwnerDepth = 8
+
+// ownerKinds lists the kinds followed when resolving the owner chain of a pod.
+// Reading any other kind through the cached client would start an informer
+// the controller may not be allowed to run, so the chain stops at them.
+var ownerKinds = map[schema.GroupKind]bool{
+	{Group: "apps", Kind: "ReplicaSet"}:  true,
+	{Group: "apps", Kind: "Deployment"}:  true,
+	{Group: "apps", Kind: "StatefulSet"}: true,
+	{Group: "apps", Kind: "DaemonSet"}:   true,
+	{Group: "batch", Kind: "Job"}:        true,
+	{Group: "batch", Kind: "CronJob"}:    true,
+}
+
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=replicasets;deployments;statefulsets;daemonsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;list;watch
+
+// Renderer executes the templates of the policies. Besides the pod, the
+// template context exposes:
+//   - .namespace: the name, labels and annotations of the pod namespace.
+//   - .owner: the top-level controller of the pod (e.g. the Deployment of a
+//     ReplicaSet, the CronJob of a Job), or the pod itself when it has none.
+//   - .policy and .schedule: their name and labels.
+//   - .cluster: the name of the cluster.
+//
+// The templates may use the sprig functions, except those reading the
+// environment or reaching the network, and the ownerName, podLabel,
+// sanitizeDNS and hashShort helpers.
+type Renderer struct {
+	// Client is used to resolve the namespace and the owner chain. It should
+	// be the cached client of the manager.
+	Client client.Reader
+
+	// ClusterName is exposed as .cluster (optional).
+	ClusterName string
+
+	// AllowedFuncs restricts the functions available in the templates
+	// (optional, defaults to all the safe functions).
+	AllowedFuncs []string
+}
+
+// Render executes the templates of the policy against the given pod and
+// returns the rendered policy. Each string of the policy holding an action is
+// executed as its own template, so that the values and the string literals of
+// the templates may hold any character; a literal "{{" is written {{ "{{" }}.
+func (r *Renderer) Render(ctx context.Context, policy v1alpha1.Policy, schedule v1alpha1.Schedule, pod corev1.Pod) (v1alpha1.Policy, error) {
+	data, err := r.context(ctx, policy, schedule, pod)
+	if err != nil {
+		return v1alpha1.Policy{}, err
+	}
+
+	policyJson, err := json.Marshal(policy)
+	if err != nil {
+		return v1alpha1.Policy{}, fmt.Errorf("error while marshaling the policy: %w", err)
+	}
+
+	var fields any
+	if err := json.Unmarshal(policyJson, &fields); err != nil {
+		return v1alpha1.Policy{}, fmt.Errorf("error while converting the policy: %w", err)
+	}
+
+	fields, err = renderFields(fields, "", r.funcs(data), data)
+	if err != nil {
+		return v1alpha1.Policy{}, err
+	}
+
+	resultJson, err := json.Marshal(fields)
+	if err != nil {
+		return v1alpha1.Policy{}, fmt.Errorf("error while marshaling the rendered policy: %w", err)
+	}
+
+	var rendered v1alpha1.Policy
+	if err := json.Unmarshal(resultJson, &rendered); err != nil {
+		return v1alpha1.Policy{}, fmt.Errorf("error while unmarshaling the policy: %w", err)
+	}
+
+	return rendered, nil
+}
+
+// renderFields executes the templates of the strings of the value, converted
+// from JSON, found at the given path of the policy.
+func renderFields(value any, path string, funcs template.FuncMap, data map[string]any) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			rendered, err := renderFields(field, strings.TrimPrefix(path+"."+key, "."), funcs, data)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = rendered
+		}
+	case []any:
+		for i, item := range v {
+			rendered, err := renderFields(item, fmt.Sprintf("%s[%d]", path, i), funcs, data)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = rendered
+		}
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+
+		tpl, err := template.New(path).Funcs(funcs).Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing the template of %s: %w", path, err)
+		}
+
+		var result bytes.Buffer
+		if err := tpl.Execute(&result, data); err != nil {
+			return nil, fmt.Errorf("error while executing the template of %s: %w", path, err)
+		}
+		return result.String(), nil
+	}
+	return value, nil
+}
+
+// funcs returns the functions available in the templates rendered with the
+// given context.
+func (r *Renderer) funcs(data map[string]any) template.FuncMap {
+	funcs := safeFuncs(data)
+	if r.AllowedFuncs == nil {
+		return funcs
+	}
+
+	allowed := make(template.FuncMap, len(r.AllowedFuncs))
+	for _, name := range r.AllowedFuncs {
+		if f, ok := funcs[name]; ok {
+			allowed[name] = f
+		}
+	}
+	return allowed
+}
+
+// context builds the template context of the given pod.
+func (r *Renderer) context(ctx context.Context, policy v1alpha1.Policy, schedule v1alpha1.Schedule, pod corev1.Pod) (map[string]any, error) {
+	podJson, err := json.Marshal(pod)
+	if err != nil {
+		return nil, fmt.Errorf("error while marshaling the pod: %w", err)
+	}
+
+	var podMap map[string]any
+	if err := json.Unmarshal(podJson, &podMap); err != nil {
+		return nil, fmt.Errorf("error while converting the pod: %w", err)
+	}
+
+	namespace := map[string]any{"name": pod.Namespace}
+	var ns corev1.Namespace
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: pod.Namespace}, &ns); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("error while fetching the namespace %q: %w", pod.Namespace, err)
+		}
+	} else {
+		namespace["labels"] = ns.Labels
+		namespace["annotations"] = ns.Annotations
+	}
+
+	owner, err := r.owner(ctx, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"pod":       podMap,
+		"namespace": namespace,
+		"owner":     owner,
+		"policy":    map[string]any{"name": policy.Name, "labels": policy.Labels},
+		"schedule":  map[string]any{"name": schedule.Name, "labels": schedule.Labels},
+		"cluster":   r.ClusterName,
+	}, nil
+}
+
+// owner resolves the top-level controller of the pod by following the
+// controller references. The chain stops at the first owner of an unsupported
+// kind, or which no longer exists.
+func (r *Renderer) owner(ctx context.Context, pod corev1.Pod) (map[string]any, error) {
+	owner := ownerMap(corev1.SchemeGroupVersion.String(), "Pod", &pod.ObjectMeta)
+
+	ref := metav1.GetControllerOf(&pod)
+	for depth := 0; ref != nil && depth < maxOwnerDepth; depth++ {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid owner reference %q: %w", ref.APIVersion, err)
+		}
+
+		// The reference itself is still known
+		refOwner := map[string]any{"apiVersion": ref.APIVersion, "kind": ref.Kind, "name": ref.Name, "uid": string(ref.UID)}
+		if !ownerKinds[gv.WithKind(ref.Kind).GroupKind()] {
+			owner = refOwner
+			break
+		}
+
+		obj := &metav1.PartialObjectMetadata{}
+		obj.SetGroupVersionKind(gv.WithKind(ref.Kind))
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: ref.Name}, obj); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("error while fetching the owner %s %q: %w", ref.Kind, ref.Name, err)
+			}
+			owner = refOwner
+			break
+		}
+
+		owner = ownerMap(ref.APIVersion, ref.Kind, &obj.ObjectMeta)
+		ref = metav1.GetControllerOf(obj)
+	}
+
+	return owner, nil
+}
+
+func ownerMap(apiVersion, kind string, meta *metav1.ObjectMeta) map[string]any {
+	return map[string]any{
+		"apiVersion":  apiVersion,
+		"kind":        kind,
+		"name":        meta.Name,
+		"uid":         string(meta.UID),
+		"labels":      meta.Labels,
+		"annotations": meta.Annotations,
+	}
+}
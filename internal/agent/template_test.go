@@ -0,0 +1,274 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+var _ = Describe("Renderer", func() {
+	var renderer *Renderer
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+
+		namespace := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "storage"}},
+		}
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", UID: "deployment-uid"},
+		}
+		replicaSet := &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "app-6d4cf56db6",
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1",
+					Kind:       "Deployment",
+					Name:       "app",
+					UID:        "deployment-uid",
+					Controller: ptr.To(true),
+				}},
+			},
+		}
+
+		renderer = &Renderer{
+			Client:      fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespace, deployment, replicaSet).Build(),
+			ClusterName: "production",
+		}
+	})
+
+	It("Should expose the namespace, the top-level owner, the policy, the schedule and the cluster", func() {
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "app-6d4cf56db6-x2x9z",
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1",
+					Kind:       "ReplicaSet",
+					Name:       "app-6d4cf56db6",
+					Controller: ptr.To(true),
+				}},
+			},
+		}
+		policy := v1alpha1.Policy{
+			ObjectMeta: metav1.ObjectMeta{Name: "files"},
+			Spec: v1alpha1.PolicySpec{
+				Environment: []corev1.EnvVar{{
+					Name:  "RESTIC_HOST",
+					Value: "{{ .cluster }}/{{ .namespace.labels.team }}/{{ .owner.kind }}/{{ .owner.name }}/{{ .policy.name }}/{{ .schedule.name }}",
+				}},
+			},
+		}
+		schedule := v1alpha1.Schedule{ObjectMeta: metav1.ObjectMeta{Name: "daily"}}
+
+		rendered, err := renderer.Render(context.Background(), policy, schedule, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered.Spec.Environment[0].Value).To(Equal("production/storage/Deployment/app/files/daily"))
+	})
+
+	It("Should expose the pod itself as the owner when it has no controller", func() {
+		pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "standalone"}}
+		policy := v1alpha1.Policy{
+			Spec: v1alpha1.PolicySpec{
+				Environment: []corev1.EnvVar{{Name: "RESTIC_HOST", Value: "{{ .owner.kind }}/{{ .owner.name }}"}},
+			},
+		}
+
+		rendered, err := renderer.Render(context.Background(), policy, v1alpha1.Schedule{}, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered.Spec.Environment[0].Value).To(Equal("Pod/standalone"))
+	})
+	It("Should pass the Kubernetes metadata of the backup as tags", func() {
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1",
+					Kind:       "ReplicaSet",
+					Name:       "app-6d4cf56db6",
+					Controller: ptr.To(true),
+				}},
+			},
+		}
+		policy := v1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Name: "files"}}
+		schedule := v1alpha1.Schedule{ObjectMeta: metav1.ObjectMeta{Name: "daily"}}
+
+		env, err := renderer.TagEnv(context.Background(), policy, schedule, pod)
+		Expect(err).NotTo(HaveOccurred())
+
+		values := make(map[string]string)
+		for _, e := range env {
+			values[e.Name] = e.Value
+			if e.Name == "BC_TAG_POD" {
+				Expect(e.ValueFrom.FieldRef.FieldPath).To(Equal("metadata.name"))
+			}
+		}
+		Expect(values).To(Equal(map[string]string{
+			"BC_TAG_NAMESPACE":  "default",
+			"BC_TAG_OWNER_KIND": "Deployment",
+			"BC_TAG_OWNER_NAME": "app",
+			"BC_TAG_POD":        "",
+			"BC_TAG_POLICY":     "files",
+			"BC_TAG_SCHEDULE":   "daily",
+			"BC_TAG_CLUSTER":    "production",
+		}))
+	})
+
+	It("Should tell apart the replicas of a StatefulSet sharing the owner tags", func() {
+		policy := v1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Name: "files"}}
+		schedule := v1alpha1.Schedule{ObjectMeta: metav1.ObjectMeta{Name: "daily"}}
+
+		var tags []map[string]string
+		for _, name := range []string{"db-0", "db-1"} {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Name:      name,
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "apps/v1",
+						Kind:       "StatefulSet",
+						Name:       "db",
+						Controller: ptr.To(true),
+					}},
+				},
+			}
+
+			env, err := renderer.TagEnv(context.Background(), policy, schedule, pod)
+			Expect(err).NotTo(HaveOccurred())
+
+			values := make(map[string]string)
+			for _, e := range env {
+				values[e.Name] = e.Value
+				if e.Name == ReplicaTagEnv {
+					Expect(e.ValueFrom.FieldRef.FieldPath).To(Equal("metadata.name"))
+				}
+			}
+			Expect(values).To(HaveKey(ReplicaTagEnv))
+			tags = append(tags, values)
+		}
+		Expect(tags[0]).To(Equal(tags[1]))
+		Expect(tags[0]).To(HaveKeyWithValue("BC_TAG_OWNER_NAME", "db"))
+	})
+
+	It("Should not expose the controller environment", func() {
+		policy := v1alpha1.Policy{
+			Spec: v1alpha1.PolicySpec{
+				Environment: []corev1.EnvVar{{Name: "LEAK", Value: `{{ env "HOME" }}`}},
+			},
+		}
+
+		_, err := renderer.Render(context.Background(), policy, v1alpha1.Schedule{}, corev1.Pod{})
+		Expect(err).To(MatchError(ContainSubstring(`function "env" not defined`)))
+	})
+
+	It("Should provide the project helpers", func() {
+		pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "standalone", Labels: map[string]string{"app": "My_App"}}}
+		policy := v1alpha1.Policy{
+			Spec: v1alpha1.PolicySpec{
+				Environment: []corev1.EnvVar{{Name: "RESTIC_HOST", Value: `{{ ownerName }}-{{ podLabel "app" | sanitizeDNS }}-{{ hashShort "x" }}`}},
+			},
+		}
+
+		rendered, err := renderer.Render(context.Background(), policy, v1alpha1.Schedule{}, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered.Spec.Environment[0].Value).To(Equal("standalone-my-app-" + hashShort("x")))
+	})
+
+	It("Should only allow the configured functions", func() {
+		renderer.AllowedFuncs = []string{"upper"}
+		policy := v1alpha1.Policy{
+			Spec: v1alpha1.PolicySpec{
+				Environment: []corev1.EnvVar{{Name: "RESTIC_HOST", Value: `{{ lower "A" }}`}},
+			},
+		}
+
+		_, err := renderer.Render(context.Background(), policy, v1alpha1.Schedule{}, corev1.Pod{})
+		Expect(err).To(MatchError(ContainSubstring(`function "lower" not defined`)))
+		Expect(ValidateFuncNames([]string{"upper", "env"})).To(HaveOccurred())
+	})
+
+	It("Should render the templates with quoted arguments and the values with quotes", func() {
+		pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "standalone",
+			Annotations: map[string]string{"note": `say "hi" \o/`},
+		}}
+		policy := v1alpha1.Policy{
+			Spec: v1alpha1.PolicySpec{
+				Environment: []corev1.EnvVar{
+					{Name: "QUOTED", Value: `{{ printf "%s-\"%s\"" .owner.name "backup" }}`},
+					{Name: "ANNOTATION", Value: `{{ index .pod.metadata.annotations "note" }}`},
+					{Name: "TEXT", Value: `"{{ .owner.name }}" in C:\backups\{{ .namespace.name }}`},
+					{Name: "PLAIN", Value: `no "action" \ here`},
+				},
+			},
+		}
+
+		rendered, err := renderer.Render(context.Background(), policy, v1alpha1.Schedule{}, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered.Spec.Environment).To(Equal([]corev1.EnvVar{
+			{Name: "QUOTED", Value: `standalone-"backup"`},
+			{Name: "ANNOTATION", Value: `say "hi" \o/`},
+			{Name: "TEXT", Value: `"standalone" in C:\backups\default`},
+			{Name: "PLAIN", Value: `no "action" \ here`},
+		}))
+	})
+
+	It("Should render the literal braces of the templates", func() {
+		pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "standalone"}}
+		policy := v1alpha1.Policy{
+			Spec: v1alpha1.PolicySpec{
+				Environment: []corev1.EnvVar{
+					{Name: "BRACES", Value: `{{ "{{" }} .owner.name }} is {{ .owner.name }}`},
+					{Name: "CLOSING", Value: `}} {{ "}}" }}`},
+				},
+			},
+		}
+
+		rendered, err := renderer.Render(context.Background(), policy, v1alpha1.Schedule{}, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered.Spec.Environment[0].Value).To(Equal("{{ .owner.name }} is standalone"))
+		Expect(rendered.Spec.Environment[1].Value).To(Equal("}} }}"))
+	})
+
+	It("Should report the field of an invalid template", func() {
+		policy := v1alpha1.Policy{
+			Spec: v1alpha1.PolicySpec{
+				Environment: []corev1.EnvVar{{Name: "OK", Value: "ok"}, {Name: "BROKEN", Value: "{{ .owner.name"}},
+			},
+		}
+
+		_, err := renderer.Render(context.Background(), policy, v1alpha1.Schedule{}, corev1.Pod{})
+		Expect(err).To(MatchError(ContainSubstring("the template of spec.environment[1].value")))
+	})
+})
@@ -0,0 +1,65 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"math"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+// TerminationGracePeriod returns the termination grace period of the pod, in
+// seconds, raised to the one of the policy, or nil when neither sets one.
+func TerminationGracePeriod(pod *corev1.Pod, termination *v1alpha1.Termination) *int64 {
+	grace := pod.Spec.TerminationGracePeriodSeconds
+	if termination == nil || termination.GracePeriod == nil {
+		if grace == nil {
+			return nil
+		}
+		return ptr.To(*grace)
+	}
+
+	seconds := int64(math.Ceil(termination.GracePeriod.Seconds()))
+	if grace != nil && *grace > seconds {
+		return ptr.To(*grace)
+	}
+	return &seconds
+}
+
+// terminationEnv returns the environment variables passing the termination
+// grace period of the pod and the abort period of the policy to the agent, in
+// seconds. The agent defaults to the grace period of the pods without one.
+func terminationEnv(pod *corev1.Pod, termination *v1alpha1.Termination) []corev1.EnvVar {
+	var env []corev1.EnvVar
+	if grace := TerminationGracePeriod(pod, termination); grace != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  "BC_TERMINATION_GRACE_PERIOD",
+			Value: strconv.FormatInt(*grace, 10),
+		})
+	}
+	if termination != nil && termination.AbortPeriod != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  "BC_TERMINATION_ABORT_PERIOD",
+			Value: strconv.Itoa(int(math.Ceil(termination.AbortPeriod.Seconds()))),
+		})
+	}
+	return env
+}
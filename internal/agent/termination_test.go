@@ -0,0 +1,61 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+var _ = Describe("Termination", func() {
+	withGrace := func(seconds *int64) *corev1.Pod {
+		return &corev1.Pod{Spec: corev1.PodSpec{TerminationGracePeriodSeconds: seconds}}
+	}
+
+	It("Should raise the grace period of the pod to the one of the policy", func() {
+		termination := &v1alpha1.Termination{GracePeriod: &metav1.Duration{Duration: 10 * time.Minute}}
+
+		Expect(TerminationGracePeriod(withGrace(nil), termination)).To(Equal(ptr.To[int64](600)))
+		Expect(TerminationGracePeriod(withGrace(ptr.To[int64](30)), termination)).To(Equal(ptr.To[int64](600)))
+		Expect(TerminationGracePeriod(withGrace(ptr.To[int64](900)), termination)).To(Equal(ptr.To[int64](900)))
+	})
+
+	It("Should keep the grace period of the pod without one in the policy", func() {
+		Expect(TerminationGracePeriod(withGrace(nil), nil)).To(BeNil())
+		Expect(TerminationGracePeriod(withGrace(ptr.To[int64](45)), &v1alpha1.Termination{})).To(Equal(ptr.To[int64](45)))
+	})
+
+	It("Should pass the grace and abort periods to the agent in seconds", func() {
+		termination := &v1alpha1.Termination{
+			GracePeriod: &metav1.Duration{Duration: 5 * time.Minute},
+			AbortPeriod: &metav1.Duration{Duration: 30 * time.Second},
+		}
+		Expect(terminationEnv(withGrace(ptr.To[int64](30)), termination)).To(Equal([]corev1.EnvVar{
+			{Name: "BC_TERMINATION_GRACE_PERIOD", Value: "300"},
+			{Name: "BC_TERMINATION_ABORT_PERIOD", Value: "30"},
+		}))
+		Expect(terminationEnv(withGrace(nil), nil)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,73 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+// verifyEnv returns the environment variables passing the verification runs of
+// the policy to the agent, the size limit in bytes.
+func verifyEnv(verify *v1alpha1.Verify) []corev1.EnvVar {
+	if verify == nil {
+		return nil
+	}
+
+	env := []corev1.EnvVar{
+		{Name: "BC_VERIFY_SCHEDULE", Value: verify.Schedule},
+		{Name: "BC_VERIFY_DIR", Value: constants.VerifyMountPath},
+	}
+	if verify.SampleSize > 0 {
+		env = append(env, corev1.EnvVar{Name: "BC_VERIFY_SAMPLE_SIZE", Value: strconv.Itoa(int(verify.SampleSize))})
+	}
+	if verify.CompareLive {
+		env = append(env, corev1.EnvVar{Name: "BC_VERIFY_COMPARE_LIVE", Value: "true"})
+	}
+	if verify.ScratchSizeLimit != nil {
+		env = append(env, corev1.EnvVar{Name: "BC_VERIFY_SIZE_LIMIT", Value: strconv.FormatInt(verify.ScratchSizeLimit.Value(), 10)})
+	}
+	return env
+}
+
+// VerifyVolume mounts the scratch emptyDir the verification runs restore the
+// snapshots into in the agent container, and returns it, or nil when the policy
+// does not verify its backups. The volume is named after the container, so that
+// each agent of the pod gets its own.
+func VerifyVolume(container *corev1.Container, verify *v1alpha1.Verify) *corev1.Volume {
+	if verify == nil {
+		return nil
+	}
+
+	name := container.Name + "-verify"
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      name,
+		MountPath: constants.VerifyMountPath,
+	})
+	return &corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{
+				SizeLimit: verify.ScratchSizeLimit,
+			},
+		},
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+var _ = Describe("Verify", func() {
+	verify := &v1alpha1.Verify{
+		Schedule:         "0 4 * * 0",
+		SampleSize:       100,
+		CompareLive:      true,
+		ScratchSizeLimit: ptr.To(resource.MustParse("1Gi")),
+	}
+
+	It("Should pass the verification runs to the agent", func() {
+		Expect(verifyEnv(verify)).To(Equal([]corev1.EnvVar{
+			{Name: "BC_VERIFY_SCHEDULE", Value: "0 4 * * 0"},
+			{Name: "BC_VERIFY_DIR", Value: constants.VerifyMountPath},
+			{Name: "BC_VERIFY_SAMPLE_SIZE", Value: "100"},
+			{Name: "BC_VERIFY_COMPARE_LIVE", Value: "true"},
+			{Name: "BC_VERIFY_SIZE_LIMIT", Value: "1073741824"},
+		}))
+		Expect(verifyEnv(nil)).To(BeEmpty())
+	})
+
+	It("Should mount a scratch volume named after the agent", func() {
+		container := corev1.Container{Name: ContainerName + "-data"}
+		volume := VerifyVolume(&container, verify)
+
+		Expect(volume).NotTo(BeNil())
+		Expect(volume.Name).To(Equal("backup-agent-data-verify"))
+		Expect(volume.EmptyDir).NotTo(BeNil())
+		Expect(volume.EmptyDir.SizeLimit.String()).To(Equal("1Gi"))
+		Expect(container.VolumeMounts).To(ConsistOf(corev1.VolumeMount{
+			Name:      "backup-agent-data-verify",
+			MountPath: constants.VerifyMountPath,
+		}))
+	})
+
+	It("Should not mount anything without verification runs", func() {
+		container := corev1.Container{Name: ContainerName}
+		Expect(VerifyVolume(&container, nil)).To(BeNil())
+		Expect(container.VolumeMounts).To(BeEmpty())
+	})
+})
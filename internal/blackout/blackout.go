@@ -0,0 +1,170 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package blackout computes the windows during which the backups must not
+// run: the blackout windows of the schedules and the backup freezes.
+package blackout
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+// maxOccurrences bounds the occurrences of a recurring window computed over a
+// period.
+const maxOccurrences = 1000
+
+// Window is an occurrence of a blackout window or a freeze.
+type Window struct {
+	Start  time.Time
+	End    time.Time
+	Reason string
+}
+
+// Validate checks a blackout window of a schedule.
+func Validate(w v1alpha1.BlackoutWindow, timeZone string) error {
+	recurring := w.Schedule != "" || w.Duration != nil
+	dateRange := w.Start != nil || w.End != nil
+
+	switch {
+	case recurring && dateRange:
+		return errors.New("either schedule and duration or start and end must be set, not both")
+	case recurring:
+		if _, err := parse(w.Schedule, timeZone); err != nil {
+			return fmt.Errorf("invalid schedule %q: %w", w.Schedule, err)
+		}
+		if w.Duration == nil || w.Duration.Duration <= 0 {
+			return errors.New("the duration must be positive")
+		}
+	case dateRange:
+		if w.Start == nil || w.End == nil {
+			return errors.New("both start and end must be set")
+		}
+		if !w.End.After(w.Start.Time) {
+			return errors.New("the end must be after the start")
+		}
+	default:
+		return errors.New("either schedule and duration or start and end must be set")
+	}
+	return nil
+}
+
+// Windows returns the windows of the schedule and the freezes overlapping the
+// given period, clipped to it and sorted by start, in UTC.
+func Windows(spec v1alpha1.ScheduleSpec, freezes []v1alpha1.BackupFreeze, from, to time.Time) ([]Window, error) {
+	var windows []Window
+	add := func(start, end time.Time, reason string) {
+		if start.Before(from) {
+			start = from
+		}
+		if end.IsZero() || end.After(to) {
+			end = to
+		}
+		if start.Before(end) {
+			windows = append(windows, Window{Start: start.UTC(), End: end.UTC(), Reason: reason})
+		}
+	}
+
+	for _, w := range spec.BlackoutWindows {
+		reason := w.Reason
+		if reason == "" {
+			reason = "blackout window"
+		}
+
+		if w.Start != nil && w.End != nil {
+			add(w.Start.Time, w.End.Time, reason)
+			continue
+		}
+		if w.Schedule == "" || w.Duration == nil {
+			continue
+		}
+
+		sched, err := parse(w.Schedule, spec.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blackout window schedule %q: %w", w.Schedule, err)
+		}
+		// The occurrences started before the period may still overlap it
+		start := sched.Next(from.Add(-w.Duration.Duration - time.Second))
+		for i := 0; i < maxOccurrences && !start.IsZero() && start.Before(to); i++ {
+			add(start, start.Add(w.Duration.Duration), reason)
+			start = sched.Next(start)
+		}
+	}
+
+	for _, f := range freezes {
+		if !f.DeletionTimestamp.IsZero() {
+			continue
+		}
+		start := f.CreationTimestamp.Time
+		if f.Spec.Start != nil {
+			start = f.Spec.Start.Time
+		}
+		var end time.Time
+		if f.Spec.End != nil {
+			end = f.Spec.End.Time
+		}
+		reason := f.Spec.Reason
+		if reason == "" {
+			reason = "freeze " + f.Name
+		}
+		add(start, end, reason)
+	}
+
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+
+	return windows, nil
+}
+
+// Active returns the window in effect at the given time, the one ending last
+// when several overlap.
+func Active(windows []Window, t time.Time) (Window, bool) {
+	var active Window
+	found := false
+	for _, w := range windows {
+		if !t.Before(w.Start) && t.Before(w.End) && (!found || w.End.After(active.End)) {
+			active = w
+			found = true
+		}
+	}
+	return active, found
+}
+
+// NextChange returns the first time after the given one at which a window
+// starts or ends.
+func NextChange(windows []Window, t time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, w := range windows {
+		for _, b := range []time.Time{w.Start, w.End} {
+			if b.After(t) && (next.IsZero() || b.Before(next)) {
+				next = b
+			}
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func parse(schedule, timeZone string) (cron.Schedule, error) {
+	if timeZone != "" {
+		schedule = "CRON_TZ=" + timeZone + " " + schedule
+	}
+	return cron.ParseStandard(schedule)
+}
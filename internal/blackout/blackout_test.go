@@ -0,0 +1,101 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blackout
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+)
+
+var _ = Describe("Blackout", func() {
+	at := func(value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		Expect(err).NotTo(HaveOccurred())
+		return t
+	}
+
+	It("Should compute the occurrences of the recurring windows in the time zone of the schedule", func() {
+		spec := v1alpha1.ScheduleSpec{
+			TimeZone: "Europe/Paris",
+			BlackoutWindows: []v1alpha1.BlackoutWindow{{
+				Reason:   "nightly batch",
+				Schedule: "0 1 * * *",
+				Duration: &metav1.Duration{Duration: 2 * time.Hour},
+			}},
+		}
+
+		// 01:00 in Paris is 00:00 UTC in winter
+		windows, err := Windows(spec, nil, at("2025-01-11T00:30:00Z"), at("2025-01-12T01:00:00Z"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(windows).To(Equal([]Window{
+			{Start: at("2025-01-11T00:30:00Z"), End: at("2025-01-11T02:00:00Z"), Reason: "nightly batch"},
+			{Start: at("2025-01-12T00:00:00Z"), End: at("2025-01-12T01:00:00Z"), Reason: "nightly batch"},
+		}))
+
+		w, ok := Active(windows, at("2025-01-11T01:00:00Z"))
+		Expect(ok).To(BeTrue())
+		Expect(w.Reason).To(Equal("nightly batch"))
+
+		_, ok = Active(windows, at("2025-01-11T12:00:00Z"))
+		Expect(ok).To(BeFalse())
+
+		next, ok := NextChange(windows, at("2025-01-11T12:00:00Z"))
+		Expect(ok).To(BeTrue())
+		Expect(next).To(Equal(at("2025-01-12T00:00:00Z")))
+	})
+
+	It("Should include the date ranges and the freezes", func() {
+		spec := v1alpha1.ScheduleSpec{
+			BlackoutWindows: []v1alpha1.BlackoutWindow{{
+				Start: &metav1.Time{Time: at("2025-01-10T10:00:00Z")},
+				End:   &metav1.Time{Time: at("2025-01-10T12:00:00Z")},
+			}},
+		}
+		freezes := []v1alpha1.BackupFreeze{{
+			ObjectMeta: metav1.ObjectMeta{Name: "migration", CreationTimestamp: metav1.Time{Time: at("2025-01-10T11:00:00Z")}},
+		}}
+
+		windows, err := Windows(spec, freezes, at("2025-01-10T00:00:00Z"), at("2025-01-11T00:00:00Z"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(windows).To(Equal([]Window{
+			{Start: at("2025-01-10T10:00:00Z"), End: at("2025-01-10T12:00:00Z"), Reason: "blackout window"},
+			{Start: at("2025-01-10T11:00:00Z"), End: at("2025-01-11T00:00:00Z"), Reason: "freeze migration"},
+		}))
+
+		// The overlapping window ending last is in effect
+		w, ok := Active(windows, at("2025-01-10T11:30:00Z"))
+		Expect(ok).To(BeTrue())
+		Expect(w.Reason).To(Equal("freeze migration"))
+	})
+
+	It("Should reject incomplete or mixed windows", func() {
+		hour := &metav1.Duration{Duration: time.Hour}
+		now := &metav1.Time{Time: time.Now()}
+
+		Expect(Validate(v1alpha1.BlackoutWindow{Schedule: "0 1 * * *", Duration: hour}, "")).To(Succeed())
+		Expect(Validate(v1alpha1.BlackoutWindow{}, "")).NotTo(Succeed())
+		Expect(Validate(v1alpha1.BlackoutWindow{Schedule: "0 1 * * *"}, "")).NotTo(Succeed())
+		Expect(Validate(v1alpha1.BlackoutWindow{Schedule: "0 1 * * *", Duration: hour, Start: now}, "")).NotTo(Succeed())
+		Expect(Validate(v1alpha1.BlackoutWindow{Start: now, End: now}, "")).NotTo(Succeed())
+	})
+})
@@ -7,18 +7,33 @@ const (
 	// ScheduleAnnotation is the annotation used by the user to define the schedule to use
 	ScheduleAnnotation = "backup-controller.rclsilver-org.github.com/schedule"
 
-	// FilterAnnotation is the annotation used by the user to filter the pod name
+	// FilterAnnotation is the annotation used by the user to filter the pod name.
+	// Deprecated: use SelectorAnnotation, e.g. `pod.metadata.name.matches("...")`.
 	FilterAnnotation = "backup-controller.rclsilver-org.github.com/filter"
 
-	// AutoDetectVolumeAnnotation specify the volume name to use when auto-detect is enabled
+	// SelectorAnnotation is the annotation used by the user to select the pod with a CEL
+	// expression, which gets the pod as the `pod` variable and must return a bool
+	SelectorAnnotation = "backup-controller.rclsilver-org.github.com/selector"
+
+	// AutoDetectVolumeAnnotation specify the volume names to use when auto-detect is enabled, comma-separated
 	AutoDetectVolumeAnnotation = "backup-controller.rclsilver-org.github.com/detect.volume"
 
-	// AutoDetectContainerAnnotation specify the volume container to use when auto-detect is enabled
+	// AutoDetectContainerAnnotation specify the volume containers to use when auto-detect is enabled, comma-separated
 	AutoDetectContainerAnnotation = "backup-controller.rclsilver-org.github.com/detect.container"
 
-	// RetentionDaysAnnotation is the annotation used to override BC_RETENTION_DAYS for a specific pod
+	// BackupsAnnotation is the annotation used to request several backups for a pod, as a
+	// list of policy@schedule pairs (e.g. "onsite@daily,offsite@weekly") or a JSON list of
+	// {"policy": ..., "schedule": ...} objects. Each pair gets its own backup agent.
+	BackupsAnnotation = "backup-controller.rclsilver-org.github.com/backups"
+
+	// RetentionDaysAnnotation is the annotation used to override BC_RETENTION_DAYS for a specific pod,
+	// either for all its agents (e.g. "30") or per policy (e.g. "onsite=7,offsite=90")
 	RetentionDaysAnnotation = "backup-controller.rclsilver-org.github.com/retention-days"
 
+	// BackupOptionsAnnotation is the annotation used to override the backup options of the
+	// policies for a specific pod, as a JSON object with the fields of spec.backup
+	BackupOptionsAnnotation = "backup-controller.rclsilver-org.github.com/backup-options"
+
 	// MutatedLabel is the label set by the controller when a pod is mutated
 	MutatedLabel = "backup-controller.rclsilver-org.github.com/mutated"
 
@@ -26,4 +41,51 @@ const (
 	// sidecar is injected. A single PodMonitor selecting this label can then
 	// auto-discover every exporter across all namespaces.
 	ExporterLabel = "backup-controller.rclsilver-org.github.com/exporter"
+
+	// CronJobLabel is the label set by the controller on the backup CronJobs it
+	// manages for the policies in CronJob mode
+	CronJobLabel = "backup-controller.rclsilver-org.github.com/cronjob"
+
+	// SnapshotLabel is the label set by the controller on the backup Jobs it
+	// runs for the policies in Snapshot mode
+	SnapshotLabel = "backup-controller.rclsilver-org.github.com/snapshot"
+
+	// VolumeLabel is the label set by the controller on the backup Jobs it runs
+	// for the policies in Snapshot mode, holding the name of the backed up volume
+	VolumeLabel = "backup-controller.rclsilver-org.github.com/volume"
+
+	// BackupLabel is the label set by the controller on the backup Jobs it runs
+	// for the policies in Snapshot mode when the workload requests several
+	// backups, holding the suffix of the policy and schedule pair
+	BackupLabel = "backup-controller.rclsilver-org.github.com/backup"
+
+	// ScheduledTimeAnnotation is the annotation set by the controller on the
+	// backup Jobs it runs for the policies in Snapshot mode, holding the time
+	// the run was scheduled at
+	ScheduledTimeAnnotation = "backup-controller.rclsilver-org.github.com/scheduled-time"
+
+	// SchedulesConfigMapName is the name of the ConfigMap maintained by the controller
+	// in the namespaces of the mutated pods, holding the cron expression of each
+	// schedule they use. The agents watch it to reload their schedule.
+	SchedulesConfigMapName = "backup-controller-schedules"
+
+	// SchedulesMountPath is the path the schedules ConfigMap is mounted at in the agents
+	SchedulesMountPath = "/etc/backup-controller/schedules"
+
+	// VerifyMountPath is the path the scratch volume of the verification runs is
+	// mounted at in the agents
+	VerifyMountPath = "/var/lib/backup-controller/verify"
+
+	// HooksRoleName is the name of the Role, the RoleBinding, the ServiceAccount
+	// and its token Secret maintained by the controller in the namespaces of the
+	// mutated pods with hooks, allowing the agents to run the hooks in them.
+	HooksRoleName = "backup-controller-hooks"
+
+	// HooksTokenMountPath is the path the token of the hooks ServiceAccount is
+	// mounted at in the agents
+	HooksTokenMountPath = "/var/run/secrets/backup-controller/hooks"
+
+	// SpecHashAnnotation is the annotation set by the controller on the objects
+	// it manages, holding a hash of the last applied spec
+	SpecHashAnnotation = "backup-controller.rclsilver-org.github.com/spec-hash"
 )
@@ -0,0 +1,344 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+// maxCronJobNameLength is the maximum length of a CronJob name: the Job
+// controller appends an 11 characters suffix to it and Job names are limited
+// to 63 characters.
+const maxCronJobNameLength = 52
+
+// CronJobReconciler maintains the backup CronJobs of the workloads whose pod
+// template uses a policy in CronJob mode: one CronJob per workload, policy and
+// schedule pair and backed up volume, running the agent once on the schedule's
+// cron. The CronJobs are
+// owned by the workload, so they are garbage collected along with it. They are
+// suspended during the blackout windows of the schedule and the freezes.
+type CronJobReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Renderer renders the policies against the workload pod template.
+	Renderer *agent.Renderer
+
+	// Now returns the current time (optional, used by the tests).
+	Now func() time.Time
+}
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=backup-controller.rclsilver-org.github.com,resources=policies;schedules;backupfreezes,verbs=get;list;watch
+
+// SetupWithManager sets up one controller per supported workload kind with the Manager.
+func (r *CronJobReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	for _, w := range workloads {
+		err := ctrl.NewControllerManagedBy(mgr).
+			Named(strings.ToLower(w.kind)+"-backup-cronjob").
+			For(w.object()).
+			Owns(&batchv1.CronJob{}).
+			Watches(&v1alpha1.Policy{}, handler.EnqueueRequestsFromMapFunc(referencing(r.Client, w, func(b agent.Backup) string { return b.Policy }))).
+			Watches(&v1alpha1.Schedule{}, handler.EnqueueRequestsFromMapFunc(referencing(r.Client, w, func(b agent.Backup) string { return b.Schedule }))).
+			Watches(&v1alpha1.BackupFreeze{}, handler.EnqueueRequestsFromMapFunc(withBackups(r.Client, w))).
+			Complete(reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+				return r.reconcile(ctx, w, req)
+			}))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *CronJobReconciler) reconcile(ctx context.Context, w workload, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx).WithValues("kind", w.kind)
+
+	obj := w.object()
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		// The CronJobs of a deleted workload are garbage collected by Kubernetes
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	desired, requeue, err := r.desiredCronJobs(ctx, w, obj)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var existing batchv1.CronJobList
+	if err := r.List(ctx, &existing, client.InNamespace(obj.GetNamespace()), client.MatchingLabels{constants.CronJobLabel: "true"}); err != nil {
+		return ctrl.Result{}, fmt.Errorf("error while listing the backup cronjobs: %w", err)
+	}
+
+	for i := range existing.Items {
+		cronJob := &existing.Items[i]
+		if !metav1.IsControlledBy(cronJob, obj) {
+			continue
+		}
+		if _, ok := desired[cronJob.Name]; ok {
+			continue
+		}
+		if err := r.Delete(ctx, cronJob); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, fmt.Errorf("error while deleting the backup cronjob %q: %w", cronJob.Name, err)
+		}
+		log.Info("deleted the backup cronjob", "cronjob", cronJob.Name)
+	}
+
+	for name, spec := range desired {
+		cronJob := &batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: obj.GetNamespace(),
+			},
+		}
+
+		result, err := controllerutil.CreateOrUpdate(ctx, r.Client, cronJob, func() error {
+			if cronJob.Labels == nil {
+				cronJob.Labels = make(map[string]string, 1)
+			}
+			cronJob.Labels[constants.CronJobLabel] = "true"
+
+			// The API server defaults many fields of the spec, so it is only
+			// replaced when the desired one changed, or when the live one
+			// drifted from it, to avoid endless updates.
+			hash, err := specHash(spec)
+			if err != nil {
+				return err
+			}
+			matches, err := specMatches(spec, cronJob.Spec)
+			if err != nil {
+				return err
+			}
+			if cronJob.Annotations[constants.SpecHashAnnotation] != hash || !matches {
+				if cronJob.Annotations == nil {
+					cronJob.Annotations = make(map[string]string, 1)
+				}
+				cronJob.Annotations[constants.SpecHashAnnotation] = hash
+				cronJob.Spec = spec
+			}
+
+			return controllerutil.SetControllerReference(obj, cronJob, r.Scheme)
+		})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error while reconciling the backup cronjob %q: %w", name, err)
+		}
+		if result != controllerutil.OperationResultNone {
+			log.Info("reconciled the backup cronjob", "cronjob", name, "operation", result)
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+func (r *CronJobReconciler) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+// desiredCronJobs returns the spec of the backup CronJobs the workload should
+// own, indexed by name, and the delay after which the blackout windows should
+// be checked again. It is empty when the pod template does not use a policy
+// in CronJob mode.
+func (r *CronJobReconciler) desiredCronJobs(ctx context.Context, w workload, obj client.Object) (map[string]batchv1.CronJobSpec, time.Duration, error) {
+	agents, err := resolveAgents(ctx, r.Client, r.Renderer, w, obj, v1alpha1.PolicyModeCronJob)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make(map[string]batchv1.CronJobSpec)
+	var requeue time.Duration
+	for _, a := range agents {
+		agentRequeue, err := r.agentCronJobs(ctx, a, obj, result)
+		if err != nil {
+			return nil, 0, err
+		}
+		if agentRequeue > 0 && (requeue == 0 || agentRequeue < requeue) {
+			requeue = agentRequeue
+		}
+	}
+
+	return result, requeue, nil
+}
+
+// agentCronJobs adds the spec of the backup CronJobs of an agent of the
+// workload to the given ones, and returns the delay after which the blackout
+// windows of its schedule should be checked again.
+func (r *CronJobReconciler) agentCronJobs(ctx context.Context, a *backupAgent, obj client.Object, result map[string]batchv1.CronJobSpec) (time.Duration, error) {
+	window, requeue, err := activeBlackout(ctx, r.Client, a.schedule, r.now())
+	if err != nil {
+		return 0, err
+	}
+	if window != nil {
+		log.FromContext(ctx).Info("suspending the backup cronjobs", "schedule", a.schedule.Name, "reason", window.Reason, "until", window.End)
+	}
+
+	add := func(name string, spec batchv1.CronJobSpec) {
+		if window != nil {
+			spec.Suspend = ptr.To(true)
+		}
+		result[cronJobName(obj.GetName(), a.key(name))] = spec
+	}
+
+	// Each maintenance task with its own schedule gets its own CronJob
+	if m := a.schedule.Spec.Maintenance; m != nil {
+		for _, task := range []struct{ name, schedule string }{{"forget", m.Forget}, {"prune", m.Prune}, {"check", m.Check}} {
+			if task.schedule == "" {
+				continue
+			}
+			spec := cronJobSpec(a, a.maintenanceContainer(task.name), nil)
+			spec.Schedule = task.schedule
+			add("maintenance-"+task.name, spec)
+		}
+	}
+
+	if len(a.volumes) == 0 {
+		add("", cronJobSpec(a, a.container, nil))
+		return requeue, nil
+	}
+
+	// Each volume gets its own CronJob, per replica for the claim templates
+	volumes, err := a.backupVolumes()
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range volumes {
+		add(v.key, cronJobSpec(a, a.backupContainer(v), []corev1.Volume{v.volume}))
+	}
+
+	return requeue, nil
+}
+
+// cronJobSpec builds the spec of a backup CronJob running the agent container
+// once with the given volumes on the schedule's cron.
+func cronJobSpec(a *backupAgent, container corev1.Container, volumes []corev1.Volume) batchv1.CronJobSpec {
+	spec := batchv1.CronJobSpec{
+		Schedule:          a.schedule.Spec.Schedule,
+		ConcurrencyPolicy: batchv1.ForbidConcurrent,
+		JobTemplate: batchv1.JobTemplateSpec{
+			Spec: jobSpec(a, constants.CronJobLabel, container, volumes),
+		},
+	}
+	if a.schedule.Spec.TimeZone != "" {
+		spec.TimeZone = ptr.To(a.schedule.Spec.TimeZone)
+	}
+	if deadline := a.schedule.Spec.StartingDeadline; deadline != nil && deadline.Duration > 0 {
+		spec.StartingDeadlineSeconds = ptr.To(int64(deadline.Seconds()))
+	}
+	return spec
+}
+
+// specHash returns a short hash of the given spec.
+func specHash(spec any) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("error while marshaling the spec: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16], nil
+}
+
+// specMatches tells whether the live spec holds every field set in the
+// desired one, ignoring the fields defaulted by the API server.
+func specMatches(desired, live any) (bool, error) {
+	var values [2]any
+	for i, spec := range []any{desired, live} {
+		data, err := json.Marshal(spec)
+		if err != nil {
+			return false, fmt.Errorf("error while marshaling the spec: %w", err)
+		}
+		if err := json.Unmarshal(data, &values[i]); err != nil {
+			return false, fmt.Errorf("error while unmarshaling the spec: %w", err)
+		}
+	}
+	return subset(values[0], values[1]), nil
+}
+
+// subset tells whether the JSON value a is contained in b: the objects of b
+// may have more fields, while the lists must have the same length.
+func subset(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, v := range a {
+			if !subset(v, b[k]) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !subset(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// cronJobName returns the name of the backup CronJob of a workload volume,
+// shortened with a hash suffix when it would exceed the CronJob name limit.
+func cronJobName(workloadName, volumeName string) string {
+	name := workloadName + "-backup"
+	if volumeName != "" {
+		name = workloadName + "-" + volumeName + "-backup"
+	}
+
+	if len(name) <= maxCronJobNameLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(sum[:])[:8]
+	return strings.TrimRight(name[:maxCronJobNameLength-len(suffix)], "-.") + suffix
+}
@@ -0,0 +1,367 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+var _ = Describe("CronJob Controller", func() {
+	var (
+		policy     *v1alpha1.Policy
+		schedule   *v1alpha1.Schedule
+		deployment *appsv1.Deployment
+		k8sClient  client.Client
+		reconciler *CronJobReconciler
+	)
+
+	deploymentWorkload := workloads[0]
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+
+	BeforeEach(func() {
+		policy = &v1alpha1.Policy{
+			ObjectMeta: metav1.ObjectMeta{Name: "nfs"},
+			Spec: v1alpha1.PolicySpec{
+				Image:                  v1alpha1.Image{Name: "agent", Tag: "1.0"},
+				Mode:                   v1alpha1.PolicyModeCronJob,
+				AutoDetectVolumeMounts: true,
+			},
+		}
+		schedule = &v1alpha1.Schedule{
+			ObjectMeta: metav1.ObjectMeta{Name: "daily"},
+			Spec:       v1alpha1.ScheduleSpec{Schedule: "0 3 * * *"},
+		}
+		deployment = &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", UID: "uid"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{
+							constants.PolicyAnnotation:   "nfs",
+							constants.ScheduleAnnotation: "daily",
+						},
+					},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{
+							Name: "app",
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "data", MountPath: "/data"},
+								{Name: "media", MountPath: "/media"},
+							},
+						}},
+						Volumes: []corev1.Volume{
+							{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+							{Name: "media", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "media"}}},
+						},
+					},
+				},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy, schedule, deployment).Build()
+		reconciler = &CronJobReconciler{Client: k8sClient, Scheme: scheme, Renderer: &agent.Renderer{Client: k8sClient}}
+	})
+
+	listCronJobs := func() []batchv1.CronJob {
+		var cronJobs batchv1.CronJobList
+		Expect(k8sClient.List(ctx, &cronJobs, client.InNamespace("default"))).To(Succeed())
+		return cronJobs.Items
+	}
+
+	Context("When the policy runs in CronJob mode", func() {
+		It("Should create one run-once CronJob per volume on the schedule's cron", func() {
+			_, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			cronJobs := listCronJobs()
+			Expect(cronJobs).To(HaveLen(2))
+
+			for _, cronJob := range cronJobs {
+				Expect(cronJob.Spec.Schedule).To(Equal("0 3 * * *"))
+				Expect(metav1.IsControlledBy(&cronJob, deployment)).To(BeTrue())
+
+				spec := cronJob.Spec.JobTemplate.Spec.Template.Spec
+				Expect(spec.Volumes).To(HaveLen(1))
+				Expect(spec.Containers).To(HaveLen(1))
+				Expect(spec.Containers[0].VolumeMounts).To(HaveLen(1))
+				Expect(spec.Containers[0].Env).To(ContainElements(
+					corev1.EnvVar{Name: "BC_BACKUP_DIR", Value: spec.Containers[0].VolumeMounts[0].MountPath},
+					corev1.EnvVar{Name: "BC_RUN_ONCE", Value: "true"},
+				))
+			}
+		})
+
+		It("Should repair a CronJob edited by hand", func() {
+			_, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			var cronJob batchv1.CronJob
+			key := client.ObjectKey{Namespace: "default", Name: "app-data-backup"}
+			Expect(k8sClient.Get(ctx, key, &cronJob)).To(Succeed())
+
+			By("leaving the fields defaulted by the API server alone")
+			cronJob.Spec.SuccessfulJobsHistoryLimit = ptr.To[int32](3)
+			cronJob.Spec.JobTemplate.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirst
+			Expect(k8sClient.Update(ctx, &cronJob)).To(Succeed())
+			version := cronJob.ResourceVersion
+
+			_, err = reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, key, &cronJob)).To(Succeed())
+			Expect(cronJob.ResourceVersion).To(Equal(version))
+
+			By("restoring the fields it sets")
+			cronJob.Spec.Schedule = "* * * * *"
+			cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Image = "busybox"
+			Expect(k8sClient.Update(ctx, &cronJob)).To(Succeed())
+
+			_, err = reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, key, &cronJob)).To(Succeed())
+			Expect(cronJob.Spec.Schedule).To(Equal("0 3 * * *"))
+			Expect(cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Image).To(Equal("agent:1.0"))
+		})
+
+		It("Should run the Jobs on the nodes the workload is constrained to", func() {
+			nodeAffinity := &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+						MatchExpressions: []corev1.NodeSelectorRequirement{{
+							Key:      "topology.kubernetes.io/zone",
+							Operator: corev1.NodeSelectorOpIn,
+							Values:   []string{"a"},
+						}},
+					}},
+				},
+			}
+			deployment.Spec.Template.Spec.NodeSelector = map[string]string{"storage": "local"}
+			deployment.Spec.Template.Spec.Tolerations = []corev1.Toleration{{Key: "storage", Operator: corev1.TolerationOpExists}}
+			deployment.Spec.Template.Spec.Affinity = &corev1.Affinity{
+				NodeAffinity:    nodeAffinity,
+				PodAntiAffinity: &corev1.PodAntiAffinity{},
+			}
+			Expect(k8sClient.Update(ctx, deployment)).To(Succeed())
+
+			_, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, cronJob := range listCronJobs() {
+				spec := cronJob.Spec.JobTemplate.Spec.Template.Spec
+				Expect(spec.NodeSelector).To(Equal(map[string]string{"storage": "local"}))
+				Expect(spec.Tolerations).To(HaveLen(1))
+				Expect(spec.Affinity).To(Equal(&corev1.Affinity{NodeAffinity: nodeAffinity}))
+			}
+		})
+
+		It("Should carry the time zone, the jitter and the starting deadline of the schedule", func() {
+			schedule.Spec.TimeZone = "Europe/Paris"
+			schedule.Spec.Jitter = &metav1.Duration{Duration: 15 * time.Minute}
+			schedule.Spec.StartingDeadline = &metav1.Duration{Duration: time.Hour}
+			Expect(k8sClient.Update(ctx, schedule)).To(Succeed())
+
+			_, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, cronJob := range listCronJobs() {
+				Expect(cronJob.Spec.TimeZone).To(HaveValue(Equal("Europe/Paris")))
+				Expect(cronJob.Spec.StartingDeadlineSeconds).To(HaveValue(BeEquivalentTo(3600)))
+				Expect(cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Env).To(ContainElements(
+					corev1.EnvVar{Name: "BC_SCHEDULE_TIMEZONE", Value: "Europe/Paris"},
+					corev1.EnvVar{Name: "BC_SCHEDULE_JITTER", Value: "900"},
+					corev1.EnvVar{Name: "BC_SCHEDULE_STARTING_DEADLINE", Value: "3600"},
+				))
+			}
+		})
+
+		It("Should carry the catch-up policy of the schedule", func() {
+			schedule.Spec.CatchUp = &v1alpha1.CatchUp{
+				Policy:      v1alpha1.CatchUpPolicyImmediate,
+				GracePeriod: &metav1.Duration{Duration: 30 * time.Minute},
+			}
+			Expect(k8sClient.Update(ctx, schedule)).To(Succeed())
+
+			_, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, cronJob := range listCronJobs() {
+				Expect(cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Env).To(ContainElements(
+					corev1.EnvVar{Name: "BC_CATCHUP_POLICY", Value: "Immediate"},
+					corev1.EnvVar{Name: "BC_CATCHUP_GRACE_PERIOD", Value: "1800"},
+				))
+			}
+		})
+
+		It("Should run the maintenance tasks with their own schedule in their own CronJob", func() {
+			schedule.Spec.Maintenance = &v1alpha1.Maintenance{Prune: "0 5 * * 0", CheckReadDataSubset: "5%"}
+			Expect(k8sClient.Update(ctx, schedule)).To(Succeed())
+
+			_, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			cronJobs := listCronJobs()
+			Expect(cronJobs).To(HaveLen(3))
+
+			var prune *batchv1.CronJob
+			for i := range cronJobs {
+				if cronJobs[i].Name == cronJobName("app", "maintenance-prune") {
+					prune = &cronJobs[i]
+				}
+			}
+			Expect(prune).NotTo(BeNil())
+			Expect(prune.Spec.Schedule).To(Equal("0 5 * * 0"))
+
+			spec := prune.Spec.JobTemplate.Spec.Template.Spec
+			Expect(spec.Volumes).To(BeEmpty())
+			Expect(spec.Containers[0].VolumeMounts).To(BeEmpty())
+			Expect(spec.Containers[0].Env).To(ContainElements(
+				corev1.EnvVar{Name: "BC_MAINTENANCE_TASK", Value: "prune"},
+				corev1.EnvVar{Name: "BC_PRUNE_SCHEDULE", Value: "0 5 * * 0"},
+				corev1.EnvVar{Name: "BC_CHECK_READ_DATA_SUBSET", Value: "5%"},
+			))
+		})
+
+		It("Should suspend the CronJobs during a freeze", func() {
+			Expect(k8sClient.Create(ctx, &v1alpha1.BackupFreeze{
+				ObjectMeta: metav1.ObjectMeta{Name: "migration"},
+				Spec:       v1alpha1.BackupFreezeSpec{End: &metav1.Time{Time: time.Now().Add(time.Hour)}},
+			})).To(Succeed())
+
+			result, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically("~", time.Hour, time.Minute))
+
+			for _, cronJob := range listCronJobs() {
+				Expect(cronJob.Spec.Suspend).To(HaveValue(BeTrue()))
+			}
+		})
+
+		It("Should create the CronJobs of each pair, named after it", func() {
+			offsite := &v1alpha1.Policy{
+				ObjectMeta: metav1.ObjectMeta{Name: "offsite"},
+				Spec:       *policy.Spec.DeepCopy(),
+			}
+			offsite.Spec.Environment = []corev1.EnvVar{{Name: "RESTIC_REPOSITORY", Value: "s3:offsite"}}
+			Expect(k8sClient.Create(ctx, offsite)).To(Succeed())
+			Expect(k8sClient.Create(ctx, &v1alpha1.Schedule{
+				ObjectMeta: metav1.ObjectMeta{Name: "weekly"},
+				Spec:       v1alpha1.ScheduleSpec{Schedule: "0 4 * * 0"},
+			})).To(Succeed())
+
+			deployment.Spec.Template.Annotations = map[string]string{
+				constants.BackupsAnnotation: "nfs@daily,offsite@weekly",
+			}
+			Expect(k8sClient.Update(ctx, deployment)).To(Succeed())
+
+			_, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			schedules := make(map[string]string)
+			for _, cronJob := range listCronJobs() {
+				Expect(metav1.IsControlledBy(&cronJob, deployment)).To(BeTrue())
+				schedules[cronJob.Name] = cronJob.Spec.Schedule
+			}
+			Expect(schedules).To(Equal(map[string]string{
+				"app-nfs-daily-data-backup":       "0 3 * * *",
+				"app-nfs-daily-media-backup":      "0 3 * * *",
+				"app-offsite-weekly-data-backup":  "0 4 * * 0",
+				"app-offsite-weekly-media-backup": "0 4 * * 0",
+			}))
+		})
+
+		It("Should delete the CronJobs when the policy switches back to Sidecar", func() {
+			_, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listCronJobs()).To(HaveLen(2))
+
+			policy.Spec.Mode = v1alpha1.PolicyModeSidecar
+			Expect(k8sClient.Update(ctx, policy)).To(Succeed())
+
+			_, err = reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listCronJobs()).To(BeEmpty())
+		})
+	})
+
+	Context("When the volumes come from the claim templates of a StatefulSet", func() {
+		It("Should create one CronJob per replica claim, tagged with the replica", func() {
+			statefulSet := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db", UID: "uid"},
+				Spec: appsv1.StatefulSetSpec{
+					Replicas: ptr.To[int32](2),
+					Template: deployment.Spec.Template,
+					VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+						{ObjectMeta: metav1.ObjectMeta{Name: "data"}},
+						{ObjectMeta: metav1.ObjectMeta{Name: "media"}},
+					},
+				},
+			}
+			statefulSet.Spec.Template.Spec.Volumes = nil
+			Expect(k8sClient.Create(ctx, statefulSet)).To(Succeed())
+
+			_, err := reconciler.reconcile(ctx, workloads[1], ctrl.Request{NamespacedName: client.ObjectKeyFromObject(statefulSet)})
+			Expect(err).NotTo(HaveOccurred())
+
+			claims := make(map[string]string)
+			for _, cronJob := range listCronJobs() {
+				Expect(metav1.IsControlledBy(&cronJob, statefulSet)).To(BeTrue())
+
+				spec := cronJob.Spec.JobTemplate.Spec.Template.Spec
+				Expect(spec.Volumes).To(HaveLen(1))
+				Expect(spec.Volumes[0].PersistentVolumeClaim).NotTo(BeNil())
+				for _, e := range spec.Containers[0].Env {
+					if e.Name == agent.ReplicaTagEnv {
+						claims[cronJob.Name] = spec.Volumes[0].PersistentVolumeClaim.ClaimName + "@" + e.Value
+					}
+				}
+			}
+			Expect(claims).To(Equal(map[string]string{
+				"db-data-0-backup":  "data-db-0@db-0",
+				"db-data-1-backup":  "data-db-1@db-1",
+				"db-media-0-backup": "media-db-0@db-0",
+				"db-media-1-backup": "media-db-1@db-1",
+			}))
+		})
+	})
+
+	Context("When naming the CronJobs", func() {
+		It("Should keep the names within the CronJob limit", func() {
+			name := cronJobName(strings.Repeat("a", 40), strings.Repeat("b", 40))
+			Expect(len(name)).To(BeNumerically("<=", maxCronJobNameLength))
+			Expect(name).To(Equal(cronJobName(strings.Repeat("a", 40), strings.Repeat("b", 40))))
+		})
+	})
+})
@@ -0,0 +1,266 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+// HooksReconciler maintains the hooks ServiceAccount, its token Secret, Role
+// and RoleBinding in the namespaces holding mutated pods whose policies have
+// hooks: the Role allows running commands in these pods only, and is bound to
+// the hooks ServiceAccount, whose token is mounted in the agents only, so that
+// the containers of the application do not get to exec into their peers. They
+// are all deleted once the namespace has no such pod left.
+//
+// The Role lists the names of the pods, which are only known once they are
+// created: the first hooks of a new pod may be denied until the Role is
+// updated, which the agents retry.
+type HooksReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
+// +kubebuilder:rbac:groups=core,resources=serviceaccounts;secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=backup-controller.rclsilver-org.github.com,resources=policies,verbs=get;list;watch
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HooksReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isHooksObject := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == constants.HooksRoleName
+	})
+	isMutatedPod := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetLabels()[constants.MutatedLabel] == "true"
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("backup-hooks").
+		For(&rbacv1.Role{}, builder.WithPredicates(isHooksObject)).
+		Watches(&rbacv1.RoleBinding{}, handler.EnqueueRequestsFromMapFunc(hooksRequest), builder.WithPredicates(isHooksObject)).
+		Watches(&corev1.ServiceAccount{}, handler.EnqueueRequestsFromMapFunc(hooksRequest), builder.WithPredicates(isHooksObject)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(hooksRequest), builder.WithPredicates(isHooksObject)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(hooksRequest), builder.WithPredicates(isMutatedPod)).
+		Watches(&v1alpha1.Policy{}, handler.EnqueueRequestsFromMapFunc(r.hooksRequests)).
+		Complete(r)
+}
+
+// hooksRequest maps an object to the hooks Role of its namespace.
+func hooksRequest(_ context.Context, obj client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Namespace: obj.GetNamespace(),
+		Name:      constants.HooksRoleName,
+	}}}
+}
+
+// hooksRequests maps a policy to the hooks Roles of the namespaces holding
+// mutated pods using it.
+func (r *HooksReconciler) hooksRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.MatchingLabels{constants.MutatedLabel: "true"}); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list the mutated pods")
+		return nil
+	}
+
+	namespaces := make(map[string]bool)
+	var requests []reconcile.Request
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if namespaces[pod.Namespace] {
+			continue
+		}
+		backups, err := agent.ParseBackups(pod.Annotations)
+		if err != nil {
+			continue
+		}
+		for _, b := range backups {
+			if b.Policy == obj.GetName() {
+				namespaces[pod.Namespace] = true
+				requests = append(requests, hooksRequest(ctx, pod)...)
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// Reconcile updates the hooks ServiceAccount, Secret, Role and RoleBinding of
+// a namespace.
+func (r *HooksReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	pods, err := r.hookedPods(ctx, req.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	meta := metav1.ObjectMeta{Name: req.Name, Namespace: req.Namespace}
+	account := &corev1.ServiceAccount{ObjectMeta: meta}
+	secret := &corev1.Secret{ObjectMeta: *meta.DeepCopy()}
+	role := &rbacv1.Role{ObjectMeta: *meta.DeepCopy()}
+	binding := &rbacv1.RoleBinding{ObjectMeta: *meta.DeepCopy()}
+
+	if len(pods) == 0 {
+		for _, obj := range []client.Object{binding, role, secret, account} {
+			if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			} else if err == nil {
+				log.Info("deleted the hooks object", "kind", fmt.Sprintf("%T", obj))
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, account, func() error {
+		// The agents get the token from the Secret, not from the pods
+		account.AutomountServiceAccountToken = ptr.To(false)
+		return nil
+	})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error while reconciling the hooks service account: %w", err)
+	}
+	if result != controllerutil.OperationResultNone {
+		log.Info("reconciled the hooks service account", "operation", result)
+	}
+
+	// The token of the ServiceAccount is filled in the Secret by Kubernetes,
+	// and revoked along with it
+	result, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string, 1)
+		}
+		secret.Annotations[corev1.ServiceAccountNameKey] = account.Name
+		secret.Type = corev1.SecretTypeServiceAccountToken
+		return nil
+	})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error while reconciling the hooks token secret: %w", err)
+	}
+	if result != controllerutil.OperationResultNone {
+		log.Info("reconciled the hooks token secret", "operation", result)
+	}
+
+	result, err = controllerutil.CreateOrUpdate(ctx, r.Client, role, func() error {
+		role.Rules = []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}, ResourceNames: pods},
+			{APIGroups: []string{""}, Resources: []string{"pods/exec"}, Verbs: []string{"create"}, ResourceNames: pods},
+		}
+		return nil
+	})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error while reconciling the hooks role: %w", err)
+	}
+	if result != controllerutil.OperationResultNone {
+		log.Info("reconciled the hooks role", "operation", result)
+	}
+
+	result, err = controllerutil.CreateOrUpdate(ctx, r.Client, binding, func() error {
+		binding.RoleRef = rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		}
+		binding.Subjects = []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      account.Name,
+			Namespace: req.Namespace,
+		}}
+		return nil
+	})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error while reconciling the hooks role binding: %w", err)
+	}
+	if result != controllerutil.OperationResultNone {
+		log.Info("reconciled the hooks role binding", "operation", result)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// hookedPods returns the names of the mutated pods of the namespace whose
+// policies have hooks, sorted.
+func (r *HooksReconciler) hookedPods(ctx context.Context, namespace string) ([]string, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(namespace), client.MatchingLabels{constants.MutatedLabel: "true"}); err != nil {
+		return nil, fmt.Errorf("error while listing the mutated pods: %w", err)
+	}
+
+	policies := make(map[string]*v1alpha1.Policy)
+	names := make(map[string]bool)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+
+		// An invalid annotation would not have been mutated
+		backups, _ := agent.ParseBackups(pod.Annotations)
+		for _, b := range backups {
+			policy, ok := policies[b.Policy]
+			if !ok {
+				policy = &v1alpha1.Policy{}
+				if err := r.Get(ctx, client.ObjectKey{Name: b.Policy}, policy); err != nil {
+					if !apierrors.IsNotFound(err) {
+						return nil, fmt.Errorf("error while fetching the policy %q: %w", b.Policy, err)
+					}
+					policy = nil
+				}
+				policies[b.Policy] = policy
+			}
+			if !hasHooks(policy) {
+				continue
+			}
+
+			names[pod.Name] = true
+		}
+	}
+
+	return slices.Sorted(maps.Keys(names)), nil
+}
+
+// hasHooks tells whether the agents of the policy run hooks.
+func hasHooks(policy *v1alpha1.Policy) bool {
+	if policy == nil || policy.Spec.Hooks == nil {
+		return false
+	}
+	if policy.Spec.Mode != "" && policy.Spec.Mode != v1alpha1.PolicyModeSidecar {
+		return false
+	}
+	return len(policy.Spec.Hooks.Pre)+len(policy.Spec.Hooks.Post) > 0
+}
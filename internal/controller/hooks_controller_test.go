@@ -0,0 +1,133 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+var _ = Describe("Hooks Controller", func() {
+	var (
+		k8sClient  client.Client
+		reconciler *HooksReconciler
+	)
+
+	key := types.NamespacedName{Namespace: "default", Name: constants.HooksRoleName}
+
+	mutatedPod := func(name, account, backups string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        name,
+				Labels:      map[string]string{constants.MutatedLabel: "true"},
+				Annotations: map[string]string{constants.BackupsAnnotation: backups},
+			},
+			Spec: corev1.PodSpec{ServiceAccountName: account},
+		}
+	}
+
+	BeforeEach(func() {
+		hooked := &v1alpha1.Policy{
+			ObjectMeta: metav1.ObjectMeta{Name: "hooked"},
+			Spec: v1alpha1.PolicySpec{
+				Hooks: &v1alpha1.Hooks{
+					Pre: []v1alpha1.Hook{{Name: "flush", Container: "app", Command: []string{"sync"}}},
+				},
+			},
+		}
+		plain := &v1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Name: "plain"}}
+
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			hooked, plain,
+			mutatedPod("db", "database", "hooked@daily"),
+			mutatedPod("web", "", "plain@daily,hooked@weekly"),
+			mutatedPod("static", "static", "plain@daily"),
+		).Build()
+		reconciler = &HooksReconciler{Client: k8sClient, Scheme: scheme}
+	})
+
+	It("Should allow the hooks ServiceAccount to exec into the hooked pods", func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		var role rbacv1.Role
+		Expect(k8sClient.Get(ctx, key, &role)).To(Succeed())
+		Expect(role.Rules).To(HaveLen(2))
+		Expect(role.Rules[0].Resources).To(Equal([]string{"pods"}))
+		Expect(role.Rules[1].Resources).To(Equal([]string{"pods/exec"}))
+		for _, rule := range role.Rules {
+			Expect(rule.ResourceNames).To(Equal([]string{"db", "web"}))
+		}
+
+		var binding rbacv1.RoleBinding
+		Expect(k8sClient.Get(ctx, key, &binding)).To(Succeed())
+		Expect(binding.RoleRef.Name).To(Equal(constants.HooksRoleName))
+		Expect(binding.Subjects).To(ConsistOf(rbacv1.Subject{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      constants.HooksRoleName,
+			Namespace: "default",
+		}))
+
+		By("creating the ServiceAccount and its token Secret")
+		var account corev1.ServiceAccount
+		Expect(k8sClient.Get(ctx, key, &account)).To(Succeed())
+		Expect(account.AutomountServiceAccountToken).To(HaveValue(BeFalse()))
+		var secret corev1.Secret
+		Expect(k8sClient.Get(ctx, key, &secret)).To(Succeed())
+		Expect(secret.Type).To(Equal(corev1.SecretTypeServiceAccountToken))
+		Expect(secret.Annotations).To(HaveKeyWithValue(corev1.ServiceAccountNameKey, constants.HooksRoleName))
+
+		By("mapping a policy to the namespaces of its pods")
+		Expect(reconciler.hooksRequests(ctx, &v1alpha1.Policy{ObjectMeta: metav1.ObjectMeta{Name: "hooked"}})).
+			To(ConsistOf(ctrl.Request{NamespacedName: key}))
+	})
+
+	It("Should delete the hooks objects once no hooked pod is left", func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		var policy v1alpha1.Policy
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "hooked"}, &policy)).To(Succeed())
+		policy.Spec.Hooks = nil
+		Expect(k8sClient.Update(ctx, &policy)).To(Succeed())
+
+		_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = k8sClient.Get(ctx, key, &rbacv1.Role{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		err = k8sClient.Get(ctx, key, &rbacv1.RoleBinding{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		err = k8sClient.Get(ctx, key, &corev1.ServiceAccount{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		err = k8sClient.Get(ctx, key, &corev1.Secret{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,284 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/blackout"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+// blackoutsHorizon is how far ahead the blackout windows are published in
+// the schedules ConfigMaps. They are refreshed before reaching it.
+const blackoutsHorizon = 24 * time.Hour
+
+// SchedulesReconciler maintains the schedules ConfigMap of the namespaces
+// holding mutated pods: it maps the name of each schedule their agents use to
+// its cron expression. The agents mount it and reload their crontab when it
+// changes, so updating a Schedule does not require restarting the pods. The
+// ConfigMap is deleted once the namespace has no mutated pod left.
+//
+// The ConfigMap also holds, under the "<schedule>.blackouts" keys, the blackout
+// windows and the freezes in effect within the next day, one per line as
+// "<start> <end> <reason>" with the times in seconds since the epoch, and under
+// the "<schedule>.env" and "<policy>.policy.env" keys the other settings of the
+// schedules and of the policies the agents reload, as "NAME=value" lines.
+type SchedulesReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Now returns the current time (optional, used by the tests).
+	Now func() time.Time
+}
+
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=backup-controller.rclsilver-org.github.com,resources=policies;schedules;backupfreezes,verbs=get;list;watch
+
+// SetupWithManager sets up the controller with the Manager. The cache of the
+// manager only holds the schedules ConfigMaps and the mutated pods (see
+// CacheOptions).
+func (r *SchedulesReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isSchedulesConfigMap := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == constants.SchedulesConfigMapName
+	})
+	isMutatedPod := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetLabels()[constants.MutatedLabel] == "true"
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("backup-schedules").
+		For(&corev1.ConfigMap{}, builder.WithPredicates(isSchedulesConfigMap)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(schedulesRequest), builder.WithPredicates(isMutatedPod)).
+		Watches(&v1alpha1.Policy{}, handler.EnqueueRequestsFromMapFunc(r.schedulesRequests)).
+		Watches(&v1alpha1.Schedule{}, handler.EnqueueRequestsFromMapFunc(r.schedulesRequests)).
+		Watches(&v1alpha1.BackupFreeze{}, handler.EnqueueRequestsFromMapFunc(r.schedulesRequests)).
+		Complete(r)
+}
+
+// schedulesRequest maps a pod to the schedules ConfigMap of its namespace.
+func schedulesRequest(_ context.Context, obj client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Namespace: obj.GetNamespace(),
+		Name:      constants.SchedulesConfigMapName,
+	}}}
+}
+
+// schedulesRequests maps a schedule or a policy to the schedules ConfigMaps of
+// the namespaces holding mutated pods using it, and a freeze to all of them.
+func (r *SchedulesReconciler) schedulesRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	_, freeze := obj.(*v1alpha1.BackupFreeze)
+	name := func(b agent.Backup) string { return b.Schedule }
+	if _, ok := obj.(*v1alpha1.Policy); ok {
+		name = func(b agent.Backup) string { return b.Policy }
+	}
+
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.MatchingLabels{constants.MutatedLabel: "true"}); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list the mutated pods")
+		return nil
+	}
+
+	namespaces := make(map[string]bool)
+	var requests []reconcile.Request
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if namespaces[pod.Namespace] {
+			continue
+		}
+		backups, err := agent.ParseBackups(pod.Annotations)
+		if err != nil {
+			continue
+		}
+		for _, b := range backups {
+			if freeze || name(b) == obj.GetName() {
+				namespaces[pod.Namespace] = true
+				requests = append(requests, schedulesRequest(ctx, pod)...)
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// Reconcile updates the schedules ConfigMap of a namespace.
+func (r *SchedulesReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	schedules, policies, err := r.used(ctx, req.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      req.Name,
+			Namespace: req.Namespace,
+		},
+	}
+
+	if len(schedules) == 0 {
+		if err := r.Delete(ctx, configMap); err != nil {
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		log.Info("deleted the schedules configmap")
+		return ctrl.Result{}, nil
+	}
+
+	var freezes v1alpha1.BackupFreezeList
+	if err := r.List(ctx, &freezes); err != nil {
+		return ctrl.Result{}, fmt.Errorf("error while listing the backup freezes: %w", err)
+	}
+
+	now := r.now()
+	horizon := now.Add(blackoutsHorizon)
+	// Refresh the windows before reaching the horizon
+	requeue := now.Add(blackoutsHorizon / 2)
+
+	data := make(map[string]string, 3*len(schedules)+len(policies))
+	for _, policy := range policies {
+		data[agent.PolicySettingsKey(policy.Name)] = agent.PolicySettings(policy.Spec)
+	}
+	for _, schedule := range schedules {
+		data[schedule.Name] = schedule.Spec.Schedule
+		data[agent.ScheduleSettingsKey(schedule.Name)] = agent.ScheduleSettings(schedule.Spec)
+
+		windows, err := blackout.Windows(schedule.Spec, freezes.Items, now, horizon)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error while computing the blackout windows of the schedule %q: %w", schedule.Name, err)
+		}
+		if len(windows) == 0 {
+			continue
+		}
+
+		var lines []string
+		for _, w := range windows {
+			lines = append(lines, fmt.Sprintf("%d %d %s", w.Start.Unix(), w.End.Unix(), w.Reason))
+		}
+		data[schedule.Name+".blackouts"] = strings.Join(lines, "\n") + "\n"
+
+		if next, ok := blackout.NextChange(windows, now); ok && next.Before(requeue) {
+			requeue = next
+		}
+	}
+
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		configMap.Data = data
+		return nil
+	})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error while reconciling the schedules configmap: %w", err)
+	}
+	if result != controllerutil.OperationResultNone {
+		log.Info("reconciled the schedules configmap", "operation", result)
+	}
+
+	return ctrl.Result{RequeueAfter: requeue.Sub(now)}, nil
+}
+
+func (r *SchedulesReconciler) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+// used returns the schedules and the policies used by the mutated pods of the
+// namespace, sorted by name.
+func (r *SchedulesReconciler) used(ctx context.Context, namespace string) ([]v1alpha1.Schedule, []v1alpha1.Policy, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(namespace), client.MatchingLabels{constants.MutatedLabel: "true"}); err != nil {
+		return nil, nil, fmt.Errorf("error while listing the mutated pods: %w", err)
+	}
+
+	scheduleNames := make(map[string]bool)
+	policyNames := make(map[string]bool)
+	for i := range pods.Items {
+		// An invalid annotation would not have been mutated
+		backups, _ := agent.ParseBackups(pods.Items[i].Annotations)
+		for _, b := range backups {
+			scheduleNames[b.Schedule] = true
+			policyNames[b.Policy] = true
+		}
+	}
+
+	var schedules []v1alpha1.Schedule
+	for _, name := range slices.Sorted(maps.Keys(scheduleNames)) {
+		var schedule v1alpha1.Schedule
+		if err := r.Get(ctx, client.ObjectKey{Name: name}, &schedule); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, nil, fmt.Errorf("error while fetching the schedule %q: %w", name, err)
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	var policies []v1alpha1.Policy
+	for _, name := range slices.Sorted(maps.Keys(policyNames)) {
+		var policy v1alpha1.Policy
+		if err := r.Get(ctx, client.ObjectKey{Name: name}, &policy); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, nil, fmt.Errorf("error while fetching the policy %q: %w", name, err)
+		}
+		policies = append(policies, policy)
+	}
+
+	return schedules, policies, nil
+}
+
+// CacheOptions restricts the cache of the manager to the ConfigMaps, pods,
+// ServiceAccounts and Secrets the controllers read: the schedules ConfigMaps,
+// the mutated pods, and the hooks ServiceAccounts and their token Secrets.
+func CacheOptions() cache.Options {
+	hooks := fields.OneTermEqualSelector("metadata.name", constants.HooksRoleName)
+	return cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.ConfigMap{}:      {Field: fields.OneTermEqualSelector("metadata.name", constants.SchedulesConfigMapName)},
+			&corev1.Pod{}:            {Label: labels.SelectorFromSet(labels.Set{constants.MutatedLabel: "true"})},
+			&corev1.ServiceAccount{}: {Field: hooks},
+			&corev1.Secret{}:         {Field: hooks},
+		},
+	}
+}
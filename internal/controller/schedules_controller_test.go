@@ -0,0 +1,141 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+var _ = Describe("Schedules Controller", func() {
+	var (
+		pod        *corev1.Pod
+		k8sClient  client.Client
+		reconciler *SchedulesReconciler
+	)
+
+	key := types.NamespacedName{Namespace: "default", Name: constants.SchedulesConfigMapName}
+
+	BeforeEach(func() {
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "app",
+				Labels:    map[string]string{constants.MutatedLabel: "true"},
+				Annotations: map[string]string{
+					constants.BackupsAnnotation: "onsite@daily,offsite@weekly",
+				},
+			},
+		}
+		daily := &v1alpha1.Schedule{
+			ObjectMeta: metav1.ObjectMeta{Name: "daily"},
+			Spec:       v1alpha1.ScheduleSpec{Schedule: "0 3 * * *"},
+		}
+		weekly := &v1alpha1.Schedule{
+			ObjectMeta: metav1.ObjectMeta{Name: "weekly"},
+			Spec:       v1alpha1.ScheduleSpec{Schedule: "0 4 * * 0"},
+		}
+
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod, daily, weekly).Build()
+		reconciler = &SchedulesReconciler{Client: k8sClient, Scheme: scheme}
+	})
+
+	It("Should map the schedules used by the mutated pods to their cron expression", func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		var configMap corev1.ConfigMap
+		Expect(k8sClient.Get(ctx, key, &configMap)).To(Succeed())
+		Expect(configMap.Data).To(HaveKeyWithValue("daily", "0 3 * * *"))
+		Expect(configMap.Data).To(HaveKeyWithValue("weekly", "0 4 * * 0"))
+
+		By("updating a schedule")
+		var daily v1alpha1.Schedule
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "daily"}, &daily)).To(Succeed())
+		daily.Spec.Schedule = "30 2 * * *"
+		Expect(k8sClient.Update(ctx, &daily)).To(Succeed())
+
+		Expect(reconciler.schedulesRequests(ctx, &daily)).To(HaveLen(1))
+		_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Get(ctx, key, &configMap)).To(Succeed())
+		Expect(configMap.Data).To(HaveKeyWithValue("daily", "30 2 * * *"))
+	})
+
+	It("Should publish the settings of the schedules and of the policies the agents reload", func() {
+		onsite := &v1alpha1.Policy{
+			ObjectMeta: metav1.ObjectMeta{Name: "onsite"},
+			Spec: v1alpha1.PolicySpec{
+				Verify: &v1alpha1.Verify{Schedule: "0 6 * * 0", SampleSize: 10},
+			},
+		}
+		Expect(k8sClient.Create(ctx, onsite)).To(Succeed())
+
+		var daily v1alpha1.Schedule
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "daily"}, &daily)).To(Succeed())
+		daily.Spec.TimeZone = "Europe/Paris"
+		daily.Spec.Jitter = &metav1.Duration{Duration: 15 * time.Minute}
+		daily.Spec.Maintenance = &v1alpha1.Maintenance{Prune: "0 5 * * 0"}
+		Expect(k8sClient.Update(ctx, &daily)).To(Succeed())
+
+		Expect(reconciler.schedulesRequests(ctx, onsite)).To(HaveLen(1))
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		var configMap corev1.ConfigMap
+		Expect(k8sClient.Get(ctx, key, &configMap)).To(Succeed())
+		Expect(strings.Split(configMap.Data["daily.env"], "\n")).To(ContainElements(
+			"BC_SCHEDULE_TIMEZONE=Europe/Paris",
+			"BC_SCHEDULE_JITTER=900",
+			"BC_PRUNE_SCHEDULE=0 5 * * 0",
+			// The settings which are not set are dropped by the agents
+			"BC_FORGET_SCHEDULE=",
+		))
+		Expect(strings.Split(configMap.Data["onsite.policy.env"], "\n")).To(ContainElements(
+			"BC_VERIFY_SCHEDULE=0 6 * * 0",
+			"BC_VERIFY_SAMPLE_SIZE=10",
+			"BC_VERIFY_COMPARE_LIVE=",
+		))
+		Expect(configMap.Data).NotTo(HaveKey("offsite.policy.env"))
+	})
+
+	It("Should delete the ConfigMap once no mutated pod is left", func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(k8sClient.Delete(ctx, pod)).To(Succeed())
+		_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = k8sClient.Get(ctx, key, &corev1.ConfigMap{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,425 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	"github.com/robfig/cron/v3"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+const (
+	// snapshotStartingDeadline is how late a run may be started after its
+	// scheduled time, e.g. after a controller restart, unless the schedule sets
+	// its own. Older runs are skipped.
+	snapshotStartingDeadline = 10 * time.Minute
+
+	// snapshotJobsHistoryLimit is the number of finished Jobs kept per volume.
+	snapshotJobsHistoryLimit = 3
+)
+
+// SnapshotReconciler runs the backups of the workloads whose pod template uses
+// a policy in Snapshot mode. On each run of the schedule's cron, it takes a
+// VolumeSnapshot of every backed up PersistentVolumeClaim, restores it to a
+// temporary claim and runs the agent once against it in a Job owned by the
+// workload. The snapshot and the claim are owned by the Job and deleted as
+// soon as it is finished.
+type SnapshotReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Renderer renders the policies against the workload pod template.
+	Renderer *agent.Renderer
+
+	// Now returns the current time (optional, defaults to time.Now).
+	Now func() time.Time
+}
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=backup-controller.rclsilver-org.github.com,resources=policies;schedules;backupfreezes,verbs=get;list;watch
+
+// SetupWithManager sets up one controller per supported workload kind with the Manager.
+func (r *SnapshotReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	for _, w := range workloads {
+		err := ctrl.NewControllerManagedBy(mgr).
+			Named(strings.ToLower(w.kind)+"-backup-snapshot").
+			For(w.object()).
+			Owns(&batchv1.Job{}).
+			Watches(&v1alpha1.Policy{}, handler.EnqueueRequestsFromMapFunc(referencing(r.Client, w, func(b agent.Backup) string { return b.Policy }))).
+			Watches(&v1alpha1.Schedule{}, handler.EnqueueRequestsFromMapFunc(referencing(r.Client, w, func(b agent.Backup) string { return b.Schedule }))).
+			Watches(&v1alpha1.BackupFreeze{}, handler.EnqueueRequestsFromMapFunc(withBackups(r.Client, w))).
+			Complete(reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+				return r.reconcile(ctx, w, req)
+			}))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SnapshotReconciler) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+func (r *SnapshotReconciler) reconcile(ctx context.Context, w workload, req ctrl.Request) (ctrl.Result, error) {
+	ctx = log.IntoContext(ctx, log.FromContext(ctx).WithValues("kind", w.kind))
+
+	obj := w.object()
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		// The Jobs of a deleted workload are garbage collected by Kubernetes,
+		// along with their snapshots and claims
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var list batchv1.JobList
+	if err := r.List(ctx, &list, client.InNamespace(obj.GetNamespace()), client.MatchingLabels{constants.SnapshotLabel: "true"}); err != nil {
+		return ctrl.Result{}, fmt.Errorf("error while listing the backup jobs: %w", err)
+	}
+
+	jobs := make(map[string][]*batchv1.Job)
+	for i := range list.Items {
+		job := &list.Items[i]
+		if metav1.IsControlledBy(job, obj) {
+			key := backupKey(job.Labels[constants.BackupLabel], job.Labels[constants.VolumeLabel])
+			jobs[key] = append(jobs[key], job)
+		}
+	}
+
+	for _, volumeJobs := range jobs {
+		if err := r.cleanup(ctx, volumeJobs); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	agents, err := resolveAgents(ctx, r.Client, r.Renderer, w, obj, v1alpha1.PolicyModeSnapshot)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var requeue time.Duration
+	for _, a := range agents {
+		agentRequeue, err := r.reconcileAgent(ctx, a, obj, jobs)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if requeue == 0 || agentRequeue < requeue {
+			requeue = agentRequeue
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// reconcileAgent runs the due backups of an agent of the workload, given the
+// Jobs of the workload indexed by key, and returns the delay until its next
+// run or the next change of its blackout windows.
+func (r *SnapshotReconciler) reconcileAgent(ctx context.Context, a *backupAgent, obj client.Object, jobs map[string][]*batchv1.Job) (time.Duration, error) {
+	log := log.FromContext(ctx).WithValues("schedule", a.schedule.Name)
+
+	expression := a.schedule.Spec.Schedule
+	if tz := a.schedule.Spec.TimeZone; tz != "" {
+		expression = "CRON_TZ=" + tz + " " + expression
+	}
+	sched, err := cron.ParseStandard(expression)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule %q: %w", expression, err)
+	}
+
+	deadline := snapshotStartingDeadline
+	if d := a.schedule.Spec.StartingDeadline; d != nil && d.Duration > 0 {
+		deadline = d.Duration
+	}
+
+	now := r.now()
+
+	// The runs due during a blackout window are deferred to its end, and
+	// skipped if the starting deadline is over by then
+	window, blackoutRequeue, err := activeBlackout(ctx, r.Client, a.schedule, now)
+	if err != nil {
+		return 0, err
+	}
+
+	volumes, err := a.backupVolumes()
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range volumes {
+		name, volume := a.key(v.key), v.volume
+		if volume.PersistentVolumeClaim == nil {
+			log.Info("skipping the volume because it is not a persistent volume claim", "volume", name)
+			continue
+		}
+
+		// Only one run per volume at a time: the running Job is completed with
+		// its snapshot and claim in case their creation was interrupted.
+		last := now.Add(-deadline)
+		var running *batchv1.Job
+		for _, job := range jobs[name] {
+			if !jobFinished(job) {
+				running = job
+			}
+			if scheduled := jobScheduledTime(job); scheduled.After(last) {
+				last = scheduled
+			}
+		}
+
+		if running != nil {
+			if err := r.provision(ctx, a, running, volume); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		scheduled := sched.Next(last)
+		if scheduled.After(now) {
+			continue
+		}
+
+		if window != nil {
+			log.Info("deferring the backup run", "volume", name, "reason", window.Reason, "until", window.End)
+			continue
+		}
+
+		if err := r.run(ctx, a, obj, v, scheduled); err != nil {
+			return 0, err
+		}
+	}
+
+	requeue := sched.Next(now).Sub(now)
+	if blackoutRequeue > 0 && blackoutRequeue < requeue {
+		requeue = blackoutRequeue
+	}
+	return requeue, nil
+}
+
+// run creates the backup Job of a volume for the given scheduled time, with
+// the snapshot and the claim it runs against.
+func (r *SnapshotReconciler) run(ctx context.Context, a *backupAgent, obj client.Object, v backupVolume, scheduled time.Time) error {
+	volume := v.volume
+	name := snapshotJobName(obj.GetName(), a.key(v.key), scheduled)
+
+	container := a.backupContainer(v)
+	for i := range container.VolumeMounts {
+		container.VolumeMounts[i].ReadOnly = true
+	}
+	// The maintenance tasks run after each backup in Snapshot mode
+	container.Env = slices.DeleteFunc(container.Env, func(e corev1.EnvVar) bool {
+		return slices.Contains([]string{"BC_FORGET_SCHEDULE", "BC_PRUNE_SCHEDULE", "BC_CHECK_SCHEDULE"}, e.Name)
+	})
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: obj.GetNamespace(),
+			Labels: map[string]string{
+				constants.SnapshotLabel: "true",
+				constants.VolumeLabel:   v.key,
+			},
+			Annotations: map[string]string{
+				constants.ScheduledTimeAnnotation: scheduled.UTC().Format(time.RFC3339),
+			},
+		},
+		Spec: jobSpec(a, constants.SnapshotLabel, container, []corev1.Volume{{
+			Name: volume.Name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: name,
+					ReadOnly:  true,
+				},
+			},
+		}}),
+	}
+	if a.suffix != "" {
+		job.Labels[constants.BackupLabel] = a.suffix
+	}
+	if err := controllerutil.SetControllerReference(obj, job, r.Scheme); err != nil {
+		return err
+	}
+
+	if err := r.Create(ctx, job); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return fmt.Errorf("error while creating the backup job %q: %w", name, err)
+	}
+	log.FromContext(ctx).Info("created the backup job", "job", name, "volume", a.key(v.key))
+
+	return r.provision(ctx, a, job, volume)
+}
+
+// provision creates the snapshot of the volume and the temporary claim the
+// backup Job runs against, unless they already exist.
+func (r *SnapshotReconciler) provision(ctx context.Context, a *backupAgent, job *batchv1.Job, volume corev1.Volume) error {
+	var source corev1.PersistentVolumeClaim
+	if err := r.Get(ctx, client.ObjectKey{Namespace: job.Namespace, Name: volume.PersistentVolumeClaim.ClaimName}, &source); err != nil {
+		return fmt.Errorf("error while fetching the claim %q: %w", volume.PersistentVolumeClaim.ClaimName, err)
+	}
+
+	var snapshotClass *string
+	storageClass := source.Spec.StorageClassName
+	if a.policy.Spec.Snapshot != nil {
+		snapshotClass = a.policy.Spec.Snapshot.VolumeSnapshotClassName
+		if a.policy.Spec.Snapshot.StorageClassName != nil {
+			storageClass = a.policy.Spec.Snapshot.StorageClassName
+		}
+	}
+
+	// The restored claim must be at least as large as the snapshot
+	size, ok := source.Status.Capacity[corev1.ResourceStorage]
+	if !ok {
+		size = source.Spec.Resources.Requests[corev1.ResourceStorage]
+	}
+
+	snapshot := &snapshotv1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name,
+			Namespace: job.Namespace,
+			Labels:    map[string]string{constants.SnapshotLabel: "true"},
+		},
+		Spec: snapshotv1.VolumeSnapshotSpec{
+			Source: snapshotv1.VolumeSnapshotSource{
+				PersistentVolumeClaimName: ptr.To(source.Name),
+			},
+			VolumeSnapshotClassName: snapshotClass,
+		},
+	}
+
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name,
+			Namespace: job.Namespace,
+			Labels:    map[string]string{constants.SnapshotLabel: "true"},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      source.Spec.AccessModes,
+			StorageClassName: storageClass,
+			VolumeMode:       source.Spec.VolumeMode,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size.DeepCopy()},
+			},
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: ptr.To(snapshotv1.SchemeGroupVersion.Group),
+				Kind:     "VolumeSnapshot",
+				Name:     snapshot.Name,
+			},
+		},
+	}
+
+	for _, o := range []client.Object{snapshot, claim} {
+		if err := controllerutil.SetControllerReference(job, o, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, o); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("error while creating the %T %q: %w", o, o.GetName(), err)
+		}
+	}
+
+	return nil
+}
+
+// cleanup deletes the snapshot and the claim of the finished Jobs of a volume,
+// and the finished Jobs beyond the history limit.
+func (r *SnapshotReconciler) cleanup(ctx context.Context, jobs []*batchv1.Job) error {
+	var finished []*batchv1.Job
+	for _, job := range jobs {
+		if jobFinished(job) {
+			finished = append(finished, job)
+		}
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return jobScheduledTime(finished[i]).After(jobScheduledTime(finished[j]))
+	})
+
+	for i, job := range finished {
+		owned := []client.Object{
+			&snapshotv1.VolumeSnapshot{ObjectMeta: metav1.ObjectMeta{Namespace: job.Namespace, Name: job.Name}},
+			&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: job.Namespace, Name: job.Name}},
+		}
+		for _, o := range owned {
+			if err := r.Delete(ctx, o); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("error while deleting the %T %q: %w", o, o.GetName(), err)
+			}
+		}
+
+		if i < snapshotJobsHistoryLimit {
+			continue
+		}
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("error while deleting the backup job %q: %w", job.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func jobFinished(job *batchv1.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func jobScheduledTime(job *batchv1.Job) time.Time {
+	scheduled, err := time.Parse(time.RFC3339, job.Annotations[constants.ScheduledTimeAnnotation])
+	if err != nil {
+		return job.CreationTimestamp.Time
+	}
+	return scheduled
+}
+
+// snapshotJobName returns the name of the backup Job of a workload volume for
+// the given scheduled time, also used by its snapshot and claim.
+func snapshotJobName(workloadName, volumeName string, scheduled time.Time) string {
+	return cronJobName(workloadName, volumeName) + "-" + strconv.FormatInt(scheduled.Unix()/60, 10)
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+var _ = Describe("Snapshot Controller", func() {
+	var (
+		now        time.Time
+		policy     *v1alpha1.Policy
+		schedule   *v1alpha1.Schedule
+		claim      *corev1.PersistentVolumeClaim
+		deployment *appsv1.Deployment
+		k8sClient  client.Client
+		reconciler *SnapshotReconciler
+	)
+
+	deploymentWorkload := workloads[0]
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+
+	BeforeEach(func() {
+		now = time.Date(2025, 1, 1, 3, 0, 5, 0, time.UTC)
+		policy = &v1alpha1.Policy{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapshot"},
+			Spec: v1alpha1.PolicySpec{
+				Image:                  v1alpha1.Image{Name: "agent", Tag: "1.0"},
+				Mode:                   v1alpha1.PolicyModeSnapshot,
+				Snapshot:               &v1alpha1.Snapshot{VolumeSnapshotClassName: ptr.To("csi")},
+				AutoDetectVolumeMounts: true,
+			},
+		}
+		schedule = &v1alpha1.Schedule{
+			ObjectMeta: metav1.ObjectMeta{Name: "daily"},
+			Spec:       v1alpha1.ScheduleSpec{Schedule: "0 3 * * *"},
+		}
+		claim = &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "data"},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				StorageClassName: ptr.To("fast"),
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+				},
+			},
+		}
+		deployment = &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", UID: "uid"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{
+							constants.PolicyAnnotation:   "snapshot",
+							constants.ScheduleAnnotation: "daily",
+						},
+					},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{
+							Name:         "app",
+							VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}},
+						}},
+						Volumes: []corev1.Volume{
+							{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+						},
+					},
+				},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy, schedule, claim, deployment).Build()
+		reconciler = &SnapshotReconciler{Client: k8sClient, Scheme: scheme, Renderer: &agent.Renderer{Client: k8sClient}, Now: func() time.Time { return now }}
+	})
+
+	listJobs := func() []batchv1.Job {
+		var jobs batchv1.JobList
+		Expect(k8sClient.List(ctx, &jobs, client.InNamespace("default"))).To(Succeed())
+		return jobs.Items
+	}
+
+	Context("When the policy runs in Snapshot mode", func() {
+		It("Should run the agent against a snapshot of the claim at the scheduled time", func() {
+			result, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(24*time.Hour - 5*time.Second))
+
+			jobs := listJobs()
+			Expect(jobs).To(HaveLen(1))
+			job := jobs[0]
+			Expect(metav1.IsControlledBy(&job, deployment)).To(BeTrue())
+
+			spec := job.Spec.Template.Spec
+			Expect(spec.Volumes).To(HaveLen(1))
+			Expect(spec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal(job.Name))
+			Expect(spec.Containers[0].VolumeMounts[0].ReadOnly).To(BeTrue())
+			Expect(spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "BC_RUN_ONCE", Value: "true"}))
+
+			var snapshot snapshotv1.VolumeSnapshot
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: job.Name}, &snapshot)).To(Succeed())
+			Expect(*snapshot.Spec.Source.PersistentVolumeClaimName).To(Equal("data"))
+			Expect(snapshot.Spec.VolumeSnapshotClassName).To(Equal(ptr.To("csi")))
+
+			var clone corev1.PersistentVolumeClaim
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: job.Name}, &clone)).To(Succeed())
+			Expect(clone.Spec.DataSource.Kind).To(Equal("VolumeSnapshot"))
+			Expect(clone.Spec.DataSource.Name).To(Equal(snapshot.Name))
+			Expect(clone.Spec.StorageClassName).To(Equal(ptr.To("fast")))
+
+			// A second reconciliation does not run the backup again
+			_, err = reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listJobs()).To(HaveLen(1))
+		})
+
+		It("Should delete the snapshot and the claim once the job is finished", func() {
+			_, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			job := listJobs()[0]
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+			Expect(k8sClient.Status().Update(ctx, &job)).To(Succeed())
+
+			_, err = reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			key := client.ObjectKey{Namespace: "default", Name: job.Name}
+			Expect(apierrors.IsNotFound(k8sClient.Get(ctx, key, &snapshotv1.VolumeSnapshot{}))).To(BeTrue())
+			Expect(apierrors.IsNotFound(k8sClient.Get(ctx, key, &corev1.PersistentVolumeClaim{}))).To(BeTrue())
+			Expect(listJobs()).To(HaveLen(1))
+		})
+
+		It("Should run the Jobs of each pair apart", func() {
+			offsite := &v1alpha1.Policy{
+				ObjectMeta: metav1.ObjectMeta{Name: "offsite"},
+				Spec:       *policy.Spec.DeepCopy(),
+			}
+			Expect(k8sClient.Create(ctx, offsite)).To(Succeed())
+			deployment.Spec.Template.Annotations = map[string]string{
+				constants.BackupsAnnotation: "snapshot@daily,offsite@daily",
+			}
+			Expect(k8sClient.Update(ctx, deployment)).To(Succeed())
+
+			_, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			backups := make(map[string]string)
+			for _, job := range listJobs() {
+				backups[job.Labels[constants.BackupLabel]] = job.Labels[constants.VolumeLabel]
+			}
+			Expect(backups).To(Equal(map[string]string{
+				"snapshot-daily": "data",
+				"offsite-daily":  "data",
+			}))
+
+			// A second reconciliation does not run the backups again
+			_, err = reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listJobs()).To(HaveLen(2))
+		})
+
+		It("Should not run the backup before the scheduled time", func() {
+			now = now.Add(-time.Hour)
+
+			result, err := reconciler.reconcile(ctx, deploymentWorkload, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(time.Hour - 5*time.Second))
+			Expect(listJobs()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,465 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/blackout"
+)
+
+// workload describes a kind of owning workload for which the controller runs
+// the backup agent itself, when its pod template uses a policy in a mode
+// other than Sidecar.
+type workload struct {
+	kind     string
+	object   func() client.Object
+	list     func() client.ObjectList
+	items    func(client.ObjectList) []client.Object
+	template func(client.Object) *corev1.PodTemplateSpec
+
+	// claims returns the names of the volume claim templates of the workload
+	// and its number of replicas, if it has any (optional).
+	claims func(client.Object) ([]string, int32)
+}
+
+var workloads = []workload{
+	{
+		kind:   "Deployment",
+		object: func() client.Object { return &appsv1.Deployment{} },
+		list:   func() client.ObjectList { return &appsv1.DeploymentList{} },
+		items: func(l client.ObjectList) []client.Object {
+			var items []client.Object
+			for i := range l.(*appsv1.DeploymentList).Items {
+				items = append(items, &l.(*appsv1.DeploymentList).Items[i])
+			}
+			return items
+		},
+		template: func(o client.Object) *corev1.PodTemplateSpec { return &o.(*appsv1.Deployment).Spec.Template },
+	},
+	{
+		kind:   "StatefulSet",
+		object: func() client.Object { return &appsv1.StatefulSet{} },
+		list:   func() client.ObjectList { return &appsv1.StatefulSetList{} },
+		items: func(l client.ObjectList) []client.Object {
+			var items []client.Object
+			for i := range l.(*appsv1.StatefulSetList).Items {
+				items = append(items, &l.(*appsv1.StatefulSetList).Items[i])
+			}
+			return items
+		},
+		template: func(o client.Object) *corev1.PodTemplateSpec { return &o.(*appsv1.StatefulSet).Spec.Template },
+		claims: func(o client.Object) ([]string, int32) {
+			sts := o.(*appsv1.StatefulSet)
+			var names []string
+			for _, t := range sts.Spec.VolumeClaimTemplates {
+				names = append(names, t.Name)
+			}
+			return names, ptr.Deref(sts.Spec.Replicas, 1)
+		},
+	},
+}
+
+// referencing returns a map function enqueuing the workloads of the given kind
+// whose pod template requests a backup referencing the changed object, as
+// returned by the given function.
+func referencing(c client.Client, w workload, name func(agent.Backup) string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		list := w.list()
+		if err := c.List(ctx, list); err != nil {
+			log.FromContext(ctx).Error(err, "unable to list workloads", "kind", w.kind)
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for _, item := range w.items(list) {
+			// Invalid annotations are reported when reconciling the workload
+			backups, _ := agent.ParseBackups(w.template(item).Annotations)
+			for _, backup := range backups {
+				if name(backup) == obj.GetName() {
+					requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(item)})
+					break
+				}
+			}
+		}
+		return requests
+	}
+}
+
+// withBackups returns a map function enqueuing all the workloads of the given
+// kind whose pod template requests a backup, e.g. when a freeze changes.
+func withBackups(c client.Client, w workload) handler.MapFunc {
+	return func(ctx context.Context, _ client.Object) []reconcile.Request {
+		list := w.list()
+		if err := c.List(ctx, list); err != nil {
+			log.FromContext(ctx).Error(err, "unable to list workloads", "kind", w.kind)
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for _, item := range w.items(list) {
+			if backups, _ := agent.ParseBackups(w.template(item).Annotations); len(backups) > 0 {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(item)})
+			}
+		}
+		return requests
+	}
+}
+
+// activeBlackout returns the blackout window or freeze in effect for the
+// schedule at the given time, if any, along with the delay after which it
+// should be checked again (zero when no window is coming within a day).
+func activeBlackout(ctx context.Context, c client.Client, schedule v1alpha1.Schedule, now time.Time) (*blackout.Window, time.Duration, error) {
+	var freezes v1alpha1.BackupFreezeList
+	if err := c.List(ctx, &freezes); err != nil {
+		return nil, 0, fmt.Errorf("error while listing the backup freezes: %w", err)
+	}
+
+	windows, err := blackout.Windows(schedule.Spec, freezes.Items, now, now.Add(blackoutsHorizon))
+	if err != nil {
+		return nil, 0, fmt.Errorf("error while computing the blackout windows of the schedule %q: %w", schedule.Name, err)
+	}
+
+	var requeue time.Duration
+	if next, ok := blackout.NextChange(windows, now); ok {
+		requeue = next.Sub(now)
+	}
+
+	if w, ok := blackout.Active(windows, now); ok {
+		return &w, requeue, nil
+	}
+	return nil, requeue, nil
+}
+
+// backupAgent is the backup agent resolved for the pod template of a workload
+// and one of the policy and schedule pairs it requests.
+type backupAgent struct {
+	// suffix identifies the pair when the pod template requests several, as
+	// for the names of the sidecar containers
+	suffix string
+
+	template  *corev1.PodTemplateSpec
+	pod       *corev1.Pod
+	policy    v1alpha1.Policy
+	schedule  v1alpha1.Schedule
+	container corev1.Container
+
+	// volumes lists the names of the backed up volumes, in mount order
+	volumes []string
+	mounts  map[string][]corev1.VolumeMount
+
+	// workloadName, claims and replicas describe the volume claim templates
+	// of the workload, whose claims are created per replica.
+	workloadName string
+	claims       []string
+	replicas     int32
+}
+
+// backupVolume is a backed up volume of a workload. The volumes coming from
+// a volume claim template are backed up once per replica claim.
+type backupVolume struct {
+	// key identifies the volume among the CronJobs and Jobs of the workload
+	key    string
+	volume corev1.Volume
+
+	// pod is the name of the replica owning the claim, if any
+	pod string
+}
+
+// resolveAgents builds the backup agents of a workload, running once, one per
+// policy and schedule pair of the pod template with a policy in the given
+// mode. It returns none when the pod template requests no such backup.
+func resolveAgents(ctx context.Context, c client.Client, renderer *agent.Renderer, w workload, obj client.Object, mode v1alpha1.PolicyMode) ([]*backupAgent, error) {
+	backups, err := agent.ParseBackups(w.template(obj).Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	var agents []*backupAgent
+	for _, backup := range backups {
+		var policy v1alpha1.Policy
+		if err := c.Get(ctx, client.ObjectKey{Name: backup.Policy}, &policy); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("error while fetching the policy: %w", err)
+		}
+
+		if policy.Spec.Mode != mode {
+			continue
+		}
+
+		var schedule v1alpha1.Schedule
+		if err := c.Get(ctx, client.ObjectKey{Name: backup.Schedule}, &schedule); err != nil {
+			return nil, fmt.Errorf("error while fetching the schedule: %w", err)
+		}
+
+		a, err := resolveAgent(ctx, renderer, w, obj, policy, schedule)
+		if err != nil {
+			return nil, err
+		}
+		// Each pair gets its own CronJobs and Jobs, named after it when there
+		// are several
+		if len(backups) > 1 {
+			a.suffix = backup.Suffix()
+		}
+		agents = append(agents, a)
+	}
+
+	return agents, nil
+}
+
+// resolveAgent builds the backup agent of a workload for the given policy and
+// schedule.
+func resolveAgent(ctx context.Context, renderer *agent.Renderer, w workload, obj client.Object, policy v1alpha1.Policy, schedule v1alpha1.Schedule) (*backupAgent, error) {
+	template := w.template(obj)
+
+	// The agent is built against a pod resolved from the template, owned by
+	// the workload itself.
+	pod := &corev1.Pod{
+		ObjectMeta: *template.ObjectMeta.DeepCopy(),
+		Spec:       *template.Spec.DeepCopy(),
+	}
+	pod.Namespace = obj.GetNamespace()
+	// The StatefulSet controller adds the volumes of the claim templates to
+	// its pods
+	var claims []string
+	var replicas int32
+	if w.claims != nil {
+		claims, replicas = w.claims(obj)
+	}
+	for _, name := range claims {
+		if _, ok := findVolume(pod.Spec.Volumes, name); ok {
+			continue
+		}
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: name},
+			},
+		})
+	}
+	pod.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: appsv1.SchemeGroupVersion.String(),
+		Kind:       w.kind,
+		Name:       obj.GetName(),
+		UID:        obj.GetUID(),
+		Controller: ptr.To(true),
+	}}
+
+	rendered, err := renderer.Render(ctx, policy, schedule, *pod)
+	if err != nil {
+		return nil, fmt.Errorf("error while templating the policy: %w", err)
+	}
+
+	container, err := agent.BuildContainer(ctx, pod, rendered, schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	// The variables of the policy may still override the tags
+	tagEnv, err := renderer.TagEnv(ctx, policy, schedule, *pod)
+	if err != nil {
+		return nil, fmt.Errorf("error while resolving the snapshot tags: %w", err)
+	}
+	// The Job pods are not the replicas, which are only told apart for the
+	// volumes of their own claims
+	tagEnv = slices.DeleteFunc(tagEnv, func(e corev1.EnvVar) bool { return e.Name == agent.ReplicaTagEnv })
+	container.Env = append(tagEnv, container.Env...)
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name:  "BC_RUN_ONCE",
+		Value: "true",
+	})
+
+	a := &backupAgent{
+		template:  template,
+		pod:       pod,
+		policy:    rendered,
+		schedule:  schedule,
+		container: container,
+		mounts:    make(map[string][]corev1.VolumeMount),
+
+		workloadName: obj.GetName(),
+		claims:       claims,
+		replicas:     replicas,
+	}
+
+	for _, m := range container.VolumeMounts {
+		if _, ok := a.mounts[m.Name]; !ok {
+			a.volumes = append(a.volumes, m.Name)
+		}
+		a.mounts[m.Name] = append(a.mounts[m.Name], m)
+	}
+
+	return a, nil
+}
+
+// key returns the key identifying the given name, e.g. a volume, among the
+// CronJobs and Jobs of the workload.
+func (a *backupAgent) key(name string) string {
+	return backupKey(a.suffix, name)
+}
+
+// backupKey prefixes the given name with the suffix of a policy and schedule
+// pair, if any.
+func backupKey(suffix, name string) string {
+	switch {
+	case suffix == "":
+		return name
+	case name == "":
+		return suffix
+	}
+	return suffix + "-" + name
+}
+
+// volumeContainer returns the agent container restricted to the mounts of
+// the given volume.
+func (a *backupAgent) volumeContainer(name string) corev1.Container {
+	c := *a.container.DeepCopy()
+	c.VolumeMounts = nil
+	for _, m := range a.mounts[name] {
+		c.VolumeMounts = append(c.VolumeMounts, *m.DeepCopy())
+	}
+	for i, e := range c.Env {
+		if e.Name == "BC_BACKUP_DIR" {
+			c.Env[i].Value = agent.BackupDir(c.VolumeMounts)
+		}
+	}
+	return c
+}
+
+// backupVolumes resolves the backed up volumes of the workload, in mount
+// order. A volume coming from a volume claim template of a StatefulSet is
+// returned once per replica, with the claim named after the replica pod as
+// the StatefulSet controller does.
+func (a *backupAgent) backupVolumes() ([]backupVolume, error) {
+	var volumes []backupVolume
+	for _, name := range a.volumes {
+		if !slices.Contains(a.claims, name) {
+			volume, ok := findVolume(a.pod.Spec.Volumes, name)
+			if !ok {
+				return nil, fmt.Errorf("volume %q not found in the pod template", name)
+			}
+			volumes = append(volumes, backupVolume{key: name, volume: volume})
+			continue
+		}
+		for i := range a.replicas {
+			pod := fmt.Sprintf("%s-%d", a.workloadName, i)
+			volumes = append(volumes, backupVolume{
+				key: fmt.Sprintf("%s-%d", name, i),
+				volume: corev1.Volume{
+					Name: name,
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+							ClaimName: name + "-" + pod,
+						},
+					},
+				},
+				pod: pod,
+			})
+		}
+	}
+	return volumes, nil
+}
+
+// backupContainer returns the agent container backing up the given volume,
+// tagging the snapshots with the replica owning its claim, if any.
+func (a *backupAgent) backupContainer(v backupVolume) corev1.Container {
+	c := a.volumeContainer(v.volume.Name)
+	if v.pod != "" {
+		c.Env = append([]corev1.EnvVar{{Name: agent.ReplicaTagEnv, Value: v.pod}}, c.Env...)
+	}
+	return c
+}
+
+// maintenanceContainer returns the agent container running the given
+// maintenance task of the repository, without any volume.
+func (a *backupAgent) maintenanceContainer(task string) corev1.Container {
+	c := *a.container.DeepCopy()
+	c.VolumeMounts = nil
+	c.Env = slices.DeleteFunc(c.Env, func(e corev1.EnvVar) bool { return e.Name == "BC_BACKUP_DIR" })
+	c.Env = append(c.Env, corev1.EnvVar{Name: "BC_MAINTENANCE_TASK", Value: task})
+	return c
+}
+
+// jobSpec builds the spec of a Job running the agent container once with the
+// given volumes, inheriting the settings of the workload pod template the
+// volumes may depend on, e.g. to run on the node a volume is attached to. The
+// anti-affinity of the workload is left out, as it could keep the Job away from
+// the pods of the workload. The termination grace period is the one passed to
+// the agent.
+func jobSpec(a *backupAgent, label string, container corev1.Container, volumes []corev1.Volume) batchv1.JobSpec {
+	template := a.template
+
+	var affinity *corev1.Affinity
+	if template.Spec.Affinity != nil {
+		affinity = template.Spec.Affinity.DeepCopy()
+		affinity.PodAntiAffinity = nil
+		if affinity.NodeAffinity == nil && affinity.PodAffinity == nil {
+			affinity = nil
+		}
+	}
+
+	return batchv1.JobSpec{
+		// The agent reports failures through its output modules, retrying
+		// would only report them twice.
+		BackoffLimit: ptr.To[int32](0),
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{label: "true"},
+			},
+			Spec: corev1.PodSpec{
+				RestartPolicy:      corev1.RestartPolicyNever,
+				ServiceAccountName: template.Spec.ServiceAccountName,
+				ImagePullSecrets:   template.Spec.ImagePullSecrets,
+				SecurityContext:    template.Spec.SecurityContext,
+				NodeSelector:       template.Spec.NodeSelector,
+				Affinity:           affinity,
+				Tolerations:        template.Spec.Tolerations,
+				Containers:         []corev1.Container{container},
+				Volumes:            volumes,
+
+				TerminationGracePeriodSeconds: agent.TerminationGracePeriod(a.pod, a.policy.Spec.Termination),
+			},
+		},
+	}
+}
+
+func findVolume(volumes []corev1.Volume, name string) (corev1.Volume, bool) {
+	for _, v := range volumes {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return corev1.Volume{}, false
+}
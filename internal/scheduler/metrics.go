@@ -0,0 +1,58 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// The results of the runs, as reported by the runs counter.
+const (
+	resultSucceeded = "succeeded"
+	resultFailed    = "failed"
+	// The previous run of the agent was still queued or running
+	resultSkipped = "skipped"
+	// The starting deadline was over before a slot was available
+	resultMissed = "missed"
+	// The run was due during a blackout window or a freeze
+	resultBlackout = "blackout"
+)
+
+var (
+	queuedRuns = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "backup_controller_scheduler_queued_runs",
+		Help: "Number of backup runs waiting for a free slot.",
+	})
+	runningRuns = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "backup_controller_scheduler_running_runs",
+		Help: "Number of backup runs in progress.",
+	})
+	runsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "backup_controller_scheduler_runs_total",
+		Help: "Number of backup runs due, by result.",
+	}, []string{"result"})
+	queueWaitSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "backup_controller_scheduler_queue_wait_seconds",
+		Help:    "Time the backup runs waited in the queue before being started.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(queuedRuns, runningRuns, runsTotal, queueWaitSeconds)
+}
@@ -0,0 +1,465 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scheduler implements the central scheduler of the backup agents:
+// instead of their own crond, the agents injected while it is enabled are
+// triggered by the manager, which bounds the number of concurrent runs.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/blackout"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+const (
+	// defaultInterval is the default delay between two evaluations of the
+	// schedules.
+	defaultInterval = 15 * time.Second
+
+	// blackoutsHorizon bounds the blackout windows computed to decide whether
+	// a run can wait for the end of the current one.
+	blackoutsHorizon = 24 * time.Hour
+)
+
+// Limits bounds the number of backup runs in progress. Zero means unlimited.
+type Limits struct {
+	// Cluster is the limit of runs in the whole cluster.
+	Cluster int
+	// PerNode is the limit of runs of the pods of a node.
+	PerNode int
+	// PerRepository is the limit of runs against a restic repository.
+	PerRepository int
+}
+
+// Scheduler triggers the runs of the backup agents injected with the
+// central scheduler enabled (see agent.CentralSchedulerEnv) on their
+// Schedule. The runs due while the limits are reached are queued until a
+// slot is free, and dropped once the starting deadline of their schedule is
+// over. A queued run does not block the ones behind it when they target
+// another node or repository.
+//
+// It only runs on the leader of the managers.
+type Scheduler struct {
+	client.Client
+	Executor Executor
+	Limits   Limits
+
+	// Interval is the delay between two evaluations of the schedules
+	// (optional, 15 seconds by default).
+	Interval time.Duration
+
+	// Now returns the current time (optional, used by the tests).
+	Now func() time.Time
+
+	mu sync.Mutex
+	// The next run of each agent, by pod UID and container name
+	agents map[string]*agentState
+	// The runs waiting for a slot, in the order they were due
+	queue []*run
+	// The runs in progress, by agent
+	running map[string]*run
+	// The active blackout window of each schedule
+	blackouts map[string]blackout.Window
+	// Wakes the scheduler up when a run exits
+	wake chan struct{}
+}
+
+var (
+	_ manager.Runnable               = &Scheduler{}
+	_ manager.LeaderElectionRunnable = &Scheduler{}
+)
+
+type agentState struct {
+	expression string
+	next       time.Time
+}
+
+type run struct {
+	agent      string
+	pod        types.NamespacedName
+	container  string
+	node       string
+	repository string
+	schedule   string
+	scheduled  time.Time
+	deadline   time.Duration
+	queued     time.Time
+}
+
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
+// +kubebuilder:rbac:groups=backup-controller.rclsilver-org.github.com,resources=schedules;backupfreezes,verbs=get;list;watch
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (s *Scheduler) NeedLeaderElection() bool {
+	return true
+}
+
+// Start implements manager.Runnable. It blocks until the context is done.
+func (s *Scheduler) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("scheduler")
+	ctx = log.IntoContext(ctx, logger)
+
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	s.mu.Lock()
+	s.wake = make(chan struct{}, 1)
+	s.mu.Unlock()
+
+	logger.Info("starting the central scheduler",
+		"cluster", s.Limits.Cluster, "perNode", s.Limits.PerNode, "perRepository", s.Limits.PerRepository)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.sync(ctx); err != nil {
+			logger.Error(err, "unable to evaluate the schedules")
+		}
+		s.dispatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *Scheduler) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// sync queues the runs of the agents which are due.
+func (s *Scheduler) sync(ctx context.Context) error {
+	log := log.FromContext(ctx)
+
+	var pods corev1.PodList
+	if err := s.List(ctx, &pods, client.MatchingLabels{constants.MutatedLabel: "true"}); err != nil {
+		return fmt.Errorf("error while listing the mutated pods: %w", err)
+	}
+
+	var freezes v1alpha1.BackupFreezeList
+	if err := s.List(ctx, &freezes); err != nil {
+		return fmt.Errorf("error while listing the backup freezes: %w", err)
+	}
+
+	now := s.now()
+	schedules := make(map[string]*v1alpha1.Schedule)
+	blackouts := make(map[string]blackout.Window)
+	seen := make(map[string]bool)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.agents == nil {
+		s.agents = make(map[string]*agentState)
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		// An invalid annotation would not have been mutated
+		backups, _ := agent.ParseBackups(pod.Annotations)
+		for _, backup := range backups {
+			name := agent.ContainerName
+			if len(backups) > 1 {
+				name += "-" + backup.Suffix()
+			}
+			container := findContainer(pod, name)
+			if container == nil || envValue(container, agent.CentralSchedulerEnv) != "true" {
+				continue
+			}
+
+			schedule, ok := schedules[backup.Schedule]
+			if !ok {
+				schedule = &v1alpha1.Schedule{}
+				if err := s.Get(ctx, client.ObjectKey{Name: backup.Schedule}, schedule); err != nil {
+					if !apierrors.IsNotFound(err) {
+						return fmt.Errorf("error while fetching the schedule %q: %w", backup.Schedule, err)
+					}
+					schedule = nil
+				} else {
+					windows, err := blackout.Windows(schedule.Spec, freezes.Items, now, now.Add(blackoutsHorizon))
+					if err != nil {
+						log.Error(err, "invalid blackout windows", "schedule", schedule.Name)
+					} else if w, ok := blackout.Active(windows, now); ok {
+						blackouts[schedule.Name] = w
+					}
+				}
+				schedules[backup.Schedule] = schedule
+			}
+			if schedule == nil {
+				continue
+			}
+
+			expression := schedule.Spec.Schedule
+			if tz := schedule.Spec.TimeZone; tz != "" {
+				expression = "CRON_TZ=" + tz + " " + expression
+			}
+			sched, err := cron.ParseStandard(expression)
+			if err != nil {
+				log.Error(err, "invalid schedule", "schedule", schedule.Name)
+				continue
+			}
+
+			key := string(pod.UID) + "/" + name
+			seen[key] = true
+
+			// The first run of an agent, or after its schedule changed, is
+			// the next one: the runs missed before are not caught up
+			state := s.agents[key]
+			if state == nil || state.expression != expression {
+				s.agents[key] = &agentState{expression: expression, next: sched.Next(now)}
+				continue
+			}
+
+			// The runs of the pods sharing the schedule are spread by the
+			// jitter of the schedule
+			var jitter time.Duration
+			if j := schedule.Spec.Jitter; j != nil && j.Duration > 0 {
+				jitter = spread(pod.Name, j.Duration)
+			}
+			// The starting deadline counts from the jittered due time
+			scheduled := state.next.Add(jitter)
+			if now.Before(scheduled) {
+				continue
+			}
+			state.next = sched.Next(now)
+
+			if s.pending(key) {
+				log.Info("skipping the run because the previous one is not over", "pod", client.ObjectKeyFromObject(pod), "container", name)
+				runsTotal.WithLabelValues(resultSkipped).Inc()
+				continue
+			}
+
+			r := &run{
+				agent:      key,
+				pod:        client.ObjectKeyFromObject(pod),
+				container:  name,
+				node:       pod.Spec.NodeName,
+				repository: repository(container, backup.Policy),
+				schedule:   schedule.Name,
+				scheduled:  scheduled,
+				queued:     now,
+			}
+			if d := schedule.Spec.StartingDeadline; d != nil && d.Duration > 0 {
+				r.deadline = d.Duration
+			}
+			s.queue = append(s.queue, r)
+		}
+	}
+
+	// Forget the pods which are gone
+	for key := range s.agents {
+		if !seen[key] {
+			delete(s.agents, key)
+		}
+	}
+	queue := s.queue[:0]
+	for _, r := range s.queue {
+		if seen[r.agent] {
+			queue = append(queue, r)
+		}
+	}
+	s.queue = queue
+	s.blackouts = blackouts
+
+	queuedRuns.Set(float64(len(s.queue)))
+
+	return nil
+}
+
+// dispatch starts the queued runs within the limits.
+func (s *Scheduler) dispatch(ctx context.Context) {
+	log := log.FromContext(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	queue := s.queue[:0]
+	for _, r := range s.queue {
+		expired := r.deadline > 0 && now.Sub(r.scheduled) > r.deadline
+
+		// The runs due during a blackout window wait for its end when the
+		// starting deadline allows it, like the agents do
+		if w, ok := s.blackouts[r.schedule]; ok {
+			if r.deadline == 0 || w.End.After(r.scheduled.Add(r.deadline)) {
+				log.Info("skipping the run during the blackout window", "pod", r.pod, "container", r.container, "reason", w.Reason)
+				runsTotal.WithLabelValues(resultBlackout).Inc()
+				continue
+			}
+			if !expired {
+				queue = append(queue, r)
+				continue
+			}
+		}
+
+		if expired {
+			log.Info("skipping the run because its starting deadline is over", "pod", r.pod, "container", r.container)
+			runsTotal.WithLabelValues(resultMissed).Inc()
+			continue
+		}
+
+		if !s.allowed(r) {
+			queue = append(queue, r)
+			continue
+		}
+
+		s.start(ctx, r, now)
+	}
+	s.queue = queue
+
+	queuedRuns.Set(float64(len(s.queue)))
+}
+
+// allowed tells whether starting the run keeps the runs within the limits.
+func (s *Scheduler) allowed(r *run) bool {
+	var node, repository int
+	for _, other := range s.running {
+		if other.node == r.node {
+			node++
+		}
+		if other.repository == r.repository {
+			repository++
+		}
+	}
+
+	return (s.Limits.Cluster <= 0 || len(s.running) < s.Limits.Cluster) &&
+		(s.Limits.PerNode <= 0 || node < s.Limits.PerNode) &&
+		(s.Limits.PerRepository <= 0 || repository < s.Limits.PerRepository)
+}
+
+// start runs the backup script of the agent in the background.
+func (s *Scheduler) start(ctx context.Context, r *run, now time.Time) {
+	log := log.FromContext(ctx).WithValues("pod", r.pod, "container", r.container)
+
+	if s.running == nil {
+		s.running = make(map[string]*run)
+	}
+	s.running[r.agent] = r
+	runningRuns.Set(float64(len(s.running)))
+	queueWaitSeconds.Observe(now.Sub(r.queued).Seconds())
+
+	log.Info("starting the backup run", "scheduledTime", r.scheduled)
+
+	// The agent checks the starting deadline against the time the run was
+	// due, and does not apply the jitter again
+	command := []string{"/bin/bash", "-c", fmt.Sprintf(
+		`BC_SCHEDULED_TIME=%d exec "${BC_ROOT_DIR}/scripts/run-backup.sh" >> /proc/1/fd/1 2>&1`,
+		r.scheduled.Unix(),
+	)}
+
+	go func() {
+		err := s.Executor.Exec(ctx, r.pod, r.container, command)
+
+		s.mu.Lock()
+		delete(s.running, r.agent)
+		runningRuns.Set(float64(len(s.running)))
+		s.mu.Unlock()
+
+		if err != nil {
+			log.Error(err, "the backup run failed")
+			runsTotal.WithLabelValues(resultFailed).Inc()
+		} else {
+			log.Info("the backup run succeeded")
+			runsTotal.WithLabelValues(resultSucceeded).Inc()
+		}
+
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}()
+}
+
+// pending tells whether a run of the agent is queued or in progress.
+func (s *Scheduler) pending(key string) bool {
+	if _, ok := s.running[key]; ok {
+		return true
+	}
+	for _, r := range s.queue {
+		if r.agent == key {
+			return true
+		}
+	}
+	return false
+}
+
+// repository returns the key identifying the restic repository of the agent:
+// its RESTIC_REPOSITORY variable, or the name of the policy when it is not
+// set inline, e.g. when read from a secret.
+func repository(container *corev1.Container, policy string) string {
+	if value := envValue(container, "RESTIC_REPOSITORY"); value != "" {
+		return value
+	}
+	return "policy:" + policy
+}
+
+// spread returns a delay up to max, derived from the given name so that it is
+// stable.
+func spread(name string, max time.Duration) time.Duration {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return time.Duration(h.Sum64() % uint64(max/time.Second+1) * uint64(time.Second))
+}
+
+func findContainer(pod *corev1.Pod, name string) *corev1.Container {
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == name {
+			return &pod.Spec.Containers[i]
+		}
+	}
+	return nil
+}
+
+// envValue returns the inline value of the last definition of the variable.
+func envValue(container *corev1.Container, name string) string {
+	var value string
+	for _, env := range container.Env {
+		if env.Name == name {
+			value = env.Value
+		}
+	}
+	return value
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
+)
+
+// fakeExecutor records the runs and blocks them until released.
+type fakeExecutor struct {
+	mu       sync.Mutex
+	pods     []string
+	commands [][]string
+	release  chan struct{}
+}
+
+func (e *fakeExecutor) Exec(ctx context.Context, pod types.NamespacedName, container string, command []string) error {
+	e.mu.Lock()
+	e.pods = append(e.pods, pod.Name)
+	e.commands = append(e.commands, command)
+	e.mu.Unlock()
+
+	select {
+	case <-e.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func (e *fakeExecutor) started() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.pods...)
+}
+
+func agentPod(name, node, repository string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			UID:       types.UID(name),
+			Labels:    map[string]string{constants.MutatedLabel: "true"},
+			Annotations: map[string]string{
+				constants.PolicyAnnotation:   "onsite",
+				constants.ScheduleAnnotation: "hourly",
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Containers: []corev1.Container{{
+				Name: agent.ContainerName,
+				Env: []corev1.EnvVar{
+					{Name: "RESTIC_REPOSITORY", Value: repository},
+					{Name: agent.CentralSchedulerEnv, Value: "true"},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+var _ = Describe("Scheduler", func() {
+	var (
+		now       time.Time
+		executor  *fakeExecutor
+		schedule  *v1alpha1.Schedule
+		scheduler *Scheduler
+	)
+
+	// tick evaluates the schedules at the given time
+	tick := func(t time.Time) {
+		now = t
+		Expect(scheduler.sync(ctx)).To(Succeed())
+		scheduler.dispatch(ctx)
+	}
+
+	newScheduler := func(limits Limits, objs ...client.Object) {
+		k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objs, schedule)...).Build()
+		scheduler = &Scheduler{
+			Client:   k8sClient,
+			Executor: executor,
+			Limits:   limits,
+			Now:      func() time.Time { return now },
+		}
+	}
+
+	BeforeEach(func() {
+		executor = &fakeExecutor{release: make(chan struct{})}
+		schedule = &v1alpha1.Schedule{
+			ObjectMeta: metav1.ObjectMeta{Name: "hourly"},
+			Spec:       v1alpha1.ScheduleSpec{Schedule: "0 * * * *"},
+		}
+	})
+
+	AfterEach(func() {
+		close(executor.release)
+	})
+
+	It("Should trigger the runs on the schedule of the agents", func() {
+		legacy := agentPod("legacy", "node-1", "s3:bucket/legacy")
+		legacy.Spec.Containers[0].Env = legacy.Spec.Containers[0].Env[:1]
+		newScheduler(Limits{}, agentPod("app", "node-1", "s3:bucket/app"), legacy)
+
+		tick(time.Date(2025, 6, 1, 10, 30, 0, 0, time.UTC))
+		Expect(executor.started()).To(BeEmpty())
+
+		tick(time.Date(2025, 6, 1, 11, 0, 5, 0, time.UTC))
+		Eventually(executor.started).Should(Equal([]string{"app"}))
+		Expect(executor.commands[0][2]).To(ContainSubstring("BC_SCHEDULED_TIME=1748775600 "))
+
+		By("skipping the next run while the previous one is in progress")
+		tick(time.Date(2025, 6, 1, 12, 0, 5, 0, time.UTC))
+		Consistently(executor.started, "100ms").Should(HaveLen(1))
+	})
+
+	It("Should queue the runs over the limits", func() {
+		newScheduler(Limits{PerNode: 1},
+			agentPod("a", "node-1", "s3:bucket/a"),
+			agentPod("b", "node-1", "s3:bucket/b"),
+			agentPod("c", "node-2", "s3:bucket/c"),
+		)
+
+		tick(time.Date(2025, 6, 1, 10, 30, 0, 0, time.UTC))
+		tick(time.Date(2025, 6, 1, 11, 0, 0, 0, time.UTC))
+		Eventually(executor.started).Should(ConsistOf("a", "c"))
+		Expect(scheduler.queue).To(HaveLen(1))
+
+		By("starting the queued run once a slot is free")
+		executor.release <- struct{}{}
+		executor.release <- struct{}{}
+		Eventually(func() []string {
+			scheduler.dispatch(ctx)
+			return executor.started()
+		}).Should(ConsistOf("a", "b", "c"))
+	})
+
+	It("Should limit the runs against a repository", func() {
+		newScheduler(Limits{PerRepository: 1, Cluster: 2},
+			agentPod("a", "node-1", "s3:bucket/shared"),
+			agentPod("b", "node-2", "s3:bucket/shared"),
+			agentPod("c", "node-3", "s3:bucket/c"),
+			agentPod("d", "node-4", "s3:bucket/d"),
+		)
+
+		tick(time.Date(2025, 6, 1, 10, 30, 0, 0, time.UTC))
+		tick(time.Date(2025, 6, 1, 11, 0, 0, 0, time.UTC))
+		Eventually(executor.started).Should(HaveLen(2))
+		Consistently(executor.started, "100ms").Should(ConsistOf("a", "c"))
+	})
+
+	It("Should drop the queued runs past their starting deadline", func() {
+		schedule.Spec.StartingDeadline = &metav1.Duration{Duration: 10 * time.Minute}
+		newScheduler(Limits{Cluster: 1},
+			agentPod("a", "node-1", "s3:bucket/a"),
+			agentPod("b", "node-2", "s3:bucket/b"),
+		)
+
+		tick(time.Date(2025, 6, 1, 10, 30, 0, 0, time.UTC))
+		tick(time.Date(2025, 6, 1, 11, 0, 0, 0, time.UTC))
+		Eventually(executor.started).Should(HaveLen(1))
+		Expect(scheduler.queue).To(HaveLen(1))
+
+		tick(time.Date(2025, 6, 1, 11, 15, 0, 0, time.UTC))
+		Expect(scheduler.queue).To(BeEmpty())
+	})
+
+	It("Should count the starting deadline from the jittered due time", func() {
+		schedule.Spec.Jitter = &metav1.Duration{Duration: 30 * time.Minute}
+		schedule.Spec.StartingDeadline = &metav1.Duration{Duration: 31 * time.Minute}
+		newScheduler(Limits{}, agentPod("a", "node-1", "s3:bucket/a"))
+
+		tick(time.Date(2025, 6, 1, 10, 30, 0, 0, time.UTC))
+		tick(time.Date(2025, 6, 1, 11, 20, 0, 0, time.UTC))
+		Expect(executor.started()).To(BeEmpty())
+
+		// The run of the pod is due at 11:20:38
+		tick(time.Date(2025, 6, 1, 11, 35, 38, 0, time.UTC))
+		Eventually(executor.started).Should(Equal([]string{"a"}))
+		Expect(executor.commands[0][2]).To(ContainSubstring("BC_SCHEDULED_TIME=1748776838 "))
+	})
+
+	It("Should skip the runs due during a blackout window", func() {
+		freeze := &v1alpha1.BackupFreeze{
+			ObjectMeta: metav1.ObjectMeta{Name: "migration"},
+			Spec: v1alpha1.BackupFreezeSpec{
+				Reason: "migration",
+				Start:  &metav1.Time{Time: time.Date(2025, 6, 1, 10, 45, 0, 0, time.UTC)},
+				End:    &metav1.Time{Time: time.Date(2025, 6, 1, 11, 30, 0, 0, time.UTC)},
+			},
+		}
+		newScheduler(Limits{}, agentPod("a", "node-1", "s3:bucket/a"), freeze)
+
+		tick(time.Date(2025, 6, 1, 10, 30, 0, 0, time.UTC))
+		tick(time.Date(2025, 6, 1, 11, 0, 0, 0, time.UTC))
+		Expect(scheduler.queue).To(BeEmpty())
+		Consistently(executor.started, "100ms").Should(BeEmpty())
+	})
+})
@@ -0,0 +1,115 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package selector evaluates the CEL expressions selecting the pods to back
+// up, e.g. `!has(pod.metadata.labels.role) || pod.metadata.labels.role != "replica"`
+// or `pod.metadata.name.endsWith("-0")`. The expressions get the pod as the
+// `pod` variable and must return a boolean.
+package selector
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// costLimit bounds the evaluation cost of an expression.
+	costLimit = 100000
+
+	// maxCachedPrograms bounds the number of compiled expressions kept in
+	// memory: the cache is reset once it is reached.
+	maxCachedPrograms = 256
+)
+
+var (
+	envOnce sync.Once
+	env     *cel.Env
+	envErr  error
+
+	mu       sync.Mutex
+	programs = make(map[string]cel.Program)
+)
+
+func getEnv() (*cel.Env, error) {
+	envOnce.Do(func() {
+		env, envErr = cel.NewEnv(cel.Variable("pod", cel.DynType))
+	})
+	return env, envErr
+}
+
+// Compile compiles the given expression, or returns it from the cache.
+func Compile(expression string) (cel.Program, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if program, ok := programs[expression]; ok {
+		return program, nil
+	}
+
+	env, err := getEnv()
+	if err != nil {
+		return nil, fmt.Errorf("error while creating the CEL environment: %w", err)
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if t := ast.OutputType(); t != cel.BoolType && t != cel.DynType {
+		return nil, fmt.Errorf("the expression must return a bool, got %s", t)
+	}
+
+	program, err := env.Program(ast, cel.CostLimit(costLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(programs) >= maxCachedPrograms {
+		programs = make(map[string]cel.Program)
+	}
+	programs[expression] = program
+
+	return program, nil
+}
+
+// Matches evaluates the given expression against the pod.
+func Matches(expression string, pod *corev1.Pod) (bool, error) {
+	program, err := Compile(expression)
+	if err != nil {
+		return false, err
+	}
+
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+	if err != nil {
+		return false, fmt.Errorf("error while converting the pod: %w", err)
+	}
+
+	value, _, err := program.Eval(map[string]any{"pod": object})
+	if err != nil {
+		return false, err
+	}
+
+	matched, ok := value.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("the expression must return a bool, got %s", value.Type())
+	}
+
+	return matched, nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selector
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Selector", func() {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "db-0",
+			Labels: map[string]string{"role": "primary"},
+		},
+	}
+
+	DescribeTable("Should evaluate the expression against the pod",
+		func(expression string, expected bool) {
+			matched, err := Matches(expression, pod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(matched).To(Equal(expected))
+		},
+		Entry("ordinal", `pod.metadata.name.endsWith("-0")`, true),
+		Entry("label", `pod.metadata.labels.role != "replica"`, true),
+		Entry("missing label", `has(pod.metadata.labels.tier)`, false),
+	)
+
+	It("Should reject invalid expressions", func() {
+		_, err := Compile(`pod.metadata.name.endsWith(`)
+		Expect(err).To(HaveOccurred())
+
+		_, err = Compile(`"db-0"`)
+		Expect(err).To(MatchError(ContainSubstring("must return a bool")))
+	})
+
+	It("Should report evaluation errors", func() {
+		_, err := Matches(`pod.metadata.annotations.owner == "me"`, pod)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should cache the compiled expressions", func() {
+		first, err := Compile(`true`)
+		Expect(err).NotTo(HaveOccurred())
+		second, err := Compile(`true`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second).To(BeIdenticalTo(first))
+	})
+})
@@ -17,34 +17,33 @@ limitations under the License.
 package v1
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"regexp"
-	"slices"
-	"strconv"
 
-	"text/template"
-
-	"github.com/Masterminds/sprig/v3"
 	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
 	"github.com/rclsilver-org/backup-controller/internal/constants"
+	"github.com/rclsilver-org/backup-controller/internal/selector"
 	corev1 "k8s.io/api/core/v1"
 
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/intstr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 // SetupPodWebhookWithManager registers the webhook for Pod in the manager.
-func SetupPodWebhookWithManager(mgr ctrl.Manager) error {
+// The policies are rendered with the given renderer. When centralScheduler is
+// set, the agents are triggered by the central scheduler of the manager.
+func SetupPodWebhookWithManager(mgr ctrl.Manager, renderer *agent.Renderer, centralScheduler bool) error {
 	return ctrl.NewWebhookManagedBy(mgr).For(&corev1.Pod{}).
 		WithDefaulter(&PodCustomDefaulter{
-			client: mgr.GetClient(),
+			client:           mgr.GetClient(),
+			renderer:         renderer,
+			centralScheduler: centralScheduler,
 		}).
 		Complete()
 }
@@ -58,7 +57,9 @@ func SetupPodWebhookWithManager(mgr ctrl.Manager) error {
 // NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
 // as it is used only for temporary operations and does not need to be deeply copied.
 type PodCustomDefaulter struct {
-	client client.Client
+	client           client.Client
+	renderer         *agent.Renderer
+	centralScheduler bool
 }
 
 var _ webhook.CustomDefaulter = &PodCustomDefaulter{}
@@ -72,24 +73,25 @@ func (d *PodCustomDefaulter) Default(ctx context.Context, obj runtime.Object) er
 		return fmt.Errorf("expected an Pod object but got %T", obj)
 	}
 
-	annotations := pod.GetAnnotations()
-
-	policyName, ok := annotations[constants.PolicyAnnotation]
-	if !ok {
-		policyName = ""
+	// The namespace of the pods created by controllers is only set on the
+	// admission request, it is filled first as the selectors may match on it
+	if pod.Namespace == "" {
+		if req, err := admission.RequestFromContext(ctx); err == nil {
+			pod.Namespace = req.Namespace
+		}
 	}
 
-	scheduleName, ok := annotations[constants.ScheduleAnnotation]
-	if !ok {
-		scheduleName = ""
+	backups, err := agent.ParseBackups(pod.GetAnnotations())
+	if err != nil {
+		return err
 	}
 
-	filterPattern, ok := annotations[constants.FilterAnnotation]
+	filterPattern, ok := pod.GetAnnotations()[constants.FilterAnnotation]
 	if !ok {
 		filterPattern = ""
 	}
 
-	if policyName == "" || scheduleName == "" {
+	if len(backups) == 0 {
 		log.Info("ignoring the pod because either policy or schedule undefined")
 		return nil
 	}
@@ -105,198 +107,179 @@ func (d *PodCustomDefaulter) Default(ctx context.Context, obj runtime.Object) er
 		}
 	}
 
-	sourcePolicy, err := d.getPolicy(ctx, policyName)
-	if err != nil {
-		return fmt.Errorf("error while fetching the policy: %w", err)
-	}
-
-	schedule, err := d.getSchedule(ctx, scheduleName)
-	if err != nil {
-		return fmt.Errorf("error while fetching the schedule: %w", err)
-	}
-
-	policy, err := d.buildTemplate(*sourcePolicy, *pod)
-	if err != nil {
-		return fmt.Errorf("error while templating the policy: %w", err)
+	if expression := pod.GetAnnotations()[constants.SelectorAnnotation]; expression != "" {
+		matched, err := selector.Matches(expression, pod)
+		if err != nil {
+			return fmt.Errorf("error while evaluating the selector %q of the pod: %w", expression, err)
+		}
+		if !matched {
+			log.Info("ignoring the pod because it does not match the selector", "podName", pod.Name, "selector", expression)
+			return nil
+		}
 	}
 
-	newContainer := corev1.Container{
-		Name: "backup-agent",
+	// All the containers are built against the pod as submitted, so that an
+	// agent does not detect the volume mounts of another one.
+	var containers []corev1.Container
+	exporters := 0
+	usedPorts := make(map[int32]bool)
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			usedPorts[p.ContainerPort] = true
+		}
 	}
+	var gracePeriod *int64
+	var volumes []corev1.Volume
+	hooksVolume := false
 
-	newContainer.Image, newContainer.ImagePullPolicy = resolveImage(policy.Spec.Image)
-
-	if policy.Spec.AutoDetectVolumeMounts {
-		mounts, err := d.getDetectedVolumeMounts(*pod)
+	for _, backup := range backups {
+		sourcePolicy, err := d.getPolicy(ctx, backup.Policy)
 		if err != nil {
-			return fmt.Errorf("error while detecting volume mounts: %w", err)
+			return fmt.Errorf("error while fetching the policy: %w", err)
 		}
 
-		var backupDir string
-		if len(mounts) == 1 {
-			log.Info("detected volume mount", "path", mounts[0].MountPath)
-			backupDir = mounts[0].MountPath
-		} else {
-			log.Info(fmt.Sprintf("detected %d volume mounts", len(mounts)))
-			for i, m := range mounts {
-				if i > 0 {
-					backupDir += ":"
-				}
-				backupDir += m.MountPath
-			}
+		schedule, err := d.getSchedule(ctx, backup.Schedule)
+		if err != nil {
+			return fmt.Errorf("error while fetching the schedule: %w", err)
 		}
 
-		newContainer.VolumeMounts = append(newContainer.VolumeMounts, mounts...)
-		newContainer.Env = append(newContainer.Env, corev1.EnvVar{
-			Name:  "BC_BACKUP_DIR",
-			Value: backupDir,
-		})
-	}
+		switch sourcePolicy.Spec.Mode {
+		case v1alpha1.PolicyModeCronJob:
+			log.Info("ignoring the policy because it runs the agent as a CronJob", "policy", backup.Policy)
+			continue
+		case v1alpha1.PolicyModeSnapshot:
+			log.Info("ignoring the policy because it runs the agent against volume snapshots", "policy", backup.Policy)
+			continue
+		}
 
-	newContainer.Env = append(newContainer.Env, policy.Spec.Environment...)
+		if expression := sourcePolicy.Spec.PodSelectorExpression; expression != "" {
+			matched, err := selector.Matches(expression, pod)
+			if err != nil {
+				return fmt.Errorf("error while evaluating the pod selector expression of the policy %q: %w", backup.Policy, err)
+			}
+			if !matched {
+				log.Info("ignoring the policy because the pod does not match its selector", "policy", backup.Policy)
+				continue
+			}
+		}
 
-	for _, spec := range policy.Spec.CopyEnv {
-		variable, err := d.getContainerEnv(pod, spec.ContainerName, spec.VariableName)
+		policy, err := d.renderer.Render(ctx, *sourcePolicy, *schedule, *pod)
 		if err != nil {
-			return fmt.Errorf("error while copying environment variable %q from the container %q: %w", spec.VariableName, spec.ContainerName, err)
+			return fmt.Errorf("error while templating the policy: %w", err)
 		}
-		if spec.NewName != "" {
-			variable.Name = spec.NewName
+
+		newContainer, err := agent.BuildContainer(ctx, pod, policy, *schedule)
+		if err != nil {
+			return err
 		}
-		newContainer.Env = append(newContainer.Env, variable)
-	}
 
-	for _, spec := range policy.Spec.CopyVolumeMount {
-		mount, err := d.getContainerVolumeMount(pod, spec.ContainerName, spec.MountPath)
+		// The variables of the policy may still override the tags
+		tagEnv, err := d.renderer.TagEnv(ctx, *sourcePolicy, *schedule, *pod)
 		if err != nil {
-			return fmt.Errorf("error while copying volume mount %q from the container %q: %w", spec.MountPath, spec.ContainerName, err)
+			return fmt.Errorf("error while resolving the snapshot tags: %w", err)
 		}
-		newContainer.VolumeMounts = append(newContainer.VolumeMounts, mount)
-	}
+		newContainer.Env = append(tagEnv, newContainer.Env...)
 
-	newContainer.Env = append(newContainer.Env, corev1.EnvVar{
-		Name:  "BC_SCHEDULE",
-		Value: schedule.Spec.Schedule,
-	})
+		// Each pair gets its own agent, named after it when there are several
+		if len(backups) > 1 {
+			newContainer.Name += "-" + backup.Suffix()
+		}
 
-	if err := d.applyRetentionDays(annotations, &newContainer); err != nil {
-		return err
-	}
+		// Health checks for the agent are opt-in per policy: the right check depends
+		// on the agent image (crond-based agents vs. the plain cnpg binary), so no
+		// default is applied here, except for the freshness of the backups.
+		newContainer.LivenessProbe = policy.Spec.LivenessProbe
+		newContainer.ReadinessProbe = policy.Spec.ReadinessProbe
+		if newContainer.ReadinessProbe == nil {
+			newContainer.ReadinessProbe = agent.FreshnessProbe(policy.Spec.Freshness)
+		}
+		newContainer.StartupProbe = policy.Spec.StartupProbe
 
-	var zero int64 = 0
-	var false bool = false
-	newContainer.SecurityContext = &corev1.SecurityContext{
-		RunAsUser:    &zero,
-		RunAsGroup:   &zero,
-		RunAsNonRoot: &false,
-	}
+		// The agent reloads the settings of its schedule and policy from the
+		// ConfigMap maintained by the controller when they change
+		agent.WatchSchedule(&newContainer, schedule.Name, sourcePolicy.Name)
 
-	// Health checks for the agent are opt-in per policy: the right check depends
-	// on the agent image (crond-based agents vs. the plain cnpg binary), so no
-	// default is applied here.
-	newContainer.LivenessProbe = policy.Spec.LivenessProbe
-	newContainer.ReadinessProbe = policy.Spec.ReadinessProbe
-	newContainer.StartupProbe = policy.Spec.StartupProbe
+		// The verification runs restore the snapshots into a scratch volume
+		if volume := agent.VerifyVolume(&newContainer, policy.Spec.Verify); volume != nil {
+			volumes = append(volumes, *volume)
+		}
+
+		// The hooks run with the token of the hooks ServiceAccount, mounted in
+		// the agents only and shared by them
+		if volume := agent.HooksVolume(&newContainer, policy.Spec.Hooks); volume != nil && !hooksVolume {
+			volumes = append(volumes, *volume)
+			hooksVolume = true
+		}
 
-	pod.Spec.Containers = append(pod.Spec.Containers, newContainer)
+		if d.centralScheduler {
+			newContainer.Env = append(newContainer.Env, corev1.EnvVar{
+				Name:  agent.CentralSchedulerEnv,
+				Value: "true",
+			})
+		}
 
-	// Optionally inject a metrics exporter sidecar that shares the agent's
-	// environment (restic credentials + repository) and exposes Prometheus metrics.
-	if policy.Spec.Exporter != nil {
-		pod.Spec.Containers = append(pod.Spec.Containers, buildExporterContainer(policy.Spec.Exporter, newContainer.Env))
+		containers = append(containers, newContainer)
 
-		if pod.Labels == nil {
-			pod.Labels = make(map[string]string, 1)
+		// The pod gets the longest grace period of its policies
+		if grace := agent.TerminationGracePeriod(pod, policy.Spec.Termination); grace != nil && (gracePeriod == nil || *grace > *gracePeriod) {
+			gracePeriod = grace
 		}
-		pod.Labels[constants.ExporterLabel] = "true"
 
-		log.Info("spawned the restic exporter container")
-	}
+		// Optionally inject a metrics exporter sidecar that shares the agent's
+		// environment (restic credentials + repository) and exposes Prometheus metrics.
+		if policy.Spec.Exporter != nil {
+			exporter := agent.BuildExporterContainer(policy.Spec.Exporter, newContainer.Env)
 
-	if pod.Labels == nil {
-		pod.Labels = make(map[string]string, 1)
-	}
-	pod.Labels[constants.MutatedLabel] = "true"
+			// An exporter whose port is already taken in the pod listens on
+			// the next free one instead
+			if port := exporter.Ports[0].ContainerPort; usedPorts[port] {
+				for usedPorts[port] {
+					port++
+				}
+				spec := policy.Spec.Exporter.DeepCopy()
+				spec.Port = port
+				exporter = agent.BuildExporterContainer(spec, newContainer.Env)
+				log.Info("moved the restic exporter to a free port", "policy", backup.Policy, "port", port)
+			}
+			usedPorts[exporter.Ports[0].ContainerPort] = true
+
+			if len(backups) > 1 {
+				exporter.Name += "-" + backup.Suffix()
+			}
+			if exporters > 0 {
+				// Port names must be unique within the pod
+				exporter.Ports[0].Name = fmt.Sprintf("metrics-%d", exporters+1)
+			}
+			exporters++
 
-	log.Info("spawned the backup agent container")
+			containers = append(containers, exporter)
 
-	return nil
-}
+			log.Info("spawned the restic exporter container", "container", exporter.Name)
+		}
 
-// resolveImage returns the full image reference and pull policy for an Image
-// spec: Always for an empty/"latest" tag, IfNotPresent otherwise, unless an
-// explicit pull policy is set.
-func resolveImage(img v1alpha1.Image) (string, corev1.PullPolicy) {
-	ref := img.Name
-	if img.Tag != "" {
-		ref += ":" + img.Tag
+		log.Info("spawned the backup agent container", "container", newContainer.Name)
 	}
 
-	switch {
-	case img.PullPolicy != "":
-		return ref, img.PullPolicy
-	case img.Tag == "" || img.Tag == "latest":
-		return ref, corev1.PullAlways
-	default:
-		return ref, corev1.PullIfNotPresent
+	if len(containers) == 0 {
+		return nil
 	}
-}
 
-// buildExporterContainer builds the metrics exporter sidecar. It inherits the
-// backup agent's environment (restic repository + credentials) so it can query
-// the same repository, then applies the listen port and exporter-specific env.
-func buildExporterContainer(exporter *v1alpha1.Exporter, agentEnv []corev1.EnvVar) corev1.Container {
-	port := exporter.Port
-	if port == 0 {
-		port = 8001
+	pod.Spec.Containers = append(pod.Spec.Containers, containers...)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, agent.SchedulesVolume())
+	pod.Spec.Volumes = append(pod.Spec.Volumes, volumes...)
+	if gracePeriod != nil {
+		pod.Spec.TerminationGracePeriodSeconds = gracePeriod
 	}
 
-	image, pullPolicy := resolveImage(exporter.Image)
-
-	env := append([]corev1.EnvVar{}, agentEnv...)
-	env = append(env, corev1.EnvVar{Name: "LISTEN_PORT", Value: fmt.Sprintf("%d", port)})
-	env = append(env, exporter.Environment...)
-
-	liveness := exporter.LivenessProbe
-	if liveness == nil {
-		liveness = metricsProbe(port, 30, 60, 5)
-	}
-	readiness := exporter.ReadinessProbe
-	if readiness == nil {
-		readiness = metricsProbe(port, 10, 30, 3)
+	if pod.Labels == nil {
+		pod.Labels = make(map[string]string, 2)
 	}
-
-	return corev1.Container{
-		Name:            "restic-exporter",
-		Image:           image,
-		ImagePullPolicy: pullPolicy,
-		Env:             env,
-		Ports: []corev1.ContainerPort{{
-			Name:          "metrics",
-			ContainerPort: port,
-			Protocol:      corev1.ProtocolTCP,
-		}},
-		LivenessProbe:  liveness,
-		ReadinessProbe: readiness,
-		StartupProbe:   exporter.StartupProbe,
+	if exporters > 0 {
+		pod.Labels[constants.ExporterLabel] = "true"
 	}
-}
+	pod.Labels[constants.MutatedLabel] = "true"
 
-// metricsProbe builds an HTTP GET probe against /metrics on the given port,
-// used as the default health check for the exporter sidecar.
-func metricsProbe(port, initialDelaySeconds, periodSeconds, failureThreshold int32) *corev1.Probe {
-	return &corev1.Probe{
-		ProbeHandler: corev1.ProbeHandler{
-			HTTPGet: &corev1.HTTPGetAction{
-				Path: "/metrics",
-				Port: intstr.FromInt32(port),
-			},
-		},
-		InitialDelaySeconds: initialDelaySeconds,
-		PeriodSeconds:       periodSeconds,
-		TimeoutSeconds:      5,
-		FailureThreshold:    failureThreshold,
-	}
+	return nil
 }
 
 func (d *PodCustomDefaulter) getPolicy(ctx context.Context, name string) (*v1alpha1.Policy, error) {
@@ -318,149 +301,3 @@ func (d *PodCustomDefaulter) getSchedule(ctx context.Context, name string) (*v1a
 
 	return &schedule, nil
 }
-
-func (d *PodCustomDefaulter) getContainerEnv(pod *corev1.Pod, container, variable string) (corev1.EnvVar, error) {
-	for _, c := range pod.Spec.Containers {
-		if c.Name == container {
-			for _, e := range c.Env {
-				if e.Name == variable {
-					return e, nil
-				}
-			}
-			return corev1.EnvVar{}, fmt.Errorf("variable not found")
-		}
-	}
-	return corev1.EnvVar{}, fmt.Errorf("container not found")
-}
-
-func (d *PodCustomDefaulter) getContainerVolumeMount(pod *corev1.Pod, container, mountPath string) (corev1.VolumeMount, error) {
-	for _, c := range pod.Spec.Containers {
-		if c.Name == container {
-			for _, m := range c.VolumeMounts {
-				if m.MountPath == mountPath {
-					return m, nil
-				}
-			}
-			return corev1.VolumeMount{}, fmt.Errorf("volume mount not found")
-		}
-	}
-	return corev1.VolumeMount{}, fmt.Errorf("container not found")
-}
-
-func (d *PodCustomDefaulter) buildTemplate(policy v1alpha1.Policy, pod corev1.Pod) (v1alpha1.Policy, error) {
-	podJson, err := json.Marshal(pod)
-	if err != nil {
-		return v1alpha1.Policy{}, fmt.Errorf("error while marshaling the pod: %w", err)
-	}
-
-	var podMap map[string]any
-	if err := json.Unmarshal(podJson, &podMap); err != nil {
-		return v1alpha1.Policy{}, fmt.Errorf("error while converting the pod: %w", err)
-	}
-
-	policyJson, err := json.Marshal(policy)
-	if err != nil {
-		return v1alpha1.Policy{}, fmt.Errorf("error while marshaling the policy: %w", err)
-	}
-
-	policyTpl, err := template.New("base").Funcs(sprig.FuncMap()).Parse(string(policyJson))
-	if err != nil {
-		return v1alpha1.Policy{}, fmt.Errorf("error while parsing the policy templates: %w", err)
-	}
-
-	resultJson := bytes.NewBuffer(nil)
-	if err := policyTpl.Execute(resultJson, map[string]any{"pod": podMap}); err != nil {
-		return v1alpha1.Policy{}, fmt.Errorf("error while executing the policy templates: %w", err)
-	}
-
-	if err := json.Unmarshal(resultJson.Bytes(), &policy); err != nil {
-		return v1alpha1.Policy{}, fmt.Errorf("error while unmarshaling the policy: %w", err)
-	}
-
-	return policy, nil
-}
-
-func (d *PodCustomDefaulter) getDetectedVolumeMounts(pod corev1.Pod) ([]corev1.VolumeMount, error) {
-	annotations := pod.GetAnnotations()
-	volumeAnnotation := annotations[constants.AutoDetectVolumeAnnotation]
-	containerAnnotation := annotations[constants.AutoDetectContainerAnnotation]
-
-	var validVolumes []string
-	for _, v := range pod.Spec.Volumes {
-		valid := false
-
-		if volumeAnnotation != "" {
-			if v.Name == volumeAnnotation {
-				valid = true
-			}
-		} else {
-			if v.HostPath != nil && v.HostPath.Path != "" {
-				valid = true
-			}
-
-			if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName != "" {
-				valid = true
-			}
-
-			if v.NFS != nil && v.NFS.Server != "" {
-				valid = true
-			}
-
-			if v.ISCSI != nil && v.ISCSI.IQN != "" {
-				valid = true
-			}
-
-			if v.FC != nil && v.FC.Lun != nil {
-				valid = true
-			}
-
-			if v.CSI != nil && v.CSI.Driver != "" {
-				valid = true
-			}
-		}
-
-		if valid {
-			if !slices.Contains(validVolumes, v.Name) {
-				validVolumes = append(validVolumes, v.Name)
-			}
-		}
-	}
-
-	var mounts []corev1.VolumeMount
-	for _, c := range pod.Spec.Containers {
-		if containerAnnotation != "" && c.Name != containerAnnotation {
-			continue
-		}
-
-		for _, m := range c.VolumeMounts {
-			if slices.Contains(validVolumes, m.Name) {
-				mounts = append(mounts, m)
-			}
-		}
-	}
-
-	if len(mounts) == 0 {
-		return nil, fmt.Errorf("no volume found")
-	}
-
-	return mounts, nil
-}
-
-func (d *PodCustomDefaulter) applyRetentionDays(annotations map[string]string, container *corev1.Container) error {
-	retentionDaysStr, ok := annotations[constants.RetentionDaysAnnotation]
-	if !ok {
-		return nil
-	}
-
-	retentionDays, err := strconv.Atoi(retentionDaysStr)
-	if err != nil || retentionDays <= 0 {
-		return fmt.Errorf("annotation %q must be a positive integer, got %q", constants.RetentionDaysAnnotation, retentionDaysStr)
-	}
-
-	container.Env = append(container.Env, corev1.EnvVar{
-		Name:  "BC_RETENTION_DAYS",
-		Value: retentionDaysStr,
-	})
-
-	return nil
-}
@@ -17,45 +17,225 @@ limitations under the License.
 package v1
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	corev1 "k8s.io/api/core/v1"
-	// TODO (user): Add any additional imports if needed
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
 )
 
 var _ = Describe("Pod Webhook", func() {
 	var (
-		obj       *corev1.Pod
-		oldObj    *corev1.Pod
-		defaulter PodCustomDefaulter
+		pod     *corev1.Pod
+		onsite  *v1alpha1.Policy
+		offsite *v1alpha1.Policy
+		daily   *v1alpha1.Schedule
+		weekly  *v1alpha1.Schedule
 	)
 
-	BeforeEach(func() {
-		obj = &corev1.Pod{}
-		oldObj = &corev1.Pod{}
-		defaulter = PodCustomDefaulter{}
-		Expect(defaulter).NotTo(BeNil(), "Expected defaulter to be initialized")
-		Expect(oldObj).NotTo(BeNil(), "Expected oldObj to be initialized")
-		Expect(obj).NotTo(BeNil(), "Expected obj to be initialized")
-		// TODO (user): Add any setup logic common to all tests
-	})
+	// defaults runs the defaulter against a fake client holding the policies and
+	// the schedules.
+	defaults := func() error {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(onsite, offsite, daily, weekly).Build()
+		defaulter := &PodCustomDefaulter{client: c, renderer: &agent.Renderer{Client: c}}
+		return defaulter.Default(ctx, pod)
+	}
+
+	containerNames := func() []string {
+		var names []string
+		for _, c := range pod.Spec.Containers {
+			names = append(names, c.Name)
+		}
+		return names
+	}
 
-	AfterEach(func() {
-		// TODO (user): Add any teardown logic common to all tests
+	container := func(name string) corev1.Container {
+		for _, c := range pod.Spec.Containers {
+			if c.Name == name {
+				return c
+			}
+		}
+		Fail("no container " + name + " in the pod")
+		return corev1.Container{}
+	}
+
+	BeforeEach(func() {
+		onsite = &v1alpha1.Policy{
+			ObjectMeta: metav1.ObjectMeta{Name: "onsite"},
+			Spec: v1alpha1.PolicySpec{
+				Image:       v1alpha1.Image{Name: "agent", Tag: "1.0"},
+				Environment: []corev1.EnvVar{{Name: "RESTIC_REPOSITORY", Value: "s3:onsite"}},
+			},
+		}
+		offsite = &v1alpha1.Policy{
+			ObjectMeta: metav1.ObjectMeta{Name: "offsite"},
+			Spec: v1alpha1.PolicySpec{
+				Image:       v1alpha1.Image{Name: "agent", Tag: "1.0"},
+				Environment: []corev1.EnvVar{{Name: "RESTIC_REPOSITORY", Value: "s3:offsite"}},
+			},
+		}
+		daily = &v1alpha1.Schedule{
+			ObjectMeta: metav1.ObjectMeta{Name: "daily"},
+			Spec:       v1alpha1.ScheduleSpec{Schedule: "0 3 * * *"},
+		}
+		weekly = &v1alpha1.Schedule{
+			ObjectMeta: metav1.ObjectMeta{Name: "weekly"},
+			Spec:       v1alpha1.ScheduleSpec{Schedule: "0 4 * * 0"},
+		}
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "app-0",
+				Annotations: map[string]string{
+					constants.BackupsAnnotation: "onsite@daily,offsite@weekly",
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name:  "app",
+					Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8001}},
+				}},
+			},
+		}
 	})
 
 	Context("When creating Pod under Defaulting Webhook", func() {
-		// TODO (user): Add logic for defaulting webhooks
-		// Example:
-		// It("Should apply defaults when a required field is empty", func() {
-		//     By("simulating a scenario where defaults should be applied")
-		//     obj.SomeFieldWithDefault = ""
-		//     By("calling the Default method to apply defaults")
-		//     defaulter.Default(ctx, obj)
-		//     By("checking that the default values are set")
-		//     Expect(obj.SomeFieldWithDefault).To(Equal("default_value"))
-		// })
-	})
+		It("Should ignore the pods without a policy and a schedule", func() {
+			pod.Annotations = nil
+
+			Expect(defaults()).To(Succeed())
+			Expect(containerNames()).To(Equal([]string{"app"}))
+			Expect(pod.Labels).NotTo(HaveKey(constants.MutatedLabel))
+		})
+
+		It("Should inject one agent named after the container for a single pair", func() {
+			pod.Annotations = map[string]string{
+				constants.PolicyAnnotation:   "onsite",
+				constants.ScheduleAnnotation: "daily",
+			}
+
+			Expect(defaults()).To(Succeed())
+			Expect(containerNames()).To(Equal([]string{"app", agent.ContainerName}))
+			Expect(pod.Labels).To(HaveKeyWithValue(constants.MutatedLabel, "true"))
+			Expect(pod.Labels).NotTo(HaveKey(constants.ExporterLabel))
+		})
+
+		It("Should inject one agent per pair, suffixed with the pair", func() {
+			Expect(defaults()).To(Succeed())
+
+			onsiteAgent := agent.ContainerName + "-" + agent.Backup{Policy: "onsite", Schedule: "daily"}.Suffix()
+			offsiteAgent := agent.ContainerName + "-" + agent.Backup{Policy: "offsite", Schedule: "weekly"}.Suffix()
+			Expect(containerNames()).To(Equal([]string{"app", onsiteAgent, offsiteAgent}))
+			Expect(container(onsiteAgent).Env).To(ContainElement(corev1.EnvVar{Name: "RESTIC_REPOSITORY", Value: "s3:onsite"}))
+			Expect(container(offsiteAgent).Env).To(ContainElement(corev1.EnvVar{Name: "RESTIC_REPOSITORY", Value: "s3:offsite"}))
+
+			volumes := 0
+			for _, v := range pod.Spec.Volumes {
+				if v.Name == agent.SchedulesVolumeName {
+					volumes++
+				}
+			}
+			Expect(volumes).To(Equal(1))
+		})
+
+		It("Should skip the pairs whose policy runs as a CronJob or against snapshots", func() {
+			onsite.Spec.Mode = v1alpha1.PolicyModeCronJob
+
+			Expect(defaults()).To(Succeed())
+			Expect(containerNames()).To(Equal([]string{
+				"app",
+				agent.ContainerName + "-" + agent.Backup{Policy: "offsite", Schedule: "weekly"}.Suffix(),
+			}))
+		})
+
+		It("Should leave the pod untouched when all its pairs are skipped", func() {
+			onsite.Spec.Mode = v1alpha1.PolicyModeCronJob
+			offsite.Spec.Mode = v1alpha1.PolicyModeSnapshot
+
+			Expect(defaults()).To(Succeed())
+			Expect(containerNames()).To(Equal([]string{"app"}))
+			Expect(pod.Spec.Volumes).To(BeEmpty())
+			Expect(pod.Labels).NotTo(HaveKey(constants.MutatedLabel))
+		})
 
+		It("Should move the exporters to free ports with unique port names", func() {
+			onsite.Spec.Exporter = &v1alpha1.Exporter{Image: v1alpha1.Image{Name: "exporter", Tag: "1.0"}}
+			offsite.Spec.Exporter = &v1alpha1.Exporter{Image: v1alpha1.Image{Name: "exporter", Tag: "1.0"}}
+
+			Expect(defaults()).To(Succeed())
+
+			onsiteExporter := container(agent.ExporterContainerName + "-" + agent.Backup{Policy: "onsite", Schedule: "daily"}.Suffix())
+			Expect(onsiteExporter.Ports).To(Equal([]corev1.ContainerPort{{Name: "metrics", ContainerPort: 8002, Protocol: corev1.ProtocolTCP}}))
+			Expect(onsiteExporter.Env).To(ContainElement(corev1.EnvVar{Name: "LISTEN_PORT", Value: "8002"}))
+
+			offsiteExporter := container(agent.ExporterContainerName + "-" + agent.Backup{Policy: "offsite", Schedule: "weekly"}.Suffix())
+			Expect(offsiteExporter.Ports).To(Equal([]corev1.ContainerPort{{Name: "metrics-2", ContainerPort: 8003, Protocol: corev1.ProtocolTCP}}))
+			Expect(offsiteExporter.Env).To(ContainElement(corev1.EnvVar{Name: "LISTEN_PORT", Value: "8003"}))
+
+			Expect(pod.Labels).To(HaveKeyWithValue(constants.ExporterLabel, "true"))
+		})
+
+		It("Should keep the port of an exporter when it is free", func() {
+			pod.Spec.Containers[0].Ports = nil
+			onsite.Spec.Exporter = &v1alpha1.Exporter{Image: v1alpha1.Image{Name: "exporter", Tag: "1.0"}, Port: 9000}
+
+			Expect(defaults()).To(Succeed())
+
+			exporter := container(agent.ExporterContainerName + "-" + agent.Backup{Policy: "onsite", Schedule: "daily"}.Suffix())
+			Expect(exporter.Ports[0].ContainerPort).To(Equal(int32(9000)))
+			Expect(exporter.Ports[0].Name).To(Equal("metrics"))
+		})
+
+		It("Should give the pod the longest grace period of its policies", func() {
+			pod.Spec.TerminationGracePeriodSeconds = ptr.To[int64](30)
+			onsite.Spec.Termination = &v1alpha1.Termination{GracePeriod: &metav1.Duration{Duration: 5 * time.Minute}}
+			offsite.Spec.Termination = &v1alpha1.Termination{GracePeriod: &metav1.Duration{Duration: 2 * time.Minute}}
+
+			Expect(defaults()).To(Succeed())
+			Expect(pod.Spec.TerminationGracePeriodSeconds).To(Equal(ptr.To[int64](300)))
+		})
+
+		It("Should keep the grace period of the pod when it is the longest", func() {
+			pod.Spec.TerminationGracePeriodSeconds = ptr.To[int64](600)
+			onsite.Spec.Termination = &v1alpha1.Termination{GracePeriod: &metav1.Duration{Duration: 5 * time.Minute}}
+
+			Expect(defaults()).To(Succeed())
+			Expect(pod.Spec.TerminationGracePeriodSeconds).To(Equal(ptr.To[int64](600)))
+		})
+
+		It("Should share a single hooks volume between the agents", func() {
+			hooks := &v1alpha1.Hooks{Pre: []v1alpha1.Hook{{Container: "app", Command: []string{"sync"}}}}
+			onsite.Spec.Hooks = hooks
+			offsite.Spec.Hooks = hooks
+
+			Expect(defaults()).To(Succeed())
+
+			var volumes []string
+			for _, v := range pod.Spec.Volumes {
+				volumes = append(volumes, v.Name)
+			}
+			Expect(volumes).To(Equal([]string{agent.SchedulesVolumeName, agent.HooksVolumeName}))
+
+			for _, pair := range []agent.Backup{{Policy: "onsite", Schedule: "daily"}, {Policy: "offsite", Schedule: "weekly"}} {
+				Expect(container(agent.ContainerName + "-" + pair.Suffix()).VolumeMounts).To(ContainElement(
+					HaveField("Name", agent.HooksVolumeName),
+				))
+			}
+			Expect(container("app").VolumeMounts).To(BeEmpty())
+		})
+
+		It("Should fail when a policy does not exist", func() {
+			pod.Annotations[constants.BackupsAnnotation] = "missing@daily"
+
+			Expect(defaults()).To(MatchError(ContainSubstring("error while fetching the policy")))
+		})
+	})
 })
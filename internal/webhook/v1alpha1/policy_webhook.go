@@ -20,7 +20,12 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/robfig/cron/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -28,7 +33,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	api "github.com/rclsilver-org/backup-controller/api/v1alpha1"
-	"github.com/rclsilver-org/backup-controller/internal/constants"
+	"github.com/rclsilver-org/backup-controller/internal/agent"
+	"github.com/rclsilver-org/backup-controller/internal/selector"
 )
 
 // SetupPolicyWebhookWithManager registers the webhook for Policy in the manager.
@@ -92,14 +98,13 @@ func (v *PolicyCustomValidator) ValidateDelete(ctx context.Context, obj runtime.
 		return nil, fmt.Errorf("expected a Policy object but got %T", obj)
 	}
 
-	pods, err := getMutatedPods(ctx, v.client)
+	backups, err := getUsedBackups(ctx, v.client)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, pod := range pods {
-		policyValue, ok := pod.GetAnnotations()[constants.PolicyAnnotation]
-		if ok && policyValue == policy.GetName() {
+	for _, backup := range backups {
+		if backup.Policy == policy.GetName() {
 			return nil, fmt.Errorf("unable to delete the %q policy because it is still used", policy.GetName())
 		}
 	}
@@ -111,5 +116,103 @@ func (v *PolicyCustomValidator) ValidateDelete(ctx context.Context, obj runtime.
 
 // validate validates a given policy
 func (v *PolicyCustomValidator) validate(policy *api.Policy) error {
+	var allErrs field.ErrorList
+
+	if policy.Spec.Mode == api.PolicyModeCronJob || policy.Spec.Mode == api.PolicyModeSnapshot {
+		if policy.Spec.Exporter != nil {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("exporter"), fmt.Sprintf("the exporter sidecar is not supported in %s mode", policy.Spec.Mode)))
+		}
+	}
+
+	if policy.Spec.PodSelectorExpression != "" {
+		if _, err := selector.Compile(policy.Spec.PodSelectorExpression); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("podSelectorExpression"), policy.Spec.PodSelectorExpression, err.Error()))
+		}
+	}
+
+	if policy.Spec.AutoDetect != nil {
+		if err := agent.ValidatePatterns(policy.Spec.AutoDetect); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("autoDetect"), policy.Spec.AutoDetect, err.Error()))
+		}
+	}
+
+	if hooks := policy.Spec.Hooks; hooks != nil {
+		if policy.Spec.Mode == api.PolicyModeCronJob || policy.Spec.Mode == api.PolicyModeSnapshot {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("hooks"), fmt.Sprintf("the hooks are not supported in %s mode", policy.Spec.Mode)))
+		}
+		for _, phase := range []struct {
+			name  string
+			hooks []api.Hook
+		}{{"pre", hooks.Pre}, {"post", hooks.Post}} {
+			for i, h := range phase.hooks {
+				if h.Timeout != nil && h.Timeout.Duration <= 0 {
+					allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("hooks", phase.name).Index(i).Child("timeout"), h.Timeout.Duration.String(), "must be positive"))
+				}
+			}
+		}
+	}
+
+	if freshness := policy.Spec.Freshness; freshness != nil {
+		path := field.NewPath("spec").Child("freshness")
+		if policy.Spec.Mode == api.PolicyModeCronJob || policy.Spec.Mode == api.PolicyModeSnapshot {
+			allErrs = append(allErrs, field.Forbidden(path, fmt.Sprintf("the freshness checks are not supported in %s mode", policy.Spec.Mode)))
+		}
+		for _, d := range []struct {
+			name     string
+			duration *metav1.Duration
+		}{{"warnAfter", freshness.WarnAfter}, {"failAfter", freshness.FailAfter}} {
+			if d.duration != nil && d.duration.Duration <= 0 {
+				allErrs = append(allErrs, field.Invalid(path.Child(d.name), d.duration.Duration.String(), "must be positive"))
+			}
+		}
+		if freshness.WarnAfter != nil && freshness.FailAfter != nil && freshness.FailAfter.Duration <= freshness.WarnAfter.Duration {
+			allErrs = append(allErrs, field.Invalid(path.Child("failAfter"), freshness.FailAfter.Duration.String(), "must be greater than warnAfter"))
+		}
+	}
+
+	if termination := policy.Spec.Termination; termination != nil {
+		path := field.NewPath("spec").Child("termination")
+		for _, d := range []struct {
+			name     string
+			duration *metav1.Duration
+		}{{"gracePeriod", termination.GracePeriod}, {"abortPeriod", termination.AbortPeriod}} {
+			if d.duration != nil && d.duration.Duration <= 0 {
+				allErrs = append(allErrs, field.Invalid(path.Child(d.name), d.duration.Duration.String(), "must be positive"))
+			}
+		}
+		if termination.GracePeriod != nil && termination.AbortPeriod != nil && termination.AbortPeriod.Duration >= termination.GracePeriod.Duration {
+			allErrs = append(allErrs, field.Invalid(path.Child("abortPeriod"), termination.AbortPeriod.Duration.String(), "must be less than gracePeriod"))
+		}
+	}
+
+	if retry := policy.Spec.Retry; retry != nil && retry.Backoff != nil && retry.Backoff.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("retry").Child("backoff"), retry.Backoff.Duration.String(), "must be positive"))
+	}
+
+	if verify := policy.Spec.Verify; verify != nil {
+		path := field.NewPath("spec").Child("verify")
+		if policy.Spec.Mode != "" && policy.Spec.Mode != api.PolicyModeSidecar {
+			allErrs = append(allErrs, field.Forbidden(path, "only supported in Sidecar mode"))
+		}
+		if _, err := cron.ParseStandard(verify.Schedule); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("schedule"), verify.Schedule, fmt.Sprintf("invalid cron expression: %v", err)))
+		}
+		if limit := verify.ScratchSizeLimit; limit != nil && limit.Sign() <= 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child("scratchSizeLimit"), limit.String(), "must be positive"))
+		}
+	}
+
+	if policy.Spec.Mode != api.PolicyModeSnapshot && policy.Spec.Snapshot != nil {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("snapshot"), "only supported in Snapshot mode"))
+	}
+
+	if len(allErrs) > 0 {
+		return apierrors.NewInvalid(
+			schema.GroupKind{Group: "backup-controller.rclsilver-org.github.com", Kind: "Policy"},
+			policy.Name,
+			allErrs,
+		)
+	}
+
 	return nil
 }
@@ -17,55 +17,190 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	backupcontrollerrclsilverorggithubcomv1alpha1 "github.com/rclsilver-org/backup-controller/api/v1alpha1"
-	// TODO (user): Add any additional imports if needed
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
 )
 
 var _ = Describe("Policy Webhook", func() {
 	var (
-		obj       *backupcontrollerrclsilverorggithubcomv1alpha1.Policy
-		oldObj    *backupcontrollerrclsilverorggithubcomv1alpha1.Policy
+		obj       *api.Policy
+		oldObj    *api.Policy
 		validator PolicyCustomValidator
 	)
 
-	BeforeEach(func() {
-		obj = &backupcontrollerrclsilverorggithubcomv1alpha1.Policy{}
-		oldObj = &backupcontrollerrclsilverorggithubcomv1alpha1.Policy{}
-		validator = PolicyCustomValidator{}
-		Expect(validator).NotTo(BeNil(), "Expected validator to be initialized")
-		Expect(oldObj).NotTo(BeNil(), "Expected oldObj to be initialized")
-		Expect(obj).NotTo(BeNil(), "Expected obj to be initialized")
-		// TODO (user): Add any setup logic common to all tests
-	})
+	duration := func(d time.Duration) *metav1.Duration {
+		return &metav1.Duration{Duration: d}
+	}
 
-	AfterEach(func() {
-		// TODO (user): Add any teardown logic common to all tests
+	BeforeEach(func() {
+		obj = &api.Policy{
+			ObjectMeta: metav1.ObjectMeta{Name: "nfs"},
+			Spec: api.PolicySpec{
+				Image: api.Image{Name: "agent", Tag: "1.0"},
+			},
+		}
+		oldObj = obj.DeepCopy()
+		validator = PolicyCustomValidator{client: fake.NewClientBuilder().WithScheme(scheme).Build()}
 	})
 
 	Context("When creating or updating Policy under Validating Webhook", func() {
-		// TODO (user): Add logic for validating webhooks
-		// Example:
-		// It("Should deny creation if a required field is missing", func() {
-		//     By("simulating an invalid creation scenario")
-		//     obj.SomeRequiredField = ""
-		//     Expect(validator.ValidateCreate(ctx, obj)).Error().To(HaveOccurred())
-		// })
-		//
-		// It("Should admit creation if all required fields are present", func() {
-		//     By("simulating an invalid creation scenario")
-		//     obj.SomeRequiredField = "valid_value"
-		//     Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
-		// })
-		//
-		// It("Should validate updates correctly", func() {
-		//     By("simulating a valid update scenario")
-		//     oldObj.SomeRequiredField = "updated_value"
-		//     obj.SomeRequiredField = "updated_value"
-		//     Expect(validator.ValidateUpdate(ctx, oldObj, obj)).To(BeNil())
-		// })
+		It("Should admit a valid policy", func() {
+			obj.Spec.Exporter = &api.Exporter{Image: api.Image{Name: "exporter", Tag: "1.0"}}
+			obj.Spec.Freshness = &api.Freshness{WarnAfter: duration(26 * time.Hour), FailAfter: duration(50 * time.Hour)}
+			obj.Spec.Termination = &api.Termination{GracePeriod: duration(5 * time.Minute), AbortPeriod: duration(time.Minute)}
+			obj.Spec.Verify = &api.Verify{Schedule: "0 5 * * 0"}
+
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		DescribeTable("Should forbid the sidecar features outside the Sidecar mode",
+			func(mode api.PolicyMode) {
+				obj.Spec.Mode = mode
+				obj.Spec.Exporter = &api.Exporter{Image: api.Image{Name: "exporter", Tag: "1.0"}}
+				obj.Spec.Hooks = &api.Hooks{Pre: []api.Hook{{Container: "app", Command: []string{"sync"}}}}
+				obj.Spec.Freshness = &api.Freshness{WarnAfter: duration(time.Hour)}
+				obj.Spec.Verify = &api.Verify{Schedule: "0 5 * * 0"}
+
+				_, err := validator.ValidateCreate(ctx, obj)
+				Expect(err).To(MatchError(And(
+					ContainSubstring("spec.exporter: Forbidden"),
+					ContainSubstring("spec.hooks: Forbidden"),
+					ContainSubstring("spec.freshness: Forbidden"),
+					ContainSubstring("spec.verify: Forbidden"),
+				)))
+			},
+			Entry("CronJob", api.PolicyModeCronJob),
+			Entry("Snapshot", api.PolicyModeSnapshot),
+		)
+
+		DescribeTable("Should deny the invalid settings",
+			func(update func(*api.PolicySpec), message string) {
+				update(&obj.Spec)
+
+				_, err := validator.ValidateCreate(ctx, obj)
+				Expect(err).To(MatchError(ContainSubstring(message)))
+
+				_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+				Expect(err).To(MatchError(ContainSubstring(message)))
+			},
+			Entry("an invalid pod selector expression", func(spec *api.PolicySpec) {
+				spec.PodSelectorExpression = "pod.metadata.name =="
+			}, "spec.podSelectorExpression"),
+			Entry("a hook timeout which is not positive", func(spec *api.PolicySpec) {
+				spec.Hooks = &api.Hooks{Post: []api.Hook{{Container: "app", Command: []string{"sync"}, Timeout: duration(0)}}}
+			}, "spec.hooks.post[0].timeout: Invalid value: \"0s\": must be positive"),
+			Entry("a freshness threshold which is not positive", func(spec *api.PolicySpec) {
+				spec.Freshness = &api.Freshness{WarnAfter: duration(-time.Hour)}
+			}, "spec.freshness.warnAfter: Invalid value: \"-1h0m0s\": must be positive"),
+			Entry("a failAfter shorter than warnAfter", func(spec *api.PolicySpec) {
+				spec.Freshness = &api.Freshness{WarnAfter: duration(2 * time.Hour), FailAfter: duration(time.Hour)}
+			}, "spec.freshness.failAfter: Invalid value: \"1h0m0s\": must be greater than warnAfter"),
+			Entry("a failAfter equal to warnAfter", func(spec *api.PolicySpec) {
+				spec.Freshness = &api.Freshness{WarnAfter: duration(time.Hour), FailAfter: duration(time.Hour)}
+			}, "must be greater than warnAfter"),
+			Entry("a grace period which is not positive", func(spec *api.PolicySpec) {
+				spec.Termination = &api.Termination{GracePeriod: duration(0)}
+			}, "spec.termination.gracePeriod: Invalid value: \"0s\": must be positive"),
+			Entry("an abort period as long as the grace period", func(spec *api.PolicySpec) {
+				spec.Termination = &api.Termination{GracePeriod: duration(time.Minute), AbortPeriod: duration(time.Minute)}
+			}, "spec.termination.abortPeriod: Invalid value: \"1m0s\": must be less than gracePeriod"),
+			Entry("a retry backoff which is not positive", func(spec *api.PolicySpec) {
+				spec.Retry = &api.Retry{MaxAttempts: 3, Backoff: duration(-time.Second)}
+			}, "spec.retry.backoff: Invalid value: \"-1s\": must be positive"),
+			Entry("an invalid verification schedule", func(spec *api.PolicySpec) {
+				spec.Verify = &api.Verify{Schedule: "every sunday"}
+			}, "spec.verify.schedule: Invalid value: \"every sunday\": invalid cron expression"),
+			Entry("a scratch size limit which is not positive", func(spec *api.PolicySpec) {
+				spec.Verify = &api.Verify{Schedule: "0 5 * * 0", ScratchSizeLimit: ptr.To(resource.MustParse("0"))}
+			}, "spec.verify.scratchSizeLimit: Invalid value: \"0\": must be positive"),
+			Entry("snapshot settings outside the Snapshot mode", func(spec *api.PolicySpec) {
+				spec.Snapshot = &api.Snapshot{VolumeSnapshotClassName: ptr.To("csi")}
+			}, "spec.snapshot: Forbidden: only supported in Snapshot mode"),
+		)
+
+		It("Should admit the snapshot settings in Snapshot mode", func() {
+			obj.Spec.Mode = api.PolicyModeSnapshot
+			obj.Spec.Snapshot = &api.Snapshot{VolumeSnapshotClassName: ptr.To("csi")}
+
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
 	})
 
+	Context("When deleting Policy under Validating Webhook", func() {
+		withObjects := func(objects ...client.Object) {
+			validator.client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+		}
+
+		It("Should admit the deletion of an unused policy", func() {
+			withObjects(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "app-0",
+					Labels:      map[string]string{constants.MutatedLabel: "true"},
+					Annotations: map[string]string{constants.BackupsAnnotation: "offsite@daily"},
+				},
+			})
+
+			Expect(validator.ValidateDelete(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny the deletion of a policy used by a mutated pod", func() {
+			withObjects(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "app-0",
+					Labels:      map[string]string{constants.MutatedLabel: "true"},
+					Annotations: map[string]string{constants.BackupsAnnotation: "offsite@daily,nfs@weekly"},
+				},
+			})
+
+			_, err := validator.ValidateDelete(ctx, obj)
+			Expect(err).To(MatchError(`unable to delete the "nfs" policy because it is still used`))
+		})
+
+		It("Should ignore the pods which have not been mutated", func() {
+			withObjects(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "app-0",
+					Annotations: map[string]string{constants.BackupsAnnotation: "nfs@daily"},
+				},
+			})
+
+			Expect(validator.ValidateDelete(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny the deletion of a policy used by a workload template", func() {
+			withObjects(&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Annotations: map[string]string{
+								constants.PolicyAnnotation:   "nfs",
+								constants.ScheduleAnnotation: "daily",
+							},
+						},
+					},
+				},
+			})
+
+			_, err := validator.ValidateDelete(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("still used")))
+		})
+	})
 })
@@ -19,6 +19,7 @@ package v1alpha1
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/robfig/cron/v3"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -32,7 +33,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	api "github.com/rclsilver-org/backup-controller/api/v1alpha1"
-	"github.com/rclsilver-org/backup-controller/internal/constants"
+	"github.com/rclsilver-org/backup-controller/internal/blackout"
 )
 
 // SetupScheduleWebhookWithManager registers the webhook for Schedule in the manager.
@@ -96,14 +97,13 @@ func (v *ScheduleCustomValidator) ValidateDelete(ctx context.Context, obj runtim
 		return nil, fmt.Errorf("expected a Schedule object but got %T", obj)
 	}
 
-	pods, err := getMutatedPods(ctx, v.client)
+	backups, err := getUsedBackups(ctx, v.client)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, pod := range pods {
-		scheduleValue, ok := pod.GetAnnotations()[constants.ScheduleAnnotation]
-		if ok && scheduleValue == schedule.GetName() {
+	for _, backup := range backups {
+		if backup.Schedule == schedule.GetName() {
 			return nil, fmt.Errorf("unable to delete the %q schedule because it is still used", schedule.GetName())
 		}
 	}
@@ -121,6 +121,47 @@ func (v *ScheduleCustomValidator) validate(schedule *api.Schedule) error {
 		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("schedule"), schedule.Spec.Schedule, err.Error()))
 	}
 
+	if schedule.Spec.TimeZone != "" {
+		if _, err := time.LoadLocation(schedule.Spec.TimeZone); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("timeZone"), schedule.Spec.TimeZone, "unknown time zone"))
+		}
+	}
+
+	if jitter := schedule.Spec.Jitter; jitter != nil && jitter.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("jitter"), jitter.Duration.String(), "must not be negative"))
+	}
+
+	if deadline := schedule.Spec.StartingDeadline; deadline != nil && deadline.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("startingDeadline"), deadline.Duration.String(), "must be positive"))
+	}
+
+	// A run delayed by the jitter must still be able to start
+	if jitter, deadline := schedule.Spec.Jitter, schedule.Spec.StartingDeadline; jitter != nil && deadline != nil && deadline.Duration > 0 && jitter.Duration >= deadline.Duration {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("jitter"), jitter.Duration.String(), "must be shorter than the starting deadline"))
+	}
+
+	if m := schedule.Spec.Maintenance; m != nil {
+		for _, task := range []struct{ name, expression string }{{"forget", m.Forget}, {"prune", m.Prune}, {"check", m.Check}} {
+			name, expression := task.name, task.expression
+			if expression == "" {
+				continue
+			}
+			if _, err := cron.ParseStandard(expression); err != nil {
+				allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("maintenance", name), expression, err.Error()))
+			}
+		}
+	}
+
+	if c := schedule.Spec.CatchUp; c != nil && c.GracePeriod != nil && c.GracePeriod.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("catchUp", "gracePeriod"), c.GracePeriod.Duration.String(), "must not be negative"))
+	}
+
+	for i, w := range schedule.Spec.BlackoutWindows {
+		if err := blackout.Validate(w, schedule.Spec.TimeZone); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("blackoutWindows").Index(i), w, err.Error()))
+		}
+	}
+
 	if len(allErrs) > 0 {
 		return apierrors.NewInvalid(
 			schema.GroupKind{Group: "backup-controller.rclsilver-org.github.com", Kind: "Schedule"},
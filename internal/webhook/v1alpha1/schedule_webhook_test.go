@@ -17,55 +17,164 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	backupcontrollerrclsilverorggithubcomv1alpha1 "github.com/rclsilver-org/backup-controller/api/v1alpha1"
-	// TODO (user): Add any additional imports if needed
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	api "github.com/rclsilver-org/backup-controller/api/v1alpha1"
+	"github.com/rclsilver-org/backup-controller/internal/constants"
 )
 
 var _ = Describe("Schedule Webhook", func() {
 	var (
-		obj       *backupcontrollerrclsilverorggithubcomv1alpha1.Schedule
-		oldObj    *backupcontrollerrclsilverorggithubcomv1alpha1.Schedule
+		obj       *api.Schedule
+		oldObj    *api.Schedule
 		validator ScheduleCustomValidator
 	)
 
-	BeforeEach(func() {
-		obj = &backupcontrollerrclsilverorggithubcomv1alpha1.Schedule{}
-		oldObj = &backupcontrollerrclsilverorggithubcomv1alpha1.Schedule{}
-		validator = ScheduleCustomValidator{}
-		Expect(validator).NotTo(BeNil(), "Expected validator to be initialized")
-		Expect(oldObj).NotTo(BeNil(), "Expected oldObj to be initialized")
-		Expect(obj).NotTo(BeNil(), "Expected obj to be initialized")
-		// TODO (user): Add any setup logic common to all tests
-	})
+	duration := func(d time.Duration) *metav1.Duration {
+		return &metav1.Duration{Duration: d}
+	}
+
+	date := func(value string) *metav1.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		Expect(err).NotTo(HaveOccurred())
+		return &metav1.Time{Time: t}
+	}
 
-	AfterEach(func() {
-		// TODO (user): Add any teardown logic common to all tests
+	BeforeEach(func() {
+		obj = &api.Schedule{
+			ObjectMeta: metav1.ObjectMeta{Name: "daily"},
+			Spec:       api.ScheduleSpec{Schedule: "0 3 * * *"},
+		}
+		oldObj = obj.DeepCopy()
+		validator = ScheduleCustomValidator{client: fake.NewClientBuilder().WithScheme(scheme).Build()}
 	})
 
 	Context("When creating or updating Schedule under Validating Webhook", func() {
-		// TODO (user): Add logic for validating webhooks
-		// Example:
-		// It("Should deny creation if a required field is missing", func() {
-		//     By("simulating an invalid creation scenario")
-		//     obj.SomeRequiredField = ""
-		//     Expect(validator.ValidateCreate(ctx, obj)).Error().To(HaveOccurred())
-		// })
-		//
-		// It("Should admit creation if all required fields are present", func() {
-		//     By("simulating an invalid creation scenario")
-		//     obj.SomeRequiredField = "valid_value"
-		//     Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
-		// })
-		//
-		// It("Should validate updates correctly", func() {
-		//     By("simulating a valid update scenario")
-		//     oldObj.SomeRequiredField = "updated_value"
-		//     obj.SomeRequiredField = "updated_value"
-		//     Expect(validator.ValidateUpdate(ctx, oldObj, obj)).To(BeNil())
-		// })
+		It("Should admit a valid schedule", func() {
+			obj.Spec.TimeZone = "Europe/Paris"
+			obj.Spec.Jitter = duration(15 * time.Minute)
+			obj.Spec.StartingDeadline = duration(time.Hour)
+			obj.Spec.Maintenance = &api.Maintenance{Forget: "0 4 * * *", Prune: "0 5 * * 0"}
+			obj.Spec.CatchUp = &api.CatchUp{Policy: api.CatchUpPolicyImmediate, GracePeriod: duration(0)}
+			obj.Spec.BlackoutWindows = []api.BlackoutWindow{
+				{Schedule: "0 8 * * 1-5", Duration: duration(10 * time.Hour)},
+				{Start: date("2026-12-24T00:00:00Z"), End: date("2026-12-26T00:00:00Z")},
+			}
+
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		DescribeTable("Should deny the invalid settings",
+			func(update func(*api.ScheduleSpec), message string) {
+				update(&obj.Spec)
+
+				_, err := validator.ValidateCreate(ctx, obj)
+				Expect(err).To(MatchError(ContainSubstring(message)))
+
+				_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+				Expect(err).To(MatchError(ContainSubstring(message)))
+			},
+			Entry("an invalid cron expression", func(spec *api.ScheduleSpec) {
+				spec.Schedule = "0 3 * *"
+			}, "spec.schedule: Invalid value: \"0 3 * *\""),
+			Entry("an unknown time zone", func(spec *api.ScheduleSpec) {
+				spec.TimeZone = "Mars/Olympus"
+			}, "spec.timeZone: Invalid value: \"Mars/Olympus\": unknown time zone"),
+			Entry("a negative jitter", func(spec *api.ScheduleSpec) {
+				spec.Jitter = duration(-time.Minute)
+			}, "spec.jitter: Invalid value: \"-1m0s\": must not be negative"),
+			Entry("a starting deadline which is not positive", func(spec *api.ScheduleSpec) {
+				spec.StartingDeadline = duration(0)
+			}, "spec.startingDeadline: Invalid value: \"0s\": must be positive"),
+			Entry("a jitter as long as the starting deadline", func(spec *api.ScheduleSpec) {
+				spec.Jitter = duration(time.Hour)
+				spec.StartingDeadline = duration(time.Hour)
+			}, "spec.jitter: Invalid value: \"1h0m0s\": must be shorter than the starting deadline"),
+			Entry("an invalid maintenance schedule", func(spec *api.ScheduleSpec) {
+				spec.Maintenance = &api.Maintenance{Check: "weekly"}
+			}, "spec.maintenance.check: Invalid value: \"weekly\""),
+			Entry("a negative catch-up grace period", func(spec *api.ScheduleSpec) {
+				spec.CatchUp = &api.CatchUp{Policy: api.CatchUpPolicyImmediate, GracePeriod: duration(-time.Hour)}
+			}, "spec.catchUp.gracePeriod: Invalid value: \"-1h0m0s\": must not be negative"),
+			Entry("a blackout window without a duration", func(spec *api.ScheduleSpec) {
+				spec.BlackoutWindows = []api.BlackoutWindow{{Schedule: "0 8 * * 1-5"}}
+			}, "spec.blackoutWindows[0]"),
+			Entry("a blackout window with an invalid schedule", func(spec *api.ScheduleSpec) {
+				spec.BlackoutWindows = []api.BlackoutWindow{
+					{Schedule: "0 8 * * 1-5", Duration: duration(time.Hour)},
+					{Schedule: "8am", Duration: duration(time.Hour)},
+				}
+			}, "spec.blackoutWindows[1]"),
+			Entry("a blackout window ending before its start", func(spec *api.ScheduleSpec) {
+				spec.BlackoutWindows = []api.BlackoutWindow{{Start: date("2026-12-26T00:00:00Z"), End: date("2026-12-24T00:00:00Z")}}
+			}, "the end must be after the start"),
+			Entry("a blackout window both recurring and dated", func(spec *api.ScheduleSpec) {
+				spec.BlackoutWindows = []api.BlackoutWindow{{
+					Schedule: "0 8 * * 1-5",
+					Duration: duration(time.Hour),
+					Start:    date("2026-12-24T00:00:00Z"),
+					End:      date("2026-12-26T00:00:00Z"),
+				}}
+			}, "not both"),
+		)
 	})
 
+	Context("When deleting Schedule under Validating Webhook", func() {
+		withObjects := func(objects ...client.Object) {
+			validator.client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+		}
+
+		It("Should admit the deletion of an unused schedule", func() {
+			withObjects(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "app-0",
+					Labels:      map[string]string{constants.MutatedLabel: "true"},
+					Annotations: map[string]string{constants.BackupsAnnotation: "nfs@weekly"},
+				},
+			})
+
+			Expect(validator.ValidateDelete(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny the deletion of a schedule used by a mutated pod", func() {
+			withObjects(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "app-0",
+					Labels:      map[string]string{constants.MutatedLabel: "true"},
+					Annotations: map[string]string{constants.BackupsAnnotation: "nfs@weekly,offsite@daily"},
+				},
+			})
+
+			_, err := validator.ValidateDelete(ctx, obj)
+			Expect(err).To(MatchError(`unable to delete the "daily" schedule because it is still used`))
+		})
+
+		It("Should deny the deletion of a schedule used by a workload template", func() {
+			withObjects(&appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+				Spec: appsv1.StatefulSetSpec{
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Annotations: map[string]string{constants.BackupsAnnotation: "nfs@daily"},
+						},
+					},
+				},
+			})
+
+			_, err := validator.ValidateDelete(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("still used")))
+		})
+	})
 })
@@ -4,12 +4,16 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/rclsilver-org/backup-controller/internal/agent"
 	"github.com/rclsilver-org/backup-controller/internal/constants"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch
 
 // getMutatedPods returns the list of the mutated pods
 func getMutatedPods(ctx context.Context, c client.Client) ([]corev1.Pod, error) {
@@ -22,3 +26,59 @@ func getMutatedPods(ctx context.Context, c client.Client) ([]corev1.Pod, error)
 
 	return result.Items, nil
 }
+
+// getPodBackups returns the policy and schedule pairs of a mutated pod. An
+// invalid annotation cannot have been admitted by the pod webhook, so it is
+// only logged.
+func getPodBackups(ctx context.Context, pod corev1.Pod) []agent.Backup {
+	backups, err := agent.ParseBackups(pod.GetAnnotations())
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to parse the backups of the pod", "namespace", pod.Namespace, "name", pod.Name)
+	}
+	return backups
+}
+
+// getUsedBackups returns the policy and schedule pairs in use: those of the
+// mutated pods, and those of the pod templates of the Deployments and the
+// StatefulSets, whose agents the controller runs itself in CronJob and Snapshot
+// modes without mutating their pods.
+func getUsedBackups(ctx context.Context, c client.Client) ([]agent.Backup, error) {
+	pods, err := getMutatedPods(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []agent.Backup
+	for _, pod := range pods {
+		backups = append(backups, getPodBackups(ctx, pod)...)
+	}
+
+	var deployments appsv1.DeploymentList
+	if err := c.List(ctx, &deployments); err != nil {
+		return nil, fmt.Errorf("error while fetching deployments: %w", err)
+	}
+	for _, d := range deployments.Items {
+		backups = append(backups, getTemplateBackups(ctx, "Deployment", d.Namespace, d.Name, d.Spec.Template)...)
+	}
+
+	var statefulSets appsv1.StatefulSetList
+	if err := c.List(ctx, &statefulSets); err != nil {
+		return nil, fmt.Errorf("error while fetching statefulsets: %w", err)
+	}
+	for _, s := range statefulSets.Items {
+		backups = append(backups, getTemplateBackups(ctx, "StatefulSet", s.Namespace, s.Name, s.Spec.Template)...)
+	}
+
+	return backups, nil
+}
+
+// getTemplateBackups returns the policy and schedule pairs of the pod template
+// of a workload. An invalid annotation is reported when reconciling the
+// workload, so it is only logged.
+func getTemplateBackups(ctx context.Context, kind, namespace, name string, template corev1.PodTemplateSpec) []agent.Backup {
+	backups, err := agent.ParseBackups(template.GetAnnotations())
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to parse the backups of the pod template", "kind", kind, "namespace", namespace, "name", name)
+	}
+	return backups
+}